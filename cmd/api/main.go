@@ -13,12 +13,17 @@ import (
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
 	httpHandler "github.com/yokitheyo/imageprocessor/internal/handler/http"
 	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
 	"github.com/yokitheyo/imageprocessor/internal/helpers"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/cache"
 	infradatabase "github.com/yokitheyo/imageprocessor/internal/infrastructure/database"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/kafka"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/metrics"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/webhook"
 	"github.com/yokitheyo/imageprocessor/internal/repository/postgres"
 	"github.com/yokitheyo/imageprocessor/internal/retry"
 	"github.com/yokitheyo/imageprocessor/internal/usecase"
@@ -77,33 +82,170 @@ func main() {
 		zlog.Logger.Fatal().Err(err).Msg("Failed to initialize storage")
 	}
 
-	// Kafka Producer
+	// Kafka Producer; closed explicitly once in-flight requests are drained
 	kafkaProducer := kafka.NewProducer(&cfg.Kafka)
-	defer kafkaProducer.Close()
 
 	// Repository + Usecase
 	repo := postgres.NewImageRepository(database, retry.DefaultStrategy)
-	imageUsecase := usecase.NewImageUsecase(repo, storageService, kafkaProducer)
+	jobRepo := postgres.NewJobRepository(database, retry.DefaultStrategy)
+	processedVariantRepo := postgres.NewProcessedVariantRepository(database, retry.DefaultStrategy)
+	imageVariantRepo := postgres.NewImageVariantRepository(database, retry.DefaultStrategy)
+	var variantCache *cache.DiskCache
+	if cfg.Storage.VariantCacheMaxMB > 0 {
+		variantCache, err = cache.NewDiskCache(cfg.Storage.VariantCacheDir, int64(cfg.Storage.VariantCacheMaxMB)*1024*1024)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("Failed to initialize variant cache")
+		}
+	}
+	var resizeCache *cache.DiskCache
+	if cfg.Storage.ResizeCacheMaxMB > 0 {
+		resizeCache, err = cache.NewDiskCache(cfg.Storage.ResizeCacheDir, int64(cfg.Storage.ResizeCacheMaxMB)*1024*1024)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("Failed to initialize resize cache")
+		}
+	}
+	var presignExpiry time.Duration
+	if cfg.Storage.PresignedURLs {
+		expirySec := cfg.Storage.PresignedURLExpirySec
+		if expirySec == 0 {
+			expirySec = 900
+		}
+		presignExpiry = time.Duration(expirySec) * time.Second
+	}
+	imageProcessor := processor.NewImageProcessor(&cfg.Processing)
+	// Hybrid mode: small uploads are processed in the request while the queue is short
+	var inline domain.ProcessorService
+	// callbacks outlive the signal, so uploads finishing during shutdown still report
+	callbackCtx, stopCallbacks := context.WithCancel(context.Background())
+	defer stopCallbacks()
+	var callbacks *webhook.CallbackNotifier
+	if cfg.Processing.SyncMaxBytes > 0 {
+		var notifier domain.ProcessingNotifier
+		if cfg.Webhook.URL != "" {
+			notifier = webhook.NewNotifier(&cfg.Webhook)
+		}
+		callbacks = webhook.NewCallbackNotifier(&cfg.Webhook)
+		go callbacks.Run(callbackCtx)
+		inline = usecase.NewProcessorUsecase(repo, jobRepo, processedVariantRepo, imageVariantRepo, storageService, imageProcessor, cfg.Processing.RetryPolicy(), notifier, callbacks)
+	}
+	// Sync thumbnails are rendered in the upload request; full processing stays queued
+	var thumbnails domain.PreviewService
+	if cfg.Processing.SyncThumbnail {
+		thumbnails = usecase.NewPreviewUsecase(imageProcessor)
+	}
+	imageUsecase := usecase.NewImageUsecase(repo, jobRepo, processedVariantRepo, storageService, kafkaProducer, &cfg.Processing, variantCache, resizeCache, presignExpiry, inline, thumbnails)
 
 	// Gin engine + middleware
 	engine := ginext.New("api")
+	overloadRetrySec := cfg.Server.OverloadRetrySec
+	if overloadRetrySec == 0 {
+		overloadRetrySec = 5
+	}
+	drainer := middleware.NewDrainer()
 	engine.Use(
+		drainer.Middleware(),
 		middleware.ErrorHandlerMiddleware(),
 		middleware.LoggerMiddleware(),
+		middleware.OverloadMiddleware(cfg.Server.MaxInFlight, overloadRetrySec),
 		middleware.CORSMiddleware(),
 	)
 
-	engine.GET("/health", func(c *ginext.Context) {
-		c.JSON(http.StatusOK, ginext.H{"status": "ok"})
+	httpHandler.NewHealthHandler([]httpHandler.HealthCheck{
+		{Name: "database", Check: database.Master.PingContext},
+		{Name: "kafka", Check: func(ctx context.Context) error {
+			return kafka.CheckBrokers(ctx, cfg.Kafka.Brokers)
+		}},
+		{Name: "storage", Check: storageService.Ping},
+	}, 5*time.Second).RegisterRoutes(engine)
+	metricsHandler := metrics.Handler()
+	engine.GET("/metrics", func(c *ginext.Context) {
+		metricsHandler.ServeHTTP(c.Writer, c.Request)
 	})
 
+	routeTimeouts := httpHandler.RouteTimeouts{
+		Upload:     time.Duration(cfg.Server.RouteTimeouts.UploadSec) * time.Second,
+		Processing: time.Duration(cfg.Server.RouteTimeouts.ProcessingSec) * time.Second,
+		Read:       time.Duration(cfg.Server.RouteTimeouts.ReadSec) * time.Second,
+		Admin:      time.Duration(cfg.Server.RouteTimeouts.AdminSec) * time.Second,
+		Write:      time.Duration(cfg.Server.WriteTimeoutSec) * time.Second,
+	}
+
+	maxBatchFiles := cfg.Server.MaxBatchFiles
+	if maxBatchFiles == 0 {
+		maxBatchFiles = 20
+	}
+	archiveLimits := httpHandler.ArchiveLimits{
+		MaxSize:         int64(cfg.Server.MaxArchiveSizeMB) * 1024 * 1024,
+		MaxUncompressed: int64(cfg.Server.MaxArchiveUncompressedMB) * 1024 * 1024,
+		MaxFiles:        cfg.Server.MaxArchiveFiles,
+	}
+	if archiveLimits.MaxSize == 0 {
+		archiveLimits.MaxSize = 100 * 1024 * 1024
+	}
+	if archiveLimits.MaxUncompressed == 0 {
+		archiveLimits.MaxUncompressed = 500 * 1024 * 1024
+	}
+	if archiveLimits.MaxFiles == 0 {
+		archiveLimits.MaxFiles = 200
+	}
+	urlFetchTimeout := cfg.Server.URLFetchTimeoutSec
+	if urlFetchTimeout == 0 {
+		urlFetchTimeout = 15
+	}
 	imageHandler := httpHandler.NewImageHandler(
 		imageUsecase,
 		cfg.Server.MaxUploadSizeMB,
+		cfg.Server.FormatSizeLimitsMB(),
 		cfg.Processing.SupportedFormats,
+		maxBatchFiles,
+		archiveLimits,
+		routeTimeouts,
+		time.Duration(urlFetchTimeout)*time.Second,
+		cfg.Server.Pagination(),
 	)
 	imageHandler.RegisterRoutes(engine)
 
+	previewTimeout := cfg.Server.PreviewTimeoutSec
+	if previewTimeout == 0 {
+		previewTimeout = 10
+	}
+	previewUsecase := usecase.NewPreviewUsecase(imageProcessor)
+	previewHandler := httpHandler.NewPreviewHandler(
+		previewUsecase,
+		cfg.Server.MaxUploadSizeMB,
+		cfg.Processing.SupportedFormats,
+		time.Duration(previewTimeout)*time.Second,
+	)
+	previewHandler.RegisterRoutes(engine)
+
+	spriteHandler := httpHandler.NewSpriteHandler(
+		usecase.NewSpriteUsecase(cfg.Processing.MaxPixels),
+		cfg.Server.MaxUploadSizeMB,
+		cfg.Processing.SupportedFormats,
+		maxBatchFiles,
+		routeTimeouts.Processing,
+	)
+	spriteHandler.RegisterRoutes(engine)
+
+	watermarkTemplate := cfg.Processing.WatermarkTemplate
+	if watermarkTemplate == "" {
+		watermarkTemplate = "{user}-{timestamp}"
+	}
+	watermarkUsecase := usecase.NewWatermarkUsecase(repo, storageService, imageProcessor, watermarkTemplate)
+	httpHandler.NewWatermarkHandler(watermarkUsecase, routeTimeouts).RegisterRoutes(engine)
+
+	analysisUsecase := usecase.NewAnalysisUsecase(repo, storageService, cfg.Processing.MaxPixels)
+	httpHandler.NewAnalysisHandler(analysisUsecase, routeTimeouts).RegisterRoutes(engine)
+
+	processedVariantUsecase := usecase.NewProcessedVariantUsecase(repo, processedVariantRepo, storageService, kafkaProducer, &cfg.Processing)
+	httpHandler.NewProcessedVariantHandler(processedVariantUsecase, routeTimeouts).RegisterRoutes(engine)
+
+	jobUsecase := usecase.NewJobUsecase(jobRepo)
+	httpHandler.NewJobHandler(jobUsecase, routeTimeouts).RegisterRoutes(engine)
+
+	adminUsecase := usecase.NewAdminUsecase(repo, cfg.Admin.ManifestSecret)
+	httpHandler.NewAdminHandler(adminUsecase, cfg.Admin.Token, routeTimeouts).RegisterRoutes(engine)
+
 	engine.GET("/", func(c *ginext.Context) {
 		c.File("./static/index.html")
 	})
@@ -130,11 +272,34 @@ func main() {
 	defer cancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		zlog.Logger.Error().Err(err).Msg("HTTP server shutdown failed")
+		zlog.Logger.Error().Err(err).Int64("in_flight", drainer.InFlight()).Msg("HTTP server shutdown failed")
 	} else {
 		zlog.Logger.Info().Msg("HTTP server stopped gracefully")
 	}
 
+	// Shutdown returns at its deadline even if handlers are still running;
+	// an upload may have stored its file and not yet published its task,
+	// so the producer must outlive every handler.
+	if drainer.InFlight() > 0 {
+		drainCtx, cancelDrain := context.WithTimeout(context.Background(), time.Duration(cfg.Server.DrainTimeoutSec)*time.Second)
+		if err := drainer.Wait(drainCtx); err != nil {
+			zlog.Logger.Error().Int64("in_flight", drainer.InFlight()).Msg("requests still running after drain timeout")
+		} else {
+			zlog.Logger.Info().Msg("in-flight requests drained")
+		}
+		cancelDrain()
+	}
+
+	if callbacks != nil {
+		if err := callbacks.Flush(shutdownCtx); err != nil {
+			zlog.Logger.Warn().Err(err).Msg("callbacks still pending at shutdown were dropped")
+		}
+	}
+	stopCallbacks()
+
+	// Close flushes messages still buffered by the writer; it logs failures itself
+	_ = kafkaProducer.Close()
+
 	if database != nil && database.Master != nil {
 		if err := database.Master.Close(); err != nil {
 			zlog.Logger.Error().Err(err).Msg("closing db master failed")
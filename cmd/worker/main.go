@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
@@ -13,10 +14,13 @@ import (
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
 	infradatabase "github.com/yokitheyo/imageprocessor/internal/infrastructure/database"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/kafka"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/metrics"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/webhook"
 	"github.com/yokitheyo/imageprocessor/internal/repository/postgres"
 	"github.com/yokitheyo/imageprocessor/internal/retry"
 	"github.com/yokitheyo/imageprocessor/internal/usecase"
@@ -73,33 +77,155 @@ func main() {
 		zlog.Logger.Fatal().Err(err).Msg("Failed to initialize storage")
 	}
 
+	// Track storage latency so the consumer can back off when it degrades
+	var backpressure kafka.Backpressure
+	if cfg.Kafka.BackpressureLatencyMS > 0 {
+		tracker := storage.NewLatencyTracker(time.Duration(cfg.Kafka.BackpressureLatencyMS) * time.Millisecond)
+		storageService = storage.WithLatencyTracking(storageService, tracker)
+		backpressure = tracker
+	}
+
 	// Setup Image Processor
 	imageProcessor := processor.NewImageProcessor(&cfg.Processing)
 
 	// Setup Repository and Usecase
 	repo := postgres.NewImageRepository(database, retry.DefaultStrategy)
-	processorUsecase := usecase.NewProcessorUsecase(repo, storageService, imageProcessor)
-	imageWorker := worker.NewImageWorker(processorUsecase)
-
-	// Kafka Consumer
-	kafkaConsumer, err := kafka.NewConsumer(&cfg.Kafka, imageWorker.HandleProcessingTask)
+	retryPolicy := cfg.Processing.RetryPolicy()
+	var notifier domain.ProcessingNotifier
+	if cfg.Webhook.URL != "" {
+		notifier = webhook.NewNotifier(&cfg.Webhook)
+	}
+	jobRepo := postgres.NewJobRepository(database, retry.DefaultStrategy)
+	processedVariantRepo := postgres.NewProcessedVariantRepository(database, retry.DefaultStrategy)
+	imageVariantRepo := postgres.NewImageVariantRepository(database, retry.DefaultStrategy)
+	callbacks := webhook.NewCallbackNotifier(&cfg.Webhook)
+	// callbacks outlive the signal, so tasks finishing during shutdown still report
+	callbackCtx, stopCallbacks := context.WithCancel(context.Background())
+	defer stopCallbacks()
+	go callbacks.Run(callbackCtx)
+	processorUsecase := usecase.NewProcessorUsecase(repo, jobRepo, processedVariantRepo, imageVariantRepo, storageService, imageProcessor, retryPolicy, notifier, callbacks)
+	imageWorker := worker.NewImageWorker(processorUsecase, cfg.Processing.DeadLetterUnknown)
+
+	// Kafka Consumer; every task passes through the tracker behind /debug/current
+	taskTracker := worker.NewTaskTracker()
+	kafkaConsumer, err := kafka.NewConsumer(&cfg.Kafka, kafka.MessageHandler(taskTracker.Track(imageWorker.HandleProcessingTask)), backpressure)
 	if err != nil {
 		zlog.Logger.Fatal().Err(err).Msg("Failed to initialize Kafka consumer")
 	}
 	defer kafkaConsumer.Close()
 
+	// High-priority uploads have their own topic and consumer, so they never queue behind the normal backlog
+	var highConsumer *kafka.Consumer
+	if cfg.Kafka.HighPriorityTopic != "" {
+		highCfg := cfg.Kafka.HighPriority()
+		highConsumer, err = kafka.NewConsumer(&highCfg, kafka.MessageHandler(taskTracker.Track(imageWorker.HandleProcessingTask)), backpressure)
+		if err != nil {
+			zlog.Logger.Fatal().Err(err).Msg("Failed to initialize high-priority Kafka consumer")
+		}
+		defer highConsumer.Close()
+	}
+
+	// Retries are republished through the same topic the API writes to
+	kafkaProducer := kafka.NewProducer(&cfg.Kafka)
+	defer kafkaProducer.Close()
+
+	retryPoll := cfg.Processing.RetryPollSec
+	if retryPoll == 0 {
+		retryPoll = 15
+	}
+	retryScheduler := worker.NewRetryScheduler(repo, kafkaProducer, time.Duration(retryPoll)*time.Second)
+	go retryScheduler.Run(ctx)
+
+	// variants whose worker died mid-processing are handed to another one
+	variantLease := imageProcessor.VariantLease()
+	variantSweeper := worker.NewVariantSweeper(processedVariantRepo, kafkaProducer, variantLease, variantLease)
+	go variantSweeper.Run(ctx)
+
+	if days := cfg.Processing.DeletedRetentionDays; days > 0 {
+		purgeScheduler := worker.NewPurgeScheduler(processorUsecase, time.Duration(days)*24*time.Hour, time.Hour)
+		go purgeScheduler.Run(ctx)
+	}
+
+	if interval := cfg.Worker.OrphanCleanupIntervalSec; interval > 0 {
+		graceHours := cfg.Worker.OrphanGraceHours
+		if graceHours == 0 {
+			graceHours = 24
+		}
+		orphanCleaner := worker.NewOrphanCleaner(repo, storageService, time.Duration(interval)*time.Second, time.Duration(graceHours)*time.Hour)
+		go orphanCleaner.Run(ctx)
+	}
+
+	if cfg.Worker.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsServer := &http.Server{
+			Addr:              cfg.Worker.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			zlog.Logger.Info().Str("addr", cfg.Worker.MetricsAddr).Msg("Worker metrics listening")
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				zlog.Logger.Error().Err(err).Msg("Worker metrics server error")
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	if cfg.Worker.DebugAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/current", taskTracker.Handler())
+		debugServer := &http.Server{
+			Addr:              cfg.Worker.DebugAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			zlog.Logger.Info().Str("addr", cfg.Worker.DebugAddr).Msg("Worker debug endpoint listening")
+			if err := debugServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				zlog.Logger.Error().Err(err).Msg("Worker debug server error")
+			}
+		}()
+		defer debugServer.Close()
+	}
+
 	go func() {
 		if err := kafkaConsumer.Start(ctx); err != nil {
 			zlog.Logger.Error().Err(err).Msg("Kafka consumer error")
 		}
 	}()
+	if highConsumer != nil {
+		go func() {
+			if err := highConsumer.Start(ctx); err != nil {
+				zlog.Logger.Error().Err(err).Msg("High-priority Kafka consumer error")
+			}
+		}()
+	}
 
 	<-ctx.Done()
 	zlog.Logger.Info().Msg("Shutdown signal received")
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownTimeout := time.Duration(cfg.Worker.ShutdownTimeoutSec) * time.Second
+	if shutdownTimeout == 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	<-shutdownCtx.Done()
+	waitErr := kafkaConsumer.Wait(shutdownCtx)
+	if highConsumer != nil {
+		if err := highConsumer.Wait(shutdownCtx); err != nil {
+			waitErr = err
+		}
+	}
+	if waitErr != nil {
+		zlog.Logger.Warn().Err(waitErr).Msg("Tasks in flight did not finish in time, left for redelivery")
+	} else {
+		zlog.Logger.Info().Msg("Tasks in flight finished")
+	}
+	if err := callbacks.Flush(shutdownCtx); err != nil {
+		zlog.Logger.Warn().Err(err).Msg("Callbacks still pending at shutdown were dropped")
+	}
+	stopCallbacks()
 
 	if database != nil && database.Master != nil {
 		database.Master.Close()
@@ -1,11 +1,17 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
+	"image/color"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/wb-go/wbf/config"
 	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
 )
 
 type Config struct {
@@ -15,15 +21,76 @@ type Config struct {
 	Kafka      KafkaConfig      `mapstructure:"kafka"`
 	Storage    StorageConfig    `mapstructure:"storage"`
 	Processing ProcessingConfig `mapstructure:"processing"`
+	Worker     WorkerConfig     `mapstructure:"worker"`
+	Webhook    WebhookConfig    `mapstructure:"webhook"`
+	Admin      AdminConfig      `mapstructure:"admin"`
 	Logging    LoggingConfig    `mapstructure:"logging"`
 }
 
 type ServerConfig struct {
 	Addr               string `mapstructure:"addr"`
 	ShutdownTimeoutSec int    `mapstructure:"shutdown_timeout_sec"`
-	ReadTimeoutSec     int    `mapstructure:"read_timeout_sec"`
-	WriteTimeoutSec    int    `mapstructure:"write_timeout_sec"`
-	MaxUploadSizeMB    int    `mapstructure:"max_upload_size_mb"`
+	// DrainTimeoutSec is the extra time given to requests still running
+	// after shutdown_timeout_sec, before the Kafka producer is closed.
+	DrainTimeoutSec    int `mapstructure:"drain_timeout_sec"`
+	ReadTimeoutSec     int `mapstructure:"read_timeout_sec"`
+	WriteTimeoutSec    int `mapstructure:"write_timeout_sec"`
+	MaxUploadSizeMB    int `mapstructure:"max_upload_size_mb"`
+	PreviewTimeoutSec  int `mapstructure:"preview_timeout_sec"`
+	MaxInFlight        int `mapstructure:"max_in_flight"`
+	OverloadRetrySec   int `mapstructure:"overload_retry_sec"`
+	MaxBatchFiles      int `mapstructure:"max_batch_files"`
+	URLFetchTimeoutSec int `mapstructure:"url_fetch_timeout_sec"`
+	MaxSizePNGMB       int `mapstructure:"max_size_png_mb"`
+	MaxSizeJPEGMB      int `mapstructure:"max_size_jpeg_mb"`
+	// DefaultPageLimit and MaxPageLimit bound GET /images; 0 means 10 and
+	// 100.
+	DefaultPageLimit int `mapstructure:"default_page_limit"`
+	MaxPageLimit     int `mapstructure:"max_page_limit"`
+	// MaxArchive* bound POST /upload/archive: the ZIP as uploaded, the
+	// bytes extracted from all of its entries, and the number of entries;
+	// 0 means 100 MB, 500 MB and 200.
+	MaxArchiveSizeMB         int `mapstructure:"max_archive_size_mb"`
+	MaxArchiveUncompressedMB int `mapstructure:"max_archive_uncompressed_mb"`
+	MaxArchiveFiles          int `mapstructure:"max_archive_files"`
+
+	RouteTimeouts RouteTimeoutsConfig `mapstructure:"route_timeouts"`
+}
+
+// RouteTimeoutsConfig holds per endpoint group timeouts in seconds;
+// 0 leaves the group bounded only by the server write timeout.
+type RouteTimeoutsConfig struct {
+	UploadSec     int `mapstructure:"upload_sec"`
+	ProcessingSec int `mapstructure:"processing_sec"`
+	ReadSec       int `mapstructure:"read_sec"`
+	AdminSec      int `mapstructure:"admin_sec"`
+}
+
+// Pagination returns the page size bounds of list endpoints, with the
+// defaults for unset values.
+func (c *ServerConfig) Pagination() domain.Pagination {
+	p := domain.Pagination{DefaultLimit: c.DefaultPageLimit, MaxLimit: c.MaxPageLimit}
+	if p.DefaultLimit == 0 {
+		p.DefaultLimit = 10
+	}
+	if p.MaxLimit == 0 {
+		p.MaxLimit = 100
+	}
+	return p
+}
+
+// FormatSizeLimitsMB returns upload limits that override MaxUploadSizeMB,
+// keyed by lower-case file extension without the dot.
+func (c *ServerConfig) FormatSizeLimitsMB() map[string]int {
+	limits := make(map[string]int)
+	if c.MaxSizePNGMB > 0 {
+		limits["png"] = c.MaxSizePNGMB
+	}
+	if c.MaxSizeJPEGMB > 0 {
+		limits["jpg"] = c.MaxSizeJPEGMB
+		limits["jpeg"] = c.MaxSizeJPEGMB
+	}
+	return limits
 }
 
 type DatabaseConfig struct {
@@ -47,6 +114,40 @@ type KafkaConfig struct {
 	Partition            int      `mapstructure:"partition"`
 	SessionTimeoutSec    int      `mapstructure:"session_timeout_sec"`
 	HeartbeatIntervalSec int      `mapstructure:"heartbeat_interval_sec"`
+	// BackpressureLatencyMS pauses fetching while the moving average of
+	// storage call latency is above it; 0 disables backpressure.
+	BackpressureLatencyMS int `mapstructure:"backpressure_latency_ms"`
+	BackpressurePauseMS   int `mapstructure:"backpressure_pause_ms"`
+	// DeadLetterTopic receives tasks that failed MaxProcessingAttempts
+	// times; empty means "<topic>-dlq".
+	DeadLetterTopic       string `mapstructure:"dead_letter_topic"`
+	MaxProcessingAttempts int    `mapstructure:"max_processing_attempts"`
+	// RequeueBackoffMS is the wait before a failed task is re-queued,
+	// doubling with every attempt up to a minute; 0 means 1000.
+	RequeueBackoffMS int `mapstructure:"requeue_backoff_ms"`
+	// WorkerPoolSize is how many tasks the worker processes at once; 0 means 1.
+	WorkerPoolSize int `mapstructure:"worker_pool_size"`
+	// HighPriorityTopic takes the tasks of uploads sent with X-Priority:
+	// high and is consumed next to Topic, so they never wait behind its
+	// backlog; empty queues every task on Topic.
+	HighPriorityTopic string `mapstructure:"high_priority_topic"`
+}
+
+// DeadLetterTopicName is the configured dead-letter topic or its default.
+func (c *KafkaConfig) DeadLetterTopicName() string {
+	if c.DeadLetterTopic != "" {
+		return c.DeadLetterTopic
+	}
+	return c.Topic + "-dlq"
+}
+
+// HighPriority is the consumer config of HighPriorityTopic. Tasks failing
+// there are re-queued on it but share the dead-letter topic of Topic.
+func (c *KafkaConfig) HighPriority() KafkaConfig {
+	high := *c
+	high.Topic = c.HighPriorityTopic
+	high.DeadLetterTopic = c.DeadLetterTopicName()
+	return high
 }
 
 type StorageConfig struct {
@@ -61,18 +162,310 @@ type StorageConfig struct {
 	S3Bucket    string `mapstructure:"s3_bucket"`
 	S3Region    string `mapstructure:"s3_region"`
 	S3UseSSL    bool   `mapstructure:"s3_use_ssl"`
+	// S3 storage classes, e.g. STANDARD_IA for originals; empty uses the
+	// bucket default. Local storage ignores them.
+	S3OriginalStorageClass  string `mapstructure:"s3_original_storage_class"`
+	S3ProcessedStorageClass string `mapstructure:"s3_processed_storage_class"`
+	// S3FallbackLocal starts on LocalPath when S3 is unreachable at boot
+	// and retries S3 every S3FallbackRetrySec (0 means 30) until it is back.
+	S3FallbackLocal    bool `mapstructure:"s3_fallback_local"`
+	S3FallbackRetrySec int  `mapstructure:"s3_fallback_retry_sec"`
+	// S3LocalBackend keeps LocalPath as a second backend next to S3, so
+	// images can be migrated between the two, without the degraded start.
+	S3LocalBackend bool `mapstructure:"s3_local_backend"`
+
+	// Encryption at rest: AES-GCM with a 16, 24 or 32 byte key, given
+	// base64-encoded in EncryptionKey or as raw bytes in EncryptionKeyFile.
+	EncryptionEnabled bool   `mapstructure:"encryption_enabled"`
+	EncryptionKey     string `mapstructure:"encryption_key"`
+	EncryptionKeyFile string `mapstructure:"encryption_key_file"`
+
+	// VariantCacheMaxMB caps the local disk copy of served variants; 0 disables it.
+	VariantCacheDir   string `mapstructure:"variant_cache_dir"`
+	VariantCacheMaxMB int    `mapstructure:"variant_cache_max_mb"`
+	// ResizeCacheMaxMB caps the local disk copy of on-the-fly resizes; 0 disables it.
+	ResizeCacheDir   string `mapstructure:"resize_cache_dir"`
+	ResizeCacheMaxMB int    `mapstructure:"resize_cache_max_mb"`
+
+	// PresignedURLs makes GET /image/:id and /image/:id/original redirect
+	// to a presigned S3 URL valid for PresignedURLExpirySec (0 means 900)
+	// instead of streaming the file. Ignored for other backends and when
+	// encryption at rest is enabled.
+	PresignedURLs         bool `mapstructure:"presigned_urls"`
+	PresignedURLExpirySec int  `mapstructure:"presigned_url_expiry_sec"`
 }
 
 type ProcessingConfig struct {
-	ResizeWidth      int      `mapstructure:"resize_width"`
-	ResizeHeight     int      `mapstructure:"resize_height"`
-	ThumbnailWidth   int      `mapstructure:"thumbnail_width"`
-	ThumbnailHeight  int      `mapstructure:"thumbnail_height"`
-	WatermarkText    string   `mapstructure:"watermark_text"`
-	WatermarkImage   string   `mapstructure:"watermark_image"`
-	WatermarkOpacity int      `mapstructure:"watermark_opacity"`
-	OutputQuality    int      `mapstructure:"output_quality"`
-	SupportedFormats []string `mapstructure:"supported_formats"`
+	ResizeWidth     int `mapstructure:"resize_width"`
+	ResizeHeight    int `mapstructure:"resize_height"`
+	ThumbnailWidth  int `mapstructure:"thumbnail_width"`
+	ThumbnailHeight int `mapstructure:"thumbnail_height"`
+	CropWidth       int `mapstructure:"crop_width"`
+	CropHeight      int `mapstructure:"crop_height"`
+	DenoiseRadius   int `mapstructure:"denoise_radius"`
+	// ThumbnailPadColor, #RRGGBB or #RRGGBBAA, letterboxes thumbnails to
+	// exactly ThumbnailWidth x ThumbnailHeight, or the width and height the
+	// upload asked for; empty keeps the fitted size.
+	ThumbnailPadColor string `mapstructure:"thumbnail_pad_color"`
+	// RoundedCropRadius is the default corner radius of rounded_crop in
+	// pixels; 0 cuts a circle.
+	RoundedCropRadius int    `mapstructure:"rounded_crop_radius"`
+	WatermarkText     string `mapstructure:"watermark_text"`
+	WatermarkImage    string `mapstructure:"watermark_image"`
+	WatermarkOpacity  int    `mapstructure:"watermark_opacity"`
+	WatermarkTemplate string `mapstructure:"watermark_template"`
+	// WatermarkSmartPlacement draws the watermark of the watermark type
+	// once, in the least busy corner or edge region, instead of tiling it.
+	WatermarkSmartPlacement bool `mapstructure:"watermark_smart_placement"`
+	// WatermarkFontSize and WatermarkColor style WatermarkText, which is
+	// drawn when no watermark image is available.
+	WatermarkFontSize int    `mapstructure:"watermark_font_size"`
+	WatermarkColor    string `mapstructure:"watermark_color"`
+	OutputQuality     int    `mapstructure:"output_quality"`
+	OutputFormat      string `mapstructure:"output_format"`
+	MinOutputBytes    int    `mapstructure:"min_output_bytes"`
+	// MaxCompressionRatio flags results larger than this share of the
+	// original's file size when processing reduced the pixel count; 0
+	// disables the check.
+	MaxCompressionRatio float64 `mapstructure:"max_compression_ratio"`
+	// MaxPixels bounds width*height of the images the worker decodes, read
+	// from the header before any pixels are; 0 disables the check.
+	MaxPixels int64 `mapstructure:"max_pixels"`
+	// MaxDecodeSec abandons a decode running longer than this, well
+	// before the task timeout; 0 disables the limit.
+	MaxDecodeSec int `mapstructure:"max_decode_sec"`
+	// MaxConcurrentProcessing caps the tasks a worker decodes and encodes
+	// at once, whatever the consumer pool sizes; 0 disables the cap.
+	MaxConcurrentProcessing int `mapstructure:"max_concurrent_processing"`
+	// VariantLeaseSec is how long a worker may hold a processed variant in
+	// processing before it is handed to another worker; 0 means 1800.
+	VariantLeaseSec int `mapstructure:"variant_lease_sec"`
+	// Pipelines are named step lists, e.g. [autoorient, resize:1200, watermark],
+	// selected per upload with processing_type=pipeline.
+	Pipelines          map[string][]string `mapstructure:"pipelines"`
+	FlattenAlpha       bool                `mapstructure:"flatten_alpha"`
+	BlurhashEnabled    bool                `mapstructure:"blurhash_enabled"`
+	LQIPEnabled        bool                `mapstructure:"lqip_enabled"`
+	SupportedFormats   []string            `mapstructure:"supported_formats"`
+	MaxAttempts        int                 `mapstructure:"max_attempts"`
+	RetryDelaysSec     []int               `mapstructure:"retry_delays_sec"`
+	RetryPollSec       int                 `mapstructure:"retry_poll_sec"`
+	DeadLetterUnknown  bool                `mapstructure:"dead_letter_unknown_types"`
+	RequiredAspect     float64             `mapstructure:"required_aspect_ratio"`
+	AspectTolerance    float64             `mapstructure:"aspect_tolerance"`
+	AspectMode         string              `mapstructure:"aspect_mode"`
+	NormalizeOriginals bool                `mapstructure:"normalize_originals"`
+	DownscaleOnly      bool                `mapstructure:"downscale_only"`
+	SkipWithinBounds   bool                `mapstructure:"skip_within_bounds"`
+	StripMetadata      bool                `mapstructure:"strip_metadata"`
+	TenantMaxOriginals int                 `mapstructure:"tenant_max_originals"`
+	TenantOverCap      string              `mapstructure:"tenant_over_cap"`
+	// RepairCorruptImages retries a JPEG that fails to decode as truncated,
+	// padding the lost part of the scan instead of failing the task.
+	RepairCorruptImages bool `mapstructure:"repair_corrupt_images"`
+	// PreserveColorProfile carries the RGB ICC profile of a JPEG or PNG
+	// original over to JPEG and PNG output.
+	PreserveColorProfile bool `mapstructure:"preserve_color_profile"`
+	// PreserveMetadata names metadata copied from JPEG and PNG originals
+	// to JPEG and PNG output: icc_profile and EXIF text tags.
+	PreserveMetadata []string `mapstructure:"preserve_metadata"`
+	// CMYKColorManagement converts CMYK JPEGs to RGB through the ICC
+	// profile they embed rather than the plain CMYK formula.
+	CMYKColorManagement bool `mapstructure:"cmyk_color_management"`
+	// StrictImageStructure rejects uploads with data past the end of the
+	// image, e.g. polyglots that are also a script or an archive.
+	StrictImageStructure bool `mapstructure:"strict_image_structure"`
+	// TransliterateFilenames keeps storage keys and download names ASCII;
+	// the uploaded name is still stored as-is for display.
+	TransliterateFilenames bool `mapstructure:"transliterate_filenames"`
+	// DeletedRetentionDays is how long soft-deleted images stay recoverable
+	// before the worker purges them; 0 keeps them forever.
+	DeletedRetentionDays int `mapstructure:"deleted_retention_days"`
+	// SyncMaxBytes turns on hybrid processing: the API processes uploads
+	// up to this size itself while fewer than SyncMaxPending images wait
+	// in the queue ahead of them; 0 keeps every upload asynchronous.
+	SyncMaxBytes   int64 `mapstructure:"sync_max_bytes"`
+	SyncMaxPending int   `mapstructure:"sync_max_pending"`
+	// DedupUploads answers an upload with the tenant's earlier image of
+	// the same bytes and processing instead of storing it again.
+	DedupUploads bool `mapstructure:"dedup_uploads"`
+	// SyncThumbnail renders a thumbnail during the upload request, linked
+	// from the response while the full processing is still queued.
+	SyncThumbnail bool `mapstructure:"sync_thumbnail"`
+	// OnTheFly* bound GET /image/:id/original?w=&h=; a zero maximum means
+	// MaxTargetDimension, and a non-empty OnTheFlySizes ("300x200", "640x0")
+	// is the only set of boxes served. OnTheFlyMaxConcurrent caps the
+	// resizes rendered at once; 0 means one per CPU. OnTheFlyWebP serves
+	// the resizes as WebP to clients whose Accept header lists image/webp.
+	OnTheFlyMaxWidth      int      `mapstructure:"on_the_fly_max_width"`
+	OnTheFlyMaxHeight     int      `mapstructure:"on_the_fly_max_height"`
+	OnTheFlySizes         []string `mapstructure:"on_the_fly_sizes"`
+	OnTheFlyMaxConcurrent int      `mapstructure:"on_the_fly_max_concurrent"`
+	OnTheFlyWebP          bool     `mapstructure:"on_the_fly_webp"`
+
+	Variants []VariantConfig `mapstructure:"variants"`
+}
+
+// VariantConfig describes an extra rendition generated for every processed
+// image, fitted into Width x Height and encoded as Format.
+type VariantConfig struct {
+	Name   string `mapstructure:"name"`
+	Width  int    `mapstructure:"width"`
+	Height int    `mapstructure:"height"`
+	Format string `mapstructure:"format"`
+}
+
+func (c *ProcessingConfig) AspectRatioPolicy() domain.AspectRatioPolicy {
+	return domain.AspectRatioPolicy{
+		Ratio:     c.RequiredAspect,
+		Tolerance: c.AspectTolerance,
+		Crop:      c.AspectMode == "crop",
+	}
+}
+
+// WatermarkRGBA parses WatermarkColor as #RRGGBB or #RRGGBBAA; empty means
+// opaque red, matching the README's watermark sample.
+func (c *ProcessingConfig) WatermarkRGBA() (color.NRGBA, error) {
+	if c.WatermarkColor == "" {
+		return color.NRGBA{R: 255, A: 255}, nil
+	}
+	return parseHexColor(c.WatermarkColor)
+}
+
+// ThumbnailPadRGBA parses ThumbnailPadColor like WatermarkColor. ok is
+// false when it is empty and thumbnails keep their fitted size.
+func (c *ProcessingConfig) ThumbnailPadRGBA() (col color.NRGBA, ok bool, err error) {
+	if c.ThumbnailPadColor == "" {
+		return color.NRGBA{}, false, nil
+	}
+	col, err = parseHexColor(c.ThumbnailPadColor)
+	return col, err == nil, err
+}
+
+func parseHexColor(s string) (color.NRGBA, error) {
+	raw := strings.TrimPrefix(s, "#")
+	if len(raw) != 6 && len(raw) != 8 {
+		return color.NRGBA{}, fmt.Errorf("color %q must be #RRGGBB or #RRGGBBAA", s)
+	}
+	if len(raw) == 6 {
+		raw += "ff"
+	}
+
+	v, err := strconv.ParseUint(raw, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("color %q is not hexadecimal", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// EncryptionKeyBytes returns the configured encryption key. EncryptionKeyFile
+// wins over EncryptionKey so a mounted secret needs no further config.
+func (c *StorageConfig) EncryptionKeyBytes() ([]byte, error) {
+	var key []byte
+	if c.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(c.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption key file: %w", err)
+		}
+		key = data
+	} else {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(c.EncryptionKey))
+		if err != nil {
+			return nil, fmt.Errorf("encryption key must be base64: %w", err)
+		}
+		key = decoded
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("encryption key must be 16, 24 or 32 bytes, got %d", len(key))
+	}
+}
+
+type WorkerConfig struct {
+	// MetricsAddr is where the worker serves GET /metrics; empty disables it.
+	MetricsAddr string `mapstructure:"metrics_addr"`
+	// DebugAddr is where the worker serves GET /debug/current with the
+	// tasks it is running and the last ones it finished; empty disables it.
+	DebugAddr string `mapstructure:"debug_addr"`
+	// ShutdownTimeoutSec bounds how long a stopping worker waits for the
+	// tasks in flight; 0 means 30. Tasks still running after it are left
+	// uncommitted and redelivered.
+	ShutdownTimeoutSec int `mapstructure:"shutdown_timeout_sec"`
+	// OrphanCleanupIntervalSec is how often the worker deletes stored
+	// files no record refers to; 0 disables the cleanup. Files younger
+	// than OrphanGraceHours (0 means 24) are kept, as an upload stores
+	// its original before the record.
+	OrphanCleanupIntervalSec int `mapstructure:"orphan_cleanup_interval_sec"`
+	OrphanGraceHours         int `mapstructure:"orphan_grace_hours"`
+}
+
+type WebhookConfig struct {
+	URL        string `mapstructure:"url"`
+	Secret     string `mapstructure:"secret"`
+	TimeoutSec int    `mapstructure:"timeout_sec"`
+	// CallbackAttempts bounds the deliveries to an upload's callback_url;
+	// 0 means 3. PublicBaseURL prefixes the processed_url it is sent, which
+	// is relative when empty.
+	CallbackAttempts int    `mapstructure:"callback_attempts"`
+	PublicBaseURL    string `mapstructure:"public_base_url"`
+}
+
+// ResizePolicy parses the on-the-fly resize limits.
+func (c *ProcessingConfig) ResizePolicy() (domain.ResizePolicy, error) {
+	policy := domain.ResizePolicy{
+		MaxWidth:  c.OnTheFlyMaxWidth,
+		MaxHeight: c.OnTheFlyMaxHeight,
+	}
+	if policy.MaxWidth == 0 {
+		policy.MaxWidth = domain.MaxTargetDimension
+	}
+	if policy.MaxHeight == 0 {
+		policy.MaxHeight = domain.MaxTargetDimension
+	}
+
+	for _, raw := range c.OnTheFlySizes {
+		w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(raw)), "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width < 0 || height < 0 || (width == 0 && height == 0) {
+			return domain.ResizePolicy{}, fmt.Errorf("size %q must be WxH, with 0 for an omitted side", raw)
+		}
+		policy.Sizes = append(policy.Sizes, domain.ResizeSize{Width: width, Height: height})
+	}
+
+	return policy, nil
+}
+
+// RetryPolicy builds the retry schedule, filling in the defaults for a
+// zero max_attempts or empty retry_delays_sec.
+func (c *ProcessingConfig) RetryPolicy() domain.RetryPolicy {
+	policy := domain.RetryPolicy{MaxAttempts: c.MaxAttempts}
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = 4
+	}
+	delays := c.RetryDelaysSec
+	if len(delays) == 0 {
+		delays = []int{60, 300, 1800}
+	}
+	for _, d := range delays {
+		policy.Delays = append(policy.Delays, time.Duration(d)*time.Second)
+	}
+	return policy
+}
+
+func (c *ProcessingConfig) TenantRetentionPolicy() domain.TenantRetentionPolicy {
+	return domain.TenantRetentionPolicy{
+		MaxOriginals: c.TenantMaxOriginals,
+		Reject:       c.TenantOverCap == "reject",
+	}
+}
+
+type AdminConfig struct {
+	Token          string `mapstructure:"token"`
+	ManifestSecret string `mapstructure:"manifest_secret"`
 }
 
 type LoggingConfig struct {
@@ -102,6 +495,11 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// privacy-sensitive, so on unless explicitly turned off
+	cfg.SetDefault("processing.strip_metadata", true)
+	// deleted images must stay recoverable unless retention is set to 0
+	cfg.SetDefault("processing.deleted_retention_days", 30)
+
 	appConfig := &Config{}
 	if err := cfg.Unmarshal(appConfig); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
@@ -130,6 +528,18 @@ func validateConfig(cfg *Config) error {
 	if cfg.Server.ShutdownTimeoutSec <= 0 {
 		return fmt.Errorf("server.shutdown_timeout_sec must be positive")
 	}
+	if cfg.Server.DrainTimeoutSec < 0 {
+		return fmt.Errorf("server.drain_timeout_sec must be non-negative")
+	}
+	if cfg.Server.DefaultPageLimit < 0 || cfg.Server.MaxPageLimit < 0 {
+		return fmt.Errorf("server.default_page_limit and server.max_page_limit must be non-negative")
+	}
+	if cfg.Server.MaxArchiveSizeMB < 0 || cfg.Server.MaxArchiveUncompressedMB < 0 || cfg.Server.MaxArchiveFiles < 0 {
+		return fmt.Errorf("server.max_archive_size_mb, server.max_archive_uncompressed_mb and server.max_archive_files must be non-negative")
+	}
+	if p := cfg.Server.Pagination(); p.DefaultLimit > p.MaxLimit {
+		return fmt.Errorf("server.default_page_limit must not exceed server.max_page_limit")
+	}
 	if cfg.Server.ReadTimeoutSec <= 0 {
 		return fmt.Errorf("server.read_timeout_sec must be positive")
 	}
@@ -139,6 +549,31 @@ func validateConfig(cfg *Config) error {
 	if cfg.Server.MaxUploadSizeMB <= 0 {
 		return fmt.Errorf("server.max_upload_size_mb must be positive")
 	}
+	if cfg.Server.PreviewTimeoutSec < 0 {
+		return fmt.Errorf("server.preview_timeout_sec must be non-negative")
+	}
+	if cfg.Server.MaxInFlight < 0 {
+		return fmt.Errorf("server.max_in_flight must be non-negative")
+	}
+	if cfg.Server.MaxSizePNGMB < 0 {
+		return fmt.Errorf("server.max_size_png_mb must be non-negative")
+	}
+	if cfg.Server.MaxSizeJPEGMB < 0 {
+		return fmt.Errorf("server.max_size_jpeg_mb must be non-negative")
+	}
+	if cfg.Server.URLFetchTimeoutSec < 0 {
+		return fmt.Errorf("server.url_fetch_timeout_sec must be non-negative")
+	}
+	if cfg.Server.MaxBatchFiles < 0 {
+		return fmt.Errorf("server.max_batch_files must be non-negative")
+	}
+	if cfg.Server.OverloadRetrySec < 0 {
+		return fmt.Errorf("server.overload_retry_sec must be non-negative")
+	}
+	rt := cfg.Server.RouteTimeouts
+	if rt.UploadSec < 0 || rt.ProcessingSec < 0 || rt.ReadSec < 0 || rt.AdminSec < 0 {
+		return fmt.Errorf("server.route_timeouts values must be non-negative")
+	}
 
 	// Database
 	if cfg.Database.DSN == "" {
@@ -166,17 +601,26 @@ func validateConfig(cfg *Config) error {
 	if cfg.Kafka.GroupID == "" {
 		return fmt.Errorf("kafka.group_id is required")
 	}
+	if high := cfg.Kafka.HighPriorityTopic; high != "" && (high == cfg.Kafka.Topic || high == cfg.Kafka.DeadLetterTopicName()) {
+		return fmt.Errorf("kafka.high_priority_topic must differ from kafka.topic and the dead-letter topic")
+	}
 
 	// Storage
 	if cfg.Storage.Type == "" {
-		return fmt.Errorf("storage.type is required (local|s3)")
+		return fmt.Errorf("storage.type is required (local|s3|memory)")
 	}
-	if cfg.Storage.Type != "local" && cfg.Storage.Type != "s3" {
-		return fmt.Errorf("storage.type must be 'local' or 's3'")
+	switch cfg.Storage.Type {
+	case "local", "s3", "memory":
+	default:
+		return fmt.Errorf("storage.type must be 'local', 's3' or 'memory'")
 	}
 	if cfg.Storage.Type == "local" && cfg.Storage.LocalPath == "" {
 		return fmt.Errorf("storage.local_path is required for local storage")
 	}
+	// S3 rejects presigned URLs valid for more than 7 days
+	if cfg.Storage.PresignedURLExpirySec < 0 || cfg.Storage.PresignedURLExpirySec > 7*24*3600 {
+		return fmt.Errorf("storage.presigned_url_expiry_sec must be between 0 and 604800")
+	}
 
 	// Processing
 	if cfg.Processing.ResizeWidth <= 0 {
@@ -191,16 +635,215 @@ func validateConfig(cfg *Config) error {
 	if cfg.Processing.ThumbnailHeight <= 0 {
 		return fmt.Errorf("processing.thumbnail_height must be positive")
 	}
+	if cfg.Processing.CropWidth < 0 || cfg.Processing.CropHeight < 0 {
+		return fmt.Errorf("processing.crop_width and processing.crop_height must be non-negative")
+	}
+	if cfg.Processing.WatermarkFontSize < 0 || cfg.Processing.WatermarkFontSize > 512 {
+		return fmt.Errorf("processing.watermark_font_size must be between 0 and 512")
+	}
+	if _, err := cfg.Processing.WatermarkRGBA(); err != nil {
+		return fmt.Errorf("processing.watermark_color: %w", err)
+	}
+	if _, _, err := cfg.Processing.ThumbnailPadRGBA(); err != nil {
+		return fmt.Errorf("processing.thumbnail_pad_color: %w", err)
+	}
+	if cfg.Processing.OutputQuality != 0 && (cfg.Processing.OutputQuality < 1 || cfg.Processing.OutputQuality > 100) {
+		return fmt.Errorf("processing.output_quality must be between 1 and 100")
+	}
+	if cfg.Processing.MaxCompressionRatio < 0 {
+		return fmt.Errorf("processing.max_compression_ratio must not be negative")
+	}
+	if cfg.Processing.MaxDecodeSec < 0 {
+		return fmt.Errorf("processing.max_decode_sec must be non-negative")
+	}
+	if cfg.Processing.MaxPixels < 0 {
+		return fmt.Errorf("processing.max_pixels must not be negative")
+	}
+	if cfg.Processing.MaxConcurrentProcessing < 0 {
+		return fmt.Errorf("processing.max_concurrent_processing must not be negative")
+	}
+	if cfg.Processing.VariantLeaseSec < 0 {
+		return fmt.Errorf("processing.variant_lease_sec must not be negative")
+	}
+	switch cfg.Processing.OutputFormat {
+	case "", "jpeg", "jpg", "png", "gif", "original":
+	default:
+		return fmt.Errorf("processing.output_format must be 'jpeg', 'png', 'gif' or 'original'")
+	}
+	if cfg.Processing.MinOutputBytes < 0 {
+		return fmt.Errorf("processing.min_output_bytes must be non-negative")
+	}
+	if cfg.Processing.RoundedCropRadius < 0 {
+		return fmt.Errorf("processing.rounded_crop_radius must be non-negative")
+	}
+	if cfg.Processing.DenoiseRadius < 0 || cfg.Processing.DenoiseRadius > 5 {
+		return fmt.Errorf("processing.denoise_radius must be between 0 and 5")
+	}
+	if cfg.Kafka.RequeueBackoffMS < 0 {
+		return fmt.Errorf("kafka.requeue_backoff_ms must be non-negative")
+	}
+	if cfg.Kafka.BackpressureLatencyMS < 0 || cfg.Kafka.BackpressurePauseMS < 0 {
+		return fmt.Errorf("kafka.backpressure_latency_ms and kafka.backpressure_pause_ms must be non-negative")
+	}
+	if cfg.Kafka.WorkerPoolSize < 0 {
+		return fmt.Errorf("kafka.worker_pool_size must be non-negative")
+	}
+	if cfg.Kafka.MaxProcessingAttempts < 0 {
+		return fmt.Errorf("kafka.max_processing_attempts must be non-negative")
+	}
+	if cfg.Kafka.DeadLetterTopic == cfg.Kafka.Topic {
+		return fmt.Errorf("kafka.dead_letter_topic must differ from kafka.topic")
+	}
+	if cfg.Storage.EncryptionEnabled {
+		if _, err := cfg.Storage.EncryptionKeyBytes(); err != nil {
+			return fmt.Errorf("storage.encryption_key: %w", err)
+		}
+		// the disk caches hold decrypted copies, which would defeat encryption at rest
+		if cfg.Storage.VariantCacheMaxMB > 0 || cfg.Storage.ResizeCacheMaxMB > 0 {
+			return fmt.Errorf("storage.variant_cache_max_mb and storage.resize_cache_max_mb must be 0 with storage.encryption_enabled")
+		}
+	}
+	if cfg.Storage.VariantCacheMaxMB < 0 {
+		return fmt.Errorf("storage.variant_cache_max_mb must be non-negative")
+	}
+	if cfg.Storage.VariantCacheMaxMB > 0 && cfg.Storage.VariantCacheDir == "" {
+		return fmt.Errorf("storage.variant_cache_dir is required when storage.variant_cache_max_mb is set")
+	}
+	if cfg.Storage.ResizeCacheMaxMB < 0 {
+		return fmt.Errorf("storage.resize_cache_max_mb must be non-negative")
+	}
+	if cfg.Storage.ResizeCacheMaxMB > 0 && cfg.Storage.ResizeCacheDir == "" {
+		return fmt.Errorf("storage.resize_cache_dir is required when storage.resize_cache_max_mb is set")
+	}
 	if cfg.Storage.Type == "s3" {
 		if cfg.Storage.S3Endpoint == "" {
 			return fmt.Errorf("storage.s3_endpoint is required for s3 storage")
 		}
+		if !validS3StorageClass(cfg.Storage.S3OriginalStorageClass) {
+			return fmt.Errorf("storage.s3_original_storage_class %q is not a known S3 storage class", cfg.Storage.S3OriginalStorageClass)
+		}
+		if !validS3StorageClass(cfg.Storage.S3ProcessedStorageClass) {
+			return fmt.Errorf("storage.s3_processed_storage_class %q is not a known S3 storage class", cfg.Storage.S3ProcessedStorageClass)
+		}
 		if cfg.Storage.S3Bucket == "" {
 			return fmt.Errorf("storage.s3_bucket is required for s3 storage")
 		}
 		if cfg.Storage.S3AccessKey == "" || cfg.Storage.S3SecretKey == "" {
 			return fmt.Errorf("storage.s3_access_key and storage.s3_secret_key are required for s3 storage")
 		}
+		if cfg.Storage.S3FallbackLocal && cfg.Storage.LocalPath == "" {
+			return fmt.Errorf("storage.local_path is required for storage.s3_fallback_local")
+		}
+		if cfg.Storage.S3LocalBackend && cfg.Storage.LocalPath == "" {
+			return fmt.Errorf("storage.local_path is required for storage.s3_local_backend")
+		}
+		if cfg.Storage.S3FallbackRetrySec < 0 {
+			return fmt.Errorf("storage.s3_fallback_retry_sec must be non-negative")
+		}
+	}
+
+	if cfg.Processing.MaxAttempts < 0 {
+		return fmt.Errorf("processing.max_attempts must be non-negative")
+	}
+	for _, d := range cfg.Processing.RetryDelaysSec {
+		if d <= 0 {
+			return fmt.Errorf("processing.retry_delays_sec must contain only positive values")
+		}
+	}
+	if cfg.Processing.RetryPollSec < 0 {
+		return fmt.Errorf("processing.retry_poll_sec must be non-negative")
+	}
+	for _, name := range cfg.Processing.PreserveMetadata {
+		switch name {
+		case "icc_profile", "copyright", "artist", "image_description", "make", "model", "software", "datetime":
+		default:
+			return fmt.Errorf("processing.preserve_metadata entry %q must be one of icc_profile, copyright, artist, image_description, make, model, software, datetime", name)
+		}
+	}
+	if cfg.Processing.SyncMaxBytes < 0 {
+		return fmt.Errorf("processing.sync_max_bytes must be non-negative")
+	}
+	if cfg.Processing.SyncMaxPending < 0 {
+		return fmt.Errorf("processing.sync_max_pending must be non-negative")
+	}
+	if cfg.Processing.DeletedRetentionDays < 0 {
+		return fmt.Errorf("processing.deleted_retention_days must be non-negative")
+	}
+	if cfg.Processing.RequiredAspect < 0 {
+		return fmt.Errorf("processing.required_aspect_ratio must be non-negative")
+	}
+	if cfg.Processing.AspectTolerance < 0 {
+		return fmt.Errorf("processing.aspect_tolerance must be non-negative")
+	}
+	switch cfg.Processing.AspectMode {
+	case "", "reject", "crop":
+	default:
+		return fmt.Errorf("processing.aspect_mode must be 'reject' or 'crop'")
+	}
+
+	// Worker
+	if cfg.Worker.ShutdownTimeoutSec < 0 {
+		return fmt.Errorf("worker.shutdown_timeout_sec must be non-negative")
+	}
+	if cfg.Worker.OrphanCleanupIntervalSec < 0 {
+		return fmt.Errorf("worker.orphan_cleanup_interval_sec must be non-negative")
+	}
+	if cfg.Worker.OrphanGraceHours < 0 {
+		return fmt.Errorf("worker.orphan_grace_hours must be non-negative")
+	}
+
+	// Webhook
+	if cfg.Webhook.TimeoutSec < 0 {
+		return fmt.Errorf("webhook.timeout_sec must be non-negative")
+	}
+	if cfg.Webhook.CallbackAttempts < 0 || cfg.Webhook.CallbackAttempts > 10 {
+		return fmt.Errorf("webhook.callback_attempts must be between 0 and 10")
+	}
+	if cfg.Webhook.URL != "" && cfg.Webhook.Secret == "" {
+		zlog.Logger.Warn().Msg("webhook.secret is empty, callbacks will be sent unsigned")
+	}
+
+	if cfg.Processing.TenantMaxOriginals < 0 {
+		return fmt.Errorf("processing.tenant_max_originals must be non-negative")
+	}
+	switch cfg.Processing.TenantOverCap {
+	case "", "prune", "reject":
+	default:
+		return fmt.Errorf("processing.tenant_over_cap must be 'prune' or 'reject'")
+	}
+
+	if cfg.Processing.OnTheFlyMaxWidth < 0 || cfg.Processing.OnTheFlyMaxHeight < 0 {
+		return fmt.Errorf("processing.on_the_fly_max_width and processing.on_the_fly_max_height must be non-negative")
+	}
+	if cfg.Processing.OnTheFlyMaxConcurrent < 0 {
+		return fmt.Errorf("processing.on_the_fly_max_concurrent must not be negative")
+	}
+	if _, err := cfg.Processing.ResizePolicy(); err != nil {
+		return fmt.Errorf("processing.on_the_fly_sizes: %w", err)
+	}
+
+	for name, steps := range cfg.Processing.Pipelines {
+		if _, err := domain.ParsePipeline(steps); err != nil {
+			return fmt.Errorf("processing.pipelines.%s: %w", name, err)
+		}
+	}
+
+	seenVariants := make(map[string]bool, len(cfg.Processing.Variants))
+	for _, v := range cfg.Processing.Variants {
+		if v.Name == "" || seenVariants[v.Name] {
+			return fmt.Errorf("processing.variants names must be non-empty and unique")
+		}
+		seenVariants[v.Name] = true
+		if v.Width <= 0 || v.Height <= 0 {
+			return fmt.Errorf("processing.variants[%s] width and height must be positive", v.Name)
+		}
+		switch v.Format {
+		case "jpeg", "jpg", "png", "gif", "webp":
+		case "avif":
+			return fmt.Errorf("processing.variants[%s]: avif output needs an encoder that is not included in this build", v.Name)
+		default:
+			return fmt.Errorf("processing.variants[%s] format must be one of: jpeg, png, gif, webp", v.Name)
+		}
 	}
 
 	if len(cfg.Processing.SupportedFormats) == 0 {
@@ -212,3 +855,15 @@ func validateConfig(cfg *Config) error {
 
 	return nil
 }
+
+// validS3StorageClass accepts the empty value (bucket default) and the
+// storage classes understood by S3 PutObject.
+func validS3StorageClass(class string) bool {
+	switch class {
+	case "", "STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA",
+		"INTELLIGENT_TIERING", "GLACIER_IR", "GLACIER", "DEEP_ARCHIVE":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// loadRepoConfig loads the config.yaml shipped with the repository, which
+// must itself be valid, for tests to vary one setting at a time.
+func loadRepoConfig(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Load("../../config.yaml")
+	if err != nil {
+		t.Fatalf("load config.yaml: %v", err)
+	}
+	return cfg
+}
+
+func TestValidateVariantFormats(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr string
+	}{
+		{format: "jpeg"},
+		{format: "png"},
+		{format: "gif"},
+		{format: "webp"},
+		{format: "avif", wantErr: "avif output needs an encoder"},
+		{format: "bmp", wantErr: "format must be one of"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cfg := loadRepoConfig(t)
+			cfg.Processing.Variants = []VariantConfig{{Name: "thumb", Width: 320, Height: 240, Format: tt.format}}
+
+			err := validateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateConfig error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateEncryptionRejectsPlaintextCaches(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	tests := []struct {
+		name        string
+		variantMB   int
+		resizeMB    int
+		wantInvalid bool
+	}{
+		{name: "caches disabled"},
+		{name: "variant cache", variantMB: 64, wantInvalid: true},
+		{name: "resize cache", resizeMB: 64, wantInvalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadRepoConfig(t)
+			cfg.Storage.EncryptionEnabled = true
+			cfg.Storage.EncryptionKey = key
+			cfg.Storage.VariantCacheMaxMB = tt.variantMB
+			cfg.Storage.ResizeCacheMaxMB = tt.resizeMB
+
+			err := validateConfig(cfg)
+			if tt.wantInvalid != (err != nil) {
+				t.Fatalf("validateConfig error = %v, want invalid %v", err, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestValidateS3LocalBackendNeedsLocalPath(t *testing.T) {
+	cfg := loadRepoConfig(t)
+	cfg.Storage.Type = "s3"
+	cfg.Storage.S3LocalBackend = true
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("validateConfig: %v", err)
+	}
+
+	cfg.Storage.LocalPath = ""
+	if err := validateConfig(cfg); err == nil {
+		t.Fatal("s3_local_backend without local_path accepted")
+	}
+}
+
+func TestServerPagination(t *testing.T) {
+	tests := []struct {
+		name                   string
+		defaultLimit, maxLimit int
+		want                   domain.Pagination
+		wantErr                bool
+	}{
+		{name: "unset", want: domain.Pagination{DefaultLimit: 10, MaxLimit: 100}},
+		{name: "configured", defaultLimit: 25, maxLimit: 50, want: domain.Pagination{DefaultLimit: 25, MaxLimit: 50}},
+		{name: "only max", maxLimit: 500, want: domain.Pagination{DefaultLimit: 10, MaxLimit: 500}},
+		{name: "default above max", defaultLimit: 60, maxLimit: 50, wantErr: true},
+		{name: "default above implied max", defaultLimit: 200, wantErr: true},
+		{name: "negative", defaultLimit: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadRepoConfig(t)
+			cfg.Server.DefaultPageLimit = tt.defaultLimit
+			cfg.Server.MaxPageLimit = tt.maxLimit
+
+			err := validateConfig(cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("validateConfig accepted the page limits")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateConfig: %v", err)
+			}
+			if got := cfg.Server.Pagination(); got != tt.want {
+				t.Errorf("Pagination() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePipelines(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []string
+		wantErr string
+	}{
+		{name: "valid", steps: []string{"autoorient", "resize:1200", "crop:800x600", "rotate:-90", "denoise:2", "watermark", "optimize"}},
+		{name: "empty", steps: nil, wantErr: "no steps"},
+		{name: "unknown step", steps: []string{"resize", "sharpen"}, wantErr: "unknown pipeline step"},
+		{name: "odd angle", steps: []string{"rotate:45"}, wantErr: domain.ErrInvalidAngle.Error()},
+		{name: "parameter on a bare step", steps: []string{"watermark:2"}, wantErr: "takes no parameter"},
+		{name: "quality out of range", steps: []string{"quality:0"}, wantErr: "between 1 and 100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadRepoConfig(t)
+			cfg.Processing.Pipelines = map[string][]string{"product": tt.steps}
+
+			err := validateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "processing.pipelines.product") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateConfig error = %v, want it to name the pipeline and mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateS3StorageClasses(t *testing.T) {
+	tests := []struct {
+		name                string
+		original, processed string
+		wantErr             string
+	}{
+		{name: "bucket default"},
+		{name: "per kind", original: "STANDARD_IA", processed: "GLACIER_IR"},
+		{name: "unknown original class", original: "COLD", wantErr: "storage.s3_original_storage_class"},
+		{name: "lowercase processed class", processed: "standard", wantErr: "storage.s3_processed_storage_class"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadRepoConfig(t)
+			cfg.Storage.Type = "s3"
+			cfg.Storage.S3Endpoint = "localhost:9000"
+			cfg.Storage.S3Bucket = "images"
+			cfg.Storage.S3OriginalStorageClass = tt.original
+			cfg.Storage.S3ProcessedStorageClass = tt.processed
+
+			err := validateConfig(cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateConfig: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateConfig error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
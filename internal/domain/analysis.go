@@ -0,0 +1,19 @@
+package domain
+
+const (
+	ChannelRed       = "r"
+	ChannelGreen     = "g"
+	ChannelBlue      = "b"
+	ChannelLuminance = "luminance"
+)
+
+// HistogramChannels lists histogram channels in presentation order.
+var HistogramChannels = []string{ChannelRed, ChannelGreen, ChannelBlue, ChannelLuminance}
+
+// Histogram holds per-channel pixel counts split into equal-width buckets
+// over the 0-255 range.
+type Histogram struct {
+	Buckets  int
+	Pixels   int
+	Channels map[string][]int
+}
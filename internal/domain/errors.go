@@ -3,13 +3,34 @@ package domain
 import "errors"
 
 var (
-	ErrImageNotFound         = errors.New("image not found")
-	ErrInvalidFormat         = errors.New("invalid or unsupported image format")
-	ErrFileTooLarge          = errors.New("file size exceeds maximum allowed")
-	ErrInvalidImageData      = errors.New("invalid image data")
-	ErrProcessingFailed      = errors.New("image processing failed")
-	ErrStorageFailed         = errors.New("storage operation failed")
-	ErrQueueFailed           = errors.New("queue operation failed")
-	ErrAlreadyProcessing     = errors.New("image is already being processed")
-	ErrInvalidProcessingType = errors.New("invalid processing type")
+	ErrImageNotFound            = errors.New("image not found")
+	ErrJobNotFound              = errors.New("job not found")
+	ErrVariantNotFound          = errors.New("image variant not found")
+	ErrThumbnailNotFound        = errors.New("image thumbnail not found")
+	ErrBackendNotConfigured     = errors.New("storage backend is not configured")
+	ErrBackendUnavailable       = errors.New("storage backend is unavailable")
+	ErrProcessedVariantNotFound = errors.New("processed variant not found")
+	ErrInvalidVariantName       = errors.New("invalid processed variant name")
+	ErrInvalidFormat            = errors.New("invalid or unsupported image format")
+	ErrFileTooLarge             = errors.New("file size exceeds maximum allowed")
+	ErrInvalidImageData         = errors.New("invalid image data")
+	ErrProcessingFailed         = errors.New("image processing failed")
+	ErrStorageFailed            = errors.New("storage operation failed")
+	ErrQueueFailed              = errors.New("queue operation failed")
+	ErrAlreadyProcessing        = errors.New("image is already being processed")
+	ErrInvalidProcessingType    = errors.New("invalid processing type")
+	ErrInvalidSort              = errors.New("invalid sort field or order")
+	ErrImageNotPending          = errors.New("image is not pending")
+	ErrImageNotProcessed        = errors.New("image is not processed yet")
+	ErrInvalidAspectRatio       = errors.New("image aspect ratio is not allowed")
+	ErrTenantQuotaExceeded      = errors.New("tenant image quota exceeded")
+	ErrInvalidAngle             = errors.New("rotation angle must be a multiple of 90")
+	ErrInvalidFlipAxis          = errors.New("flip axis must be horizontal, vertical or both")
+	ErrUnknownPipeline          = errors.New("unknown processing pipeline")
+	ErrSpriteTooLarge           = errors.New("sprite sheet exceeds the maximum size")
+	ErrInvalidPage              = errors.New("page does not exist in the image")
+	ErrInvalidImageStructure    = errors.New("file contains data outside the image structure")
+	ErrResizeNotAllowed         = errors.New("resize parameters are not allowed")
+	ErrTooManyPixels            = errors.New("image dimensions exceed the maximum pixel count")
+	ErrDecodeTimeout            = errors.New("image decode exceeded the time limit")
 )
@@ -1,6 +1,11 @@
 package domain
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"strings"
 	"time"
 )
 
@@ -11,37 +16,381 @@ const (
 	StatusProcessing ProcessingStatus = "processing"
 	StatusCompleted  ProcessingStatus = "completed"
 	StatusFailed     ProcessingStatus = "failed"
+	// StatusDeadLettered means every processing attempt failed; the image
+	// is no longer retried automatically.
+	StatusDeadLettered ProcessingStatus = "dead_lettered"
 )
 
+func (s ProcessingStatus) IsValid() bool {
+	switch s {
+	case StatusPending, StatusProcessing, StatusCompleted, StatusFailed, StatusDeadLettered:
+		return true
+	}
+	return false
+}
+
+// FailureCategory groups processing failures for dashboards; the free-form
+// cause stays in ErrorMessage.
+type FailureCategory string
+
+const (
+	FailureDecode            FailureCategory = "decode_error"
+	FailureUnsupportedFormat FailureCategory = "unsupported_format"
+	FailureStorage           FailureCategory = "storage_error"
+	FailureTimeout           FailureCategory = "timeout"
+	FailureDecodeTimeout     FailureCategory = "decode_timeout"
+	FailureOOM               FailureCategory = "oom"
+	FailureTooLarge          FailureCategory = "too_large"
+	FailureUnknown           FailureCategory = "unknown"
+)
+
+// Retryable reports whether another attempt can succeed; an image over
+// the pixel limit stays over it, and one that stalled the decoder stalls it
+// again.
+func (c FailureCategory) Retryable() bool {
+	return c != FailureTooLarge && c != FailureDecodeTimeout
+}
+
 type ProcessingType string
 
 const (
 	ProcessingResize    ProcessingType = "resize"
 	ProcessingThumbnail ProcessingType = "thumbnail"
 	ProcessingWatermark ProcessingType = "watermark"
+	ProcessingRotate    ProcessingType = "rotate"
+	ProcessingFlip      ProcessingType = "flip"
+	ProcessingCrop      ProcessingType = "crop"
+	ProcessingDenoise   ProcessingType = "denoise"
+	// ProcessingRoundedCrop makes the corners transparent, or cuts a circle,
+	// and is always stored as PNG.
+	ProcessingRoundedCrop ProcessingType = "rounded_crop"
+	// ProcessingPipeline runs the configured pipeline named in
+	// ProcessingOptions.Pipeline.
+	ProcessingPipeline ProcessingType = "pipeline"
+)
+
+func (t ProcessingType) IsValid() bool {
+	switch t {
+	case ProcessingResize, ProcessingThumbnail, ProcessingWatermark, ProcessingRotate, ProcessingFlip, ProcessingCrop, ProcessingDenoise, ProcessingRoundedCrop, ProcessingPipeline:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProcessingOptions carries per-request overrides of the processing config.
+// Nil or zero fields mean "use the configured default".
+type ProcessingOptions struct {
+	Flatten *bool `json:"flatten,omitempty"`
+	// Angle is the clockwise rotation in degrees for the rotate type,
+	// a multiple of 90 relative to the upright (EXIF-oriented) image.
+	Angle *int `json:"angle,omitempty"`
+	// Flip is the mirror axis of the flip type; unset means horizontal.
+	Flip *FlipAxis `json:"flip,omitempty"`
+	// Pipeline names a pipeline from processing.pipelines for the
+	// pipeline type.
+	Pipeline *string `json:"pipeline,omitempty"`
+	// Width and Height replace the configured box of resize and thumbnail
+	// for one image. With only one of them set, the other side follows the
+	// aspect ratio.
+	Width  *int `json:"width,omitempty"`
+	Height *int `json:"height,omitempty"`
+	// Page is the 1-based page of a multi-page TIFF to process.
+	Page *int `json:"page,omitempty"`
+	// Radius is the corner radius in pixels for rounded_crop; 0 cuts a
+	// circle.
+	Radius *int `json:"radius,omitempty"`
+	// Quality is the JPEG quality of the result, 1-100.
+	Quality *int `json:"quality,omitempty"`
+	// WatermarkText replaces the configured watermark of the watermark
+	// type with this text.
+	WatermarkText *string `json:"watermark_text,omitempty"`
+	// Priority picks the queue of the upload's task; it is not stored, so
+	// retries are queued at normal priority.
+	Priority Priority `json:"-"`
+	// CallbackURL is stored on the uploaded Image, see Image.CallbackURL.
+	CallbackURL string `json:"-"`
+}
+
+// FlipAxis is the axis the flip type mirrors the image across.
+type FlipAxis string
+
+const (
+	// FlipHorizontal mirrors left and right.
+	FlipHorizontal FlipAxis = "horizontal"
+	// FlipVertical mirrors top and bottom.
+	FlipVertical FlipAxis = "vertical"
+	// FlipBoth mirrors both, the same as rotating by 180 degrees.
+	FlipBoth FlipAxis = "both"
+)
+
+// ParseFlipAxis accepts the known axes and their initials h and v in any
+// case.
+func ParseFlipAxis(raw string) (FlipAxis, bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "h", string(FlipHorizontal):
+		return FlipHorizontal, true
+	case "v", string(FlipVertical):
+		return FlipVertical, true
+	case string(FlipBoth):
+		return FlipBoth, true
+	default:
+		return "", false
+	}
+}
+
+// Priority is the queue priority of a processing task.
+type Priority string
+
+const (
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
 )
 
+// ParsePriority accepts the known levels in any case; empty is normal.
+func ParsePriority(raw string) (Priority, bool) {
+	switch Priority(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", PriorityNormal:
+		return PriorityNormal, true
+	case PriorityHigh:
+		return PriorityHigh, true
+	default:
+		return "", false
+	}
+}
+
+// MaxTargetDimension bounds per-request Width and Height.
+const MaxTargetDimension = 10000
+
+// ValidTargetDimension reports whether v is usable as Width or Height.
+func ValidTargetDimension(v int) bool {
+	return v > 0 && v <= MaxTargetDimension
+}
+
+// MaxPageNumber bounds Page and the pages read from a TIFF.
+const MaxPageNumber = 10000
+
+// ValidPageNumber reports whether v is usable as Page.
+func ValidPageNumber(v int) bool {
+	return v >= 1 && v <= MaxPageNumber
+}
+
+// ValidQuality reports whether v is usable as Quality.
+func ValidQuality(v int) bool {
+	return v >= 1 && v <= 100
+}
+
+// MaxWatermarkTextLength bounds WatermarkText, in characters.
+const MaxWatermarkTextLength = 64
+
+// MaxCallbackURLLength bounds CallbackURL, in bytes.
+const MaxCallbackURLLength = 2048
+
+// HasSize reports whether the request overrides the configured box.
+func (o ProcessingOptions) HasSize() bool {
+	return o.Width != nil || o.Height != nil
+}
+
+// Size returns the requested box; a side that was not given is 0.
+func (o ProcessingOptions) Size() (width, height int) {
+	if o.Width != nil {
+		width = *o.Width
+	}
+	if o.Height != nil {
+		height = *o.Height
+	}
+	return width, height
+}
+
+// PageNumber returns the requested page, 1 when none is set.
+func (o ProcessingOptions) PageNumber() int {
+	if o.Page == nil {
+		return 1
+	}
+	return *o.Page
+}
+
+// PipelineName returns the requested pipeline, or "" when none is set.
+func (o ProcessingOptions) PipelineName() string {
+	if o.Pipeline == nil {
+		return ""
+	}
+	return *o.Pipeline
+}
+
+// WithDefaults fills fields unset in o from fallback, typically the options
+// stored with the image at upload time.
+func (o ProcessingOptions) WithDefaults(fallback ProcessingOptions) ProcessingOptions {
+	if o.Flatten == nil {
+		o.Flatten = fallback.Flatten
+	}
+	if o.Angle == nil {
+		o.Angle = fallback.Angle
+	}
+	if o.Flip == nil {
+		o.Flip = fallback.Flip
+	}
+	if o.Pipeline == nil {
+		o.Pipeline = fallback.Pipeline
+	}
+	if !o.HasSize() {
+		o.Width, o.Height = fallback.Width, fallback.Height
+	}
+	if o.Page == nil {
+		o.Page = fallback.Page
+	}
+	if o.Radius == nil {
+		o.Radius = fallback.Radius
+	}
+	if o.Quality == nil {
+		o.Quality = fallback.Quality
+	}
+	if o.WatermarkText == nil {
+		o.WatermarkText = fallback.WatermarkText
+	}
+	return o
+}
+
+// ImageVariant is an extra rendition generated next to the processed image,
+// e.g. a small thumbnail, each stored in its own format.
+type ImageVariant struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Format      string `json:"format"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
 type Image struct {
-	ID               string           `json:"id"`
-	OriginalFilename string           `json:"original_filename"`
-	OriginalPath     string           `json:"original_path"`
-	ProcessedPath    string           `json:"processed_path,omitempty"`
-	MimeType         string           `json:"mime_type"`
-	Size             int64            `json:"size"`
-	Width            int              `json:"width,omitempty"`
-	Height           int              `json:"height,omitempty"`
-	Status           ProcessingStatus `json:"status"`
-	ProcessingType   ProcessingType   `json:"processing_type"`
-	ErrorMessage     string           `json:"error_message,omitempty"`
-	CreatedAt        time.Time        `json:"created_at"`
-	UpdatedAt        time.Time        `json:"updated_at"`
-	ProcessedAt      *time.Time       `json:"processed_at,omitempty"`
+	ID               string            `json:"id"`
+	PublicID         string            `json:"public_id,omitempty"`
+	TenantID         string            `json:"tenant_id,omitempty"`
+	OriginalFilename string            `json:"original_filename"`
+	OriginalPath     string            `json:"original_path"`
+	ProcessedPath    string            `json:"processed_path,omitempty"`
+	MimeType         string            `json:"mime_type"`
+	Size             int64             `json:"size"`
+	ProcessedSize    int64             `json:"processed_size,omitempty"`
+	ContentHash      string            `json:"content_hash,omitempty"`
+	Width            int               `json:"width,omitempty"`
+	Height           int               `json:"height,omitempty"`
+	Status           ProcessingStatus  `json:"status"`
+	ProcessingType   ProcessingType    `json:"processing_type"`
+	Options          ProcessingOptions `json:"processing_options"`
+	ErrorMessage     string            `json:"error_message,omitempty"`
+	FailureCategory  FailureCategory   `json:"failure_category,omitempty"`
+	Attempts         int               `json:"attempts"`
+	NextAttemptAt    *time.Time        `json:"next_attempt_at,omitempty"`
+	Blurhash         string            `json:"blurhash,omitempty"`
+	LQIP             string            `json:"lqip,omitempty"`
+	Variants         []ImageVariant    `json:"variants,omitempty"`
+	// PoorCompression flags a result that shrank the image but not the
+	// file, see processing.max_compression_ratio.
+	PoorCompression bool `json:"poor_compression,omitempty"`
+	// ProcessingDurationMs is how long the latest attempt took, from
+	// MarkAsProcessing until it completed or failed.
+	ProcessingDurationMs int64 `json:"processing_duration_ms,omitempty"`
+	// Repaired marks an image processed from a truncated original, see
+	// processing.repair_corrupt_images.
+	Repaired bool `json:"repaired,omitempty"`
+	// ThumbnailPath is the thumbnail rendered during the upload request,
+	// see processing.sync_thumbnail.
+	ThumbnailPath string `json:"thumbnail_path,omitempty"`
+	// StorageBackend is the backend ("local" or "s3") holding the image's
+	// files with storage.s3_fallback_local, empty otherwise or when unknown.
+	StorageBackend string `json:"storage_backend,omitempty"`
+	// CallbackURL receives a POST whenever processing completes or fails.
+	// It may carry a token of the receiver, so it is never serialized.
+	CallbackURL string     `json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+
+	// attemptStartedAt is set by MarkAsProcessing for the attempt
+	// running in this process.
+	attemptStartedAt time.Time
+
+	// JobID is the job issued by the upload that created the image;
+	// it is not stored with the image and is empty when loaded later.
+	JobID string `json:"job_id,omitempty"`
+}
+
+// ImageFile is a stored original or processed file opened for serving.
+type ImageFile struct {
+	Body     io.ReadCloser
+	Filename string
+	// ETag is a quoted strong validator for the file content.
+	ETag    string
+	ModTime time.Time
+	// Immutable is set for originals, which never change under their URL;
+	// a processed image is replaced when the image is reprocessed.
+	Immutable bool
+	// Vary lists the request headers, e.g. Accept, the file was chosen by
+	// when the response is negotiated; caches must key on them too.
+	Vary []string
+	// RedirectURL, when set, is a time-limited URL the file can be fetched
+	// from directly; Body is nil then.
+	RedirectURL string
+}
+
+// OriginalETag identifies the stored original: its SHA-256 when known,
+// otherwise the record it was stored under.
+func (i *Image) OriginalETag() string {
+	if i.ContentHash != "" {
+		return `"` + i.ContentHash + `"`
+	}
+	return etag(i.ID, i.OriginalPath, i.CreatedAt.UTC().Format(time.RFC3339Nano))
+}
+
+// ProcessedETag identifies the current processed file. Processed paths
+// embed a hash of their content, so a reprocessed image gets a new tag.
+func (i *Image) ProcessedETag() string {
+	return etag(i.ID, i.ProcessedPath)
+}
+
+// VariantETag identifies the current file of variant v. Like processed
+// paths, variant paths embed a hash of their content.
+func (i *Image) VariantETag(v ImageVariant) string {
+	return etag(i.ID, v.Path)
+}
+
+// ThumbnailETag identifies the thumbnail rendered at upload, which never
+// changes.
+func (i *Image) ThumbnailETag() string {
+	return etag(i.ID, i.ThumbnailPath)
+}
+
+// ResizedETag identifies an on-the-fly resize of the original, which is as
+// immutable as the original itself.
+func (i *Image) ResizedETag(r ResizeRequest) string {
+	return etag(i.OriginalETag(), r.Key())
+}
+
+func etag(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
 func (i *Image) IsProcessed() bool {
 	return i.Status == StatusCompleted
 }
 
+// HasProcessedFile reports whether a processed file can be served: the
+// status alone is not enough for legacy rows completed without a path.
+func (i *Image) HasProcessedFile() bool {
+	return i.IsProcessed() && i.ProcessedPath != ""
+}
+
+func (i *Image) Variant(name string) (ImageVariant, bool) {
+	for _, v := range i.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return ImageVariant{}, false
+}
+
 func (i *Image) IsFailed() bool {
 	return i.Status == StatusFailed
 }
@@ -52,7 +401,20 @@ func (i *Image) CanBeProcessed() bool {
 
 func (i *Image) MarkAsProcessing() {
 	i.Status = StatusProcessing
+	i.Attempts++
+	i.NextAttemptAt = nil
 	i.UpdatedAt = time.Now()
+	i.attemptStartedAt = i.UpdatedAt
+}
+
+// finishAttempt records the duration of the attempt started by
+// MarkAsProcessing, if any. It is at least 1ms: 0 is stored as NULL, which
+// would leave a fast attempt out of the performance report.
+func (i *Image) finishAttempt(now time.Time) {
+	if !i.attemptStartedAt.IsZero() {
+		i.ProcessingDurationMs = max(now.Sub(i.attemptStartedAt).Milliseconds(), 1)
+		i.attemptStartedAt = time.Time{}
+	}
 }
 
 func (i *Image) MarkAsCompleted(processedPath string, width, height int) {
@@ -63,11 +425,173 @@ func (i *Image) MarkAsCompleted(processedPath string, width, height int) {
 	now := time.Now()
 	i.ProcessedAt = &now
 	i.UpdatedAt = now
+	i.finishAttempt(now)
 	i.ErrorMessage = ""
+	i.FailureCategory = ""
+	i.NextAttemptAt = nil
 }
 
 func (i *Image) MarkAsFailed(errMsg string) {
 	i.Status = StatusFailed
 	i.ErrorMessage = errMsg
 	i.UpdatedAt = time.Now()
+	i.finishAttempt(i.UpdatedAt)
+}
+
+func (i *Image) MarkForRetry(errMsg string, nextAttemptAt time.Time) {
+	i.MarkAsFailed(errMsg)
+	i.NextAttemptAt = &nextAttemptAt
+}
+
+// HasOriginal reports whether the original is still stored; it is gone
+// once pruned by the per-tenant retention cap.
+func (i *Image) HasOriginal() bool {
+	return i.OriginalPath != ""
+}
+
+func (i *Image) MarkOriginalPruned() {
+	i.OriginalPath = ""
+	i.UpdatedAt = time.Now()
+}
+
+func (i *Image) MarkAsDeadLettered(errMsg string) {
+	i.Status = StatusDeadLettered
+	i.ErrorMessage = errMsg
+	i.NextAttemptAt = nil
+	i.UpdatedAt = time.Now()
+	i.finishAttempt(i.UpdatedAt)
+}
+
+// MarkAsInterrupted returns an image whose attempt was cut off by a worker
+// shutdown to pending. The attempt is not counted: the task is redelivered
+// and runs it again.
+func (i *Image) MarkAsInterrupted() {
+	i.Status = StatusPending
+	if i.Attempts > 0 {
+		i.Attempts--
+	}
+	i.UpdatedAt = time.Now()
+}
+
+// AspectRatioPolicy restricts uploads to a width/height ratio within a
+// relative tolerance. With Crop set, non-conforming images are accepted and
+// center-cropped during processing instead of being rejected.
+type AspectRatioPolicy struct {
+	Ratio     float64
+	Tolerance float64
+	Crop      bool
+}
+
+func (p AspectRatioPolicy) Enabled() bool {
+	return p.Ratio > 0
+}
+
+func (p AspectRatioPolicy) Allows(width, height int) bool {
+	if !p.Enabled() {
+		return true
+	}
+	if width <= 0 || height <= 0 {
+		return false
+	}
+	actual := float64(width) / float64(height)
+	return math.Abs(actual-p.Ratio)/p.Ratio <= p.Tolerance
+}
+
+// TenantRetentionPolicy caps how many originals one tenant keeps stored.
+// Over the cap the oldest processed originals are pruned, or, with Reject
+// set, further uploads are refused. Processed results are always kept.
+type TenantRetentionPolicy struct {
+	MaxOriginals int
+	Reject       bool
+}
+
+func (p TenantRetentionPolicy) Enabled() bool {
+	return p.MaxOriginals > 0
+}
+
+// RetryPolicy decides how long to wait before the next processing attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	Delays      []time.Duration
+}
+
+// NextDelay returns the delay before the attempt following attemptsMade,
+// or false when no attempts remain. Attempts past the end of Delays reuse
+// the last delay.
+func (p RetryPolicy) NextDelay(attemptsMade int) (time.Duration, bool) {
+	if attemptsMade >= p.MaxAttempts || len(p.Delays) == 0 {
+		return 0, false
+	}
+	idx := attemptsMade - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(p.Delays) {
+		idx = len(p.Delays) - 1
+	}
+	return p.Delays[idx], true
+}
+
+// QueuePosition is an approximate place of a pending image in the processing queue.
+type QueuePosition struct {
+	ImageID      string
+	Position     int
+	PendingTotal int
+}
+
+type SortField string
+
+const (
+	SortByCreatedAt SortField = "created_at"
+	SortBySize      SortField = "size"
+	SortByStatus    SortField = "status"
+)
+
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// ListSort describes how a list of images should be ordered.
+type ListSort struct {
+	Field SortField
+	Order SortOrder
+}
+
+var DefaultListSort = ListSort{Field: SortByCreatedAt, Order: SortDesc}
+
+// ImageFilter narrows a scan over all images; empty fields match any value.
+type ImageFilter struct {
+	Status         ProcessingStatus
+	ProcessingType ProcessingType
+}
+
+// Pagination bounds the page size of list endpoints.
+type Pagination struct {
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// Limit returns the page size for a requested limit: DefaultLimit when
+// none (0 or less) was requested, capped at MaxLimit.
+func (p Pagination) Limit(requested int) int {
+	limit := requested
+	if limit <= 0 {
+		limit = p.DefaultLimit
+	}
+	if limit > p.MaxLimit {
+		limit = p.MaxLimit
+	}
+	return limit
+}
+
+func (s ListSort) IsValid() bool {
+	switch s.Field {
+	case SortByCreatedAt, SortBySize, SortByStatus:
+	default:
+		return false
+	}
+	return s.Order == SortAsc || s.Order == SortDesc
 }
@@ -0,0 +1,59 @@
+package domain
+
+import "time"
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobRetrying  JobState = "retrying"
+	JobCompleted JobState = "completed"
+	JobFailed    JobState = "failed"
+)
+
+// Job tracks one processing request for an image, independently of the
+// image record: its state transitions and how long queueing and running took.
+type Job struct {
+	ID           string     `json:"id"`
+	ImageID      string     `json:"image_id"`
+	State        JobState   `json:"state"`
+	Attempts     int        `json:"attempts"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+func (j *Job) IsFinished() bool {
+	return j.State == JobCompleted || j.State == JobFailed
+}
+
+// SyncWithImage moves the job to the state matching the image status.
+// StartedAt is set on the first run only, so it covers every retry.
+func (j *Job) SyncWithImage(image *Image) {
+	now := time.Now()
+
+	switch image.Status {
+	case StatusProcessing:
+		j.State = JobRunning
+		if j.StartedAt == nil {
+			j.StartedAt = &now
+		}
+	case StatusCompleted:
+		j.State = JobCompleted
+		j.FinishedAt = &now
+	case StatusFailed:
+		j.State = JobRetrying
+	case StatusDeadLettered:
+		j.State = JobFailed
+		j.FinishedAt = &now
+	default:
+		j.State = JobQueued
+	}
+
+	j.Attempts = image.Attempts
+	j.ErrorMessage = image.ErrorMessage
+	j.UpdatedAt = now
+}
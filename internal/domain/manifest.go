@@ -0,0 +1,33 @@
+package domain
+
+import "time"
+
+// ManifestEntry describes one image in a backup manifest: enough to find
+// its files in storage and verify them on restore.
+type ManifestEntry struct {
+	ID               string           `json:"id"`
+	TenantID         string           `json:"tenant_id,omitempty"`
+	OriginalFilename string           `json:"original_filename"`
+	OriginalPath     string           `json:"original_path,omitempty"`
+	ProcessedPath    string           `json:"processed_path,omitempty"`
+	ContentHash      string           `json:"content_hash,omitempty"`
+	Size             int64            `json:"size"`
+	Status           ProcessingStatus `json:"status"`
+	ProcessingType   ProcessingType   `json:"processing_type"`
+	CreatedAt        time.Time        `json:"created_at"`
+}
+
+func NewManifestEntry(image *Image) ManifestEntry {
+	return ManifestEntry{
+		ID:               image.ID,
+		TenantID:         image.TenantID,
+		OriginalFilename: image.OriginalFilename,
+		OriginalPath:     image.OriginalPath,
+		ProcessedPath:    image.ProcessedPath,
+		ContentHash:      image.ContentHash,
+		Size:             image.Size,
+		Status:           image.Status,
+		ProcessingType:   image.ProcessingType,
+		CreatedAt:        image.CreatedAt,
+	}
+}
@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+// MaxPerformanceWindow bounds GET /admin/performance; it reads every image
+// processed within the window.
+const MaxPerformanceWindow = 30 * 24 * time.Hour
+
+// PerformanceBucket aggregates the processing attempts that finished within
+// one hour. Images count by their latest attempt: one that failed and was
+// retried successfully later counts as completed in the later hour.
+type PerformanceBucket struct {
+	Start     time.Time
+	Completed int
+	Failed    int
+	// AvgDurationMs averages the completed attempts only; 0 when there
+	// are none.
+	AvgDurationMs float64
+}
+
+// FailureRate is the share of finished attempts that failed, 0 for an
+// empty bucket.
+func (b PerformanceBucket) FailureRate() float64 {
+	if b.Completed+b.Failed == 0 {
+		return 0
+	}
+	return float64(b.Failed) / float64(b.Completed+b.Failed)
+}
+
+// PerformanceReport is an hourly series over [From, To); hours without any
+// finished attempt are present as empty buckets.
+type PerformanceReport struct {
+	From    time.Time
+	To      time.Time
+	Buckets []PerformanceBucket
+}
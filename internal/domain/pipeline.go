@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PipelineOp is one operation of a configured pipeline.
+type PipelineOp string
+
+const (
+	// StepAutoOrient documents intent only: EXIF orientation is applied
+	// when every original is decoded, before the first step runs.
+	StepAutoOrient PipelineOp = "autoorient"
+	StepResize     PipelineOp = "resize"
+	StepThumbnail  PipelineOp = "thumbnail"
+	StepCrop       PipelineOp = "crop"
+	StepRotate     PipelineOp = "rotate"
+	StepFlip       PipelineOp = "flip"
+	StepDenoise    PipelineOp = "denoise"
+	StepWatermark  PipelineOp = "watermark"
+	StepFlatten    PipelineOp = "flatten"
+	// StepOptimize encodes the result at OptimizeQuality.
+	StepOptimize PipelineOp = "optimize"
+	StepQuality  PipelineOp = "quality"
+)
+
+// OptimizeQuality is the JPEG quality set by the optimize step.
+const OptimizeQuality = 82
+
+// PipelineStep is a parsed step such as "resize:1200" or "crop:800x600".
+// Width and Height are 0 when the step falls back to the configured size;
+// a resize with only a width keeps the aspect ratio.
+type PipelineStep struct {
+	Op     PipelineOp
+	Width  int
+	Height int
+	// Value is the angle for rotate, the radius for denoise and the
+	// quality for quality.
+	Value int
+	// Axis is the mirror axis of flip, horizontal when not given.
+	Axis FlipAxis
+}
+
+// ParsePipeline parses the steps of a configured pipeline in order.
+func ParsePipeline(steps []string) ([]PipelineStep, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("pipeline has no steps")
+	}
+
+	parsed := make([]PipelineStep, 0, len(steps))
+	for _, raw := range steps {
+		step, err := ParsePipelineStep(raw)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, step)
+	}
+	return parsed, nil
+}
+
+// ParsePipelineStep parses one "op" or "op:param" step.
+func ParsePipelineStep(raw string) (PipelineStep, error) {
+	name, param, hasParam := strings.Cut(strings.TrimSpace(raw), ":")
+	step := PipelineStep{Op: PipelineOp(strings.ToLower(name))}
+
+	switch step.Op {
+	case StepAutoOrient, StepWatermark, StepFlatten, StepOptimize:
+		if hasParam {
+			return step, fmt.Errorf("step %q takes no parameter", raw)
+		}
+	case StepResize, StepThumbnail, StepCrop:
+		if !hasParam {
+			break
+		}
+		w, h, err := parseStepSize(param)
+		if err != nil {
+			return step, fmt.Errorf("step %q: %w", raw, err)
+		}
+		if h == 0 && step.Op != StepResize {
+			// a single size is a square box for thumbnail and crop
+			h = w
+		}
+		step.Width, step.Height = w, h
+	case StepRotate:
+		angle, err := strconv.Atoi(param)
+		if !hasParam || err != nil || angle%90 != 0 {
+			return step, fmt.Errorf("step %q: %w", raw, ErrInvalidAngle)
+		}
+		step.Value = angle
+	case StepFlip:
+		step.Axis = FlipHorizontal
+		if hasParam {
+			axis, ok := ParseFlipAxis(param)
+			if !ok {
+				return step, fmt.Errorf("step %q: %w", raw, ErrInvalidFlipAxis)
+			}
+			step.Axis = axis
+		}
+	case StepDenoise:
+		step.Value = 1
+		if hasParam {
+			radius, err := strconv.Atoi(param)
+			if err != nil || radius < 1 || radius > 5 {
+				return step, fmt.Errorf("step %q: radius must be between 1 and 5", raw)
+			}
+			step.Value = radius
+		}
+	case StepQuality:
+		quality, err := strconv.Atoi(param)
+		if !hasParam || err != nil || quality < 1 || quality > 100 {
+			return step, fmt.Errorf("step %q: quality must be between 1 and 100", raw)
+		}
+		step.Value = quality
+	default:
+		return step, fmt.Errorf("unknown pipeline step %q", raw)
+	}
+
+	return step, nil
+}
+
+// parseStepSize parses "W" or "WxH".
+func parseStepSize(s string) (width, height int, err error) {
+	ws, hs, hasHeight := strings.Cut(strings.ToLower(s), "x")
+	width, err = strconv.Atoi(ws)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("width must be a positive integer")
+	}
+	if hasHeight {
+		height, err = strconv.Atoi(hs)
+		if err != nil || height <= 0 {
+			return 0, 0, fmt.Errorf("height must be a positive integer")
+		}
+	}
+	return width, height, nil
+}
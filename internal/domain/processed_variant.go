@@ -0,0 +1,75 @@
+package domain
+
+import (
+	"regexp"
+	"time"
+)
+
+// ProcessedVariant is a named result of processing an original with its own
+// type and options, requested after upload with POST /image/:id/process-variant.
+// Variants of one image are processed and tracked independently of each
+// other and of the image's own processed output.
+type ProcessedVariant struct {
+	ID             string            `json:"id"`
+	ImageID        string            `json:"image_id"`
+	Name           string            `json:"name"`
+	ProcessingType ProcessingType    `json:"processing_type"`
+	Options        ProcessingOptions `json:"options"`
+	Status         ProcessingStatus  `json:"status"`
+	ProcessedPath  string            `json:"processed_path,omitempty"`
+	Width          int               `json:"width,omitempty"`
+	Height         int               `json:"height,omitempty"`
+	ErrorMessage   string            `json:"error_message,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+	ProcessedAt    *time.Time        `json:"processed_at,omitempty"`
+}
+
+// variantNamePattern keeps names safe for URLs and storage keys.
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidVariantName reports whether name can identify a processed variant:
+// 1-64 lowercase letters, digits, "_" or "-", starting with a letter or digit.
+func ValidVariantName(name string) bool {
+	return variantNamePattern.MatchString(name)
+}
+
+func (v *ProcessedVariant) IsProcessed() bool {
+	return v.Status == StatusCompleted && v.ProcessedPath != ""
+}
+
+// IsActive reports whether the variant is queued or running, in which case
+// a new request for the same name is refused.
+func (v *ProcessedVariant) IsActive() bool {
+	return v.Status == StatusPending || v.Status == StatusProcessing
+}
+
+func (v *ProcessedVariant) MarkAsCompleted(processedPath string, width, height int) {
+	v.Status = StatusCompleted
+	v.ProcessedPath = processedPath
+	v.Width = width
+	v.Height = height
+	now := time.Now()
+	v.ProcessedAt = &now
+	v.UpdatedAt = now
+	v.ErrorMessage = ""
+}
+
+func (v *ProcessedVariant) MarkAsFailed(errMsg string) {
+	v.Status = StatusFailed
+	v.ErrorMessage = errMsg
+	v.UpdatedAt = time.Now()
+}
+
+// MarkAsInterrupted returns a variant cut off by a worker shutdown to
+// pending, so the redelivered task can claim it again.
+func (v *ProcessedVariant) MarkAsInterrupted() {
+	v.Status = StatusPending
+	v.UpdatedAt = time.Now()
+}
+
+// ETag identifies the current variant file; like processed images, variant
+// paths embed a content hash.
+func (v *ProcessedVariant) ETag() string {
+	return etag(v.ID, v.ProcessedPath)
+}
@@ -0,0 +1,42 @@
+package domain
+
+import "strings"
+
+const publicIDAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// maxPublicIDLen bounds decoding so long inputs cannot overflow int64.
+const maxPublicIDLen = 10
+
+// EncodePublicID renders a sequence number as a short base62 string.
+func EncodePublicID(seq int64) string {
+	if seq <= 0 {
+		return ""
+	}
+
+	var buf [maxPublicIDLen + 1]byte
+	i := len(buf)
+	for seq > 0 {
+		i--
+		buf[i] = publicIDAlphabet[seq%62]
+		seq /= 62
+	}
+	return string(buf[i:])
+}
+
+// DecodePublicID is the inverse of EncodePublicID. It reports false for
+// strings that cannot be a public ID, such as UUIDs.
+func DecodePublicID(s string) (int64, bool) {
+	if s == "" || len(s) > maxPublicIDLen {
+		return 0, false
+	}
+
+	var seq int64
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(publicIDAlphabet, s[i])
+		if d < 0 {
+			return 0, false
+		}
+		seq = seq*62 + int64(d)
+	}
+	return seq, seq > 0
+}
@@ -1,13 +1,78 @@
 package domain
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type ImageRepository interface {
 	Create(ctx context.Context, image *Image) error
 	FindByID(ctx context.Context, id string) (*Image, error)
+	FindByPublicID(ctx context.Context, publicID string) (*Image, error)
 	Update(ctx context.Context, image *Image) error
+	// Complete stores a processed image like Update and returns the
+	// processed path it replaced, read atomically with the write.
+	Complete(ctx context.Context, image *Image) (previousPath string, err error)
+	// SetStorageBackend records the backend the image's files were moved to.
+	SetStorageBackend(ctx context.Context, id, backend string) error
+	// Delete soft-deletes the image; lookups and listings skip it from
+	// then on.
 	Delete(ctx context.Context, id string) error
+	// Purge removes the image row, soft-deleted or not.
+	Purge(ctx context.Context, id string) error
+	// FindDeletedBefore returns images soft-deleted before the given time.
+	FindDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*Image, error)
 	FindByStatus(ctx context.Context, status ProcessingStatus, limit, offset int) ([]*Image, error)
-	List(ctx context.Context, limit, offset int) ([]*Image, error)
+	List(ctx context.Context, limit, offset int, sort ListSort) ([]*Image, error)
+	ListAfterID(ctx context.Context, filter ImageFilter, afterID string, limit int) ([]*Image, error)
 	UpdateStatus(ctx context.Context, id string, status ProcessingStatus) error
+	Count(ctx context.Context) (int, error)
+	CountByStatus(ctx context.Context, status ProcessingStatus) (int, error)
+	CountPendingBefore(ctx context.Context, createdAt time.Time) (int, error)
+	// ClaimDueForRetry clears the schedule of up to limit failed images
+	// whose next attempt is due and returns them. The claim is atomic, so
+	// schedulers running side by side never get the same image.
+	ClaimDueForRetry(ctx context.Context, now time.Time, limit int) ([]*Image, error)
+	CountOriginalsByTenant(ctx context.Context, tenantID string) (int, error)
+	FindOldestPrunableByTenant(ctx context.Context, tenantID string, limit int) ([]*Image, error)
+	// FindDuplicate finds an earlier upload of the same bytes by the tenant
+	// with the same processing, ErrImageNotFound when there is none.
+	FindDuplicate(ctx context.Context, contentHash, tenantID string, processingType ProcessingType, opts ProcessingOptions) (*Image, error)
+	// UnreferencedPaths returns those of the given storage paths that no
+	// image or processed variant refers to.
+	UnreferencedPaths(ctx context.Context, paths []string) ([]string, error)
+	// PerformanceBuckets aggregates attempts finished since the given
+	// hour by hour; hours without any are left out.
+	PerformanceBuckets(ctx context.Context, since time.Time) ([]PerformanceBucket, error)
+}
+
+// ImageVariantRepository stores the configured variants rendered with an
+// image; images read through ImageRepository come with them.
+type ImageVariantRepository interface {
+	// Replace makes variants the complete set of the image's variants.
+	Replace(ctx context.Context, imageID string, variants []ImageVariant) error
+}
+
+type JobRepository interface {
+	Create(ctx context.Context, job *Job) error
+	FindByID(ctx context.Context, id string) (*Job, error)
+	FindLatestByImageID(ctx context.Context, imageID string) (*Job, error)
+	Update(ctx context.Context, job *Job) error
+}
+
+type ProcessedVariantRepository interface {
+	// Request creates the variant or re-queues a finished one of the same
+	// name; ErrAlreadyProcessing if that one is still pending or processing.
+	Request(ctx context.Context, variant *ProcessedVariant) (*ProcessedVariant, error)
+	FindByID(ctx context.Context, id string) (*ProcessedVariant, error)
+	FindByName(ctx context.Context, imageID, name string) (*ProcessedVariant, error)
+	ListByImageID(ctx context.Context, imageID string) ([]*ProcessedVariant, error)
+	// Claim atomically moves a pending variant, or one whose claim is
+	// older than expiredBefore, to processing and reports whether this
+	// caller got it.
+	Claim(ctx context.Context, id string, expiredBefore time.Time) (bool, error)
+	// FindExpiredClaims lists variants in processing claimed before
+	// expiredBefore, oldest claim first.
+	FindExpiredClaims(ctx context.Context, expiredBefore time.Time, limit int) ([]*ProcessedVariant, error)
+	Update(ctx context.Context, variant *ProcessedVariant) error
 }
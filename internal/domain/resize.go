@@ -0,0 +1,80 @@
+package domain
+
+import "fmt"
+
+// ResizeFit is how an on-the-fly resize fits the original into the
+// requested box.
+type ResizeFit string
+
+const (
+	// FitContain scales the whole image to fit inside the box.
+	FitContain ResizeFit = "contain"
+	// FitCover fills the box and crops the overflow around the center.
+	FitCover ResizeFit = "cover"
+	// FitFill stretches the image to the box, ignoring the aspect ratio.
+	FitFill ResizeFit = "fill"
+)
+
+func (f ResizeFit) IsValid() bool {
+	switch f {
+	case FitContain, FitCover, FitFill:
+		return true
+	default:
+		return false
+	}
+}
+
+// ResizeRequest is the box of GET /image/:id/original?w=&h=&fit=. A zero
+// side follows the aspect ratio of the other.
+type ResizeRequest struct {
+	Width  int
+	Height int
+	Fit    ResizeFit
+	// WebP is set when the client accepts image/webp and the rendition is
+	// to be served in it
+	WebP bool
+}
+
+// Key identifies the rendition, e.g. "300x200_cover" or "300x200_cover_webp".
+func (r ResizeRequest) Key() string {
+	if r.WebP {
+		return fmt.Sprintf("%dx%d_%s_webp", r.Width, r.Height, r.Fit)
+	}
+	return fmt.Sprintf("%dx%d_%s", r.Width, r.Height, r.Fit)
+}
+
+// ResizeSize is one allowlisted box; 0 stands for an omitted side.
+type ResizeSize struct {
+	Width  int
+	Height int
+}
+
+// ResizePolicy bounds on-the-fly resizes. With Sizes set, only those exact
+// boxes are served, which keeps the cache from being filled with arbitrary
+// sizes.
+type ResizePolicy struct {
+	MaxWidth  int
+	MaxHeight int
+	Sizes     []ResizeSize
+}
+
+func (p ResizePolicy) Allows(r ResizeRequest) bool {
+	if !r.Fit.IsValid() {
+		return false
+	}
+	if r.Width < 0 || r.Height < 0 || (r.Width == 0 && r.Height == 0) {
+		return false
+	}
+	if r.Width > p.MaxWidth || r.Height > p.MaxHeight {
+		return false
+	}
+	if len(p.Sizes) == 0 {
+		return true
+	}
+	for _, s := range p.Sizes {
+		if s.Width == r.Width && s.Height == r.Height {
+			return true
+		}
+	}
+	return false
+}
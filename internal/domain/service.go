@@ -1,25 +1,94 @@
 package domain
 
 import (
+	"bytes"
 	"context"
 	"io"
+	"time"
 )
 
 type ImageService interface {
-	UploadImage(ctx context.Context, filename string, mimeType string, size int64, reader io.Reader, processingType ProcessingType) (*Image, error)
+	UploadImage(ctx context.Context, filename string, mimeType string, size int64, reader io.Reader, processingType ProcessingType, opts ProcessingOptions, tenantID string) (*Image, error)
 	GetImage(ctx context.Context, id string) (*Image, error)
-	GetImageFile(ctx context.Context, id string, useOriginal bool) (io.ReadCloser, string, error)
-	DeleteImage(ctx context.Context, id string) error
-	ListImages(ctx context.Context, limit, offset int) ([]*Image, error)
+	GetImageFile(ctx context.Context, id string, useOriginal bool) (*ImageFile, error)
+	// GetResizedOriginal serves the original fitted into req's box.
+	GetResizedOriginal(ctx context.Context, id string, req ResizeRequest) (*ImageFile, error)
+	// GetVariantFile serves a rendition configured under processing.variants.
+	GetVariantFile(ctx context.Context, id string, name string) (*ImageFile, error)
+	// GetThumbnailFile serves the thumbnail rendered during the upload.
+	GetThumbnailFile(ctx context.Context, id string) (*ImageFile, error)
+	// MigrateImage moves the image's files to the named storage backend.
+	MigrateImage(ctx context.Context, id, backend string) (*Image, error)
+	// DeleteImage soft-deletes the image, or with hard removes its record
+	// and files at once.
+	DeleteImage(ctx context.Context, id string, hard bool) error
+	// ListImages returns one page of images and the total number of images;
+	// limit is the page size, already bounded by the server's Pagination.
+	ListImages(ctx context.Context, limit, offset int, sort ListSort) ([]*Image, int, error)
+	// StreamImages calls fn for every image matching filter in id order,
+	// reading the table page by page; an error from fn stops the scan.
+	StreamImages(ctx context.Context, filter ImageFilter, fn func(*Image) error) error
+	GetQueuePosition(ctx context.Context, id string) (*QueuePosition, error)
+}
+
+// ProcessedVariantService manages named variants processed from an
+// already uploaded original.
+type ProcessedVariantService interface {
+	RequestVariant(ctx context.Context, imageID string, name string, processingType ProcessingType, opts ProcessingOptions) (*ProcessedVariant, error)
+	GetVariant(ctx context.Context, imageID string, name string) (*ProcessedVariant, error)
+	ListVariants(ctx context.Context, imageID string) ([]*ProcessedVariant, error)
+	GetVariantFile(ctx context.Context, imageID string, name string) (*ImageFile, error)
+}
+
+type JobService interface {
+	GetJob(ctx context.Context, id string) (*Job, error)
+}
+
+type AdminService interface {
+	WriteManifest(ctx context.Context, w io.Writer) error
+	Performance(ctx context.Context, window time.Duration) (*PerformanceReport, error)
+}
+
+type PreviewService interface {
+	Preview(ctx context.Context, reader io.Reader, processingType ProcessingType, opts ProcessingOptions, w io.Writer) error
+}
+
+type SpriteService interface {
+	// BuildSprite packs inputs onto one sheet and writes it as PNG to w.
+	BuildSprite(ctx context.Context, inputs []SpriteInput, w io.Writer) (*SpriteAtlas, error)
+}
+
+type WatermarkService interface {
+	GetWatermarked(ctx context.Context, id string, user string) (*bytes.Buffer, string, error)
+}
+
+type AnalysisService interface {
+	GetHistogram(ctx context.Context, id string, buckets int) (*Histogram, error)
+	// WriteDiff writes a PNG heatmap of where the processed image differs
+	// from its original.
+	WriteDiff(ctx context.Context, id string, w io.Writer) error
 }
 
 type ProcessorService interface {
-	ProcessImage(ctx context.Context, imageID string) error
+	ProcessImage(ctx context.Context, imageID string, opts ProcessingOptions) error
+	// RejectTask fails an image whose task can never succeed, without
+	// scheduling a retry.
+	RejectTask(ctx context.Context, imageID string, reason string, deadLetter bool) error
+	// ProcessVariant renders one requested processed variant of an image.
+	ProcessVariant(ctx context.Context, variantID string) error
+	// PurgeDeleted removes up to limit images soft-deleted before the
+	// given time, files included, and returns how many it removed.
+	PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// ProcessingNotifier is told about images that reached a final status.
+type ProcessingNotifier interface {
+	NotifyProcessed(ctx context.Context, image *Image) error
 }
 
 type StorageService interface {
-	SaveOriginal(ctx context.Context, filename string, reader io.Reader) (string, error)
-	SaveProcessed(ctx context.Context, filename string, reader io.Reader) (string, error)
+	SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error)
+	SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error)
 	GetOriginal(ctx context.Context, path string) (io.ReadCloser, error)
 	GetProcessed(ctx context.Context, path string) (io.ReadCloser, error)
 	Delete(ctx context.Context, path string) error
@@ -27,6 +96,7 @@ type StorageService interface {
 }
 
 type QueueService interface {
-	PublishProcessingTask(ctx context.Context, imageID string, processingType ProcessingType) error
+	PublishProcessingTask(ctx context.Context, imageID string, processingType ProcessingType, opts ProcessingOptions) error
+	PublishVariantTask(ctx context.Context, variant *ProcessedVariant) error
 	Close() error
 }
@@ -0,0 +1,25 @@
+package domain
+
+import "io"
+
+// SpriteInput is one image to place on a sprite sheet.
+type SpriteInput struct {
+	Name   string
+	Reader io.Reader
+}
+
+// SpriteFrame is where a sprite ended up on the sheet, in pixels.
+type SpriteFrame struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// SpriteAtlas describes a packed sheet; frames keep the input order.
+type SpriteAtlas struct {
+	Width  int           `json:"width"`
+	Height int           `json:"height"`
+	Frames []SpriteFrame `json:"frames"`
+}
@@ -1,6 +1,10 @@
 package dto
 
-import "github.com/yokitheyo/imageprocessor/internal/domain"
+import (
+	"strings"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
 
 type UploadImageRequest struct {
 	ProcessingType string `form:"processing_type" binding:"required,oneof=resize thumbnail watermark"`
@@ -10,7 +14,107 @@ func (r *UploadImageRequest) ToProcessingType() domain.ProcessingType {
 	return domain.ProcessingType(r.ProcessingType)
 }
 
+type Base64UploadRequest struct {
+	Filename       string  `json:"filename" binding:"required"`
+	Data           string  `json:"data" binding:"required"`
+	ProcessingType string  `json:"processing_type"`
+	Flatten        *bool   `json:"flatten,omitempty"`
+	Angle          *int    `json:"angle,omitempty"`
+	Flip           *string `json:"flip,omitempty"`
+	Pipeline       *string `json:"pipeline,omitempty"`
+	Width          *int    `json:"width,omitempty"`
+	Height         *int    `json:"height,omitempty"`
+	Page           *int    `json:"page,omitempty"`
+	Radius         *int    `json:"radius,omitempty"`
+	Quality        *int    `json:"quality,omitempty"`
+	WatermarkText  *string `json:"watermark_text,omitempty"`
+	CallbackURL    string  `json:"callback_url,omitempty"`
+}
+
+// ProcessVariantRequest is the body of POST /image/:id/process-variant.
+type ProcessVariantRequest struct {
+	Name           string  `json:"name" binding:"required"`
+	ProcessingType string  `json:"processing_type"`
+	Flatten        *bool   `json:"flatten,omitempty"`
+	Angle          *int    `json:"angle,omitempty"`
+	Flip           *string `json:"flip,omitempty"`
+	Pipeline       *string `json:"pipeline,omitempty"`
+	Width          *int    `json:"width,omitempty"`
+	Height         *int    `json:"height,omitempty"`
+	Page           *int    `json:"page,omitempty"`
+	Radius         *int    `json:"radius,omitempty"`
+	Quality        *int    `json:"quality,omitempty"`
+	WatermarkText  *string `json:"watermark_text,omitempty"`
+}
+
+type URLUploadRequest struct {
+	URL            string  `json:"url" binding:"required"`
+	ProcessingType string  `json:"processing_type"`
+	Flatten        *bool   `json:"flatten,omitempty"`
+	Angle          *int    `json:"angle,omitempty"`
+	Flip           *string `json:"flip,omitempty"`
+	Pipeline       *string `json:"pipeline,omitempty"`
+	Width          *int    `json:"width,omitempty"`
+	Height         *int    `json:"height,omitempty"`
+	Page           *int    `json:"page,omitempty"`
+	Radius         *int    `json:"radius,omitempty"`
+	Quality        *int    `json:"quality,omitempty"`
+	WatermarkText  *string `json:"watermark_text,omitempty"`
+	CallbackURL    string  `json:"callback_url,omitempty"`
+}
+
 type ProcessImageRequest struct {
-	ImageID        string `json:"image_id"`
-	ProcessingType string `json:"processing_type"`
+	ImageID string `json:"image_id"`
+	// VariantID is set for tasks rendering a processed variant instead of
+	// the image's own processed output.
+	VariantID      string           `json:"variant_id,omitempty"`
+	ProcessingType string           `json:"processing_type"`
+	Flatten        *bool            `json:"flatten,omitempty"`
+	Angle          *int             `json:"angle,omitempty"`
+	Flip           *domain.FlipAxis `json:"flip,omitempty"`
+	Pipeline       *string          `json:"pipeline,omitempty"`
+	Width          *int             `json:"width,omitempty"`
+	Height         *int             `json:"height,omitempty"`
+	Page           *int             `json:"page,omitempty"`
+	Radius         *int             `json:"radius,omitempty"`
+	Quality        *int             `json:"quality,omitempty"`
+	WatermarkText  *string          `json:"watermark_text,omitempty"`
+}
+
+func (r *ProcessImageRequest) ToProcessingOptions() domain.ProcessingOptions {
+	return domain.ProcessingOptions{
+		Flatten:       r.Flatten,
+		Angle:         r.Angle,
+		Flip:          r.Flip,
+		Pipeline:      r.Pipeline,
+		Width:         r.Width,
+		Height:        r.Height,
+		Page:          r.Page,
+		Radius:        r.Radius,
+		Quality:       r.Quality,
+		WatermarkText: r.WatermarkText,
+	}
+}
+
+// ParseListSort parses the "sort" query parameter in the form "field" or
+// "field:order", e.g. "size:asc". An empty value yields the default sort.
+func ParseListSort(raw string) (domain.ListSort, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return domain.DefaultListSort, nil
+	}
+
+	field, order, _ := strings.Cut(raw, ":")
+	sort := domain.ListSort{
+		Field: domain.SortField(strings.ToLower(strings.TrimSpace(field))),
+		Order: domain.SortOrder(strings.ToLower(strings.TrimSpace(order))),
+	}
+	if sort.Order == "" {
+		sort.Order = domain.DefaultListSort.Order
+	}
+
+	if !sort.IsValid() {
+		return domain.ListSort{}, domain.ErrInvalidSort
+	}
+	return sort, nil
 }
@@ -0,0 +1,48 @@
+package dto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestParseListSort(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want domain.ListSort
+	}{
+		{"", domain.DefaultListSort},
+		{"created_at", domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortDesc}},
+		{"created_at:asc", domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortAsc}},
+		{"size", domain.ListSort{Field: domain.SortBySize, Order: domain.SortDesc}},
+		{"size:asc", domain.ListSort{Field: domain.SortBySize, Order: domain.SortAsc}},
+		{"size:desc", domain.ListSort{Field: domain.SortBySize, Order: domain.SortDesc}},
+		{"status:asc", domain.ListSort{Field: domain.SortByStatus, Order: domain.SortAsc}},
+		{" Status : DESC ", domain.ListSort{Field: domain.SortByStatus, Order: domain.SortDesc}},
+	}
+	for _, tt := range tests {
+		got, err := ParseListSort(tt.raw)
+		if err != nil {
+			t.Errorf("ParseListSort(%q): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseListSort(%q) = %+v, want %+v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseListSortRejectsUnknown(t *testing.T) {
+	for _, raw := range []string{
+		"id",
+		"filename:asc",
+		"size:up",
+		"size; DROP TABLE images",
+		"created_at:asc, id",
+	} {
+		if _, err := ParseListSort(raw); !errors.Is(err, domain.ErrInvalidSort) {
+			t.Errorf("ParseListSort(%q) err = %v, want ErrInvalidSort", raw, err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package dto
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/yokitheyo/imageprocessor/internal/domain"
@@ -8,21 +12,66 @@ import (
 
 type ImageResponse struct {
 	ID               string     `json:"id"`
+	PublicID         string     `json:"public_id,omitempty"`
 	OriginalFilename string     `json:"original_filename"`
 	MimeType         string     `json:"mime_type"`
 	Size             int64      `json:"size"`
+	ProcessedSize    int64      `json:"processed_size,omitempty"`
+	PoorCompression  bool       `json:"poor_compression,omitempty"`
+	Repaired         bool       `json:"repaired,omitempty"`
+	StorageBackend   string     `json:"storage_backend,omitempty"`
+	DurationMs       int64      `json:"processing_duration_ms,omitempty"`
 	Width            int        `json:"width,omitempty"`
 	Height           int        `json:"height,omitempty"`
 	Status           string     `json:"status"`
 	ProcessingType   string     `json:"processing_type"`
 	ErrorMessage     string     `json:"error_message,omitempty"`
+	FailureCategory  string     `json:"failure_category,omitempty"`
+	Blurhash         string     `json:"blurhash,omitempty"`
+	LQIP             string     `json:"lqip,omitempty"`
 	CreatedAt        time.Time  `json:"created_at"`
 	UpdatedAt        time.Time  `json:"updated_at"`
 	ProcessedAt      *time.Time `json:"processed_at,omitempty"`
+	JobID            string     `json:"job_id,omitempty"`
 
 	// URLs
 	OriginalURL  string `json:"original_url"`
 	ProcessedURL string `json:"processed_url,omitempty"`
+	// ThumbnailURL and StatusURL are set for images with a thumbnail
+	// rendered at upload, so clients can show it and poll for the rest.
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	StatusURL    string `json:"status_url,omitempty"`
+
+	Variants []*VariantResponse `json:"variants,omitempty"`
+	// Srcset and Sizes are ready-made img attributes built from the
+	// variants, see buildSrcset.
+	Srcset string `json:"srcset,omitempty"`
+	Sizes  string `json:"sizes,omitempty"`
+}
+
+type VariantResponse struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// ProcessedVariantResponse describes one processed variant; URL is set
+// once the variant is completed.
+type ProcessedVariantResponse struct {
+	ImageID        string     `json:"image_id"`
+	Name           string     `json:"name"`
+	ProcessingType string     `json:"processing_type"`
+	Status         string     `json:"status"`
+	Width          int        `json:"width,omitempty"`
+	Height         int        `json:"height,omitempty"`
+	ErrorMessage   string     `json:"error_message,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	ProcessedAt    *time.Time `json:"processed_at,omitempty"`
+	StatusURL      string     `json:"status_url"`
+	URL            string     `json:"url,omitempty"`
 }
 
 type ImageListResponse struct {
@@ -32,6 +81,111 @@ type ImageListResponse struct {
 	Offset int              `json:"offset"`
 }
 
+// ImageExportEnd is the last line of an NDJSON export. A stream without it
+// was cut off, however many records it holds.
+type ImageExportEnd struct {
+	End   bool `json:"end"`
+	Count int  `json:"count"`
+}
+
+// BatchUploadResult is the outcome for one file of a batch upload:
+// exactly one of Image or Error is set.
+type BatchUploadResult struct {
+	Filename string         `json:"filename"`
+	Image    *ImageResponse `json:"image,omitempty"`
+	Error    *ErrorResponse `json:"error,omitempty"`
+}
+
+type BatchUploadResponse struct {
+	Results   []*BatchUploadResult `json:"results"`
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+}
+
+// ArchiveEntryResult is the outcome for one entry of an uploaded archive.
+// Status is processed (Image set), skipped (Reason set: not an image
+// file) or failed (Error set).
+type ArchiveEntryResult struct {
+	Name   string         `json:"name"`
+	Status string         `json:"status"`
+	Reason string         `json:"reason,omitempty"`
+	Image  *ImageResponse `json:"image,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+type ArchiveUploadResponse struct {
+	Results   []*ArchiveEntryResult `json:"results"`
+	Processed int                   `json:"processed"`
+	Skipped   int                   `json:"skipped"`
+	Failed    int                   `json:"failed"`
+}
+
+// ImageStatusResponse is the small body served to clients polling for
+// completion.
+type ImageStatusResponse struct {
+	ID           string     `json:"id"`
+	Status       string     `json:"status"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	ProcessedAt  *time.Time `json:"processed_at,omitempty"`
+}
+
+// SpriteResponse carries the packed sheet as a PNG data URI and the atlas
+// of sprite rectangles, in upload order.
+type SpriteResponse struct {
+	Width   int                    `json:"width"`
+	Height  int                    `json:"height"`
+	Sprites []*SpriteFrameResponse `json:"sprites"`
+	Image   string                 `json:"image"`
+}
+
+type SpriteFrameResponse struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type QueuePositionResponse struct {
+	ID           string `json:"id"`
+	Position     int    `json:"position"`
+	PendingTotal int    `json:"pending_total"`
+}
+
+type HistogramResponse struct {
+	ID         string           `json:"id"`
+	Buckets    int              `json:"buckets"`
+	Pixels     int              `json:"pixels"`
+	Channels   []string         `json:"channels"`
+	Histograms map[string][]int `json:"histograms"`
+}
+
+// PerformanceResponse is the hourly processing series of
+// GET /admin/performance, oldest hour first.
+type PerformanceResponse struct {
+	Window  string                       `json:"window"`
+	From    time.Time                    `json:"from"`
+	To      time.Time                    `json:"to"`
+	Buckets []*PerformanceBucketResponse `json:"buckets"`
+}
+
+// PerformanceBucketResponse covers one hour; Completed is the throughput
+// of that hour.
+type PerformanceBucketResponse struct {
+	Start         time.Time `json:"start"`
+	Completed     int       `json:"completed"`
+	Failed        int       `json:"failed"`
+	FailureRate   float64   `json:"failure_rate"`
+	AvgDurationMs float64   `json:"avg_duration_ms"`
+}
+
+// ReadinessResponse reports each dependency as "ok" or "unavailable"; the
+// cause is only logged.
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
@@ -45,28 +199,87 @@ func MapImageToResponse(img *domain.Image, baseURL string) *ImageResponse {
 
 	resp := &ImageResponse{
 		ID:               img.ID,
+		PublicID:         img.PublicID,
 		OriginalFilename: img.OriginalFilename,
 		MimeType:         img.MimeType,
 		Size:             img.Size,
+		ProcessedSize:    img.ProcessedSize,
+		PoorCompression:  img.PoorCompression,
+		Repaired:         img.Repaired,
+		StorageBackend:   img.StorageBackend,
+		DurationMs:       img.ProcessingDurationMs,
 		Width:            img.Width,
 		Height:           img.Height,
 		Status:           string(img.Status),
 		ProcessingType:   string(img.ProcessingType),
 		ErrorMessage:     img.ErrorMessage,
+		FailureCategory:  string(img.FailureCategory),
+		Blurhash:         img.Blurhash,
+		LQIP:             img.LQIP,
 		CreatedAt:        img.CreatedAt,
 		UpdatedAt:        img.UpdatedAt,
 		ProcessedAt:      img.ProcessedAt,
+		JobID:            img.JobID,
 		OriginalURL:      baseURL + "/image/" + img.ID + "/original",
 	}
 
-	if img.IsProcessed() {
+	if img.HasProcessedFile() {
 		resp.ProcessedURL = baseURL + "/image/" + img.ID
 	}
+	if img.ThumbnailPath != "" {
+		resp.ThumbnailURL = baseURL + "/image/" + img.ID + "/thumbnail"
+		resp.StatusURL = baseURL + "/image/" + img.ID + "/status"
+	}
+
+	for _, v := range img.Variants {
+		resp.Variants = append(resp.Variants, &VariantResponse{
+			Name:   v.Name,
+			Format: v.Format,
+			Width:  v.Width,
+			Height: v.Height,
+			URL:    baseURL + "/image/" + img.ID + "/variants/" + v.Name,
+		})
+	}
+	resp.Srcset, resp.Sizes = buildSrcset(resp.Variants)
 
 	return resp
 }
 
-func MapImagesToResponse(images []*domain.Image, baseURL string, limit, offset int) *ImageListResponse {
+// defaultSizes assumes the image spans the viewport. Only the page knows
+// its layout slot, so clients with narrower slots pass their own sizes;
+// the variant widths describe the files, not the slot.
+const defaultSizes = "100vw"
+
+// buildSrcset lists the variants by ascending width as an img srcset, one
+// candidate per width, with defaultSizes as the sizes hint. Both are empty
+// when no variant has a known width.
+func buildSrcset(variants []*VariantResponse) (string, string) {
+	byWidth := make([]*VariantResponse, 0, len(variants))
+	for _, v := range variants {
+		if v.Width > 0 {
+			byWidth = append(byWidth, v)
+		}
+	}
+	if len(byWidth) == 0 {
+		return "", ""
+	}
+	// stable, so among equal widths the first variant by name wins
+	sort.SliceStable(byWidth, func(i, j int) bool { return byWidth[i].Width < byWidth[j].Width })
+
+	var candidates []string
+	for i, v := range byWidth {
+		if i > 0 && v.Width == byWidth[i-1].Width {
+			continue
+		}
+		candidates = append(candidates, fmt.Sprintf("%s %dw", v.URL, v.Width))
+	}
+
+	return strings.Join(candidates, ", "), defaultSizes
+}
+
+// MapImagesToResponse maps one page of images; total is the number of
+// images across all pages.
+func MapImagesToResponse(images []*domain.Image, total int, baseURL string, limit, offset int) *ImageListResponse {
 	responses := make([]*ImageResponse, 0, len(images))
 	for _, img := range images {
 		responses = append(responses, MapImageToResponse(img, baseURL))
@@ -74,8 +287,115 @@ func MapImagesToResponse(images []*domain.Image, baseURL string, limit, offset i
 
 	return &ImageListResponse{
 		Images: responses,
-		Total:  len(responses),
+		Total:  total,
 		Limit:  limit,
 		Offset: offset,
 	}
 }
+
+func MapProcessedVariantToResponse(v *domain.ProcessedVariant, baseURL string) *ProcessedVariantResponse {
+	statusURL := baseURL + "/image/" + v.ImageID + "/process-variant/" + v.Name
+	resp := &ProcessedVariantResponse{
+		ImageID:        v.ImageID,
+		Name:           v.Name,
+		ProcessingType: string(v.ProcessingType),
+		Status:         string(v.Status),
+		Width:          v.Width,
+		Height:         v.Height,
+		ErrorMessage:   v.ErrorMessage,
+		CreatedAt:      v.CreatedAt,
+		UpdatedAt:      v.UpdatedAt,
+		ProcessedAt:    v.ProcessedAt,
+		StatusURL:      statusURL,
+	}
+	if v.IsProcessed() {
+		resp.URL = statusURL + "/file"
+	}
+	return resp
+}
+
+type JobResponse struct {
+	ID           string     `json:"id"`
+	ImageID      string     `json:"image_id"`
+	State        string     `json:"state"`
+	Attempts     int        `json:"attempts"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+
+	// Timings in milliseconds, present once the phase has started
+	QueuedMs  *int64 `json:"queued_ms,omitempty"`
+	RunningMs *int64 `json:"running_ms,omitempty"`
+}
+
+func MapJobToResponse(job *domain.Job) *JobResponse {
+	if job == nil {
+		return nil
+	}
+
+	resp := &JobResponse{
+		ID:           job.ID,
+		ImageID:      job.ImageID,
+		State:        string(job.State),
+		Attempts:     job.Attempts,
+		ErrorMessage: job.ErrorMessage,
+		CreatedAt:    job.CreatedAt,
+		UpdatedAt:    job.UpdatedAt,
+		StartedAt:    job.StartedAt,
+		FinishedAt:   job.FinishedAt,
+	}
+
+	if job.StartedAt != nil {
+		queued := job.StartedAt.Sub(job.CreatedAt).Milliseconds()
+		resp.QueuedMs = &queued
+
+		end := time.Now()
+		if job.FinishedAt != nil {
+			end = *job.FinishedAt
+		}
+		running := end.Sub(*job.StartedAt).Milliseconds()
+		resp.RunningMs = &running
+	}
+
+	return resp
+}
+
+func MapSpriteToResponse(atlas *domain.SpriteAtlas, imageURI string) *SpriteResponse {
+	resp := &SpriteResponse{
+		Width:   atlas.Width,
+		Height:  atlas.Height,
+		Sprites: make([]*SpriteFrameResponse, 0, len(atlas.Frames)),
+		Image:   imageURI,
+	}
+	for _, f := range atlas.Frames {
+		resp.Sprites = append(resp.Sprites, &SpriteFrameResponse{
+			Name:   f.Name,
+			X:      f.X,
+			Y:      f.Y,
+			Width:  f.Width,
+			Height: f.Height,
+		})
+	}
+	return resp
+}
+
+func MapPerformanceToResponse(report *domain.PerformanceReport, window string) *PerformanceResponse {
+	resp := &PerformanceResponse{
+		Window:  window,
+		From:    report.From,
+		To:      report.To,
+		Buckets: make([]*PerformanceBucketResponse, 0, len(report.Buckets)),
+	}
+	for _, b := range report.Buckets {
+		resp.Buckets = append(resp.Buckets, &PerformanceBucketResponse{
+			Start:         b.Start,
+			Completed:     b.Completed,
+			Failed:        b.Failed,
+			FailureRate:   math.Round(b.FailureRate()*1e4) / 1e4,
+			AvgDurationMs: math.Round(b.AvgDurationMs*10) / 10,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,77 @@
+package dto
+
+import (
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestBuildSrcset(t *testing.T) {
+	tests := []struct {
+		name       string
+		variants   []domain.ImageVariant
+		wantSrcset string
+		wantSizes  string
+	}{
+		{name: "no variants"},
+		{
+			name:     "no known width",
+			variants: []domain.ImageVariant{{Name: "square", Format: "jpeg"}},
+		},
+		{
+			name:       "single variant",
+			variants:   []domain.ImageVariant{{Name: "small", Width: 320}},
+			wantSrcset: "http://api/image/a/variants/small 320w",
+			wantSizes:  "100vw",
+		},
+		{
+			name: "sorted by width, first name wins a tie",
+			variants: []domain.ImageVariant{
+				{Name: "large", Width: 1280},
+				{Name: "medium", Width: 640},
+				{Name: "medium_webp", Width: 640},
+				{Name: "small", Width: 320},
+				{Name: "square"},
+			},
+			wantSrcset: "http://api/image/a/variants/small 320w, http://api/image/a/variants/medium 640w, http://api/image/a/variants/large 1280w",
+			wantSizes:  "100vw",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := MapImageToResponse(&domain.Image{ID: "a", Variants: tt.variants}, "http://api")
+			if resp.Srcset != tt.wantSrcset {
+				t.Errorf("srcset = %q, want %q", resp.Srcset, tt.wantSrcset)
+			}
+			if resp.Sizes != tt.wantSizes {
+				t.Errorf("sizes = %q, want %q", resp.Sizes, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestProcessedURLOnlyForServableFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		image domain.Image
+		want  string
+	}{
+		{name: "completed with a file", image: domain.Image{ID: "a", Status: domain.StatusCompleted, ProcessedPath: "processed/a.jpg"}, want: "http://api/image/a"},
+		{name: "legacy completed row without a file", image: domain.Image{ID: "a", Status: domain.StatusCompleted}},
+		{name: "failed with a stale file", image: domain.Image{ID: "a", Status: domain.StatusFailed, ProcessedPath: "processed/a.jpg"}},
+		{name: "pending", image: domain.Image{ID: "a", Status: domain.StatusPending}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := MapImageToResponse(&tt.image, "http://api")
+			if resp.ProcessedURL != tt.want {
+				t.Fatalf("processed_url = %q, want %q", resp.ProcessedURL, tt.want)
+			}
+			if resp.OriginalURL != "http://api/image/a/original" {
+				t.Errorf("original_url = %q", resp.OriginalURL)
+			}
+		})
+	}
+}
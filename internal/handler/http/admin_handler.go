@@ -0,0 +1,92 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+type AdminHandler struct {
+	service  domain.AdminService
+	token    string
+	timeouts RouteTimeouts
+}
+
+func NewAdminHandler(service domain.AdminService, token string, timeouts RouteTimeouts) *AdminHandler {
+	return &AdminHandler{
+		service:  service,
+		token:    token,
+		timeouts: timeouts,
+	}
+}
+
+func (h *AdminHandler) RegisterRoutes(engine *ginext.Engine) {
+	admin := engine.Group("/admin",
+		middleware.AdminAuthMiddleware(h.token),
+		middleware.TimeoutMiddleware(h.timeouts.Admin),
+	)
+	admin.GET("/manifest", h.GetManifest)
+	admin.GET("/performance", h.GetPerformance)
+}
+
+// GET /admin/manifest
+func (h *AdminHandler) GetManifest(c *ginext.Context) {
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Disposition", "attachment; filename=manifest.json")
+	c.Header("Cache-Control", "no-store")
+
+	if err := h.service.WriteManifest(c.Request.Context(), c.Writer); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to write manifest")
+		if c.Writer.Written() {
+			// Part of the body is already out; abort so the client sees
+			// a truncated response instead of a corrupt manifest.
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to build manifest",
+		})
+	}
+}
+
+// defaultPerformanceWindow is used when GET /admin/performance has no window.
+const defaultPerformanceWindow = 24 * time.Hour
+
+// GET /admin/performance?window=24h
+func (h *AdminHandler) GetPerformance(c *ginext.Context) {
+	window := defaultPerformanceWindow
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < time.Hour || parsed > domain.MaxPerformanceWindow || parsed%time.Hour != 0 {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_window",
+				Message: fmt.Sprintf("window must be a whole number of hours between 1h and %dh, e.g. 24h", domain.MaxPerformanceWindow/time.Hour),
+			})
+			return
+		}
+		window = parsed
+	}
+
+	report, err := h.service.Performance(c.Request.Context(), window)
+	if err != nil {
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Dur("window", window).Msg("failed to build performance report")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to build performance report",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, dto.MapPerformanceToResponse(report, fmt.Sprintf("%dh", window/time.Hour)))
+}
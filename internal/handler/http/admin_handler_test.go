@@ -0,0 +1,81 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type fakeAdminService struct {
+	domain.AdminService
+	manifest string
+	err      error
+}
+
+func (s *fakeAdminService) WriteManifest(ctx context.Context, w io.Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := io.WriteString(w, s.manifest)
+	return err
+}
+
+func getManifest(service domain.AdminService, token, authorization string) *httptest.ResponseRecorder {
+	engine := ginext.New("release")
+	NewAdminHandler(service, token, RouteTimeouts{}).RegisterRoutes(engine)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/manifest", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestManifestRequiresAdminToken(t *testing.T) {
+	service := &fakeAdminService{manifest: `{"version":1,"images":[],"count":0}`}
+	tests := []struct {
+		name, token, authorization string
+		want                       int
+	}{
+		{"admin api disabled", "", "Bearer anything", http.StatusNotFound},
+		{"no token", "s3cret", "", http.StatusUnauthorized},
+		{"wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", "s3cret", "s3cret", http.StatusUnauthorized},
+		{"admin token", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getManifest(service, tt.token, tt.authorization)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != service.manifest {
+				t.Errorf("body = %q, want the manifest", rec.Body.String())
+			}
+			if got := rec.Header().Get("Content-Disposition"); got != "attachment; filename=manifest.json" {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestManifestFailureBeforeWritingIs500(t *testing.T) {
+	rec := getManifest(&fakeAdminService{err: errors.New("db down")}, "s3cret", "Bearer s3cret")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package http
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+type AnalysisHandler struct {
+	service  domain.AnalysisService
+	timeouts RouteTimeouts
+}
+
+func NewAnalysisHandler(service domain.AnalysisService, timeouts RouteTimeouts) *AnalysisHandler {
+	return &AnalysisHandler{
+		service:  service,
+		timeouts: timeouts,
+	}
+}
+
+func (h *AnalysisHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.GET("/image/:id/histogram", middleware.TimeoutMiddleware(h.timeouts.Processing), h.GetHistogram)
+	engine.GET("/image/:id/diff", middleware.TimeoutMiddleware(h.timeouts.Processing), h.GetDiff)
+}
+
+// GET /image/:id/histogram
+func (h *AnalysisHandler) GetHistogram(c *ginext.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Image ID is required",
+		})
+		return
+	}
+
+	buckets := 256
+	if b := c.Query("buckets"); b != "" {
+		val, err := strconv.Atoi(b)
+		if err != nil || val < 1 || val > 256 {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_buckets",
+				Message: "Buckets must be an integer between 1 and 256",
+			})
+			return
+		}
+		buckets = val
+	}
+
+	hist, err := h.service.GetHistogram(c.Request.Context(), id, buckets)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case errors.Is(err, domain.ErrTooManyPixels):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "too_many_pixels",
+				Message: "Stored image exceeds the maximum pixel count",
+			})
+		case errors.Is(err, domain.ErrInvalidImageData):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "decode_failed",
+				Message: "Stored image could not be decoded",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to compute histogram")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to compute histogram",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.HistogramResponse{
+		ID:         id,
+		Buckets:    hist.Buckets,
+		Pixels:     hist.Pixels,
+		Channels:   domain.HistogramChannels,
+		Histograms: hist.Channels,
+	})
+}
+
+// GET /image/:id/diff
+func (h *AnalysisHandler) GetDiff(c *ginext.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Image ID is required",
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.service.WriteDiff(c.Request.Context(), id, &buf); err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case errors.Is(err, domain.ErrImageNotProcessed):
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "not_processed",
+				Message: "Image has not been processed yet",
+			})
+		case errors.Is(err, domain.ErrTooManyPixels):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "too_many_pixels",
+				Message: "Stored image exceeds the maximum pixel count",
+			})
+		case errors.Is(err, domain.ErrInvalidImageData):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "decode_failed",
+				Message: "Stored image could not be decoded",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to compute diff")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to compute diff",
+			})
+		}
+		return
+	}
+
+	c.Header("Content-Length", strconv.Itoa(buf.Len()))
+	c.Data(http.StatusOK, "image/png", buf.Bytes())
+}
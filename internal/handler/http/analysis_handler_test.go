@@ -0,0 +1,72 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// fakeAnalysisService writes a fixed diff, or fails with err before writing.
+type fakeAnalysisService struct {
+	domain.AnalysisService
+	diff []byte
+	err  error
+}
+
+func (s *fakeAnalysisService) WriteDiff(ctx context.Context, id string, w io.Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	_, err := w.Write(s.diff)
+	return err
+}
+
+func getDiff(service domain.AnalysisService) *httptest.ResponseRecorder {
+	engine := ginext.New("release")
+	NewAnalysisHandler(service, RouteTimeouts{}).RegisterRoutes(engine)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/image/img-1/diff", nil))
+	return rec
+}
+
+func TestGetDiffServesPNG(t *testing.T) {
+	diff := encodeTestPNG(t)
+	rec := getDiff(&fakeAnalysisService{diff: diff})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if _, err := png.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+		t.Fatalf("body is not a PNG: %v", err)
+	}
+}
+
+func TestGetDiffErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{domain.ErrImageNotFound, http.StatusNotFound},
+		{domain.ErrImageNotProcessed, http.StatusConflict},
+		{domain.ErrTooManyPixels, http.StatusUnprocessableEntity},
+		{domain.ErrInvalidImageData, http.StatusUnprocessableEntity},
+		{context.Canceled, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if rec := getDiff(&fakeAnalysisService{err: tt.err}); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
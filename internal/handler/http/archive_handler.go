@@ -0,0 +1,265 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// ArchiveLimits bound POST /upload/archive.
+type ArchiveLimits struct {
+	// MaxSize caps the uploaded ZIP itself.
+	MaxSize int64
+	// MaxUncompressed caps the bytes inflated from all entries together.
+	// It is enforced on the bytes actually read, since the sizes an
+	// archive declares are exactly what a zip bomb fakes.
+	MaxUncompressed int64
+	MaxFiles        int
+}
+
+// Statuses of dto.ArchiveEntryResult.
+const (
+	archiveEntryProcessed = "processed"
+	archiveEntrySkipped   = "skipped"
+	archiveEntryFailed    = "failed"
+)
+
+// POST /upload/archive
+//
+// Every file in the ZIP goes through the same checks and upload as a file
+// of POST /upload/batch, one entry in memory at a time. Directories are
+// ignored; hidden files, macOS metadata and files that are not an allowed
+// image format are reported as skipped. Once the entries inflate past
+// MaxUncompressed, extraction stops and the remaining entries fail.
+func (h *ImageHandler) UploadArchive(c *ginext.Context) {
+	file, header, err := c.Request.FormFile("archive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "No archive file provided",
+		})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.archive.MaxSize {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "archive_too_large",
+			Message: fmt.Sprintf("Archive exceeds the maximum allowed size (%d MB)", h.archive.MaxSize/(1024*1024)),
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(c.PostForm("processing_type"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	opts, ok := parseProcessingOptions(c, pt)
+	if !ok {
+		return
+	}
+
+	tenantID, ok := parseTenantID(c)
+	if !ok {
+		return
+	}
+
+	opts.CallbackURL, ok = parseCallbackURL(c, c.PostForm("callback_url"))
+	if !ok {
+		return
+	}
+
+	zr, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("filename", header.Filename).Msg("failed to open uploaded archive")
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_archive",
+			Message: "File is not a valid ZIP archive",
+		})
+		return
+	}
+
+	if len(zr.File) > h.archive.MaxFiles {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "too_many_files",
+			Message: fmt.Sprintf("At most %d entries can be uploaded in one archive", h.archive.MaxFiles),
+		})
+		return
+	}
+
+	// the declared sizes are cheap to check up front; the budget below
+	// catches archives that understate them
+	var declared uint64
+	for _, f := range zr.File {
+		declared += f.UncompressedSize64
+	}
+	if declared > uint64(h.archive.MaxUncompressed) {
+		c.JSON(http.StatusBadRequest, archiveTooLargeResponse(h.archive.MaxUncompressed))
+		return
+	}
+
+	baseURL := getBaseURL(c)
+	response := &dto.ArchiveUploadResponse{
+		Results: make([]*dto.ArchiveEntryResult, 0, len(zr.File)),
+	}
+	budget := h.archive.MaxUncompressed
+	exhausted := false
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		result := &dto.ArchiveEntryResult{Name: f.Name}
+		response.Results = append(response.Results, result)
+
+		if reason := archiveSkipReason(f.Name, h.allowedFormats); reason != "" {
+			result.Status, result.Reason = archiveEntrySkipped, reason
+			response.Skipped++
+			continue
+		}
+
+		if exhausted {
+			result.Status, result.Error = archiveEntryFailed, archiveTooLargeResponse(h.archive.MaxUncompressed)
+			response.Failed++
+			continue
+		}
+
+		img, errResp := h.uploadArchiveEntry(c, f, pt, opts, tenantID, &budget)
+		if errResp != nil {
+			exhausted = budget < 0
+			result.Status, result.Error = archiveEntryFailed, errResp
+			response.Failed++
+			continue
+		}
+		result.Status, result.Image = archiveEntryProcessed, dto.MapImageToResponse(img, baseURL)
+		response.Processed++
+	}
+
+	zlog.Logger.Info().
+		Str("filename", header.Filename).
+		Int("processed", response.Processed).
+		Int("skipped", response.Skipped).
+		Int("failed", response.Failed).
+		Int64("inflated_bytes", h.archive.MaxUncompressed-max(budget, 0)).
+		Msg("archive upload finished")
+
+	status := http.StatusCreated
+	switch {
+	case response.Processed == 0:
+		status = http.StatusBadRequest
+	case response.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+
+	c.JSON(status, response)
+}
+
+// uploadArchiveEntry extracts and uploads one entry. Every byte inflated
+// is taken from budget; when the entry does not fit, budget is left
+// negative and the archive is not read any further.
+func (h *ImageHandler) uploadArchiveEntry(
+	c *ginext.Context,
+	f *zip.File,
+	pt domain.ProcessingType,
+	opts domain.ProcessingOptions,
+	tenantID string,
+	budget *int64,
+) (*domain.Image, *dto.ErrorResponse) {
+	ext := strings.ToLower(filepath.Ext(f.Name))
+	limit := h.maxSizeFor(ext)
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fileTooLargeResponse(ext, limit)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("entry", f.Name).Msg("failed to open archive entry")
+		return nil, invalidArchiveEntryResponse()
+	}
+	defer rc.Close()
+
+	allowed := min(limit, *budget)
+	data, err := io.ReadAll(io.LimitReader(rc, allowed+1))
+	*budget -= int64(len(data))
+	if int64(len(data)) > allowed {
+		if allowed == limit && *budget >= 0 {
+			return nil, fileTooLargeResponse(ext, limit)
+		}
+		*budget = -1
+		return nil, archiveTooLargeResponse(h.archive.MaxUncompressed)
+	}
+	if err != nil {
+		// includes checksum mismatches of corrupt entries
+		zlog.Logger.Warn().Err(err).Str("entry", f.Name).Msg("failed to extract archive entry")
+		return nil, invalidArchiveEntryResponse()
+	}
+
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return nil, &dto.ErrorResponse{
+			Error:   "decode_failed",
+			Message: "File is not a decodable image",
+		}
+	}
+
+	img, err := h.service.UploadImage(c.Request.Context(), path.Base(f.Name), http.DetectContentType(data), int64(len(data)), bytes.NewReader(data), pt, opts, tenantID)
+	if err != nil {
+		if errors.Is(err, domain.ErrFileTooLarge) {
+			return nil, fileTooLargeResponse(ext, limit)
+		}
+		if errResp := uploadErrorResponse(err); errResp != nil {
+			return nil, errResp
+		}
+		zlog.Logger.Error().Err(err).Str("entry", f.Name).Msg("failed to upload archive entry")
+		return nil, &dto.ErrorResponse{
+			Error:   "upload_failed",
+			Message: "Failed to upload image",
+		}
+	}
+
+	return img, nil
+}
+
+// archiveSkipReason tells why an entry is not uploaded, or "" when it is
+// an image file to upload.
+func archiveSkipReason(name string, allowedFormats []string) string {
+	base := path.Base(name)
+	switch {
+	case strings.HasPrefix(name, "__MACOSX/"), strings.HasPrefix(base, "."):
+		return "hidden or metadata file"
+	case !isAllowedFormat(allowedFormats, strings.ToLower(filepath.Ext(base))):
+		return "not an allowed image format"
+	default:
+		return ""
+	}
+}
+
+func archiveTooLargeResponse(maxUncompressed int64) *dto.ErrorResponse {
+	return &dto.ErrorResponse{
+		Error:   "archive_too_large",
+		Message: fmt.Sprintf("Archive contents exceed the maximum allowed uncompressed size (%d MB)", maxUncompressed/(1024*1024)),
+	}
+}
+
+func invalidArchiveEntryResponse() *dto.ErrorResponse {
+	return &dto.ErrorResponse{
+		Error:   "invalid_archive_entry",
+		Message: "Entry could not be extracted from the archive",
+	}
+}
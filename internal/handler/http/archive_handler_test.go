@@ -0,0 +1,190 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+func encodeTestPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+func buildZip(t *testing.T, entries []zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatalf("create %s: %v", e.name, err)
+		}
+		w.Write(e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// addLyingEntry appends an entry of n zero bytes whose header claims it
+// inflates to a single byte, the way a zip bomb hides its size.
+func addLyingEntry(t *testing.T, zw *zip.Writer, name string, n int) {
+	t.Helper()
+	data := make([]byte, n)
+	var compressed bytes.Buffer
+	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	fw.Write(data)
+	fw.Close()
+
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   uint64(compressed.Len()),
+		UncompressedSize64: 1,
+	})
+	if err != nil {
+		t.Fatalf("create %s: %v", name, err)
+	}
+	w.Write(compressed.Bytes())
+}
+
+func postArchive(t *testing.T, h *ImageHandler, archive []byte) (*httptest.ResponseRecorder, dto.ArchiveUploadResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "photos.zip")
+	part.Write(archive)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload/archive", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	engine := ginext.New("release")
+	engine.POST("/upload/archive", h.UploadArchive)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+
+	var resp dto.ArchiveUploadResponse
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func newArchiveHandler(service *fakeImageService, limits ArchiveLimits) *ImageHandler {
+	h := newUploadHandler(service)
+	h.archive = limits
+	return h
+}
+
+func TestUploadArchiveWithMixedContent(t *testing.T) {
+	img := encodeTestPNG(t)
+	archive := buildZip(t, []zipEntry{
+		{name: "photos/"},
+		{name: "photos/a.png", data: img},
+		{name: "b.PNG", data: img},
+		{name: "readme.txt", data: []byte("hello")},
+		{name: ".thumbs.png", data: img},
+		{name: "__MACOSX/photos/._a.png", data: []byte("resource fork")},
+		{name: "broken.jpg", data: []byte("not a jpeg")},
+	})
+	service := &fakeImageService{}
+	h := newArchiveHandler(service, ArchiveLimits{MaxSize: 1 << 20, MaxUncompressed: 1 << 20, MaxFiles: 10})
+
+	rec, resp := postArchive(t, h, archive)
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body)
+	}
+	if resp.Processed != 2 || resp.Skipped != 3 || resp.Failed != 1 {
+		t.Fatalf("processed %d, skipped %d, failed %d; want 2, 3, 1", resp.Processed, resp.Skipped, resp.Failed)
+	}
+	if !slices.Equal(service.uploadedNames, []string{"a.png", "b.PNG"}) {
+		t.Errorf("uploaded %v, want the two images by base name", service.uploadedNames)
+	}
+
+	want := map[string]string{
+		"photos/a.png":            archiveEntryProcessed,
+		"b.PNG":                   archiveEntryProcessed,
+		"readme.txt":              archiveEntrySkipped,
+		".thumbs.png":             archiveEntrySkipped,
+		"__MACOSX/photos/._a.png": archiveEntrySkipped,
+		"broken.jpg":              archiveEntryFailed,
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("%d results, want %d without the directory", len(resp.Results), len(want))
+	}
+	for _, r := range resp.Results {
+		if r.Status != want[r.Name] {
+			t.Errorf("%s: status %q, want %q", r.Name, r.Status, want[r.Name])
+		}
+		if r.Status == archiveEntryFailed && (r.Error == nil || r.Error.Error != "decode_failed") {
+			t.Errorf("%s: error %+v, want decode_failed", r.Name, r.Error)
+		}
+	}
+}
+
+func TestUploadArchiveRejectsEntryInflatingPastItsHeader(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	addLyingEntry(t, zw, "bomb.png", 2<<20)
+	w, _ := zw.Create("after.png")
+	w.Write(encodeTestPNG(t))
+	zw.Close()
+
+	service := &fakeImageService{}
+	h := newArchiveHandler(service, ArchiveLimits{MaxSize: 1 << 20, MaxUncompressed: 1 << 20, MaxFiles: 10})
+
+	rec, resp := postArchive(t, h, buf.Bytes())
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207: %s", rec.Code, rec.Body)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("%d results, want 2", len(resp.Results))
+	}
+	if bomb := resp.Results[0]; bomb.Status != archiveEntryFailed || bomb.Error == nil || bomb.Error.Error != "invalid_archive_entry" {
+		t.Errorf("bomb.png: %+v, want failed as invalid_archive_entry", bomb)
+	}
+	if !slices.Equal(service.uploadedNames, []string{"after.png"}) {
+		t.Errorf("uploaded %v, want only after.png", service.uploadedNames)
+	}
+}
+
+func TestUploadArchiveRejectsDeclaredOversize(t *testing.T) {
+	archive := buildZip(t, []zipEntry{{name: "big.png", data: make([]byte, 4096)}})
+	service := &fakeImageService{}
+	h := newArchiveHandler(service, ArchiveLimits{MaxSize: 1 << 20, MaxUncompressed: 1024, MaxFiles: 10})
+
+	rec, _ := postArchive(t, h, archive)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var errResp dto.ErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &errResp)
+	if errResp.Error != "archive_too_large" || len(service.uploadedNames) != 0 {
+		t.Fatalf("error %q, uploaded %v; want archive_too_large before any upload", errResp.Error, service.uploadedNames)
+	}
+}
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// base64Overhead leaves room for the JSON envelope around the data field.
+const base64Overhead = 64 * 1024
+
+// POST /upload/base64
+func (h *ImageHandler) UploadBase64(c *ginext.Context) {
+	// Cap the body before binding so an oversized payload is never buffered
+	// in full; the exact per-format limit is checked once the filename is known.
+	maxBody := int64(base64.StdEncoding.EncodedLen(int(h.maxAllowedSize()))) + base64Overhead
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBody)
+
+	var req dto.Base64UploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		zlog.Logger.Warn().Err(err).Msg("failed to bind base64 upload request")
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must be JSON with filename and data, and within the upload size limit",
+		})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(req.Filename))
+	if !h.isAllowedFormat(ext) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: fmt.Sprintf("Unsupported file format. Allowed: %v", h.allowedFormats),
+		})
+		return
+	}
+
+	data := req.Data
+	// Accept data URIs as produced by FileReader.readAsDataURL
+	if strings.HasPrefix(data, "data:") {
+		if _, payload, ok := strings.Cut(data, ","); ok {
+			data = payload
+		}
+	}
+
+	// DecodedLen counts padding as data, hence the slack of 2 bytes; the
+	// exact size is rechecked after decoding.
+	limit := h.maxSizeFor(ext)
+	if int64(base64.StdEncoding.DecodedLen(len(data))) > limit+2 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "file_too_large",
+			Message: fmt.Sprintf("File size exceeds maximum allowed for %s files (%d MB)", strings.TrimPrefix(ext, "."), limit/(1024*1024)),
+		})
+		return
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(decoded) == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_base64",
+			Message: "Data must be standard base64-encoded image bytes",
+		})
+		return
+	}
+	if int64(len(decoded)) > limit {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "file_too_large",
+			Message: fmt.Sprintf("File size exceeds maximum allowed for %s files (%d MB)", strings.TrimPrefix(ext, "."), limit/(1024*1024)),
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(req.ProcessingType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	if !validRotation(pt, req.Angle) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_angle",
+			Message: invalidAngleMessage,
+		})
+		return
+	}
+
+	flip, ok := parseFlip(req.Flip)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_flip",
+			Message: invalidFlipMessage,
+		})
+		return
+	}
+
+	if !validEncodeOverrides(c, req.Quality, req.WatermarkText) {
+		return
+	}
+
+	if req.Radius != nil && (*req.Radius < 0 || *req.Radius > domain.MaxTargetDimension) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_radius",
+			Message: invalidRadiusMessage,
+		})
+		return
+	}
+
+	if req.Page != nil && !domain.ValidPageNumber(*req.Page) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_page",
+			Message: invalidPageMessage,
+		})
+		return
+	}
+
+	tenantID, ok := parseTenantID(c)
+	if !ok {
+		return
+	}
+
+	callbackURL, ok := parseCallbackURL(c, req.CallbackURL)
+	if !ok {
+		return
+	}
+
+	image, err := h.service.UploadImage(
+		c.Request.Context(),
+		filepath.Base(req.Filename),
+		http.DetectContentType(decoded),
+		int64(len(decoded)),
+		bytes.NewReader(decoded),
+		pt,
+		domain.ProcessingOptions{
+			Flatten:       req.Flatten,
+			Angle:         req.Angle,
+			Flip:          flip,
+			Pipeline:      req.Pipeline,
+			Width:         targetDimension(req.Width),
+			Height:        targetDimension(req.Height),
+			Page:          req.Page,
+			Radius:        req.Radius,
+			Quality:       req.Quality,
+			WatermarkText: req.WatermarkText,
+			CallbackURL:   callbackURL,
+		},
+		tenantID,
+	)
+	if err != nil {
+		if errResp := uploadErrorResponse(err); errResp != nil {
+			c.JSON(http.StatusBadRequest, errResp)
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Msg("failed to upload base64 image")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "upload_failed",
+			Message: "Failed to upload image",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.MapImageToResponse(image, getBaseURL(c)))
+}
+
+// maxAllowedSize is the largest limit across the global and per-format ones.
+func (h *ImageHandler) maxAllowedSize() int64 {
+	max := h.maxUploadSize
+	for _, limit := range h.formatSizeLimits {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// POST /upload/batch
+//
+// Every file is validated and stored independently: a failing file is
+// reported in its own result entry and never undoes files stored before it.
+func (h *ImageHandler) UploadBatch(c *ginext.Context) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["images"]) == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "No image files provided",
+		})
+		return
+	}
+
+	headers := form.File["images"]
+	if len(headers) > h.maxBatchFiles {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "too_many_files",
+			Message: fmt.Sprintf("At most %d files can be uploaded in one batch", h.maxBatchFiles),
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(c.PostForm("processing_type"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	opts, ok := parseProcessingOptions(c, pt)
+	if !ok {
+		return
+	}
+
+	tenantID, ok := parseTenantID(c)
+	if !ok {
+		return
+	}
+
+	opts.CallbackURL, ok = parseCallbackURL(c, c.PostForm("callback_url"))
+	if !ok {
+		return
+	}
+
+	baseURL := getBaseURL(c)
+	response := &dto.BatchUploadResponse{
+		Results: make([]*dto.BatchUploadResult, 0, len(headers)),
+	}
+
+	for _, header := range headers {
+		result := &dto.BatchUploadResult{Filename: header.Filename}
+
+		img, errResp := h.uploadBatchFile(c, header, pt, opts, tenantID)
+		if errResp != nil {
+			result.Error = errResp
+			response.Failed++
+		} else {
+			result.Image = dto.MapImageToResponse(img, baseURL)
+			response.Succeeded++
+		}
+
+		response.Results = append(response.Results, result)
+	}
+
+	status := http.StatusCreated
+	switch {
+	case response.Succeeded == 0:
+		status = http.StatusBadRequest
+	case response.Failed > 0:
+		status = http.StatusMultiStatus
+	}
+
+	c.JSON(status, response)
+}
+
+func (h *ImageHandler) uploadBatchFile(
+	c *ginext.Context,
+	header *multipart.FileHeader,
+	pt domain.ProcessingType,
+	opts domain.ProcessingOptions,
+	tenantID string,
+) (*domain.Image, *dto.ErrorResponse) {
+	if errResp := h.validateFileHeader(header); errResp != nil {
+		return nil, errResp
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("filename", header.Filename).Msg("failed to open batch file")
+		return nil, &dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to read uploaded file",
+		}
+	}
+	defer file.Close()
+
+	if _, _, err := image.DecodeConfig(file); err != nil {
+		return nil, &dto.ErrorResponse{
+			Error:   "decode_failed",
+			Message: "File is not a decodable image",
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		zlog.Logger.Error().Err(err).Str("filename", header.Filename).Msg("failed to rewind batch file")
+		return nil, &dto.ErrorResponse{
+			Error:   "upload_failed",
+			Message: "Failed to upload image",
+		}
+	}
+
+	mimeType := header.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	limit := h.maxSizeFor(ext)
+	body := newSizeLimitedReader(file, limit)
+
+	img, err := h.service.UploadImage(c.Request.Context(), header.Filename, mimeType, header.Size, body, pt, opts, tenantID)
+	if err != nil {
+		if body.exceeded || errors.Is(err, domain.ErrFileTooLarge) {
+			return nil, fileTooLargeResponse(ext, limit)
+		}
+		if errResp := uploadErrorResponse(err); errResp != nil {
+			return nil, errResp
+		}
+		zlog.Logger.Error().Err(err).Str("filename", header.Filename).Msg("failed to upload batch file")
+		return nil, &dto.ErrorResponse{
+			Error:   "upload_failed",
+			Message: "Failed to upload image",
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+type batchFile struct {
+	name string
+	data []byte
+}
+
+// postBatch sends files as the images of a multipart POST /upload/batch.
+func postBatch(t *testing.T, h *ImageHandler, files []batchFile) (*httptest.ResponseRecorder, *dto.BatchUploadResponse) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := form.CreateFormFile("images", f.name)
+		if err != nil {
+			t.Fatalf("create form file: %v", err)
+		}
+		part.Write(f.data)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload/batch", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	engine := ginext.New("release")
+	engine.POST("/upload/batch", h.UploadBatch)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+
+	var resp dto.BatchUploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+	return rec, &resp
+}
+
+func TestUploadBatchReportsEachFailingFile(t *testing.T) {
+	service := &fakeImageService{}
+	h := newUploadHandler(service)
+	h.maxBatchFiles = 10
+	h.formatSizeLimits = map[string]int64{"jpg": 256}
+
+	good := encodeTestPNG(t)
+	rec, resp := postBatch(t, h, []batchFile{
+		{"first.png", good},
+		{"huge.jpg", bytes.Repeat([]byte{0xff}, 1024)},
+		{"notes.txt", []byte("not an image")},
+		{"broken.png", []byte("\x89PNG\r\n\x1a\nbroken")},
+		{"second.png", good},
+	})
+
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d, want 207", rec.Code)
+	}
+	if resp.Succeeded != 2 || resp.Failed != 3 {
+		t.Fatalf("succeeded %d, failed %d; want 2 and 3", resp.Succeeded, resp.Failed)
+	}
+
+	wantErrors := map[string]string{
+		"first.png":  "",
+		"huge.jpg":   "file_too_large",
+		"notes.txt":  "invalid_format",
+		"broken.png": "decode_failed",
+		"second.png": "",
+	}
+	if len(resp.Results) != len(wantErrors) {
+		t.Fatalf("%d results, want %d", len(resp.Results), len(wantErrors))
+	}
+	for _, r := range resp.Results {
+		want, ok := wantErrors[r.Filename]
+		if !ok {
+			t.Fatalf("result for unexpected file %q", r.Filename)
+		}
+		switch {
+		case want == "" && (r.Image == nil || r.Error != nil):
+			t.Errorf("%s: image %v, error %v; want the stored image", r.Filename, r.Image, r.Error)
+		case want != "" && (r.Error == nil || r.Error.Error != want || r.Error.Message == ""):
+			t.Errorf("%s: error %+v, want %s with a message", r.Filename, r.Error, want)
+		case want != "" && r.Image != nil:
+			t.Errorf("%s failed but also returned an image", r.Filename)
+		}
+	}
+
+	// the failures in between did not undo the files stored around them
+	if !slices.Equal(service.uploadedNames, []string{"first.png", "second.png"}) {
+		t.Fatalf("stored %v, want first.png and second.png", service.uploadedNames)
+	}
+}
+
+func TestUploadBatchAllFailingIs400(t *testing.T) {
+	h := newUploadHandler(&fakeImageService{})
+	h.maxBatchFiles = 10
+
+	rec, resp := postBatch(t, h, []batchFile{{"notes.txt", []byte("text")}})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if resp.Failed != 1 || resp.Results[0].Error.Error != "invalid_format" {
+		t.Fatalf("response %+v, want one invalid_format failure", resp)
+	}
+}
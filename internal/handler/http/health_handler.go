@@ -0,0 +1,82 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// HealthCheck is one dependency probed by GET /health/ready.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	checks  []HealthCheck
+	timeout time.Duration
+}
+
+// NewHealthHandler builds the readiness handler; timeout bounds each run
+// of all checks, which execute concurrently.
+func NewHealthHandler(checks []HealthCheck, timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		checks:  checks,
+		timeout: timeout,
+	}
+}
+
+func (h *HealthHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.GET("/health", h.Live)
+	engine.GET("/health/ready", h.Ready)
+}
+
+// GET /health
+//
+// Liveness only: the process is up and serving.
+func (h *HealthHandler) Live(c *ginext.Context) {
+	c.JSON(http.StatusOK, ginext.H{"status": "ok"})
+}
+
+// GET /health/ready
+func (h *HealthHandler) Ready(c *ginext.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+	defer cancel()
+
+	results := make(map[string]string, len(h.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			result := "ok"
+			if err := check.Check(ctx); err != nil {
+				// the error may name hosts or queries; callers are unauthenticated
+				zlog.Logger.Warn().Err(err).Str("dependency", check.Name).Msg("readiness check failed")
+				result = "unavailable"
+			}
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+	c.JSON(code, dto.ReadinessResponse{
+		Status: status,
+		Checks: results,
+	})
+}
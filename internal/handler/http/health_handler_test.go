@@ -0,0 +1,43 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+func TestReadyHidesCheckErrors(t *testing.T) {
+	h := NewHealthHandler([]HealthCheck{
+		{Name: "database", Check: func(ctx context.Context) error { return nil }},
+		{Name: "storage", Check: func(ctx context.Context) error {
+			return errors.New(`dial tcp minio.internal:9000: connection refused`)
+		}},
+	}, time.Second)
+	engine := ginext.New("release")
+	h.RegisterRoutes(engine)
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "minio.internal") {
+		t.Fatalf("response leaks the error: %s", rec.Body.String())
+	}
+	var resp dto.ReadinessResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Status != "unavailable" || resp.Checks["storage"] != "unavailable" || resp.Checks["database"] != "ok" {
+		t.Fatalf("response = %+v", resp)
+	}
+}
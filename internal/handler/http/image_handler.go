@@ -1,40 +1,100 @@
 package http
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/wb-go/wbf/ginext"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/domain"
 	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
 )
 
+// tenantHeader identifies the tenant an upload is counted against;
+// it is set by the auth gateway, like X-User.
+const (
+	tenantHeader   = "X-Tenant-ID"
+	maxTenantIDLen = 64
+)
+
+// priorityHeader raises the queue priority of POST /upload without
+// touching the form.
+const priorityHeader = "X-Priority"
+
 type ImageHandler struct {
-	service        domain.ImageService
-	maxUploadSize  int64
-	allowedFormats []string
+	service          domain.ImageService
+	maxUploadSize    int64
+	formatSizeLimits map[string]int64
+	allowedFormats   []string
+	maxBatchFiles    int
+	archive          ArchiveLimits
+	timeouts         RouteTimeouts
+	pagination       domain.Pagination
+	// remote downloads images for POST /upload/url
+	remote *http.Client
 }
 
-func NewImageHandler(service domain.ImageService, maxUploadSizeMB int, allowedFormats []string) *ImageHandler {
+func NewImageHandler(
+	service domain.ImageService,
+	maxUploadSizeMB int,
+	formatSizeLimitsMB map[string]int,
+	allowedFormats []string,
+	maxBatchFiles int,
+	archive ArchiveLimits,
+	timeouts RouteTimeouts,
+	urlFetchTimeout time.Duration,
+	pagination domain.Pagination,
+) *ImageHandler {
+	formatSizeLimits := make(map[string]int64, len(formatSizeLimitsMB))
+	for format, mb := range formatSizeLimitsMB {
+		formatSizeLimits[strings.ToLower(format)] = int64(mb) * 1024 * 1024
+	}
+
 	return &ImageHandler{
-		service:        service,
-		maxUploadSize:  int64(maxUploadSizeMB) * 1024 * 1024,
-		allowedFormats: allowedFormats,
+		service:          service,
+		maxUploadSize:    int64(maxUploadSizeMB) * 1024 * 1024,
+		formatSizeLimits: formatSizeLimits,
+		allowedFormats:   allowedFormats,
+		maxBatchFiles:    maxBatchFiles,
+		archive:          archive,
+		timeouts:         timeouts,
+		pagination:       pagination,
+		remote:           newRemoteClient(urlFetchTimeout),
 	}
 }
 
 func (h *ImageHandler) RegisterRoutes(engine *ginext.Engine) {
-	engine.POST("/upload", h.UploadImage)
-	engine.GET("/image/:id", h.GetProcessedImage)
-	engine.GET("/image/:id/original", h.GetOriginalImage)
-	engine.DELETE("/image/:id", h.DeleteImage)
-	engine.GET("/images", h.ListImages)
+	upload := middleware.TimeoutMiddleware(h.timeouts.Upload)
+	engine.POST("/upload", upload, h.UploadImage)
+	engine.POST("/upload/batch", upload, h.UploadBatch)
+	engine.POST("/upload/archive", upload, h.UploadArchive)
+	engine.POST("/upload/base64", upload, h.UploadBase64)
+	engine.POST("/upload/url", upload, h.UploadFromURL)
+
+	read := middleware.TimeoutMiddleware(h.timeouts.Read)
+	engine.GET("/image/:id", read, h.GetProcessedImage)
+	engine.GET("/image/:id/original", read, h.GetOriginalImage)
+	engine.GET("/image/:id/status", read, h.GetImageStatus)
+	engine.GET("/image/:id/thumbnail", read, h.GetThumbnail)
+	engine.GET("/image/:id/queue-position", read, h.GetQueuePosition)
+	engine.GET("/image/:id/variants/:name", read, h.GetVariant)
+	engine.GET("/image/:id/variant/:name", redirectVariantAlias)
+	engine.DELETE("/image/:id", read, h.DeleteImage)
+	engine.POST("/image/:id/migrate", middleware.TimeoutMiddleware(h.timeouts.Processing), h.MigrateImage)
+	engine.GET("/images", read, h.ListImages)
 }
 
 // POST /upload
@@ -50,41 +110,37 @@ func (h *ImageHandler) UploadImage(c *ginext.Context) {
 	}
 	defer file.Close()
 
-	if header.Size > h.maxUploadSize {
-		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
-			Error:   "file_too_large",
-			Message: fmt.Sprintf("File size exceeds maximum allowed (%d MB)", h.maxUploadSize/(1024*1024)),
-		})
+	if errResp := h.validateFileHeader(header); errResp != nil {
+		c.JSON(http.StatusBadRequest, errResp)
 		return
 	}
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if !h.isAllowedFormat(ext) {
+	pt, ok := parseProcessingType(c.PostForm("processing_type"))
+	if !ok {
 		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
-			Error:   "invalid_format",
-			Message: fmt.Sprintf("Unsupported file format. Allowed: %v", h.allowedFormats),
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
 		})
 		return
 	}
 
-	processingType := c.PostForm("processing_type")
-	if processingType == "" {
-		processingType = "resize"
+	opts, ok := parseProcessingOptions(c, pt)
+	if !ok {
+		return
 	}
 
-	var pt domain.ProcessingType
-	switch processingType {
-	case "resize":
-		pt = domain.ProcessingResize
-	case "thumbnail":
-		pt = domain.ProcessingThumbnail
-	case "watermark":
-		pt = domain.ProcessingWatermark
-	default:
-		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
-			Error:   "invalid_processing_type",
-			Message: "Processing type must be one of: resize, thumbnail, watermark",
-		})
+	tenantID, ok := parseTenantID(c)
+	if !ok {
+		return
+	}
+
+	opts.Priority, ok = parsePriority(c)
+	if !ok {
+		return
+	}
+
+	opts.CallbackURL, ok = parseCallbackURL(c, c.PostForm("callback_url"))
+	if !ok {
 		return
 	}
 
@@ -93,16 +149,34 @@ func (h *ImageHandler) UploadImage(c *ginext.Context) {
 		mimeType = "application/octet-stream"
 	}
 
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	limit := h.maxSizeFor(ext)
+	body := newSizeLimitedReader(file, limit)
+
 	image, err := h.service.UploadImage(
 		c.Request.Context(),
 		header.Filename,
 		mimeType,
 		header.Size,
-		file,
+		body,
 		pt,
+		opts,
+		tenantID,
 	)
 
 	if err != nil {
+		if body.exceeded || errors.Is(err, domain.ErrFileTooLarge) {
+			zlog.Logger.Warn().Str("filename", header.Filename).Int64("limit", limit).Msg("upload exceeded size limit while streaming")
+			c.JSON(http.StatusBadRequest, fileTooLargeResponse(ext, limit))
+			return
+		}
+		if errResp := uploadErrorResponse(err); errResp != nil {
+			c.JSON(http.StatusBadRequest, errResp)
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
 		zlog.Logger.Error().Err(err).Msg("failed to upload image")
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "upload_failed",
@@ -111,7 +185,7 @@ func (h *ImageHandler) UploadImage(c *ginext.Context) {
 		return
 	}
 
-	baseURL := h.getBaseURL(c)
+	baseURL := getBaseURL(c)
 	response := dto.MapImageToResponse(image, baseURL)
 
 	c.JSON(http.StatusCreated, response)
@@ -128,7 +202,7 @@ func (h *ImageHandler) GetProcessedImage(c *ginext.Context) {
 		return
 	}
 
-	file, filename, err := h.service.GetImageFile(c.Request.Context(), id, false)
+	file, err := h.service.GetImageFile(c.Request.Context(), id, false)
 	if err != nil {
 		if err == domain.ErrImageNotFound {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
@@ -144,36 +218,13 @@ func (h *ImageHandler) GetProcessedImage(c *ginext.Context) {
 		})
 		return
 	}
-	defer file.Close()
-
-	contentType := h.getContentType(filename)
-
-	if v, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
-		if stat, err := v.Stat(); err == nil {
-			c.Header("Content-Length", strconv.FormatInt(stat.Size(), 10))
-		}
-	} else {
-		zlog.Logger.Debug().Str("image_id", id).Str("filename", filename).Msg("file does not implement Stat(os.FileInfo); skipping Content-Length header")
-	}
-
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%s", filename))
-
-	written, err := io.Copy(c.Writer, file)
-	if err != nil {
-		zlog.Logger.Error().
-			Err(err).
-			Str("image_id", id).
-			Str("filename", filename).
-			Int64("bytes_written", written).
-			Msg("failed to write image to response")
+	if file.RedirectURL != "" {
+		redirectToFile(c, file)
 		return
 	}
-	zlog.Logger.Info().
-		Str("image_id", id).
-		Str("filename", filename).
-		Int64("bytes_written", written).
-		Msg("processed image sent successfully")
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
 }
 
 // GET /image/:id/original
@@ -187,7 +238,20 @@ func (h *ImageHandler) GetOriginalImage(c *ginext.Context) {
 		return
 	}
 
-	file, filename, err := h.service.GetImageFile(c.Request.Context(), id, true)
+	resize, ok, err := parseResizeQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_resize",
+			Message: err.Error(),
+		})
+		return
+	}
+	if ok {
+		h.getResizedOriginal(c, id, resize)
+		return
+	}
+
+	file, err := h.service.GetImageFile(c.Request.Context(), id, true)
 	if err != nil {
 		if err == domain.ErrImageNotFound {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
@@ -203,39 +267,332 @@ func (h *ImageHandler) GetOriginalImage(c *ginext.Context) {
 		})
 		return
 	}
-	defer file.Close()
+	if file.RedirectURL != "" {
+		redirectToFile(c, file)
+		return
+	}
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
+}
+
+// POST /image/:id/migrate?to=s3|local
+func (h *ImageHandler) MigrateImage(c *ginext.Context) {
+	id := c.Param("id")
+	backend := strings.ToLower(strings.TrimSpace(c.Query("to")))
+	if backend == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Target backend is required (to=s3 or to=local)",
+		})
+		return
+	}
+
+	image, err := h.service.MigrateImage(c.Request.Context(), id, backend)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case errors.Is(err, domain.ErrBackendNotConfigured):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "backend_not_configured",
+				Message: fmt.Sprintf("Storage backend %q is not configured", backend),
+			})
+		case errors.Is(err, domain.ErrBackendUnavailable):
+			c.JSON(http.StatusServiceUnavailable, dto.ErrorResponse{
+				Error:   "backend_unavailable",
+				Message: "Storage backend is unavailable, retry later",
+			})
+		default:
+			zlog.Logger.Error().Err(err).Str("image_id", id).Str("backend", backend).Msg("failed to migrate image")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "migration_failed",
+				Message: "Failed to migrate image",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MapImageToResponse(image, getBaseURL(c)))
+}
+
+// GET /image/:id/thumbnail
+func (h *ImageHandler) GetThumbnail(c *ginext.Context) {
+	id := c.Param("id")
+
+	file, err := h.service.GetThumbnailFile(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) || errors.Is(err, domain.ErrThumbnailNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image thumbnail not found",
+			})
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to get thumbnail")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to retrieve thumbnail",
+		})
+		return
+	}
+	if file.RedirectURL != "" {
+		redirectToFile(c, file)
+		return
+	}
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
+}
+
+// getResizedOriginal serves GET /image/:id/original?w=&h=&fit=.
+func (h *ImageHandler) getResizedOriginal(c *ginext.Context, id string, resize domain.ResizeRequest) {
+	file, err := h.service.GetResizedOriginal(c.Request.Context(), id, resize)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case errors.Is(err, domain.ErrResizeNotAllowed):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_resize",
+				Message: "Requested size exceeds the configured maximum or is not in the allowed sizes",
+			})
+		case errors.Is(err, domain.ErrTooManyPixels):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "too_many_pixels",
+				Message: "Stored image exceeds the maximum pixel count",
+			})
+		case errors.Is(err, domain.ErrInvalidImageData):
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "decode_failed",
+				Message: "Stored image could not be decoded",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Str("image_id", id).Str("resize", resize.Key()).Msg("failed to resize original image")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to resize image",
+			})
+		}
+		return
+	}
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
+}
+
+// parseResizeQuery reads w, h and fit. It reports false when none is set,
+// i.e. the plain original is wanted.
+func parseResizeQuery(c *ginext.Context) (domain.ResizeRequest, bool, error) {
+	rawW, rawH, rawFit := c.Query("w"), c.Query("h"), c.Query("fit")
+	if rawW == "" && rawH == "" && rawFit == "" {
+		return domain.ResizeRequest{}, false, nil
+	}
+
+	req := domain.ResizeRequest{Fit: domain.FitContain}
+	if rawFit != "" {
+		req.Fit = domain.ResizeFit(strings.ToLower(rawFit))
+		if !req.Fit.IsValid() {
+			return req, false, fmt.Errorf("fit must be one of: contain, cover, fill")
+		}
+	}
+	var err error
+	if req.Width, err = parseResizeSide(rawW); err != nil {
+		return req, false, err
+	}
+	if req.Height, err = parseResizeSide(rawH); err != nil {
+		return req, false, err
+	}
+	if req.Width == 0 && req.Height == 0 {
+		return req, false, fmt.Errorf("w or h is required to resize")
+	}
+	req.WebP = accepts(c.GetHeader("Accept"), "image/webp")
 
-	contentType := h.getContentType(filename)
+	return req, true, nil
+}
 
-	if v, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
-		if stat, err := v.Stat(); err == nil {
-			c.Header("Content-Length", strconv.FormatInt(stat.Size(), 10))
+// accepts reports whether an Accept header lists mediaType explicitly with
+// a non-zero quality. Wildcards do not count: browsers send */* without
+// being able to show every format.
+func accepts(header, mediaType string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), mediaType) {
+			continue
+		}
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil && q == 0 {
+					return false
+				}
+			}
 		}
+		return true
+	}
+	return false
+}
+
+// parseResizeSide parses w or h; empty means the side is omitted.
+func parseResizeSide(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("w and h must be positive integers")
+	}
+	return v, nil
+}
+
+// addVary adds headers to the response's Vary header, keeping the ones set
+// earlier, e.g. by middleware. It must run before the status is written, so
+// that 304 responses carry it too.
+func addVary(c *ginext.Context, headers ...string) {
+	if len(headers) == 0 {
+		return
+	}
+
+	var values []string
+	seen := make(map[string]bool)
+	for _, v := range append(c.Writer.Header().Values("Vary"), headers...) {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "" || seen[h] {
+				continue
+			}
+			seen[h] = true
+			values = append(values, h)
+		}
+	}
+	c.Header("Vary", strings.Join(values, ", "))
+}
+
+// redirectToFile sends the client to the presigned URL of file. The URL
+// expires, so the redirect itself must not be cached.
+func redirectToFile(c *ginext.Context, file *domain.ImageFile) {
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, file.RedirectURL)
+}
+
+// serveImageFile writes file to the response with its ETag and
+// Cache-Control. Seekable files (local files and S3 objects, which fetch
+// ranges on demand) go through http.ServeContent, which answers
+// If-None-Match with 304, Range with 206 and Content-Range, and malformed
+// or unsatisfiable ranges with 416. Anything else is streamed whole.
+// file.Vary is emitted for negotiated responses.
+func serveImageFile(c *ginext.Context, id string, file *domain.ImageFile) {
+	c.Header("Content-Type", helpers.ContentTypeByExtension(file.Filename))
+	c.Header("Content-Disposition", contentDisposition(file.Filename))
+	c.Header("ETag", file.ETag)
+	addVary(c, file.Vary...)
+	if file.Immutable {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
 	} else {
-		zlog.Logger.Debug().Str("image_id", id).Str("filename", filename).Msg("file does not implement Stat(os.FileInfo); skipping Content-Length header")
+		// reprocessing replaces the file under the same URL; the ETag
+		// makes revalidation after expiry cheap
+		c.Header("Cache-Control", "public, max-age=3600")
+	}
+
+	if rs, ok := file.Body.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, file.Filename, file.ModTime, rs)
+		zlog.Logger.Info().
+			Str("image_id", id).
+			Str("filename", file.Filename).
+			Int("status", c.Writer.Status()).
+			Int("bytes_written", c.Writer.Size()).
+			Msg("image sent")
+		return
 	}
 
-	c.Header("Content-Type", contentType)
-	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%s", filename))
+	if etagMatches(c.GetHeader("If-None-Match"), file.ETag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	written, err := io.Copy(c.Writer, file)
+	written, err := io.Copy(c.Writer, file.Body)
 	if err != nil {
 		zlog.Logger.Error().
 			Err(err).
 			Str("image_id", id).
-			Str("filename", filename).
+			Str("filename", file.Filename).
 			Int64("bytes_written", written).
-			Msg("failed to write original image to response")
+			Msg("failed to write image to response")
 		return
 	}
 	zlog.Logger.Info().
 		Str("image_id", id).
-		Str("filename", filename).
+		Str("filename", file.Filename).
 		Int64("bytes_written", written).
-		Msg("original image sent successfully")
+		Msg("image sent")
+}
+
+// etagMatches implements the weak comparison If-None-Match asks for.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /image/:id/variants/:name
+func (h *ImageHandler) GetVariant(c *ginext.Context) {
+	id := c.Param("id")
+	name := c.Param("name")
+
+	file, err := h.service.GetVariantFile(c.Request.Context(), id, name)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) || errors.Is(err, domain.ErrVariantNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image variant not found",
+			})
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("variant", name).Msg("failed to get variant")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to retrieve image variant",
+		})
+		return
+	}
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
+}
+
+// GET /image/:id/variant/:name
+//
+// The singular path was served alongside the canonical one; it now
+// redirects there so caches and clients keep a single URL per variant.
+func redirectVariantAlias(c *ginext.Context) {
+	target := "/image/" + url.PathEscape(c.Param("id")) + "/variants/" + url.PathEscape(c.Param("name"))
+	if c.Request.URL.RawQuery != "" {
+		target += "?" + c.Request.URL.RawQuery
+	}
+	c.Redirect(http.StatusMovedPermanently, target)
 }
 
 // DELETE image/:id
+//
+// The image is soft-deleted and purged by the worker once
+// processing.deleted_retention_days have passed; ?hard=true removes the
+// record and files immediately.
 func (h *ImageHandler) DeleteImage(c *ginext.Context) {
 	id := c.Param("id")
 	if id == "" {
@@ -246,7 +603,20 @@ func (h *ImageHandler) DeleteImage(c *ginext.Context) {
 		return
 	}
 
-	if err := h.service.DeleteImage(c.Request.Context(), id); err != nil {
+	hard := false
+	if raw := c.Query("hard"); raw != "" {
+		var err error
+		hard, err = strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_hard",
+				Message: "Hard must be a boolean (true/false)",
+			})
+			return
+		}
+	}
+
+	if err := h.service.DeleteImage(c.Request.Context(), id, hard); err != nil {
 		if err == domain.ErrImageNotFound {
 			c.JSON(http.StatusNotFound, dto.ErrorResponse{
 				Error:   "not_found",
@@ -265,14 +635,97 @@ func (h *ImageHandler) DeleteImage(c *ginext.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// GET /image/:id/status
+func (h *ImageHandler) GetImageStatus(c *ginext.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Image ID is required",
+		})
+		return
+	}
+
+	image, err := h.service.GetImage(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to get image status")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to retrieve image status",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.ImageStatusResponse{
+		ID:           image.ID,
+		Status:       string(image.Status),
+		ErrorMessage: image.ErrorMessage,
+		ProcessedAt:  image.ProcessedAt,
+	})
+}
+
+// GET /image/:id/queue-position
+func (h *ImageHandler) GetQueuePosition(c *ginext.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Image ID is required",
+		})
+		return
+	}
+
+	pos, err := h.service.GetQueuePosition(c.Request.Context(), id)
+	if err != nil {
+		switch err {
+		case domain.ErrImageNotFound:
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case domain.ErrImageNotPending:
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "not_pending",
+				Message: "Image is not waiting in the processing queue",
+			})
+		default:
+			zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to get queue position")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to retrieve queue position",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.QueuePositionResponse{
+		ID:           pos.ImageID,
+		Position:     pos.Position,
+		PendingTotal: pos.PendingTotal,
+	})
+}
+
 // GET /images
 func (h *ImageHandler) ListImages(c *ginext.Context) {
-	limit := 10
+	if c.Query("format") == "ndjson" {
+		h.streamImages(c)
+		return
+	}
+
+	requested := 0
 	if l := c.Query("limit"); l != "" {
 		if val, err := strconv.Atoi(l); err == nil && val > 0 {
-			limit = val
+			requested = val
 		}
 	}
+	limit := h.pagination.Limit(requested)
 
 	offset := 0
 	if o := c.Query("offset"); o != "" {
@@ -281,8 +734,20 @@ func (h *ImageHandler) ListImages(c *ginext.Context) {
 		}
 	}
 
-	images, err := h.service.ListImages(c.Request.Context(), limit, offset)
+	sort, err := dto.ParseListSort(c.Query("sort"))
 	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_sort",
+			Message: "Sort must be one of: created_at, size, status, optionally followed by :asc or :desc",
+		})
+		return
+	}
+
+	images, total, err := h.service.ListImages(c.Request.Context(), limit, offset, sort)
+	if err != nil {
+		if writeTimeoutIfExpired(c) {
+			return
+		}
 		zlog.Logger.Error().Err(err).Msg("failed to list images")
 		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
 			Error:   "server_error",
@@ -291,15 +756,203 @@ func (h *ImageHandler) ListImages(c *ginext.Context) {
 		return
 	}
 
-	baseURL := h.getBaseURL(c)
-	response := dto.MapImagesToResponse(images, baseURL, limit, offset)
+	baseURL := getBaseURL(c)
+	response := dto.MapImagesToResponse(images, total, baseURL, limit, offset)
 
 	c.JSON(http.StatusOK, response)
 }
 
+// streamImages writes every image as one JSON object per line, flushing
+// after each, for GET /images?format=ndjson. Exports can be far larger than
+// a page, so limit, offset and sort do not apply: images come in id order,
+// narrowed by the optional status and processing_type query parameters.
+func (h *ImageHandler) streamImages(c *ginext.Context) {
+	filter := domain.ImageFilter{
+		Status:         domain.ProcessingStatus(c.Query("status")),
+		ProcessingType: domain.ProcessingType(c.Query("processing_type")),
+	}
+	if filter.Status != "" && !filter.Status.IsValid() {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_status",
+			Message: "Status must be one of: pending, processing, completed, failed, dead_lettered",
+		})
+		return
+	}
+	if filter.ProcessingType != "" && !filter.ProcessingType.IsValid() {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	baseURL := getBaseURL(c)
+	enc := json.NewEncoder(c.Writer)
+	rc := http.NewResponseController(c.Writer)
+	count := 0
+	// an export outlives the read timeout of a page; a client that goes
+	// away still stops it, as the next write fails
+	ctx := context.WithoutCancel(c.Request.Context())
+	err := h.service.StreamImages(ctx, filter, func(image *domain.Image) error {
+		if h.timeouts.Write > 0 {
+			// the server's write timeout counts from the request start;
+			// each record pushes it back so only a stalled client hits it
+			if err := rc.SetWriteDeadline(time.Now().Add(h.timeouts.Write)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+		}
+		// Encode terminates every record with a newline
+		if err := enc.Encode(dto.MapImageToResponse(image, baseURL)); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		count++
+		return nil
+	})
+	if err != nil {
+		zlog.Logger.Error().Err(err).Int("written", count).Msg("failed to stream images")
+		if !c.Writer.Written() {
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to retrieve images",
+			})
+			return
+		}
+		// records already went out; stop without the end line so the
+		// client sees a cut-off stream rather than a complete one
+		c.Abort()
+		return
+	}
+
+	if err := enc.Encode(dto.ImageExportEnd{End: true, Count: count}); err != nil {
+		zlog.Logger.Error().Err(err).Int("written", count).Msg("failed to end image stream")
+		return
+	}
+	c.Writer.Flush()
+
+	zlog.Logger.Debug().Int("count", count).Msg("image list streamed")
+}
+
+// validateFileHeader checks the declared size and extension of an uploaded file.
+func (h *ImageHandler) validateFileHeader(header *multipart.FileHeader) *dto.ErrorResponse {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+
+	if limit := h.maxSizeFor(ext); header.Size > limit {
+		return fileTooLargeResponse(ext, limit)
+	}
+
+	if !h.isAllowedFormat(ext) {
+		return &dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: fmt.Sprintf("Unsupported file format. Allowed: %v", h.allowedFormats),
+		}
+	}
+
+	return nil
+}
+
+func fileTooLargeResponse(ext string, limit int64) *dto.ErrorResponse {
+	return &dto.ErrorResponse{
+		Error:   "file_too_large",
+		Message: fmt.Sprintf("File size exceeds maximum allowed for %s files (%d MB)", strings.TrimPrefix(ext, "."), limit/(1024*1024)),
+	}
+}
+
+// sizeLimitedReader fails with domain.ErrFileTooLarge once more than limit
+// bytes were read. The multipart header size is client-supplied, so the
+// limit is enforced again on the bytes actually stored.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func newSizeLimitedReader(r io.Reader, limit int64) *sizeLimitedReader {
+	return &sizeLimitedReader{r: r, remaining: limit}
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, domain.ErrFileTooLarge
+	}
+	// read one byte past the limit to tell "exactly at" from "over"
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		l.remaining = 0
+		return 0, domain.ErrFileTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// maxSizeFor returns the upload limit for a file extension, falling back
+// to the global limit when no per-format limit is configured.
+func (h *ImageHandler) maxSizeFor(ext string) int64 {
+	if limit, ok := h.formatSizeLimits[strings.TrimPrefix(ext, ".")]; ok {
+		return limit
+	}
+	return h.maxUploadSize
+}
+
+// uploadErrorResponse maps client-caused upload errors to a 400 body;
+// it returns nil for errors that are the server's fault.
+func uploadErrorResponse(err error) *dto.ErrorResponse {
+	switch {
+	case errors.Is(err, domain.ErrInvalidAspectRatio):
+		return &dto.ErrorResponse{
+			Error:   "invalid_aspect_ratio",
+			Message: "Image aspect ratio does not match the required ratio",
+		}
+	case errors.Is(err, domain.ErrTenantQuotaExceeded):
+		return &dto.ErrorResponse{
+			Error:   "tenant_quota_exceeded",
+			Message: "Tenant has reached the maximum number of stored images",
+		}
+	case errors.Is(err, domain.ErrInvalidFormat):
+		return &dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: "Unsupported file format",
+		}
+	case errors.Is(err, domain.ErrUnknownPipeline):
+		return &dto.ErrorResponse{
+			Error:   "invalid_pipeline",
+			Message: "Pipeline processing needs a pipeline name configured under processing.pipelines",
+		}
+	case errors.Is(err, domain.ErrInvalidImageStructure):
+		return &dto.ErrorResponse{
+			Error:   "invalid_image_structure",
+			Message: "File contains data beyond the image itself and was rejected",
+		}
+	case errors.Is(err, domain.ErrTooManyPixels):
+		return &dto.ErrorResponse{
+			Error:   "too_many_pixels",
+			Message: "Image dimensions exceed the maximum pixel count",
+		}
+	case errors.Is(err, domain.ErrInvalidImageData):
+		return &dto.ErrorResponse{
+			Error:   "decode_failed",
+			Message: "File is not a decodable image",
+		}
+	default:
+		return nil
+	}
+}
+
 func (h *ImageHandler) isAllowedFormat(ext string) bool {
+	return isAllowedFormat(h.allowedFormats, ext)
+}
+
+func isAllowedFormat(allowedFormats []string, ext string) bool {
 	ext = strings.TrimPrefix(ext, ".")
-	for _, allowed := range h.allowedFormats {
+	for _, allowed := range allowedFormats {
 		if strings.EqualFold(ext, allowed) {
 			return true
 		}
@@ -307,24 +960,285 @@ func (h *ImageHandler) isAllowedFormat(ext string) bool {
 	return false
 }
 
-func (h *ImageHandler) getContentType(filename string) string {
-	ext := strings.ToLower(filepath.Ext(filename))
-	switch ext {
-	case ".jpg", ".jpeg":
-		return "image/jpeg"
-	case ".png":
-		return "image/png"
-	case ".gif":
-		return "image/gif"
+const invalidProcessingTypeMessage = "Processing type must be one of: resize, thumbnail, watermark, rotate, flip, crop, denoise, rounded_crop, pipeline"
+
+// parseProcessingType maps the processing_type form value to a domain type,
+// defaulting to resize when it is empty.
+func parseProcessingType(raw string) (domain.ProcessingType, bool) {
+	switch raw {
+	case "", "resize":
+		return domain.ProcessingResize, true
+	case "thumbnail":
+		return domain.ProcessingThumbnail, true
+	case "watermark":
+		return domain.ProcessingWatermark, true
+	case "rotate":
+		return domain.ProcessingRotate, true
+	case "flip":
+		return domain.ProcessingFlip, true
+	case "crop":
+		return domain.ProcessingCrop, true
+	case "denoise":
+		return domain.ProcessingDenoise, true
+	case "rounded_crop":
+		return domain.ProcessingRoundedCrop, true
+	case "pipeline":
+		return domain.ProcessingPipeline, true
 	default:
-		return "application/octet-stream"
+		return "", false
+	}
+}
+
+// parseProcessingOptions reads optional per-request overrides from the form
+// for an upload processed with pt. On invalid input it writes a 400
+// response and returns false.
+func parseProcessingOptions(c *ginext.Context, pt domain.ProcessingType) (domain.ProcessingOptions, bool) {
+	var opts domain.ProcessingOptions
+
+	if raw := c.PostForm("flatten"); raw != "" {
+		flatten, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_flatten",
+				Message: "Flatten must be a boolean (true/false)",
+			})
+			return opts, false
+		}
+		opts.Flatten = &flatten
+	}
+
+	if raw := c.PostForm("angle"); raw != "" {
+		angle, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_angle",
+				Message: invalidAngleMessage,
+			})
+			return opts, false
+		}
+		opts.Angle = &angle
+	}
+	if !validRotation(pt, opts.Angle) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_angle",
+			Message: invalidAngleMessage,
+		})
+		return opts, false
+	}
+
+	if raw := c.PostForm("flip"); raw != "" {
+		axis, ok := domain.ParseFlipAxis(raw)
+		if !ok {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_flip",
+				Message: invalidFlipMessage,
+			})
+			return opts, false
+		}
+		opts.Flip = &axis
+	}
+
+	if raw := strings.TrimSpace(c.PostForm("pipeline")); raw != "" {
+		opts.Pipeline = &raw
+	}
+
+	if raw := c.PostForm("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || !domain.ValidPageNumber(page) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_page",
+				Message: invalidPageMessage,
+			})
+			return opts, false
+		}
+		opts.Page = &page
+	}
+
+	if raw := c.PostForm("radius"); raw != "" {
+		radius, err := strconv.Atoi(raw)
+		if err != nil || radius < 0 || radius > domain.MaxTargetDimension {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_radius",
+				Message: invalidRadiusMessage,
+			})
+			return opts, false
+		}
+		opts.Radius = &radius
 	}
+
+	if raw := c.PostForm("quality"); raw != "" {
+		quality, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || !domain.ValidQuality(quality) {
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_quality",
+				Message: invalidQualityMessage,
+			})
+			return opts, false
+		}
+		opts.Quality = &quality
+	}
+
+	if raw := strings.TrimSpace(c.PostForm("watermark_text")); raw != "" {
+		opts.WatermarkText = &raw
+	}
+
+	if !validEncodeOverrides(c, opts.Quality, opts.WatermarkText) {
+		return opts, false
+	}
+
+	// width/height are best effort: missing or unusable values keep the
+	// configured resize/thumbnail box instead of failing the upload
+	opts.Width = parseTargetDimension(c.PostForm("width"))
+	opts.Height = parseTargetDimension(c.PostForm("height"))
+
+	return opts, true
 }
 
-func (h *ImageHandler) getBaseURL(c *ginext.Context) string {
+// parseTenantID reads the optional tenant header. On invalid input it
+// writes a 400 response and returns false.
+func parseTenantID(c *ginext.Context) (string, bool) {
+	tenantID := strings.TrimSpace(c.GetHeader(tenantHeader))
+	if len(tenantID) > maxTenantIDLen {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_tenant",
+			Message: fmt.Sprintf("%s must be at most %d characters", tenantHeader, maxTenantIDLen),
+		})
+		return "", false
+	}
+	return tenantID, true
+}
+
+// parsePriority reads the optional priority header; missing means normal.
+// On an unknown level it writes a 400 response and returns false.
+func parsePriority(c *ginext.Context) (domain.Priority, bool) {
+	priority, ok := domain.ParsePriority(c.GetHeader(priorityHeader))
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_priority",
+			Message: fmt.Sprintf("%s must be 'normal' or 'high'", priorityHeader),
+		})
+		return "", false
+	}
+	return priority, true
+}
+
+// parseCallbackURL validates the optional callback_url of an upload; it
+// must be an absolute http or https URL. On invalid input it writes a 400
+// response and returns false.
+func parseCallbackURL(c *ginext.Context, raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || len(raw) > domain.MaxCallbackURLLength || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_callback_url",
+			Message: fmt.Sprintf("callback_url must be an absolute http or https URL of at most %d bytes", domain.MaxCallbackURLLength),
+		})
+		return "", false
+	}
+	return raw, true
+}
+
+func parseTargetDimension(raw string) *int {
+	if raw == "" {
+		return nil
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return nil
+	}
+	return targetDimension(&v)
+}
+
+func targetDimension(v *int) *int {
+	if v == nil || !domain.ValidTargetDimension(*v) {
+		return nil
+	}
+	return v
+}
+
+// validEncodeOverrides checks the requested quality and watermark text. On
+// invalid input it writes a 400 response and returns false.
+func validEncodeOverrides(c *ginext.Context, quality *int, watermarkText *string) bool {
+	if quality != nil && !domain.ValidQuality(*quality) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_quality",
+			Message: invalidQualityMessage,
+		})
+		return false
+	}
+	if watermarkText != nil && utf8.RuneCountInString(*watermarkText) > domain.MaxWatermarkTextLength {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_watermark_text",
+			Message: fmt.Sprintf("Watermark text must be at most %d characters", domain.MaxWatermarkTextLength),
+		})
+		return false
+	}
+	return true
+}
+
+const invalidQualityMessage = "Quality must be an integer between 1 and 100"
+
+var invalidRadiusMessage = fmt.Sprintf("Radius must be an integer between 0 (circle) and %d", domain.MaxTargetDimension)
+
+var invalidPageMessage = fmt.Sprintf("Page must be an integer between 1 and %d", domain.MaxPageNumber)
+
+const invalidAngleMessage = "Angle must be a whole number of degrees and a multiple of 90; rotate needs one that is not a full turn"
+
+// validRotation checks the optional angle of an upload processed with pt.
+// Any angle must be a multiple of 90, and rotate needs one that actually
+// turns the image: without it the result would just be a re-encoded copy.
+func validRotation(pt domain.ProcessingType, angle *int) bool {
+	if angle != nil && *angle%90 != 0 {
+		return false
+	}
+	if pt == domain.ProcessingRotate {
+		return angle != nil && *angle%360 != 0
+	}
+	return true
+}
+
+const invalidFlipMessage = "Flip must be one of: horizontal (h), vertical (v), both"
+
+// parseFlip validates the optional flip field of JSON requests; nil stays
+// nil.
+func parseFlip(raw *string) (*domain.FlipAxis, bool) {
+	if raw == nil {
+		return nil, true
+	}
+	axis, ok := domain.ParseFlipAxis(*raw)
+	if !ok {
+		return nil, false
+	}
+	return &axis, true
+}
+
+func getBaseURL(c *ginext.Context) string {
 	scheme := "http"
 	if c.Request.TLS != nil {
 		scheme = "https"
 	}
 	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
 }
+
+// contentDisposition builds an inline Content-Disposition for name. Names
+// outside printable ASCII get a transliterated filename for old clients
+// and the exact name in an RFC 5987 filename* parameter.
+func contentDisposition(name string) string {
+	ascii := true
+	for _, r := range name {
+		if r < ' ' || r > '~' {
+			ascii = false
+			break
+		}
+	}
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	if ascii {
+		return fmt.Sprintf(`inline; filename="%s"`, quoted.Replace(name))
+	}
+	return fmt.Sprintf(`inline; filename="%s"; filename*=UTF-8''%s`,
+		quoted.Replace(helpers.ASCIIFilename(name)), strings.ReplaceAll(url.QueryEscape(name), "+", "%20"))
+}
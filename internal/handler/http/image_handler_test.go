@@ -0,0 +1,680 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/usecase"
+)
+
+// fakeImageService serves a fixed set of images, pausing before each one.
+type fakeImageService struct {
+	domain.ImageService
+	images []*domain.Image
+	delay  time.Duration
+	err    error
+	// resized is the last on-the-fly resize asked for
+	resized domain.ResizeRequest
+	// listed is the last page asked for
+	listed *listCall
+	// uploaded holds the options of the last upload; uploadErr fails it
+	uploaded      *domain.ProcessingOptions
+	uploadedNames []string
+	uploadErr     error
+}
+
+type listCall struct {
+	limit, offset int
+	sort          domain.ListSort
+}
+
+func (s *fakeImageService) ListImages(ctx context.Context, limit, offset int, sort domain.ListSort) ([]*domain.Image, int, error) {
+	s.listed = &listCall{limit: limit, offset: offset, sort: sort}
+	return s.images, len(s.images), nil
+}
+
+func (s *fakeImageService) StreamImages(ctx context.Context, filter domain.ImageFilter, fn func(*domain.Image) error) error {
+	for _, img := range s.images {
+		if filter.Status != "" && img.Status != filter.Status {
+			continue
+		}
+		time.Sleep(s.delay)
+		if err := fn(img); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+// GetResizedOriginal answers like the usecase with on_the_fly_webp on.
+func (s *fakeImageService) GetResizedOriginal(ctx context.Context, id string, req domain.ResizeRequest) (*domain.ImageFile, error) {
+	s.resized = req
+	ext := ".jpg"
+	if req.WebP {
+		ext = ".webp"
+	}
+	return &domain.ImageFile{
+		Filename:  "photo_" + req.Key() + ext,
+		ETag:      `"` + req.Key() + `"`,
+		Immutable: true,
+		Vary:      []string{"Accept"},
+		Body:      nopSeekCloser{strings.NewReader("image")},
+	}, nil
+}
+
+func (s *fakeImageService) UploadImage(ctx context.Context, filename, mimeType string, size int64, reader io.Reader, processingType domain.ProcessingType, opts domain.ProcessingOptions, tenantID string) (*domain.Image, error) {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, err
+	}
+	if s.uploadErr != nil {
+		return nil, s.uploadErr
+	}
+	s.uploaded = &opts
+	s.uploadedNames = append(s.uploadedNames, filename)
+	return &domain.Image{ID: "img-1", OriginalFilename: filename, Status: domain.StatusPending, ProcessingType: processingType}, nil
+}
+
+type nopSeekCloser struct {
+	*strings.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// serveImages runs h behind a real server, whose write timeout is the one
+// streamImages has to renew.
+func serveImages(t *testing.T, h *ImageHandler, writeTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	engine := ginext.New("release")
+	engine.GET("/images", h.ListImages)
+
+	srv := httptest.NewUnstartedServer(engine)
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postUpload sends data as a multipart POST /upload with the given headers.
+func postUpload(t *testing.T, h *ImageHandler, filename string, data []byte, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", filename)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	engine := ginext.New("release")
+	engine.POST("/upload", h.UploadImage)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func newUploadHandler(service domain.ImageService) *ImageHandler {
+	return &ImageHandler{service: service, allowedFormats: []string{"jpg", "jpeg", "png"}, maxUploadSize: 1 << 20}
+}
+
+func testImages(n int) []*domain.Image {
+	images := make([]*domain.Image, n)
+	for i := range images {
+		status := domain.StatusCompleted
+		if i%2 == 1 {
+			status = domain.StatusFailed
+		}
+		images[i] = &domain.Image{ID: fmt.Sprintf("img-%d", i), OriginalFilename: "photo.jpg", Status: status, ProcessingType: domain.ProcessingResize}
+	}
+	return images
+}
+
+// readExport returns the image lines of an NDJSON export and its end line,
+// failing on any line that is not a JSON image record.
+func readExport(t *testing.T, url string) ([]dto.ImageResponse, *dto.ImageExportEnd) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var images []dto.ImageResponse
+	var end *dto.ImageExportEnd
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if end != nil {
+			t.Fatalf("line after the end line: %s", lines.Text())
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(lines.Bytes(), &fields); err != nil {
+			t.Fatalf("line %d is not JSON: %v: %s", len(images)+1, err, lines.Text())
+		}
+		if _, ok := fields["end"]; ok {
+			end = &dto.ImageExportEnd{}
+			json.Unmarshal(lines.Bytes(), end)
+			continue
+		}
+		var image dto.ImageResponse
+		if err := json.Unmarshal(lines.Bytes(), &image); err != nil || image.ID == "" || image.Status == "" || image.OriginalURL == "" {
+			t.Fatalf("line %d is not an image record (%v): %s", len(images)+1, err, lines.Text())
+		}
+		images = append(images, image)
+	}
+	// a server that hits its write timeout resets the connection
+	if err := lines.Err(); err != nil {
+		t.Fatalf("read export after %d records: %v", len(images), err)
+	}
+	return images, end
+}
+
+func TestStreamImagesWritesOneRecordPerLine(t *testing.T) {
+	h := &ImageHandler{service: &fakeImageService{images: testImages(5)}}
+	srv := serveImages(t, h, time.Second)
+
+	images, end := readExport(t, srv.URL+"/images?format=ndjson&status=completed")
+
+	if len(images) != 3 {
+		t.Fatalf("got %d records, want the 3 completed images", len(images))
+	}
+	for _, image := range images {
+		if image.Status != string(domain.StatusCompleted) {
+			t.Fatalf("record %s has status %s", image.ID, image.Status)
+		}
+	}
+	if end == nil || !end.End || end.Count != 3 {
+		t.Fatalf("end line = %+v, want count 3", end)
+	}
+}
+
+func TestStreamImagesOutlivesWriteTimeout(t *testing.T) {
+	// the export takes about 10 write timeouts, each record well within one
+	service := &fakeImageService{images: testImages(10), delay: 40 * time.Millisecond}
+	h := &ImageHandler{service: service, timeouts: RouteTimeouts{Write: 200 * time.Millisecond}}
+	srv := serveImages(t, h, 200*time.Millisecond)
+
+	images, end := readExport(t, srv.URL+"/images?format=ndjson")
+
+	if len(images) != 10 || end == nil || end.Count != 10 {
+		t.Fatalf("got %d records and end line %+v, want all 10 and the end line", len(images), end)
+	}
+}
+
+func TestStreamImagesFailureLeavesNoEndLine(t *testing.T) {
+	h := &ImageHandler{service: &fakeImageService{images: testImages(2), err: errors.New("database gone")}}
+	srv := serveImages(t, h, time.Second)
+
+	images, end := readExport(t, srv.URL+"/images?format=ndjson")
+
+	if len(images) != 2 {
+		t.Fatalf("got %d records, want the 2 written before the failure", len(images))
+	}
+	if end != nil {
+		t.Fatalf("failed export ended with %+v, want no end line", end)
+	}
+}
+
+func TestResizedOriginalVariesOnAccept(t *testing.T) {
+	tests := []struct {
+		accept   string
+		wantWebP bool
+	}{
+		{accept: "image/avif,image/webp,image/apng,image/*,*/*;q=0.8", wantWebP: true},
+		{accept: "IMAGE/WEBP;q=0.5", wantWebP: true},
+		{accept: "image/webp;q=0"},
+		{accept: "image/*,*/*"},
+		{accept: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			service := &fakeImageService{}
+			engine := ginext.New("release")
+			engine.GET("/image/:id/original", (&ImageHandler{service: service}).GetOriginalImage)
+
+			req := httptest.NewRequest(http.MethodGet, "/image/a/original?w=100", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if service.resized.WebP != tt.wantWebP {
+				t.Errorf("WebP = %v, want %v", service.resized.WebP, tt.wantWebP)
+			}
+			wantType := "image/jpeg"
+			if tt.wantWebP {
+				wantType = "image/webp"
+			}
+			if got := rec.Header().Get("Content-Type"); got != wantType {
+				t.Errorf("Content-Type = %q, want %q", got, wantType)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept" {
+				t.Errorf("Vary = %q, want Accept", got)
+			}
+		})
+	}
+}
+
+func TestResizedOriginalNotModifiedKeepsVary(t *testing.T) {
+	engine := ginext.New("release")
+	engine.GET("/image/:id/original", (&ImageHandler{service: &fakeImageService{}}).GetOriginalImage)
+
+	req := httptest.NewRequest(http.MethodGet, "/image/a/original?w=100", nil)
+	req.Header.Set("If-None-Match", `"100x0_contain"`)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("status = %d, want 304", rec.Code)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept" {
+		t.Errorf("Vary = %q on 304, want Accept", got)
+	}
+}
+
+func TestListImagesSortParameter(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantCode int
+		wantSort domain.ListSort
+	}{
+		{query: "", wantCode: http.StatusOK, wantSort: domain.DefaultListSort},
+		{query: "?sort=size:asc", wantCode: http.StatusOK, wantSort: domain.ListSort{Field: domain.SortBySize, Order: domain.SortAsc}},
+		{query: "?sort=status", wantCode: http.StatusOK, wantSort: domain.ListSort{Field: domain.SortByStatus, Order: domain.SortDesc}},
+		{query: "?sort=original_filename", wantCode: http.StatusBadRequest},
+		{query: "?sort=size:sideways", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			service := &fakeImageService{}
+			h := &ImageHandler{service: service, pagination: domain.Pagination{DefaultLimit: 10, MaxLimit: 100}}
+			engine := ginext.New("release")
+			engine.GET("/images", h.ListImages)
+
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images"+tt.query, nil))
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				if service.listed != nil {
+					t.Error("rejected sort reached the service")
+				}
+				return
+			}
+			if service.listed.sort != tt.wantSort {
+				t.Errorf("sort = %+v, want %+v", service.listed.sort, tt.wantSort)
+			}
+		})
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.jpg", `inline; filename="photo.jpg"`},
+		{`say "hi".jpg`, `inline; filename="say \"hi\".jpg"`},
+		{"Фото.jpg", `inline; filename="Foto.jpg"; filename*=UTF-8''%D0%A4%D0%BE%D1%82%D0%BE.jpg`},
+		{"東京 夜.png", `inline; filename="file.png"; filename*=UTF-8''%E6%9D%B1%E4%BA%AC%20%E5%A4%9C.png`},
+	}
+	for _, tt := range tests {
+		if got := contentDisposition(tt.name); got != tt.want {
+			t.Errorf("contentDisposition(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUploadRejectsPolyglotWith400(t *testing.T) {
+	service := &fakeImageService{uploadErr: fmt.Errorf("%w: 39 bytes after the end of the image", domain.ErrInvalidImageStructure)}
+
+	rec := postUpload(t, newUploadHandler(service), "a.jpg", []byte("jpeg and script"), nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	var resp dto.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if resp.Error != "invalid_image_structure" {
+		t.Errorf("error = %q, want invalid_image_structure", resp.Error)
+	}
+}
+
+func TestListImagesHonorsConfiguredPageLimits(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantLimit int
+	}{
+		{query: "", wantLimit: 25},
+		{query: "?limit=40", wantLimit: 40},
+		{query: "?limit=500", wantLimit: 50},
+		{query: "?limit=0", wantLimit: 25},
+		{query: "?limit=-3", wantLimit: 25},
+		{query: "?limit=abc", wantLimit: 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			service := &fakeImageService{}
+			h := &ImageHandler{service: service, pagination: domain.Pagination{DefaultLimit: 25, MaxLimit: 50}}
+			engine := ginext.New("release")
+			engine.GET("/images", h.ListImages)
+
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/images"+tt.query, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if service.listed.limit != tt.wantLimit {
+				t.Errorf("service asked for %d images, want %d", service.listed.limit, tt.wantLimit)
+			}
+			var resp dto.ImageListResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if resp.Limit != tt.wantLimit {
+				t.Errorf("response limit = %d, want %d", resp.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestUploadPriorityHeader(t *testing.T) {
+	tests := []struct {
+		header   string
+		wantCode int
+		want     domain.Priority
+	}{
+		{header: "", wantCode: http.StatusCreated, want: domain.PriorityNormal},
+		{header: "normal", wantCode: http.StatusCreated, want: domain.PriorityNormal},
+		{header: "high", wantCode: http.StatusCreated, want: domain.PriorityHigh},
+		{header: " HIGH ", wantCode: http.StatusCreated, want: domain.PriorityHigh},
+		{header: "urgent", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			service := &fakeImageService{}
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("X-Priority", tt.header)
+			}
+
+			rec := postUpload(t, newUploadHandler(service), "a.png", []byte("png"), header)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusCreated {
+				if service.uploaded != nil {
+					t.Error("upload with an unknown priority reached the service")
+				}
+				return
+			}
+			if service.uploaded.Priority != tt.want {
+				t.Errorf("priority = %q, want %q", service.uploaded.Priority, tt.want)
+			}
+		})
+	}
+}
+
+// publicIDRepo holds one image reachable by its UUID and its public ID.
+type publicIDRepo struct {
+	domain.ImageRepository
+	image *domain.Image
+}
+
+func (r publicIDRepo) FindByID(ctx context.Context, id string) (*domain.Image, error) {
+	if id != r.image.ID {
+		return nil, domain.ErrImageNotFound
+	}
+	return r.image, nil
+}
+
+func (r publicIDRepo) FindByPublicID(ctx context.Context, publicID string) (*domain.Image, error) {
+	if publicID != r.image.PublicID {
+		return nil, domain.ErrImageNotFound
+	}
+	return r.image, nil
+}
+
+func TestImageRoutesResolveUUIDAndPublicID(t *testing.T) {
+	image := &domain.Image{
+		ID:       "0b7e4c1a-52d9-4f3e-8a6b-2c9d1e7f4a30",
+		PublicID: domain.EncodePublicID(125),
+		Status:   domain.StatusCompleted,
+	}
+	service := usecase.NewImageUsecase(publicIDRepo{image: image}, nil, nil, nil, nil, &config.ProcessingConfig{}, nil, nil, 0, nil, nil)
+	h := &ImageHandler{service: service}
+	engine := ginext.New("release")
+	engine.GET("/image/:id/status", h.GetImageStatus)
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"uuid", image.ID, http.StatusOK},
+		{"public id", image.PublicID, http.StatusOK},
+		{"unknown uuid", "9d4a6f0e-1b2c-4d3e-8f5a-6b7c8d9e0f12", http.StatusNotFound},
+		{"unknown public id", domain.EncodePublicID(126), http.StatusNotFound},
+		{"not an id", "no-such-image", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/image/"+tt.id+"/status", nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp dto.ImageStatusResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.ID != image.ID {
+				t.Errorf("resolved to %q, want %q", resp.ID, image.ID)
+			}
+		})
+	}
+}
+
+func TestUploadAppliesPerFormatSizeLimit(t *testing.T) {
+	// 1.5 MB: over the 1 MB PNG limit, under the 2 MB global one
+	data := bytes.Repeat([]byte{0}, 3<<19)
+	tests := []struct {
+		filename string
+		want     int
+	}{
+		{"big.png", http.StatusBadRequest},
+		{"big.PNG", http.StatusBadRequest},
+		{"big.jpg", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			service := &fakeImageService{}
+			h := NewImageHandler(service, 2, map[string]int{"PNG": 1}, []string{"jpg", "png"}, 1, ArchiveLimits{}, RouteTimeouts{}, 0, domain.Pagination{})
+
+			rec := postUpload(t, h, tt.filename, data, nil)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusCreated {
+				return
+			}
+			var resp dto.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Error != "file_too_large" || !strings.Contains(resp.Message, "png files (1 MB)") {
+				t.Fatalf("response %+v, want file_too_large naming the png limit", resp)
+			}
+			if service.uploaded != nil {
+				t.Fatal("the oversized png reached the service")
+			}
+		})
+	}
+}
+
+// variantService serves one variant, re-readable for each request.
+type variantService struct {
+	domain.ImageService
+	data []byte
+}
+
+func (s *variantService) GetVariantFile(ctx context.Context, id string, name string) (*domain.ImageFile, error) {
+	if name != "small" {
+		return nil, domain.ErrVariantNotFound
+	}
+	return &domain.ImageFile{
+		Body:     io.NopCloser(bytes.NewReader(s.data)),
+		Filename: "Фото_small.webp",
+		ETag:     `"variant-etag"`,
+	}, nil
+}
+
+func getVariant(service domain.ImageService, target string, header http.Header) *httptest.ResponseRecorder {
+	engine := ginext.New("release")
+	NewImageHandler(service, 1, nil, nil, 1, ArchiveLimits{}, RouteTimeouts{}, 0, domain.Pagination{}).RegisterRoutes(engine)
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetVariantServedLikeOtherImageFiles(t *testing.T) {
+	service := &variantService{data: []byte("RIFF....WEBPVP8L")}
+
+	rec := getVariant(service, "/image/img-1/variants/small", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(service.data) {
+		t.Fatalf("response = %d %q, want 200 with the variant", rec.Code, rec.Body.String())
+	}
+	want := map[string]string{
+		"Content-Type":        "image/webp",
+		"Content-Disposition": contentDisposition("Фото_small.webp"),
+		"ETag":                `"variant-etag"`,
+		"Cache-Control":       "public, max-age=3600",
+	}
+	for key, value := range want {
+		if got := rec.Header().Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	rec = getVariant(service, "/image/img-1/variants/small", http.Header{"If-None-Match": {`"variant-etag"`}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", rec.Code)
+	}
+
+	if rec = getVariant(service, "/image/img-1/variants/large", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown variant status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSingularVariantPathRedirects(t *testing.T) {
+	rec := getVariant(&variantService{}, "/image/img-1/variant/small?v=2", nil)
+
+	if rec.Code != http.StatusMovedPermanently {
+		t.Fatalf("status = %d, want 301", rec.Code)
+	}
+	if got := rec.Header().Get("Location"); got != "/image/img-1/variants/small?v=2" {
+		t.Fatalf("Location = %q, want the canonical variant URL", got)
+	}
+}
+
+// postUploadFields uploads a PNG with the given form fields.
+func postUploadFields(t *testing.T, h *ImageHandler, fields map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	part, err := form.CreateFormFile("image", "photo.png")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(encodeTestPNG(t))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	engine := ginext.New("release")
+	engine.POST("/upload", h.UploadImage)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadRotateNeedsAnAngle(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   int
+	}{
+		{name: "rotate without angle", fields: map[string]string{"processing_type": "rotate"}, want: http.StatusBadRequest},
+		{name: "rotate by zero", fields: map[string]string{"processing_type": "rotate", "angle": "0"}, want: http.StatusBadRequest},
+		{name: "rotate by a full turn", fields: map[string]string{"processing_type": "rotate", "angle": "-360"}, want: http.StatusBadRequest},
+		{name: "rotate off the quarter turn", fields: map[string]string{"processing_type": "rotate", "angle": "45"}, want: http.StatusBadRequest},
+		{name: "rotate by 90", fields: map[string]string{"processing_type": "rotate", "angle": "90"}, want: http.StatusCreated},
+		{name: "rotate by -270", fields: map[string]string{"processing_type": "rotate", "angle": "-270"}, want: http.StatusCreated},
+		{name: "resize without angle", fields: map[string]string{"processing_type": "resize"}, want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeImageService{}
+			rec := postUploadFields(t, newUploadHandler(service), tt.fields)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d %s, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+			if tt.want == http.StatusBadRequest {
+				var resp dto.ErrorResponse
+				json.Unmarshal(rec.Body.Bytes(), &resp)
+				if resp.Error != "invalid_angle" || service.uploaded != nil {
+					t.Fatalf("error %q, uploaded %v; want invalid_angle and nothing stored", resp.Error, service.uploaded)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+type JobHandler struct {
+	service  domain.JobService
+	timeouts RouteTimeouts
+}
+
+func NewJobHandler(service domain.JobService, timeouts RouteTimeouts) *JobHandler {
+	return &JobHandler{
+		service:  service,
+		timeouts: timeouts,
+	}
+}
+
+func (h *JobHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.GET("/jobs/:jobId", middleware.TimeoutMiddleware(h.timeouts.Read), h.GetJob)
+}
+
+// GET /jobs/:jobId
+func (h *JobHandler) GetJob(c *ginext.Context) {
+	id := c.Param("jobId")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Job ID is required",
+		})
+		return
+	}
+
+	job, err := h.service.GetJob(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrJobNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Job not found",
+			})
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("job_id", id).Msg("failed to get job")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to retrieve job",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MapJobToResponse(job))
+}
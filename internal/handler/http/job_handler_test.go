@@ -0,0 +1,80 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+type fakeJobService map[string]*domain.Job
+
+func (s fakeJobService) GetJob(ctx context.Context, id string) (*domain.Job, error) {
+	job, ok := s[id]
+	if !ok {
+		return nil, domain.ErrJobNotFound
+	}
+	return job, nil
+}
+
+func getJob(t *testing.T, service domain.JobService, id string) *httptest.ResponseRecorder {
+	t.Helper()
+	engine := ginext.New("release")
+	NewJobHandler(service, RouteTimeouts{}).RegisterRoutes(engine)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+	return rec
+}
+
+func TestGetJobReportsStateAndTimings(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	started := created.Add(1500 * time.Millisecond)
+	finished := started.Add(250 * time.Millisecond)
+	service := fakeJobService{"job-1": {
+		ID:         "job-1",
+		ImageID:    "img-1",
+		State:      domain.JobCompleted,
+		Attempts:   1,
+		CreatedAt:  created,
+		UpdatedAt:  finished,
+		StartedAt:  &started,
+		FinishedAt: &finished,
+	}}
+
+	rec := getJob(t, service, "job-1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var resp dto.JobResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.ID != "job-1" || resp.ImageID != "img-1" || resp.State != "completed" {
+		t.Fatalf("response = %+v", resp)
+	}
+	if resp.QueuedMs == nil || *resp.QueuedMs != 1500 || resp.RunningMs == nil || *resp.RunningMs != 250 {
+		t.Fatalf("queued %v ms, running %v ms; want 1500 and 250", resp.QueuedMs, resp.RunningMs)
+	}
+}
+
+func TestGetJobQueuedHasNoTimings(t *testing.T) {
+	service := fakeJobService{"job-1": {ID: "job-1", State: domain.JobQueued, CreatedAt: time.Now()}}
+
+	var resp dto.JobResponse
+	json.Unmarshal(getJob(t, service, "job-1").Body.Bytes(), &resp)
+	if resp.State != "queued" || resp.QueuedMs != nil || resp.RunningMs != nil {
+		t.Fatalf("response = %+v, want queued without timings", resp)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	if rec := getJob(t, fakeJobService{}, "missing"); rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// PreviewHandler processes uploads synchronously and returns the result
+// without persisting anything.
+type PreviewHandler struct {
+	service        domain.PreviewService
+	maxUploadSize  int64
+	allowedFormats []string
+	timeout        time.Duration
+}
+
+func NewPreviewHandler(service domain.PreviewService, maxUploadSizeMB int, allowedFormats []string, timeout time.Duration) *PreviewHandler {
+	return &PreviewHandler{
+		service:        service,
+		maxUploadSize:  int64(maxUploadSizeMB) * 1024 * 1024,
+		allowedFormats: allowedFormats,
+		timeout:        timeout,
+	}
+}
+
+func (h *PreviewHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.POST("/preview", h.Preview)
+}
+
+// POST /preview
+func (h *PreviewHandler) Preview(c *ginext.Context) {
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "No image file provided",
+		})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > h.maxUploadSize {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "file_too_large",
+			Message: fmt.Sprintf("File size exceeds maximum allowed (%d MB)", h.maxUploadSize/(1024*1024)),
+		})
+		return
+	}
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !isAllowedFormat(h.allowedFormats, ext) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: fmt.Sprintf("Unsupported file format. Allowed: %v", h.allowedFormats),
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(c.PostForm("processing_type"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	opts, ok := parseProcessingOptions(c, pt)
+	if !ok {
+		return
+	}
+
+	// The request context is cancelled when the client goes away; the
+	// configured timeout bounds the work even for a patient client.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+	defer cancel()
+
+	// rendered in full before anything is sent, so a failure at any point
+	// is answered with a JSON error instead of a partial image
+	var preview bytes.Buffer
+	if err := h.service.Preview(ctx, file, pt, opts, &preview); err != nil {
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			c.JSON(http.StatusGatewayTimeout, dto.ErrorResponse{
+				Error:   "timeout",
+				Message: "Processing took too long",
+			})
+		case errors.Is(err, context.Canceled):
+			zlog.Logger.Info().Str("filename", header.Filename).Msg("preview abandoned by client")
+			c.Abort()
+		case errors.Is(err, domain.ErrInvalidPage):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_page",
+				Message: err.Error(),
+			})
+		default:
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "processing_failed",
+				Message: "Failed to process image",
+			})
+		}
+		return
+	}
+
+	c.Data(http.StatusOK, "image/jpeg", preview.Bytes())
+}
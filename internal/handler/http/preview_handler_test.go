@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+type fakePreviewService struct {
+	written []byte
+	err     error
+}
+
+func (s *fakePreviewService) Preview(ctx context.Context, reader io.Reader, processingType domain.ProcessingType, opts domain.ProcessingOptions, w io.Writer) error {
+	w.Write(s.written)
+	return s.err
+}
+
+func postPreview(t *testing.T, service domain.PreviewService) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "photo.jpg")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write([]byte("jpeg bytes"))
+	form.Close()
+
+	engine := ginext.New("release")
+	NewPreviewHandler(service, 1, []string{"jpg"}, time.Second).RegisterRoutes(engine)
+
+	req := httptest.NewRequest(http.MethodPost, "/preview", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPreviewFailureAfterPartialWriteIsJSON(t *testing.T) {
+	rec := postPreview(t, &fakePreviewService{written: []byte("\xff\xd8partial"), err: errors.New("encode failed")})
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want 422", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("Content-Type = %q, want JSON", ct)
+	}
+	var resp dto.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Error != "processing_failed" {
+		t.Fatalf("body %q is not the processing_failed error", rec.Body.String())
+	}
+}
+
+func TestPreviewSuccessIsJPEG(t *testing.T) {
+	rec := postPreview(t, &fakePreviewService{written: []byte("\xff\xd8image")})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/jpeg" {
+		t.Fatalf("Content-Type = %q, want image/jpeg", ct)
+	}
+	if rec.Body.String() != "\xff\xd8image" {
+		t.Fatalf("body = %q, want the rendered preview", rec.Body.String())
+	}
+}
+
+// stallingPreviewService works until its context is done.
+type stallingPreviewService struct {
+	started chan struct{}
+}
+
+func (s *stallingPreviewService) Preview(ctx context.Context, reader io.Reader, processingType domain.ProcessingType, opts domain.ProcessingOptions, w io.Writer) error {
+	close(s.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func previewRequest(t *testing.T) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", "photo.jpg")
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write([]byte("jpeg bytes"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/preview", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestPreviewTimesOutAtConfiguredDeadline(t *testing.T) {
+	service := &stallingPreviewService{started: make(chan struct{})}
+	engine := ginext.New("release")
+	NewPreviewHandler(service, 1, []string{"jpg"}, 50*time.Millisecond).RegisterRoutes(engine)
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, previewRequest(t))
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+}
+
+func TestPreviewAbandonedWhenClientCancels(t *testing.T) {
+	service := &stallingPreviewService{started: make(chan struct{})}
+	engine := ginext.New("release")
+	NewPreviewHandler(service, 1, []string{"jpg"}, time.Minute).RegisterRoutes(engine)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-service.started
+		cancel()
+	}()
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		engine.ServeHTTP(rec, previewRequest(t).WithContext(ctx))
+		done <- rec
+	}()
+
+	select {
+	case rec := <-done:
+		if rec.Body.Len() != 0 {
+			t.Fatalf("abandoned preview answered %q", rec.Body.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("preview kept working after the client went away")
+	}
+}
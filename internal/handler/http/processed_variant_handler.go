@@ -0,0 +1,234 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+type ProcessedVariantHandler struct {
+	service  domain.ProcessedVariantService
+	timeouts RouteTimeouts
+}
+
+func NewProcessedVariantHandler(service domain.ProcessedVariantService, timeouts RouteTimeouts) *ProcessedVariantHandler {
+	return &ProcessedVariantHandler{
+		service:  service,
+		timeouts: timeouts,
+	}
+}
+
+func (h *ProcessedVariantHandler) RegisterRoutes(engine *ginext.Engine) {
+	read := middleware.TimeoutMiddleware(h.timeouts.Read)
+	engine.POST("/image/:id/process-variant", read, h.RequestVariant)
+	engine.GET("/image/:id/process-variant", read, h.ListVariants)
+	engine.GET("/image/:id/process-variant/:name", read, h.GetVariant)
+	engine.GET("/image/:id/process-variant/:name/file", read, h.GetVariantFile)
+}
+
+// POST /image/:id/process-variant
+func (h *ProcessedVariantHandler) RequestVariant(c *ginext.Context) {
+	id := c.Param("id")
+
+	var req dto.ProcessVariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must be JSON with a variant name and processing_type",
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(req.ProcessingType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	if !validRotation(pt, req.Angle) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_angle",
+			Message: invalidAngleMessage,
+		})
+		return
+	}
+
+	flip, ok := parseFlip(req.Flip)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_flip",
+			Message: invalidFlipMessage,
+		})
+		return
+	}
+
+	if !validEncodeOverrides(c, req.Quality, req.WatermarkText) {
+		return
+	}
+
+	if req.Radius != nil && (*req.Radius < 0 || *req.Radius > domain.MaxTargetDimension) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_radius",
+			Message: invalidRadiusMessage,
+		})
+		return
+	}
+
+	if req.Page != nil && !domain.ValidPageNumber(*req.Page) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_page",
+			Message: invalidPageMessage,
+		})
+		return
+	}
+
+	variant, err := h.service.RequestVariant(c.Request.Context(), id, req.Name, pt, domain.ProcessingOptions{
+		Flatten:       req.Flatten,
+		Angle:         req.Angle,
+		Flip:          flip,
+		Pipeline:      req.Pipeline,
+		Width:         targetDimension(req.Width),
+		Height:        targetDimension(req.Height),
+		Page:          req.Page,
+		Radius:        req.Radius,
+		Quality:       req.Quality,
+		WatermarkText: req.WatermarkText,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+		case errors.Is(err, domain.ErrInvalidVariantName):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_name",
+				Message: "Name must be 1-64 lowercase letters, digits, '_' or '-', starting with a letter or digit",
+			})
+		case errors.Is(err, domain.ErrUnknownPipeline):
+			c.JSON(http.StatusBadRequest, uploadErrorResponse(err))
+		case errors.Is(err, domain.ErrAlreadyProcessing):
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "already_processing",
+				Message: "A variant with this name is still pending or processing",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Str("image_id", id).Str("variant", req.Name).Msg("failed to request processed variant")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to queue variant processing",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, dto.MapProcessedVariantToResponse(variant, getBaseURL(c)))
+}
+
+// GET /image/:id/process-variant
+func (h *ProcessedVariantHandler) ListVariants(c *ginext.Context) {
+	id := c.Param("id")
+
+	variants, err := h.service.ListVariants(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to list processed variants")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to list processed variants",
+		})
+		return
+	}
+
+	baseURL := getBaseURL(c)
+	responses := make([]*dto.ProcessedVariantResponse, 0, len(variants))
+	for _, v := range variants {
+		responses = append(responses, dto.MapProcessedVariantToResponse(v, baseURL))
+	}
+
+	c.JSON(http.StatusOK, responses)
+}
+
+// GET /image/:id/process-variant/:name
+func (h *ProcessedVariantHandler) GetVariant(c *ginext.Context) {
+	id := c.Param("id")
+	name := c.Param("name")
+
+	variant, err := h.service.GetVariant(c.Request.Context(), id, name)
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) || errors.Is(err, domain.ErrProcessedVariantNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Processed variant not found",
+			})
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("variant", name).Msg("failed to get processed variant")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to retrieve processed variant",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MapProcessedVariantToResponse(variant, getBaseURL(c)))
+}
+
+// GET /image/:id/process-variant/:name/file
+func (h *ProcessedVariantHandler) GetVariantFile(c *ginext.Context) {
+	id := c.Param("id")
+	name := c.Param("name")
+
+	file, err := h.service.GetVariantFile(c.Request.Context(), id, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrImageNotFound), errors.Is(err, domain.ErrProcessedVariantNotFound):
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Processed variant not found",
+			})
+		case errors.Is(err, domain.ErrImageNotProcessed):
+			c.JSON(http.StatusConflict, dto.ErrorResponse{
+				Error:   "not_processed",
+				Message: "Variant has not been processed yet",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Str("image_id", id).Str("variant", name).Msg("failed to get processed variant file")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "server_error",
+				Message: "Failed to retrieve processed variant",
+			})
+		}
+		return
+	}
+	defer file.Body.Close()
+
+	serveImageFile(c, id, file)
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+// minSpriteFiles is the smallest useful sheet; one image is just the image.
+const minSpriteFiles = 2
+
+type SpriteHandler struct {
+	service        domain.SpriteService
+	maxUploadSize  int64
+	allowedFormats []string
+	maxFiles       int
+	timeout        time.Duration
+}
+
+func NewSpriteHandler(service domain.SpriteService, maxUploadSizeMB int, allowedFormats []string, maxFiles int, timeout time.Duration) *SpriteHandler {
+	return &SpriteHandler{
+		service:        service,
+		maxUploadSize:  int64(maxUploadSizeMB) * 1024 * 1024,
+		allowedFormats: allowedFormats,
+		maxFiles:       maxFiles,
+		timeout:        timeout,
+	}
+}
+
+func (h *SpriteHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.POST("/sprite", middleware.TimeoutMiddleware(h.timeout), h.BuildSprite)
+}
+
+// POST /sprite
+//
+// Packs the "images" files into one PNG sheet and returns it as a data URI
+// together with the atlas of sprite rectangles, named after the files.
+func (h *SpriteHandler) BuildSprite(c *ginext.Context) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["images"]) == 0 {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "No image files provided",
+		})
+		return
+	}
+
+	headers := form.File["images"]
+	if len(headers) < minSpriteFiles || len(headers) > h.maxFiles {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_file_count",
+			Message: fmt.Sprintf("A sprite sheet needs between %d and %d images", minSpriteFiles, h.maxFiles),
+		})
+		return
+	}
+
+	inputs := make([]domain.SpriteInput, 0, len(headers))
+	for _, header := range headers {
+		file, errResp := h.openInput(header)
+		if errResp != nil {
+			c.JSON(http.StatusBadRequest, errResp)
+			return
+		}
+		defer file.Close()
+		inputs = append(inputs, domain.SpriteInput{Name: header.Filename, Reader: file})
+	}
+
+	var sheet bytes.Buffer
+	atlas, err := h.service.BuildSprite(c.Request.Context(), inputs, &sheet)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrTooManyPixels):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "too_many_pixels",
+				Message: "Every image must stay within the maximum pixel count",
+			})
+		case errors.Is(err, domain.ErrInvalidImageData):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "decode_failed",
+				Message: "Every file must be a decodable image",
+			})
+		case errors.Is(err, domain.ErrSpriteTooLarge):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "sprite_too_large",
+				Message: "Images do not fit on a sprite sheet of the maximum size",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			zlog.Logger.Error().Err(err).Msg("failed to build sprite sheet")
+			c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+				Error:   "processing_failed",
+				Message: "Failed to build sprite sheet",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.MapSpriteToResponse(atlas, "data:image/png;base64,"+base64.StdEncoding.EncodeToString(sheet.Bytes())))
+}
+
+func (h *SpriteHandler) openInput(header *multipart.FileHeader) (multipart.File, *dto.ErrorResponse) {
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if header.Size > h.maxUploadSize {
+		return nil, fileTooLargeResponse(ext, h.maxUploadSize)
+	}
+	if !isAllowedFormat(h.allowedFormats, ext) {
+		return nil, &dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: fmt.Sprintf("Unsupported file format for %s. Allowed: %v", header.Filename, h.allowedFormats),
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("filename", header.Filename).Msg("failed to open sprite file")
+		return nil, &dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Failed to read uploaded file",
+		}
+	}
+	return file, nil
+}
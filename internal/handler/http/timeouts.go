@@ -0,0 +1,36 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// RouteTimeouts bounds each endpoint group separately from the server-wide
+// read/write timeouts. Zero leaves a group unbounded.
+type RouteTimeouts struct {
+	Upload     time.Duration
+	Processing time.Duration
+	Read       time.Duration
+	Admin      time.Duration
+	// Write is the server-wide write timeout, which streaming responses
+	// renew after every record so a long export is not cut off
+	Write time.Duration
+}
+
+// writeTimeoutIfExpired answers 504 when the route deadline has passed, so a
+// failure caused by the deadline is not reported as a server error.
+func writeTimeoutIfExpired(c *ginext.Context) bool {
+	if !errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		return false
+	}
+	c.JSON(http.StatusGatewayTimeout, dto.ErrorResponse{
+		Error:   "timeout",
+		Message: "Request took too long to complete",
+	})
+	return true
+}
@@ -0,0 +1,308 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
+)
+
+// maxURLRedirects bounds the redirect chain of a remote fetch; every hop
+// goes through the same address check.
+const maxURLRedirects = 3
+
+var (
+	errBlockedAddress = errors.New("remote address is not publicly routable")
+	errRemoteStatus   = errors.New("remote server returned an error status")
+	errRemoteNotImage = errors.New("remote content is not an image")
+)
+
+// newRemoteClient builds the client for URL uploads. The address check
+// runs on the resolved IP right before connecting, so DNS names that
+// point inside the network and redirects to internal hosts are refused
+// alike. Proxies are disabled: they would connect on the client's behalf.
+func newRemoteClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !helpers.PublicIP(ip) {
+				return fmt.Errorf("%w: %s", errBlockedAddress, host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxURLRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxURLRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("%w: redirect to %s", errBlockedAddress, req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// remoteImage is a downloaded image ready for UploadImage.
+type remoteImage struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// fetchRemote downloads u, reading at most the size limit of the
+// format it resolves to.
+func (h *ImageHandler) fetchRemote(ctx context.Context, u *url.URL) (*remoteImage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := h.remote.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", errRemoteStatus, resp.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return nil, fmt.Errorf("%w: %q", errRemoteNotImage, mediaType)
+	}
+
+	filename := remoteFilename(resp.Request.URL, mediaType)
+	limit := h.maxSizeFor(path.Ext(filename))
+	if resp.ContentLength > limit {
+		return nil, domain.ErrFileTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, domain.ErrFileTooLarge
+	}
+
+	// the declared type is the server's word; trust the bytes instead
+	sniffed := http.DetectContentType(data)
+	if !strings.HasPrefix(sniffed, "image/") {
+		return nil, fmt.Errorf("%w: content sniffed as %q", errRemoteNotImage, sniffed)
+	}
+
+	return &remoteImage{filename: filename, contentType: sniffed, data: data}, nil
+}
+
+// remoteFilename takes the last path segment of the final URL, adding an
+// extension from the media type when the path has none.
+func remoteFilename(u *url.URL, mediaType string) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" {
+		name = "remote"
+	}
+	if path.Ext(name) != "" {
+		return name
+	}
+
+	switch mediaType {
+	case "image/jpeg":
+		return name + ".jpg"
+	case "image/png":
+		return name + ".png"
+	case "image/gif":
+		return name + ".gif"
+	case "image/webp":
+		return name + ".webp"
+	}
+	return name
+}
+
+// POST /upload/url
+func (h *ImageHandler) UploadFromURL(c *ginext.Context) {
+	var req dto.URLUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Body must be JSON with a url field",
+		})
+		return
+	}
+
+	u, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_url",
+			Message: "URL must be an absolute http or https URL",
+		})
+		return
+	}
+
+	pt, ok := parseProcessingType(req.ProcessingType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_processing_type",
+			Message: invalidProcessingTypeMessage,
+		})
+		return
+	}
+
+	if !validRotation(pt, req.Angle) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_angle",
+			Message: invalidAngleMessage,
+		})
+		return
+	}
+
+	flip, ok := parseFlip(req.Flip)
+	if !ok {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_flip",
+			Message: invalidFlipMessage,
+		})
+		return
+	}
+
+	if !validEncodeOverrides(c, req.Quality, req.WatermarkText) {
+		return
+	}
+
+	if req.Radius != nil && (*req.Radius < 0 || *req.Radius > domain.MaxTargetDimension) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_radius",
+			Message: invalidRadiusMessage,
+		})
+		return
+	}
+
+	if req.Page != nil && !domain.ValidPageNumber(*req.Page) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_page",
+			Message: invalidPageMessage,
+		})
+		return
+	}
+
+	tenantID, ok := parseTenantID(c)
+	if !ok {
+		return
+	}
+
+	callbackURL, ok := parseCallbackURL(c, req.CallbackURL)
+	if !ok {
+		return
+	}
+
+	remote, err := h.fetchRemote(c.Request.Context(), u)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("url", u.Redacted()).Msg("failed to fetch remote image")
+		switch {
+		case errors.Is(err, errBlockedAddress):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "forbidden_url",
+				Message: "URL must point to a publicly routable host",
+			})
+		case errors.Is(err, domain.ErrFileTooLarge):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "file_too_large",
+				Message: fmt.Sprintf("Remote file exceeds the upload size limit (%d MB)", h.maxAllowedSize()/(1024*1024)),
+			})
+		case errors.Is(err, errRemoteNotImage):
+			c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+				Error:   "invalid_format",
+				Message: "Remote content is not an image",
+			})
+		default:
+			if writeTimeoutIfExpired(c) {
+				return
+			}
+			c.JSON(http.StatusBadGateway, dto.ErrorResponse{
+				Error:   "fetch_failed",
+				Message: "Failed to download the remote image",
+			})
+		}
+		return
+	}
+
+	if !h.isAllowedFormat(path.Ext(remote.filename)) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_format",
+			Message: fmt.Sprintf("Unsupported file format. Allowed: %v", h.allowedFormats),
+		})
+		return
+	}
+
+	image, err := h.service.UploadImage(
+		c.Request.Context(),
+		remote.filename,
+		remote.contentType,
+		int64(len(remote.data)),
+		bytes.NewReader(remote.data),
+		pt,
+		domain.ProcessingOptions{
+			Flatten:       req.Flatten,
+			Angle:         req.Angle,
+			Flip:          flip,
+			Pipeline:      req.Pipeline,
+			Width:         targetDimension(req.Width),
+			Height:        targetDimension(req.Height),
+			Page:          req.Page,
+			Radius:        req.Radius,
+			Quality:       req.Quality,
+			WatermarkText: req.WatermarkText,
+			CallbackURL:   callbackURL,
+		},
+		tenantID,
+	)
+	if err != nil {
+		if errResp := uploadErrorResponse(err); errResp != nil {
+			c.JSON(http.StatusBadRequest, errResp)
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Msg("failed to upload remote image")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "upload_failed",
+			Message: "Failed to upload image",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, dto.MapImageToResponse(image, getBaseURL(c)))
+}
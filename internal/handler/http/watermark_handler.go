@@ -0,0 +1,85 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/handler/middleware"
+)
+
+// userHeader carries the authenticated user's identity. It is expected to be
+// set by the authenticating gateway in front of the API, never by clients.
+const userHeader = "X-User"
+
+type WatermarkHandler struct {
+	service  domain.WatermarkService
+	timeouts RouteTimeouts
+}
+
+func NewWatermarkHandler(service domain.WatermarkService, timeouts RouteTimeouts) *WatermarkHandler {
+	return &WatermarkHandler{
+		service:  service,
+		timeouts: timeouts,
+	}
+}
+
+func (h *WatermarkHandler) RegisterRoutes(engine *ginext.Engine) {
+	engine.GET("/image/:id/watermarked", middleware.TimeoutMiddleware(h.timeouts.Processing), h.GetWatermarkedImage)
+}
+
+// GET /image/:id/watermarked
+func (h *WatermarkHandler) GetWatermarkedImage(c *ginext.Context) {
+	id := c.Param("id")
+	if id == "" {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "invalid_request",
+			Message: "Image ID is required",
+		})
+		return
+	}
+
+	buf, filename, err := h.service.GetWatermarked(c.Request.Context(), id, c.GetHeader(userHeader))
+	if err != nil {
+		if errors.Is(err, domain.ErrImageNotFound) {
+			c.JSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Image not found",
+			})
+			return
+		}
+		if errors.Is(err, domain.ErrTooManyPixels) {
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "too_many_pixels",
+				Message: "Stored image exceeds the maximum pixel count",
+			})
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidImageData) {
+			c.JSON(http.StatusUnprocessableEntity, dto.ErrorResponse{
+				Error:   "decode_failed",
+				Message: "Stored image could not be decoded",
+			})
+			return
+		}
+		if writeTimeoutIfExpired(c) {
+			return
+		}
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to watermark image")
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "server_error",
+			Message: "Failed to watermark image",
+		})
+		return
+	}
+
+	// every response is unique to the requesting user
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Content-Length", strconv.Itoa(buf.Len()))
+	c.Header("Content-Disposition", contentDisposition(filename))
+	c.Data(http.StatusOK, "image/jpeg", buf.Bytes())
+}
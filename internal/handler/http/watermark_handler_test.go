@@ -0,0 +1,78 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// fakeWatermarkService records who asked for which image.
+type fakeWatermarkService struct {
+	id, user string
+	err      error
+}
+
+func (s *fakeWatermarkService) GetWatermarked(ctx context.Context, id string, user string) (*bytes.Buffer, string, error) {
+	s.id, s.user = id, user
+	if s.err != nil {
+		return nil, "", s.err
+	}
+	return bytes.NewBufferString("\xff\xd8marked"), "photo_watermarked.jpg", nil
+}
+
+func getWatermarked(service domain.WatermarkService, user string) *httptest.ResponseRecorder {
+	engine := ginext.New("release")
+	NewWatermarkHandler(service, RouteTimeouts{}).RegisterRoutes(engine)
+
+	req := httptest.NewRequest(http.MethodGet, "/image/img-1/watermarked", nil)
+	if user != "" {
+		req.Header.Set(userHeader, user)
+	}
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWatermarkedImageIsStampedForTheRequestingUser(t *testing.T) {
+	service := &fakeWatermarkService{}
+	rec := getWatermarked(service, "alice")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if service.id != "img-1" || service.user != "alice" {
+		t.Fatalf("watermarked %q for %q, want img-1 for alice", service.id, service.user)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("Cache-Control = %q; a per-user image must not be shared", got)
+	}
+	if got := rec.Header().Get("Content-Disposition"); got != contentDisposition("photo_watermarked.jpg") {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if rec.Body.String() != "\xff\xd8marked" {
+		t.Errorf("body = %q, want the watermarked image", rec.Body.String())
+	}
+}
+
+func TestWatermarkedImageErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{domain.ErrImageNotFound, http.StatusNotFound},
+		{domain.ErrInvalidImageData, http.StatusUnprocessableEntity},
+		{domain.ErrTooManyPixels, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if rec := getWatermarked(&fakeWatermarkService{err: tt.err}, ""); rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// AdminAuthMiddleware admits only requests carrying "Authorization: Bearer
+// <token>". With an empty token the admin API is disabled altogether.
+func AdminAuthMiddleware(token string) ginext.HandlerFunc {
+	return func(c *ginext.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, dto.ErrorResponse{
+				Error:   "not_found",
+				Message: "Admin API is disabled",
+			})
+			return
+		}
+
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			zlog.Logger.Warn().
+				Str("path", c.Request.URL.Path).
+				Str("client_ip", c.ClientIP()).
+				Msg("admin request rejected")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, dto.ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Valid admin token required",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
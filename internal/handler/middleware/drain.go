@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+// drainPollInterval is how often Drainer.Wait checks the in-flight count.
+const drainPollInterval = 50 * time.Millisecond
+
+// Drainer counts requests still being served, so shutdown can wait for
+// handlers that outlive http.Server.Shutdown's deadline before closing the
+// dependencies they use.
+type Drainer struct {
+	inFlight atomic.Int64
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{}
+}
+
+func (d *Drainer) Middleware() ginext.HandlerFunc {
+	return func(c *ginext.Context) {
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+		c.Next()
+	}
+}
+
+// InFlight returns the number of requests currently being served.
+func (d *Drainer) InFlight() int64 {
+	return d.inFlight.Load()
+}
+
+// Wait blocks until no request is in flight or ctx is done, returning the
+// context error in the latter case.
+func (d *Drainer) Wait(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for d.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
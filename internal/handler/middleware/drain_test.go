@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+// fakeProducer records publishes and fails those that come after Close,
+// the way uploads lost by an early producer close would.
+type fakeProducer struct {
+	mu        sync.Mutex
+	closed    bool
+	published int
+	lost      int
+}
+
+func (p *fakeProducer) Publish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		p.lost++
+		return
+	}
+	p.published++
+}
+
+func (p *fakeProducer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
+
+func TestDrainKeepsProducerForUploadsOutlivingShutdown(t *testing.T) {
+	drainer := NewDrainer()
+	producer := &fakeProducer{}
+	const uploads = 20
+
+	var started sync.WaitGroup
+	started.Add(uploads)
+	release := make(chan struct{})
+
+	engine := ginext.New("release")
+	engine.Use(drainer.Middleware())
+	engine.POST("/upload", func(c *ginext.Context) {
+		started.Done()
+		// the file is stored; the task is published only after the
+		// server's shutdown deadline has passed
+		<-release
+		producer.Publish()
+		c.Status(http.StatusAccepted)
+	})
+	srv := httptest.NewServer(engine)
+	defer srv.Close()
+
+	var clients sync.WaitGroup
+	for range uploads {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			resp, err := http.Post(srv.URL+"/upload", "image/jpeg", nil)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	started.Wait()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.Config.Shutdown(shutdownCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown err = %v, want the deadline to pass with uploads running", err)
+	}
+	if n := drainer.InFlight(); n != uploads {
+		t.Fatalf("InFlight = %d after Shutdown, want %d", n, uploads)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(release)
+	}()
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDrain()
+	if err := drainer.Wait(drainCtx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	producer.Close()
+	clients.Wait()
+
+	if producer.published != uploads || producer.lost != 0 {
+		t.Fatalf("published %d, lost %d; want all %d published before Close", producer.published, producer.lost, uploads)
+	}
+}
+
+func TestDrainWaitGivesUpAtDeadline(t *testing.T) {
+	drainer := NewDrainer()
+	release := make(chan struct{})
+	defer close(release)
+
+	engine := ginext.New("release")
+	engine.Use(drainer.Middleware())
+	engine.POST("/upload", func(c *ginext.Context) { <-release })
+	go engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/upload", nil))
+
+	for drainer.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*drainPollInterval)
+	defer cancel()
+	if err := drainer.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait err = %v, want DeadlineExceeded", err)
+	}
+	if n := drainer.InFlight(); n != 1 {
+		t.Errorf("InFlight = %d, want the stuck request", n)
+	}
+}
+
+func TestDrainWaitReturnsAtOnceWhenIdle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewDrainer().Wait(ctx); err != nil {
+		t.Fatalf("Wait with nothing in flight: %v", err)
+	}
+}
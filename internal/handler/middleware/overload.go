@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// OverloadMiddleware sheds load once more than maxInFlight requests are being
+// served at the same time. Health probes are never rejected so orchestrators
+// don't restart a server that is merely busy. A non-positive maxInFlight
+// disables the check.
+func OverloadMiddleware(maxInFlight int, retryAfterSec int) ginext.HandlerFunc {
+	var inFlight atomic.Int64
+	retryAfter := strconv.Itoa(retryAfterSec)
+
+	return func(c *ginext.Context) {
+		if maxInFlight <= 0 || strings.HasPrefix(c.Request.URL.Path, "/health") {
+			c.Next()
+			return
+		}
+
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		if current > int64(maxInFlight) {
+			zlog.Logger.Warn().
+				Int64("in_flight", current).
+				Int("max_in_flight", maxInFlight).
+				Str("path", c.Request.URL.Path).
+				Msg("server overloaded, rejecting request")
+
+			c.Header("Retry-After", retryAfter)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, dto.ErrorResponse{
+				Error:   "overloaded",
+				Message: "Server is overloaded, please retry later",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
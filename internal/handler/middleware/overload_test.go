@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+func TestOverloadShedsRequestsPastThreshold(t *testing.T) {
+	const maxInFlight = 3
+
+	var started sync.WaitGroup
+	started.Add(maxInFlight)
+	release := make(chan struct{})
+
+	engine := ginext.New("release")
+	engine.Use(OverloadMiddleware(maxInFlight, 7))
+	engine.GET("/slow", func(c *ginext.Context) {
+		started.Done()
+		<-release
+		c.Status(http.StatusOK)
+	})
+	engine.GET("/fast", func(c *ginext.Context) { c.Status(http.StatusOK) })
+	engine.GET("/health", func(c *ginext.Context) { c.Status(http.StatusOK) })
+
+	// fill every slot with a request that does not finish
+	var served sync.WaitGroup
+	for range maxInFlight {
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("request within the threshold got %d", rec.Code)
+			}
+		}()
+	}
+	started.Wait()
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("request past the threshold got %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "7" {
+		t.Errorf("Retry-After = %q, want 7", got)
+	}
+
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health probe while overloaded got %d, want 200", rec.Code)
+	}
+
+	close(release)
+	served.Wait()
+
+	// the shed request gave its slot back, so the server recovers
+	rec = httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request after the load passed got %d, want 200", rec.Code)
+	}
+}
+
+func TestOverloadDisabledByNonPositiveThreshold(t *testing.T) {
+	engine := ginext.New("release")
+	engine.Use(OverloadMiddleware(0, 1))
+	engine.GET("/", func(c *ginext.Context) { c.Status(http.StatusOK) })
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// TimeoutMiddleware bounds the request context of the routes it wraps. The
+// handler keeps running until it notices the cancelled context; if it gave
+// up without writing anything, the client gets a 504. A non-positive
+// timeout disables the middleware.
+func TimeoutMiddleware(timeout time.Duration) ginext.HandlerFunc {
+	return func(c *ginext.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			zlog.Logger.Warn().
+				Str("path", c.Request.URL.Path).
+				Dur("timeout", timeout).
+				Msg("request exceeded route timeout")
+			c.AbortWithStatusJSON(http.StatusGatewayTimeout, dto.ErrorResponse{
+				Error:   "timeout",
+				Message: "Request took too long to complete",
+			})
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wb-go/wbf/ginext"
+)
+
+func serveWithTimeout(timeout time.Duration, handler ginext.HandlerFunc) *httptest.ResponseRecorder {
+	engine := ginext.New("release")
+	engine.GET("/", TimeoutMiddleware(timeout), handler)
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec
+}
+
+func TestTimeoutAnswers504WhenHandlerGivesUp(t *testing.T) {
+	rec := serveWithTimeout(20*time.Millisecond, func(c *ginext.Context) {
+		<-c.Request.Context().Done()
+	})
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", rec.Code)
+	}
+}
+
+func TestTimeoutKeepsResponseWrittenInTime(t *testing.T) {
+	rec := serveWithTimeout(time.Minute, func(c *ginext.Context) {
+		if _, ok := c.Request.Context().Deadline(); !ok {
+			t.Error("handler context has no deadline")
+		}
+		c.String(http.StatusOK, "done")
+	})
+
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Fatalf("response = %d %q, want 200 done", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutKeepsHandlersOwnLateResponse(t *testing.T) {
+	rec := serveWithTimeout(time.Millisecond, func(c *ginext.Context) {
+		<-c.Request.Context().Done()
+		c.String(http.StatusServiceUnavailable, "busy")
+	})
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "busy" {
+		t.Fatalf("response = %d %q; the handler's own answer was replaced", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutDisabledByNonPositiveDuration(t *testing.T) {
+	rec := serveWithTimeout(0, func(c *ginext.Context) {
+		if _, ok := c.Request.Context().Deadline(); ok {
+			t.Error("a disabled timeout set a deadline")
+		}
+		c.Status(http.StatusOK)
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,25 @@
+package helpers
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ContentTypeByExtension returns the MIME type of an image file by its
+// extension, application/octet-stream when it is not a known image type.
+func ContentTypeByExtension(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".tif", ".tiff":
+		return "image/tiff"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "application/octet-stream"
+	}
+}
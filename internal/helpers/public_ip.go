@@ -0,0 +1,40 @@
+package helpers
+
+import "net"
+
+// blockedNetworks are ranges outgoing requests must never reach on top of
+// those the net.IP predicates cover: shared CGNAT space, "this network",
+// and the IPv4-embedding IPv6 prefixes that could smuggle a private address.
+var blockedNetworks = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),
+	mustCIDR("100.64.0.0/10"),
+	mustCIDR("192.0.0.0/24"),
+	mustCIDR("198.18.0.0/15"),
+	mustCIDR("64:ff9b::/96"),
+	mustCIDR("2002::/16"),
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// PublicIP reports whether requests on behalf of clients may connect to
+// ip: it is not loopback, private, link-local (including cloud metadata
+// at 169.254.169.254), multicast or otherwise reserved.
+func PublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// cyrillic maps Russian, Ukrainian and Belarusian letters to Latin
+// (simplified GOST 7.79 system B). Capitals are handled by ASCIIFilename.
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "j", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "x", 'ц': "cz", 'ч': "ch", 'ш': "sh", 'щ': "shh", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+}
+
+// ASCIIFilename returns a readable ASCII form of name for storage keys and
+// download headers: Cyrillic is transliterated, Latin letters lose their
+// diacritics, and anything else (CJK, punctuation, spaces) becomes a
+// single "_". The extension is kept; a name with nothing left becomes
+// "file" plus the extension.
+func ASCIIFilename(name string) string {
+	ext := filepath.Ext(name)
+	base := slug(strings.TrimSuffix(name, ext))
+	ext = slug(strings.TrimPrefix(ext, "."))
+
+	if base == "" {
+		base = "file"
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+func slug(s string) string {
+	var b strings.Builder
+	pendingSep := false
+	write := func(part string) {
+		if part == "" {
+			return
+		}
+		if pendingSep && b.Len() > 0 {
+			b.WriteByte('_')
+		}
+		pendingSep = false
+		b.WriteString(part)
+	}
+
+	for _, r := range norm.NFC.String(s) {
+		if latin, ok := cyrillic[unicode.ToLower(r)]; ok {
+			if unicode.IsUpper(r) && latin != "" {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			write(latin)
+			continue
+		}
+
+		// NFD splits "é" into "e" and a combining accent, which is dropped
+		for _, d := range norm.NFD.String(string(r)) {
+			switch {
+			case d < unicode.MaxASCII && (unicode.IsLetter(d) || unicode.IsDigit(d) || d == '-' || d == '.'):
+				write(string(d))
+			case unicode.Is(unicode.Mn, d):
+			default:
+				pendingSep = true
+			}
+		}
+	}
+	return strings.Trim(b.String(), ".-")
+}
@@ -0,0 +1,34 @@
+package helpers
+
+import "testing"
+
+func TestASCIIFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"Фото.jpg", "Foto.jpg"},
+		{"Щука и ёж.png", "Shhuka_i_yozh.png"},
+		{"Київ.jpeg", "Kiyiv.jpeg"},
+		{"東京タワー.jpg", "file.jpg"},
+		{"旅行 2024 夏.jpg", "2024.jpg"},
+		{"café crème.jpg", "cafe_creme.jpg"},
+		{"отпуск_東京.webp", "otpusk.webp"},
+		{"снимок.ЖПГ", "snimok.ZhPG"},
+		{"без расширения", "bez_rasshireniya"},
+		{"漢字", "file"},
+	}
+	for _, tt := range tests {
+		got := ASCIIFilename(tt.name)
+		if got != tt.want {
+			t.Errorf("ASCIIFilename(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		for _, r := range got {
+			if r < ' ' || r > '~' {
+				t.Errorf("ASCIIFilename(%q) = %q has non-ASCII %q", tt.name, got, r)
+				break
+			}
+		}
+	}
+}
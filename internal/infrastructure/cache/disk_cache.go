@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+)
+
+// DiskCache keeps copies of generated variants on local disk, bounded by a
+// total size. When a write pushes the total over the cap, the least recently
+// used files are evicted. Recency is stored as the file mtime, which is
+// bumped on every hit, so the order survives restarts.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List // front = most recently used
+	entries map[string]*list.Element
+	size    int64
+}
+
+type cacheEntry struct {
+	name string
+	size int64
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// NewDiskCache opens (creating if needed) the cache directory and indexes
+// the files already in it, evicting down to maxBytes if the cap shrank.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cache directory is empty")
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("cache size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cache directory: %w", err)
+	}
+
+	files := make([]fileInfo, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if filepath.Ext(e.Name()) == ".tmp" {
+			// left over from an interrupted write
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		files = append(files, fileInfo{name: e.Name(), size: info.Size(), modTime: info.ModTime()})
+	}
+
+	// oldest first, so the most recent ends up at the front
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		c.entries[f.name] = c.lru.PushFront(&cacheEntry{name: f.name, size: f.size})
+		c.size += f.size
+	}
+
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+
+	zlog.Logger.Info().
+		Str("dir", dir).
+		Int64("max_bytes", maxBytes).
+		Int64("size", c.size).
+		Int("files", c.lru.Len()).
+		Msg("Disk cache initialized")
+
+	return c, nil
+}
+
+// Open returns the cached copy of key and marks it as recently used.
+func (c *DiskCache) Open(key string) (io.ReadCloser, bool) {
+	name := fileName(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+
+	path := filepath.Join(c.dir, name)
+	file, err := os.Open(path)
+	if err != nil {
+		// removed behind our back
+		c.remove(el)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		zlog.Logger.Debug().Err(err).Str("path", path).Msg("failed to touch cache file")
+	}
+
+	return file, true
+}
+
+// Put stores the content of r under key, then evicts least recently used
+// files until the cache fits its cap again.
+func (c *DiskCache) Put(key string, r io.Reader) error {
+	name := fileName(key)
+	path := filepath.Join(c.dir, name)
+
+	tmp, err := os.CreateTemp(c.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create cache file: %w", err)
+	}
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write cache file: %w", err)
+	}
+
+	if size > c.maxBytes {
+		os.Remove(tmp.Name())
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("commit cache file: %w", err)
+	}
+
+	if el, ok := c.entries[name]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.size -= entry.size
+		entry.size = size
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[name] = c.lru.PushFront(&cacheEntry{name: name, size: size})
+	}
+	c.size += size
+
+	c.evict()
+	return nil
+}
+
+// Remove drops key from the cache, e.g. when the image is deleted.
+func (c *DiskCache) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[fileName(key)]; ok {
+		c.remove(el)
+	}
+}
+
+// RemoveDir drops every key under dir, e.g. all renditions cached for a
+// deleted image under "<image id>/".
+func (c *DiskCache) RemoveDir(dir string) {
+	prefix := dirPrefix(dir)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, el := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			c.remove(el)
+		}
+	}
+}
+
+// evict must be called with mu held.
+func (c *DiskCache) evict() {
+	for c.size > c.maxBytes {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		entry := el.Value.(*cacheEntry)
+		zlog.Logger.Debug().Str("file", entry.name).Int64("size", entry.size).Msg("evicting cached variant")
+		c.remove(el)
+	}
+}
+
+// remove must be called with mu held.
+func (c *DiskCache) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+		zlog.Logger.Warn().Err(err).Str("file", entry.name).Msg("failed to remove cache file")
+	}
+	c.lru.Remove(el)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+}
+
+// fileName maps a storage key, which may contain slashes, to a flat name
+// in the cache directory. It starts with a hash of the key's directory, so
+// RemoveDir can find the keys under it; the extension is kept for easier
+// inspection.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return dirPrefix(path.Dir(key)) + hex.EncodeToString(sum[:16]) + filepath.Ext(key)
+}
+
+func dirPrefix(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return hex.EncodeToString(sum[:8]) + "-"
+}
@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func put(t *testing.T, c *DiskCache, key, content string) {
+	t.Helper()
+	if err := c.Put(key, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put %s: %v", key, err)
+	}
+}
+
+func cached(t *testing.T, c *DiskCache, key string) (string, bool) {
+	t.Helper()
+	r, ok := c.Open(key)
+	if !ok {
+		return "", false
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", key, err)
+	}
+	return string(data), true
+}
+
+func filesIn(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+	return len(entries)
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 30)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	put(t, c, "img-1/a.jpg", "aaaaaaaaaa")
+	put(t, c, "img-1/b.jpg", "bbbbbbbbbb")
+	put(t, c, "img-2/c.jpg", "cccccccccc")
+	// a is used again, which leaves b as the least recently used
+	if _, ok := cached(t, c, "img-1/a.jpg"); !ok {
+		t.Fatal("a missing before the cache is full")
+	}
+
+	put(t, c, "img-2/d.jpg", "dddddddddd")
+	if _, ok := cached(t, c, "img-1/b.jpg"); ok {
+		t.Error("b survived although it was least recently used")
+	}
+	for _, key := range []string{"img-1/a.jpg", "img-2/c.jpg", "img-2/d.jpg"} {
+		if _, ok := cached(t, c, key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	// one write can push out several entries
+	put(t, c, "img-3/e.jpg", strings.Repeat("e", 25))
+	if got, ok := cached(t, c, "img-3/e.jpg"); !ok || len(got) != 25 {
+		t.Fatalf("e = %q, %v", got, ok)
+	}
+	if n := filesIn(t, dir); n != 1 {
+		t.Errorf("%d files on disk, want only e within the 30 byte cap", n)
+	}
+}
+
+func TestDiskCacheSkipsEntriesLargerThanTheCap(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 10)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	put(t, c, "a.jpg", "aaaa")
+	put(t, c, "big.jpg", strings.Repeat("b", 11))
+
+	if _, ok := cached(t, c, "big.jpg"); ok {
+		t.Error("an entry over the cap was cached")
+	}
+	if _, ok := cached(t, c, "a.jpg"); !ok {
+		t.Error("an entry over the cap evicted the rest")
+	}
+	if n := filesIn(t, dir); n != 1 {
+		t.Errorf("%d files on disk, want 1", n)
+	}
+}
+
+func TestDiskCacheKeepsRecencyAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 100)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	keys := []string{"old.jpg", "newer.jpg", "newest.jpg"}
+	base := time.Now().Add(-time.Hour)
+	for i, key := range keys {
+		put(t, c, key, "0123456789")
+		// recency is the mtime; spread it past any filesystem resolution
+		at := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(dir, fileName(key)), at, at); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+
+	// reopened with a smaller cap, the oldest file goes first
+	c, err = NewDiskCache(dir, 20)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	if _, ok := cached(t, c, "old.jpg"); ok {
+		t.Error("the oldest file survived the restart")
+	}
+	for _, key := range keys[1:] {
+		if _, ok := cached(t, c, key); !ok {
+			t.Errorf("%s was evicted on restart", key)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+
+	kafkago "github.com/segmentio/kafka-go"
+	wbfkafka "github.com/wb-go/wbf/kafka"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// commitTracker orders commits of messages processed concurrently. Committing
+// an offset commits everything before it in the partition, so a message is
+// only committed once every earlier message of its partition has finished;
+// otherwise a crash would skip tasks that were still running.
+type commitTracker struct {
+	mu      sync.Mutex
+	pending map[int][]*trackedMessage
+}
+
+type trackedMessage struct {
+	msg      kafkago.Message
+	finished bool
+	// commit is false for messages that failed without being re-queued;
+	// like before the pool, they are only committed implicitly by a later
+	// message of the partition
+	commit bool
+}
+
+func newCommitTracker() *commitTracker {
+	return &commitTracker{pending: make(map[int][]*trackedMessage)}
+}
+
+// add registers a fetched message. Messages of one partition are fetched in
+// offset order, so each partition's list stays sorted.
+func (t *commitTracker) add(msg kafkago.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[msg.Partition] = append(t.pending[msg.Partition], &trackedMessage{msg: msg})
+}
+
+// done marks msg finished and commits the furthest committable message of
+// its partition that has no unfinished message before it. The commit is
+// made under the lock so commits never go backwards.
+func (t *commitTracker) done(ctx context.Context, client *wbfkafka.Consumer, msg kafkago.Message, commit bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	queue := t.pending[msg.Partition]
+	for _, m := range queue {
+		if m.msg.Offset == msg.Offset {
+			m.finished = true
+			m.commit = commit
+			break
+		}
+	}
+
+	var last *trackedMessage
+	n := 0
+	for n < len(queue) && queue[n].finished {
+		if queue[n].commit {
+			last = queue[n]
+		}
+		n++
+	}
+	t.pending[msg.Partition] = queue[n:]
+
+	if last == nil {
+		return
+	}
+	if err := client.Commit(ctx, last.msg); err != nil {
+		zlog.Logger.Error().
+			Err(err).
+			Int("partition", last.msg.Partition).
+			Int64("offset", last.msg.Offset).
+			Msg("Failed to commit message")
+	}
+}
@@ -3,108 +3,371 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	kafkago "github.com/segmentio/kafka-go"
 	wbfkafka "github.com/wb-go/wbf/kafka"
 	"github.com/wb-go/wbf/retry"
 	"github.com/wb-go/wbf/zlog"
 
 	"github.com/yokitheyo/imageprocessor/internal/config"
 	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/metrics"
 )
 
 type MessageHandler func(ctx context.Context, task *dto.ProcessImageRequest) error
 
+// Backpressure reports when a downstream dependency is too slow to take
+// more work.
+type Backpressure interface {
+	Overloaded() bool
+}
+
+// defaultBackpressurePause applies when kafka.backpressure_pause_ms is unset.
+const defaultBackpressurePause = time.Second
+
+// defaultMaxProcessingAttempts applies when kafka.max_processing_attempts is unset.
+const defaultMaxProcessingAttempts = 3
+
+// defaultRequeueBackoff applies when kafka.requeue_backoff_ms is unset;
+// the wait doubles with every attempt up to maxRequeueBackoff.
+const (
+	defaultRequeueBackoff = time.Second
+	maxRequeueBackoff     = time.Minute
+)
+
+// Headers carried by re-queued and dead-lettered tasks.
+const (
+	attemptsHeader  = "x-processing-attempts"
+	lastErrorHeader = "x-last-error"
+)
+
 type Consumer struct {
-	client  *wbfkafka.Consumer
-	handler MessageHandler
-	topic   string
+	client       *wbfkafka.Consumer
+	handler      MessageHandler
+	topic        string
+	backpressure Backpressure
+	pause        time.Duration
+	// retries re-queues failed tasks on the consumed topic, deadLetters
+	// takes them after maxAttempts
+	retries     *wbfkafka.Producer
+	deadLetters *wbfkafka.Producer
+	maxAttempts int
+	// backoff is the wait before the first re-queue
+	backoff  time.Duration
+	poolSize int
+	commits  *commitTracker
+
+	// workCtx runs the tasks; it outlives the fetch loop and is only
+	// cancelled by Wait when tasks in flight overrun the shutdown timeout,
+	// after which abandoned keeps their messages uncommitted
+	workCtx    context.Context
+	cancelWork context.CancelFunc
+	abandoned  atomic.Bool
+	stopped    chan struct{}
 }
 
-func NewConsumer(cfg *config.KafkaConfig, handler MessageHandler) (*Consumer, error) {
+// NewConsumer builds a consumer that passes each task to handler. A
+// non-nil backpressure delays every fetch while it reports Overloaded.
+func NewConsumer(cfg *config.KafkaConfig, handler MessageHandler, backpressure Backpressure) (*Consumer, error) {
 	client := wbfkafka.NewConsumer(cfg.Brokers, cfg.Topic, cfg.GroupID)
 
 	zlog.Logger.Info().
 		Strs("brokers", cfg.Brokers).
 		Str("topic", cfg.Topic).
 		Str("group_id", cfg.GroupID).
+		Int("worker_pool_size", cfg.WorkerPoolSize).
 		Msg("Kafka consumer initialized (WB)")
 
+	pause := time.Duration(cfg.BackpressurePauseMS) * time.Millisecond
+	if pause == 0 {
+		pause = defaultBackpressurePause
+	}
+	maxAttempts := cfg.MaxProcessingAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxProcessingAttempts
+	}
+	backoff := time.Duration(cfg.RequeueBackoffMS) * time.Millisecond
+	if backoff == 0 {
+		backoff = defaultRequeueBackoff
+	}
+	poolSize := cfg.WorkerPoolSize
+	if poolSize == 0 {
+		poolSize = 1
+	}
+	workCtx, cancelWork := context.WithCancel(context.Background())
+
 	return &Consumer{
-		client:  client,
-		handler: handler,
-		topic:   cfg.Topic,
+		client:       client,
+		handler:      handler,
+		topic:        cfg.Topic,
+		backpressure: backpressure,
+		pause:        pause,
+		retries:      wbfkafka.NewProducer(cfg.Brokers, cfg.Topic),
+		deadLetters:  wbfkafka.NewProducer(cfg.Brokers, cfg.DeadLetterTopicName()),
+		maxAttempts:  maxAttempts,
+		backoff:      backoff,
+		poolSize:     poolSize,
+		commits:      newCommitTracker(),
+		workCtx:      workCtx,
+		cancelWork:   cancelWork,
+		stopped:      make(chan struct{}),
 	}, nil
 }
 
+// throttle waits one pause while the backpressure source is overloaded.
+// It does not wait for recovery: the next message is still fetched, and
+// its storage calls are what bring the latency average back down.
+func (c *Consumer) throttle(ctx context.Context) {
+	if c.backpressure == nil || !c.backpressure.Overloaded() {
+		return
+	}
+
+	zlog.Logger.Warn().Dur("pause", c.pause).Msg("Storage is slow, pausing Kafka fetch")
+
+	timer := time.NewTimer(c.pause)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// Start fetches messages and hands each to one of poolSize goroutines
+// until ctx is done, then waits for the tasks in flight before returning.
+// Tasks run on a context that is not cancelled by shutdown, so a task
+// that started is finished and committed rather than cut off; Wait bounds
+// how long that may take.
 func (c *Consumer) Start(ctx context.Context) error {
+	defer close(c.stopped)
+
 	strategy := retry.Strategy{
 		Attempts: 3,
 		Delay:    2 * time.Second,
 		Backoff:  2.0,
 	}
 
+	slots := make(chan struct{}, c.poolSize)
+	var wg sync.WaitGroup
+
 	for {
 		select {
 		case <-ctx.Done():
+			zlog.Logger.Info().Msg("Kafka consumer stopping, waiting for tasks in flight")
+			wg.Wait()
 			zlog.Logger.Info().Msg("Kafka consumer stopped")
 			return nil
-		default:
-			msg, err := c.client.FetchWithRetry(ctx, strategy)
-			if err != nil {
-				zlog.Logger.Error().Err(err).Msg("Failed to fetch Kafka message")
-				time.Sleep(time.Second)
+		case slots <- struct{}{}:
+			c.throttle(ctx)
+			if ctx.Err() != nil {
+				<-slots
 				continue
 			}
 
-			var task dto.ProcessImageRequest
-			if err := json.Unmarshal(msg.Value, &task); err != nil {
-				zlog.Logger.Error().
-					Err(err).
-					Bytes("msg", msg.Value).
-					Msg("Failed to unmarshal message")
+			msg, err := c.client.FetchWithRetry(ctx, strategy)
+			if err != nil {
+				<-slots
+				if ctx.Err() == nil {
+					zlog.Logger.Error().Err(err).Msg("Failed to fetch Kafka message")
+					time.Sleep(time.Second)
+				}
 				continue
 			}
 
-			if task.ImageID == "" || task.ProcessingType == "" {
-				zlog.Logger.Error().
-					Str("image_id", task.ImageID).
-					Str("processing_type", task.ProcessingType).
-					Msg("Invalid task: empty ImageID or ProcessingType")
-				continue
-			}
+			c.commits.add(msg)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				commit := c.process(c.workCtx, msg)
+				if c.abandoned.Load() {
+					zlog.Logger.Warn().
+						Int("partition", msg.Partition).
+						Int64("offset", msg.Offset).
+						Msg("Task abandoned on shutdown, left uncommitted for redelivery")
+					return
+				}
+				c.commits.done(c.workCtx, c.client, msg, commit)
+			}()
+		}
+	}
+}
 
-			zlog.Logger.Info().
-				Str("image_id", task.ImageID).
-				Str("processing_type", task.ProcessingType).
-				Msg("Received new Kafka task")
-
-			if err := c.handler(ctx, &task); err != nil {
-				zlog.Logger.Error().
-					Err(err).
-					Str("image_id", task.ImageID).
-					Str("processing_type", task.ProcessingType).
-					Msg("Task processing failed")
-				continue
-			}
+// abandonGrace is how long Wait still waits after cancelling overrunning
+// tasks, so they can release their images before resources are closed.
+const abandonGrace = 5 * time.Second
 
-			if err := c.client.Commit(ctx, msg); err != nil {
-				zlog.Logger.Error().
-					Err(err).
-					Str("image_id", task.ImageID).
-					Msg("Failed to commit message")
-				continue
-			}
+// Wait blocks until Start has returned after its context was cancelled.
+// If ctx is done first, the tasks still running are cancelled and their
+// messages are left uncommitted, so Kafka redelivers them after the
+// restart; Wait then returns ctx.Err().
+func (c *Consumer) Wait(ctx context.Context) error {
+	select {
+	case <-c.stopped:
+		return nil
+	case <-ctx.Done():
+	}
 
-			zlog.Logger.Info().
-				Str("image_id", task.ImageID).
-				Str("processing_type", task.ProcessingType).
-				Msg("Task processed and committed successfully")
+	zlog.Logger.Warn().Msg("Shutdown timeout reached, abandoning Kafka tasks in flight")
+	c.abandoned.Store(true)
+	c.cancelWork()
+
+	timer := time.NewTimer(abandonGrace)
+	defer timer.Stop()
+	select {
+	case <-c.stopped:
+	case <-timer.C:
+	}
+	return ctx.Err()
+}
+
+// process runs one message and reports whether it may be committed: the
+// task succeeded, or it failed and a copy was re-queued or dead-lettered.
+func (c *Consumer) process(ctx context.Context, msg kafkago.Message) bool {
+	var task dto.ProcessImageRequest
+	if err := json.Unmarshal(msg.Value, &task); err != nil {
+		zlog.Logger.Error().
+			Err(err).
+			Bytes("msg", msg.Value).
+			Msg("Failed to unmarshal message")
+		return false
+	}
+
+	if task.ImageID == "" || task.ProcessingType == "" {
+		zlog.Logger.Error().
+			Str("image_id", task.ImageID).
+			Str("processing_type", task.ProcessingType).
+			Msg("Invalid task: empty ImageID or ProcessingType")
+		return false
+	}
+
+	zlog.Logger.Info().
+		Str("image_id", task.ImageID).
+		Str("processing_type", task.ProcessingType).
+		Msg("Received new Kafka task")
+
+	if err := c.handler(ctx, &task); err != nil {
+		metrics.KafkaMessages.WithLabelValues("failed").Inc()
+		zlog.Logger.Error().
+			Err(err).
+			Str("image_id", task.ImageID).
+			Str("processing_type", task.ProcessingType).
+			Msg("Task processing failed")
+		if ctx.Err() != nil {
+			// cancelled by Wait: the message stays uncommitted and is
+			// redelivered as it is
+			return false
+		}
+		// the failed message is committed only once a copy is
+		// re-queued or dead-lettered, so no task is lost
+		return c.requeue(ctx, msg, &task, err)
+	}
+
+	metrics.KafkaMessages.WithLabelValues("consumed").Inc()
+	zlog.Logger.Info().
+		Str("image_id", task.ImageID).
+		Str("processing_type", task.ProcessingType).
+		Msg("Task processed successfully")
+	return true
+}
+
+// requeue publishes a failed task again with its attempt count raised, or
+// to the dead-letter topic once maxAttempts is reached, and reports whether
+// it succeeded. A message that is never committed would be fetched again
+// after every restart or rebalance and block the partition behind it.
+//
+// A re-queue first waits requeueDelay, holding the task's worker slot, so a
+// task that keeps failing at once cannot spin the consumer. If ctx ends
+// during the wait the message stays uncommitted and is redelivered.
+func (c *Consumer) requeue(ctx context.Context, msg kafkago.Message, task *dto.ProcessImageRequest, cause error) bool {
+	attempts := messageAttempts(msg) + 1
+	out := requeuedMessage(msg, attempts, cause)
+
+	producer, result := c.retries, "retried"
+	if attempts >= c.maxAttempts {
+		producer, result = c.deadLetters, "dead_lettered"
+	} else {
+		timer := time.NewTimer(requeueDelay(c.backoff, attempts))
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+		}
+	}
+
+	if err := producer.Writer.WriteMessages(ctx, out); err != nil {
+		zlog.Logger.Error().
+			Err(err).
+			Str("image_id", task.ImageID).
+			Str("topic", producer.Writer.Topic).
+			Int("attempts", attempts).
+			Msg("Failed to re-queue failed task")
+		return false
+	}
+
+	metrics.KafkaMessages.WithLabelValues(result).Inc()
+	zlog.Logger.Warn().
+		Str("image_id", task.ImageID).
+		Str("topic", producer.Writer.Topic).
+		Int("attempts", attempts).
+		Int("max_attempts", c.maxAttempts).
+		Msg("Failed task re-queued")
+	return true
+}
+
+// requeuedMessage copies msg with its attempt counter and last error
+// replaced; every other header, e.g. tracing, is kept.
+func requeuedMessage(msg kafkago.Message, attempts int, cause error) kafkago.Message {
+	headers := make([]kafkago.Header, 0, len(msg.Headers)+2)
+	for _, h := range msg.Headers {
+		if h.Key != attemptsHeader && h.Key != lastErrorHeader {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers,
+		kafkago.Header{Key: attemptsHeader, Value: []byte(strconv.Itoa(attempts))},
+		kafkago.Header{Key: lastErrorHeader, Value: []byte(cause.Error())},
+	)
+	return kafkago.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+}
+
+// requeueDelay is base doubled for every attempt after the first, capped
+// at maxRequeueBackoff.
+func requeueDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < maxRequeueBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRequeueBackoff)
+}
+
+// messageAttempts reads the attempt counter of a re-queued message; tasks
+// published by the API carry none.
+func messageAttempts(msg kafkago.Message) int {
+	for _, h := range msg.Headers {
+		if h.Key == attemptsHeader {
+			n, err := strconv.Atoi(string(h.Value))
+			if err != nil || n < 0 {
+				return 0
+			}
+			return n
 		}
 	}
+	return 0
 }
 
 func (c *Consumer) Close() error {
+	c.cancelWork()
+	if err := c.retries.Close(); err != nil {
+		zlog.Logger.Error().Err(err).Msg("Failed to close Kafka retry producer")
+	}
+	if err := c.deadLetters.Close(); err != nil {
+		zlog.Logger.Error().Err(err).Msg("Failed to close Kafka dead-letter producer")
+	}
 	if err := c.client.Close(); err != nil {
 		zlog.Logger.Error().Err(err).Msg("Failed to close Kafka consumer")
 		return err
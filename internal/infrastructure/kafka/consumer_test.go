@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+func TestRequeuedMessageKeepsHeaders(t *testing.T) {
+	msg := kafkago.Message{
+		Key:   []byte("img"),
+		Value: []byte(`{"image_id":"img"}`),
+		Headers: []kafkago.Header{
+			{Key: "traceparent", Value: []byte("00-abc-def-01")},
+			{Key: attemptsHeader, Value: []byte("1")},
+			{Key: lastErrorHeader, Value: []byte("first failure")},
+		},
+	}
+
+	out := requeuedMessage(msg, 2, errors.New("second failure"))
+
+	if string(out.Key) != "img" || string(out.Value) != string(msg.Value) {
+		t.Fatalf("key/value = %q/%q, want the original", out.Key, out.Value)
+	}
+	got := make(map[string][]string)
+	for _, h := range out.Headers {
+		got[h.Key] = append(got[h.Key], string(h.Value))
+	}
+	want := map[string]string{
+		"traceparent":   "00-abc-def-01",
+		attemptsHeader:  "2",
+		lastErrorHeader: "second failure",
+	}
+	for key, value := range want {
+		if len(got[key]) != 1 || got[key][0] != value {
+			t.Errorf("header %s = %q, want just %q", key, got[key], value)
+		}
+	}
+	if messageAttempts(out) != 2 {
+		t.Errorf("messageAttempts = %d, want 2", messageAttempts(out))
+	}
+}
+
+func TestRequeueDelayDoublesUpToCap(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 7, want: maxRequeueBackoff},
+		{attempts: 1000, want: maxRequeueBackoff},
+	}
+	for _, tt := range tests {
+		if got := requeueDelay(time.Second, tt.attempts); got != tt.want {
+			t.Errorf("requeueDelay(1s, %d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestRequeueWaitsForBackoff(t *testing.T) {
+	// nil producers: the wait must end before either is touched
+	c := &Consumer{maxAttempts: 3, backoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if c.requeue(ctx, kafkago.Message{}, &dto.ProcessImageRequest{ImageID: "img"}, errors.New("failed")) {
+		t.Fatal("requeue reported success without publishing")
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Fatalf("requeue returned after %v, before its context ended", elapsed)
+	}
+}
+
+type fixedBackpressure bool
+
+func (b fixedBackpressure) Overloaded() bool { return bool(b) }
+
+func TestThrottlePausesWhileOverloaded(t *testing.T) {
+	tests := []struct {
+		name         string
+		backpressure Backpressure
+		wantPause    bool
+	}{
+		{name: "no backpressure source"},
+		{name: "healthy storage", backpressure: fixedBackpressure(false)},
+		{name: "slow storage", backpressure: fixedBackpressure(true), wantPause: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Consumer{backpressure: tt.backpressure, pause: 30 * time.Millisecond}
+
+			start := time.Now()
+			c.throttle(context.Background())
+			if paused := time.Since(start) >= 30*time.Millisecond; paused != tt.wantPause {
+				t.Fatalf("paused = %v, want %v", paused, tt.wantPause)
+			}
+		})
+	}
+}
+
+func TestThrottleEndsWithContext(t *testing.T) {
+	c := &Consumer{backpressure: fixedBackpressure(true), pause: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.throttle(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("throttle kept pausing after shutdown")
+	}
+}
@@ -0,0 +1,29 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// CheckBrokers reports whether at least one of brokers accepts a TCP
+// connection. It does not talk the Kafka protocol; a reachable listener
+// is what readiness needs to know.
+func CheckBrokers(ctx context.Context, brokers []string) error {
+	if len(brokers) == 0 {
+		return fmt.Errorf("no brokers configured")
+	}
+
+	var dialer net.Dialer
+	var errs []error
+	for _, broker := range brokers {
+		conn, err := dialer.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
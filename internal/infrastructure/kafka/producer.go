@@ -17,6 +17,9 @@ import (
 type Producer struct {
 	client *wbfkafka.Producer
 	topic  string
+	// high takes high-priority processing tasks; nil when
+	// kafka.high_priority_topic is not set
+	high *wbfkafka.Producer
 }
 
 func NewProducer(cfg *config.KafkaConfig) *Producer {
@@ -24,11 +27,16 @@ func NewProducer(cfg *config.KafkaConfig) *Producer {
 	zlog.Logger.Info().
 		Strs("brokers", cfg.Brokers).
 		Str("topic", cfg.Topic).
+		Str("high_priority_topic", cfg.HighPriorityTopic).
 		Msg("Kafka producer initialized (wbf)")
-	return &Producer{
+	p := &Producer{
 		client: client,
 		topic:  cfg.Topic,
 	}
+	if cfg.HighPriorityTopic != "" {
+		p.high = wbfkafka.NewProducer(cfg.Brokers, cfg.HighPriorityTopic)
+	}
+	return p
 }
 
 func (p *Producer) Send(ctx context.Context, task dto.ProcessImageRequest) error {
@@ -57,6 +65,18 @@ func (p *Producer) Send(ctx context.Context, task dto.ProcessImageRequest) error
 }
 
 func (p *Producer) SendWithRetry(ctx context.Context, task dto.ProcessImageRequest) error {
+	return sendWithRetry(ctx, p.client, task)
+}
+
+// clientFor returns the producer of the topic tasks of priority go to.
+func (p *Producer) clientFor(priority domain.Priority) *wbfkafka.Producer {
+	if priority == domain.PriorityHigh && p.high != nil {
+		return p.high
+	}
+	return p.client
+}
+
+func sendWithRetry(ctx context.Context, client *wbfkafka.Producer, task dto.ProcessImageRequest) error {
 	data, err := json.Marshal(task)
 	if err != nil {
 		zlog.Logger.Error().
@@ -71,22 +91,29 @@ func (p *Producer) SendWithRetry(ctx context.Context, task dto.ProcessImageReque
 		Delay:    2 * time.Second,
 		Backoff:  2.0,
 	}
-	if err := p.client.SendWithRetry(ctx, strategy, nil, data); err != nil {
+	if err := client.SendWithRetry(ctx, strategy, nil, data); err != nil {
 		zlog.Logger.Error().
 			Err(err).
 			Str("image_id", task.ImageID).
 			Str("processing_type", task.ProcessingType).
+			Str("topic", client.Writer.Topic).
 			Msg("Failed to send Kafka message with retry")
 		return err
 	}
 	zlog.Logger.Info().
 		Str("image_id", task.ImageID).
 		Str("processing_type", task.ProcessingType).
+		Str("topic", client.Writer.Topic).
 		Msg("Message sent to Kafka with retry")
 	return nil
 }
 
 func (p *Producer) Close() error {
+	if p.high != nil {
+		if err := p.high.Close(); err != nil {
+			zlog.Logger.Error().Err(err).Msg("Failed to close high-priority Kafka producer")
+		}
+	}
 	if err := p.client.Close(); err != nil {
 		zlog.Logger.Error().Err(err).Msg("Failed to close Kafka producer")
 		return err
@@ -95,10 +122,32 @@ func (p *Producer) Close() error {
 	return nil
 }
 
-func (p *Producer) PublishProcessingTask(ctx context.Context, imageID string, processingType domain.ProcessingType) error {
+func (p *Producer) PublishProcessingTask(ctx context.Context, imageID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) error {
 	task := dto.ProcessImageRequest{
 		ImageID:        imageID,
 		ProcessingType: string(processingType),
+		Flatten:        opts.Flatten,
+		Angle:          opts.Angle,
+		Flip:           opts.Flip,
+		Pipeline:       opts.Pipeline,
+		Width:          opts.Width,
+		Height:         opts.Height,
+		Page:           opts.Page,
+		Radius:         opts.Radius,
+		Quality:        opts.Quality,
+		WatermarkText:  opts.WatermarkText,
+	}
+	return sendWithRetry(ctx, p.clientFor(opts.Priority), task)
+}
+
+// PublishVariantTask queues the rendering of a processed variant. The worker
+// reads the type and options from the variant record; they are sent along
+// for logging only.
+func (p *Producer) PublishVariantTask(ctx context.Context, variant *domain.ProcessedVariant) error {
+	task := dto.ProcessImageRequest{
+		ImageID:        variant.ImageID,
+		VariantID:      variant.ID,
+		ProcessingType: string(variant.ProcessingType),
 	}
 	return p.SendWithRetry(ctx, task)
 }
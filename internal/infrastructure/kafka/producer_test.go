@@ -0,0 +1,33 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestProducerRoutesPriorityToTopic(t *testing.T) {
+	tests := []struct {
+		name      string
+		highTopic string
+		priority  domain.Priority
+		wantTopic string
+	}{
+		{name: "normal", highTopic: "images-high", priority: domain.PriorityNormal, wantTopic: "images"},
+		{name: "high", highTopic: "images-high", priority: domain.PriorityHigh, wantTopic: "images-high"},
+		{name: "high without a high topic", priority: domain.PriorityHigh, wantTopic: "images"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// kafka-go writers connect on the first write, so no broker is needed
+			p := NewProducer(&config.KafkaConfig{Brokers: []string{"localhost:9"}, Topic: "images", HighPriorityTopic: tt.highTopic})
+			defer p.Close()
+
+			if got := p.clientFor(tt.priority).Writer.Topic; got != tt.wantTopic {
+				t.Errorf("topic = %q, want %q", got, tt.wantTopic)
+			}
+		})
+	}
+}
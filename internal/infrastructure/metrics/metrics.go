@@ -0,0 +1,50 @@
+// Package metrics keeps the process-wide Prometheus metrics and serves them,
+// together with the Go runtime and process collectors, for scraping.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// registry holds only what this package registers, so tests and other
+// libraries using the default registry do not leak into /metrics.
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	Uploads = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "imageprocessor_uploads_total",
+		Help: "Images accepted for processing.",
+	}, []string{"processing_type"})
+	ProcessingResults = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "imageprocessor_processing_total",
+		Help: "Processing attempts by result (success or failure).",
+	}, []string{"result"})
+	ProcessingDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "imageprocessor_processing_duration_seconds",
+		Help:    "Duration of processing attempts, from claiming the image to storing the result.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	})
+	KafkaMessages = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "imageprocessor_kafka_messages_total",
+		Help: "Kafka messages fetched by the worker, by result (consumed, failed, then retried or dead_lettered).",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves every metric of this process.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
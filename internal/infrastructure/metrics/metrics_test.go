@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerExposesMetrics(t *testing.T) {
+	Uploads.WithLabelValues("resize").Inc()
+	ProcessingResults.WithLabelValues("failure").Inc()
+	ProcessingDuration.Observe(0.3)
+	KafkaMessages.WithLabelValues("consumed").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, want := range []string{
+		`imageprocessor_uploads_total{processing_type="resize"} 1`,
+		`imageprocessor_processing_total{result="failure"} 1`,
+		`imageprocessor_processing_duration_seconds_bucket{le="0.25"} 0`,
+		`imageprocessor_processing_duration_seconds_bucket{le="0.5"} 1`,
+		`imageprocessor_processing_duration_seconds_count 1`,
+		`imageprocessor_kafka_messages_total{result="consumed"} 1`,
+		`go_goroutines `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// decodeBase83 is the inverse of encodeBase83.
+func decodeBase83(t *testing.T, s string) int {
+	t.Helper()
+	value := 0
+	for _, c := range s {
+		digit := strings.IndexRune(base83Chars, c)
+		if digit < 0 {
+			t.Fatalf("%q is not base83", c)
+		}
+		value = value*83 + digit
+	}
+	return value
+}
+
+func TestBlurhashIsValid(t *testing.T) {
+	for name, img := range map[string]image.Image{
+		"landscape": gradient(640, 480),
+		"portrait":  gradient(90, 300),
+		"tiny":      gradient(1, 1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			hash, err := Blurhash(img)
+			if err != nil {
+				t.Fatalf("Blurhash: %v", err)
+			}
+
+			// size flag, maximum AC, four DC digits and two per AC component
+			wantLen := 1 + 1 + 4 + 2*(blurhashComponentsX*blurhashComponentsY-1)
+			if len(hash) != wantLen {
+				t.Fatalf("hash %q has %d characters, want %d", hash, len(hash), wantLen)
+			}
+			sizeFlag := decodeBase83(t, hash[:1])
+			if x, y := sizeFlag%9+1, sizeFlag/9+1; x != blurhashComponentsX || y != blurhashComponentsY {
+				t.Errorf("size flag says %dx%d components, want %dx%d", x, y, blurhashComponentsX, blurhashComponentsY)
+			}
+			decodeBase83(t, hash[1:])
+		})
+	}
+}
+
+func TestBlurhashOfSolidColor(t *testing.T) {
+	hash, err := Blurhash(imaging.New(40, 30, color.NRGBA{R: 200, G: 60, B: 20, A: 255}))
+	if err != nil {
+		t.Fatalf("Blurhash: %v", err)
+	}
+
+	dc := decodeBase83(t, hash[2:6])
+	if r, g, b := dc>>16, dc>>8&0xff, dc&0xff; r != 200 || g != 60 || b != 20 {
+		t.Errorf("average color is %d,%d,%d, want 200,60,20", r, g, b)
+	}
+}
+
+func TestBlurhashFollowsTheImage(t *testing.T) {
+	first, err := Blurhash(gradient(64, 48))
+	if err != nil {
+		t.Fatalf("Blurhash: %v", err)
+	}
+	again, _ := Blurhash(gradient(64, 48))
+	if first != again {
+		t.Errorf("the same image hashed to %q and %q", first, again)
+	}
+	flipped, _ := Blurhash(imaging.FlipH(gradient(64, 48)))
+	if flipped == first {
+		t.Errorf("a mirrored image hashed to the same %q", first)
+	}
+}
+
+func TestBlurhashRejectsEmptyImage(t *testing.T) {
+	if _, err := Blurhash(image.NewNRGBA(image.Rect(0, 0, 0, 0))); err == nil {
+		t.Fatal("Blurhash of an empty image succeeded")
+	}
+}
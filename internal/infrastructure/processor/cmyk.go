@@ -0,0 +1,675 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// decodeCMYK decodes a CMYK JPEG that embeds a CMYK ICC profile, converting
+// it to RGB through the profile's AtoB table. Any other input, or a profile
+// that cannot be used, decodes with DecodeLimit, which converts CMYK with
+// the plain formula that ignores how the inks were meant to look.
+func decodeCMYK(data []byte, maxPixels int64) (image.Image, error) {
+	if !bytes.HasPrefix(data, jpegMagic) {
+		return DecodeLimit(bytes.NewReader(data), 1, maxPixels)
+	}
+	profile := jpegICCProfile(data)
+	if len(profile) < 128 || string(profile[16:20]) != "CMYK" {
+		return DecodeLimit(bytes.NewReader(data), 1, maxPixels)
+	}
+	transform, err := newCMYKTransform(profile)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Msg("unusable CMYK color profile, converting without it")
+		return DecodeLimit(bytes.NewReader(data), 1, maxPixels)
+	}
+
+	if maxPixels > 0 {
+		if err := checkPixels(bytes.NewReader(data), maxPixels); err != nil {
+			return nil, err
+		}
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// the profile describes the inks; it is only applied to ink values
+	if cmyk, ok := img.(*image.CMYK); ok {
+		img = transform.convert(cmyk)
+	}
+	return orient(img, ExifOrientation(data)), nil
+}
+
+// orient applies an EXIF orientation the same way imaging.AutoOrientation
+// does.
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// iccCurve maps a normalized channel value in [0, 1].
+type iccCurve func(float64) float64
+
+// iccCLUT is a multi-dimensional lookup table; the first input varies
+// slowest, as the ICC specification lays it out.
+type iccCLUT struct {
+	grid    []int
+	strides []int
+	outputs int
+	data    []float64
+}
+
+// cmykTransform evaluates the AtoB pipeline of a CMYK profile: input
+// curves, CLUT, output curves and, for lutAtoBType, an optional matrix
+// stage, then decodes the PCS value to XYZ.
+type cmykTransform struct {
+	aCurves []iccCurve
+	clut    *iccCLUT
+	mCurves []iccCurve
+	matrix  *[12]float64
+	bCurves []iccCurve
+	pcsLab  bool
+	// legacyLab is the 16-bit Lab encoding of lut16Type tables
+	legacyLab bool
+}
+
+var errUnsupportedProfile = errors.New("unsupported color profile")
+
+// newCMYKTransform reads the perceptual AtoB table of a CMYK profile,
+// falling back to the relative colorimetric one.
+func newCMYKTransform(profile []byte) (*cmykTransform, error) {
+	pcs := string(profile[20:24])
+	if pcs != "Lab " && pcs != "XYZ " {
+		return nil, fmt.Errorf("%w: PCS %q", errUnsupportedProfile, pcs)
+	}
+
+	tag := iccTag(profile, "A2B0")
+	if tag == nil {
+		tag = iccTag(profile, "A2B1")
+	}
+	if tag == nil {
+		return nil, fmt.Errorf("%w: no AtoB table", errUnsupportedProfile)
+	}
+
+	var t *cmykTransform
+	var err error
+	switch string(tag[:4]) {
+	case "mft1":
+		t, err = parseLut(tag, false)
+	case "mft2":
+		t, err = parseLut(tag, true)
+	case "mAB ":
+		t, err = parseLutAtoB(tag)
+	default:
+		return nil, fmt.Errorf("%w: AtoB type %q", errUnsupportedProfile, tag[:4])
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.pcsLab = pcs == "Lab "
+	return t, nil
+}
+
+// iccTag returns the data of the tag with the given signature.
+func iccTag(profile []byte, sig string) []byte {
+	if len(profile) < 132 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return nil
+		}
+		if string(profile[entry:entry+4]) != sig {
+			continue
+		}
+		offset := int64(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int64(binary.BigEndian.Uint32(profile[entry+8:]))
+		if size < 12 || offset+size > int64(len(profile)) {
+			return nil
+		}
+		return profile[offset : offset+size]
+	}
+	return nil
+}
+
+// parseLut reads lut8Type (mft1) and lut16Type (mft2) tables. Their matrix
+// only applies to XYZ input and is ignored.
+func parseLut(tag []byte, wide bool) (*cmykTransform, error) {
+	if len(tag) < 52 {
+		return nil, fmt.Errorf("%w: truncated lut", errUnsupportedProfile)
+	}
+	inputs, outputs, points := int(tag[8]), int(tag[9]), int(tag[10])
+	if inputs != 4 || outputs != 3 || points < 2 {
+		return nil, fmt.Errorf("%w: lut of %d inputs, %d outputs", errUnsupportedProfile, inputs, outputs)
+	}
+
+	sample, size, off := 1, 255.0, 48
+	inEntries, outEntries := 256, 256
+	if wide {
+		sample, size = 2, 65535
+		inEntries = int(binary.BigEndian.Uint16(tag[48:]))
+		outEntries = int(binary.BigEndian.Uint16(tag[50:]))
+		off = 52
+	}
+	if inEntries < 2 || outEntries < 2 {
+		return nil, fmt.Errorf("%w: lut tables too short", errUnsupportedProfile)
+	}
+	read := func(n int) ([]float64, error) {
+		if off+n*sample > len(tag) {
+			return nil, fmt.Errorf("%w: truncated lut", errUnsupportedProfile)
+		}
+		values := make([]float64, n)
+		for i := range values {
+			if wide {
+				values[i] = float64(binary.BigEndian.Uint16(tag[off+i*2:])) / size
+			} else {
+				values[i] = float64(tag[off+i]) / size
+			}
+		}
+		off += n * sample
+		return values, nil
+	}
+
+	t := &cmykTransform{legacyLab: wide}
+	for i := 0; i < inputs; i++ {
+		table, err := read(inEntries)
+		if err != nil {
+			return nil, err
+		}
+		t.aCurves = append(t.aCurves, tableCurve(table))
+	}
+
+	grid := []int{points, points, points, points}
+	clut, err := read(gridSize(grid) * outputs)
+	if err != nil {
+		return nil, err
+	}
+	t.clut = newCLUT(grid, outputs, clut)
+
+	for i := 0; i < outputs; i++ {
+		table, err := read(outEntries)
+		if err != nil {
+			return nil, err
+		}
+		t.bCurves = append(t.bCurves, tableCurve(table))
+	}
+	return t, nil
+}
+
+// parseLutAtoB reads lutAtoBType (mAB) tables: A curves, CLUT, M curves,
+// matrix and B curves, where only the B curves are mandatory.
+func parseLutAtoB(tag []byte) (*cmykTransform, error) {
+	if len(tag) < 32 {
+		return nil, fmt.Errorf("%w: truncated lutAtoB", errUnsupportedProfile)
+	}
+	inputs, outputs := int(tag[8]), int(tag[9])
+	if inputs != 4 || outputs != 3 {
+		return nil, fmt.Errorf("%w: lutAtoB of %d inputs, %d outputs", errUnsupportedProfile, inputs, outputs)
+	}
+	offset := func(at int) int { return int(binary.BigEndian.Uint32(tag[at:])) }
+	bOff, matrixOff, mOff, clutOff, aOff := offset(12), offset(16), offset(20), offset(24), offset(28)
+
+	t := &cmykTransform{}
+	var err error
+	if bOff == 0 {
+		return nil, fmt.Errorf("%w: lutAtoB without B curves", errUnsupportedProfile)
+	}
+	if t.bCurves, err = parseCurves(tag, bOff, outputs); err != nil {
+		return nil, err
+	}
+	if aOff != 0 {
+		if t.aCurves, err = parseCurves(tag, aOff, inputs); err != nil {
+			return nil, err
+		}
+	}
+	if mOff != 0 {
+		if t.mCurves, err = parseCurves(tag, mOff, outputs); err != nil {
+			return nil, err
+		}
+	}
+	if matrixOff != 0 {
+		if matrixOff+48 > len(tag) {
+			return nil, fmt.Errorf("%w: truncated matrix", errUnsupportedProfile)
+		}
+		var m [12]float64
+		for i := range m {
+			m[i] = s15Fixed16(tag[matrixOff+i*4:])
+		}
+		t.matrix = &m
+	}
+	// four inks cannot reach the three PCS channels without a table
+	if clutOff == 0 || clutOff+20 > len(tag) {
+		return nil, fmt.Errorf("%w: lutAtoB without CLUT", errUnsupportedProfile)
+	}
+
+	grid := make([]int, inputs)
+	for i := range grid {
+		grid[i] = int(tag[clutOff+i])
+		if grid[i] < 2 {
+			return nil, fmt.Errorf("%w: CLUT grid of %d points", errUnsupportedProfile, grid[i])
+		}
+	}
+	precision := int(tag[clutOff+16])
+	if precision != 1 && precision != 2 {
+		return nil, fmt.Errorf("%w: CLUT precision %d", errUnsupportedProfile, precision)
+	}
+	n := gridSize(grid) * outputs
+	start := clutOff + 20
+	if start+n*precision > len(tag) {
+		return nil, fmt.Errorf("%w: truncated CLUT", errUnsupportedProfile)
+	}
+	values := make([]float64, n)
+	for i := range values {
+		if precision == 2 {
+			values[i] = float64(binary.BigEndian.Uint16(tag[start+i*2:])) / 65535
+		} else {
+			values[i] = float64(tag[start+i]) / 255
+		}
+	}
+	t.clut = newCLUT(grid, outputs, values)
+	return t, nil
+}
+
+// parseCurves reads n consecutive curv or para curves, each padded to a
+// multiple of four bytes.
+func parseCurves(tag []byte, off, n int) ([]iccCurve, error) {
+	curves := make([]iccCurve, 0, n)
+	for i := 0; i < n; i++ {
+		curve, size, err := parseCurve(tag, off)
+		if err != nil {
+			return nil, err
+		}
+		curves = append(curves, curve)
+		off += (size + 3) &^ 3
+	}
+	return curves, nil
+}
+
+// paraParams is the parameter count of each parametricCurveType function.
+var paraParams = [...]int{1, 3, 4, 5, 7}
+
+func parseCurve(tag []byte, off int) (iccCurve, int, error) {
+	if off < 0 || off+12 > len(tag) {
+		return nil, 0, fmt.Errorf("%w: truncated curve", errUnsupportedProfile)
+	}
+	switch string(tag[off : off+4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[off+8:]))
+		size := 12 + count*2
+		if off+size > len(tag) {
+			return nil, 0, fmt.Errorf("%w: truncated curve", errUnsupportedProfile)
+		}
+		switch count {
+		case 0:
+			return func(x float64) float64 { return x }, size, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[off+12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, size, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[off+12+i*2:])) / 65535
+		}
+		return tableCurve(table), size, nil
+
+	case "para":
+		kind := int(binary.BigEndian.Uint16(tag[off+8:]))
+		if kind >= len(paraParams) {
+			return nil, 0, fmt.Errorf("%w: parametric curve type %d", errUnsupportedProfile, kind)
+		}
+		size := 12 + paraParams[kind]*4
+		if off+size > len(tag) {
+			return nil, 0, fmt.Errorf("%w: truncated curve", errUnsupportedProfile)
+		}
+		var p [7]float64
+		for i := 0; i < paraParams[kind]; i++ {
+			p[i] = s15Fixed16(tag[off+12+i*4:])
+		}
+		return paraCurve(kind, p), size, nil
+	}
+	return nil, 0, fmt.Errorf("%w: curve type %q", errUnsupportedProfile, tag[off:off+4])
+}
+
+// paraCurve builds parametric function kind with parameters g, a, b, c,
+// d, e, f as in the ICC specification.
+func paraCurve(kind int, p [7]float64) iccCurve {
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(x float64) float64 {
+		if x <= 0 {
+			return 0
+		}
+		return math.Pow(x, g)
+	}
+	switch kind {
+	case 1:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x + b)
+			}
+			return 0
+		}
+	case 2:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x+b) + c
+			}
+			return c
+		}
+	case 3:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x + b)
+			}
+			return c * x
+		}
+	case 4:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x+b) + e
+			}
+			return c*x + f
+		}
+	}
+	return pow
+}
+
+// tableCurve interpolates linearly between evenly spaced samples.
+func tableCurve(table []float64) iccCurve {
+	last := float64(len(table) - 1)
+	return func(x float64) float64 {
+		pos := clamp01(x) * last
+		i := int(pos)
+		if i >= len(table)-1 {
+			return table[len(table)-1]
+		}
+		frac := pos - float64(i)
+		return table[i] + (table[i+1]-table[i])*frac
+	}
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func gridSize(grid []int) int {
+	n := 1
+	for _, points := range grid {
+		n *= points
+	}
+	return n
+}
+
+func newCLUT(grid []int, outputs int, data []float64) *iccCLUT {
+	strides := make([]int, len(grid))
+	stride := outputs
+	for i := len(grid) - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= grid[i]
+	}
+	return &iccCLUT{grid: grid, strides: strides, outputs: outputs, data: data}
+}
+
+// eval interpolates the table multilinearly at in, writing to out.
+func (c *iccCLUT) eval(in []float64, out []float64) {
+	var base [4]int
+	var frac [4]float64
+	for i, x := range in {
+		pos := clamp01(x) * float64(c.grid[i]-1)
+		cell := min(int(pos), c.grid[i]-2)
+		base[i], frac[i] = cell, pos-float64(cell)
+	}
+	for o := range out {
+		out[o] = 0
+	}
+
+	for corner := 0; corner < 1<<len(in); corner++ {
+		weight := 1.0
+		index := 0
+		for i := range in {
+			if corner&(1<<i) != 0 {
+				weight *= frac[i]
+				index += (base[i] + 1) * c.strides[i]
+			} else {
+				weight *= 1 - frac[i]
+				index += base[i] * c.strides[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += weight * c.data[index+o]
+		}
+	}
+}
+
+// deviceLinkPoints is the grid size per ink of the table convert
+// interpolates in; evaluating the profile for every pixel would be far
+// slower, and 17 is what color management engines use for CMYK.
+const deviceLinkPoints = 17
+
+// convert renders img in sRGB. The profile is sampled once into a CMYK to
+// sRGB table, which is then interpolated per pixel.
+func (t *cmykTransform) convert(img *image.CMYK) *image.NRGBA {
+	grid := []int{deviceLinkPoints, deviceLinkPoints, deviceLinkPoints, deviceLinkPoints}
+	values := make([]float64, 0, gridSize(grid)*3)
+	const step = 1.0 / (deviceLinkPoints - 1)
+	for c := 0; c < deviceLinkPoints; c++ {
+		for m := 0; m < deviceLinkPoints; m++ {
+			for y := 0; y < deviceLinkPoints; y++ {
+				for k := 0; k < deviceLinkPoints; k++ {
+					r, g, b := t.srgb([4]float64{float64(c) * step, float64(m) * step, float64(y) * step, float64(k) * step})
+					values = append(values, r, g, b)
+				}
+			}
+		}
+	}
+	link := newCLUT(grid, 3, values)
+
+	// every ink byte falls into the same cell and fraction of the grid
+	var cell [256]int
+	var frac [256]float64
+	for v := range cell {
+		pos := float64(v) / 255 * (deviceLinkPoints - 1)
+		cell[v] = min(int(pos), deviceLinkPoints-2)
+		frac[v] = pos - float64(cell[v])
+	}
+	s := link.strides
+
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		src := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		dst := out.Pix[out.PixOffset(0, y):]
+		for x := 0; x < b.Dx(); x++ {
+			c, m, ye, k := src[x*4], src[x*4+1], src[x*4+2], src[x*4+3]
+			base := cell[c]*s[0] + cell[m]*s[1] + cell[ye]*s[2] + cell[k]*s[3]
+			wc := [2]float64{1 - frac[c], frac[c]}
+			wm := [2]float64{1 - frac[m], frac[m]}
+			wy := [2]float64{1 - frac[ye], frac[ye]}
+			wk := [2]float64{1 - frac[k], frac[k]}
+
+			// quadrilinear interpolation over the 16 corners of the cell
+			var rgb [3]float64
+			for i := 0; i < 2; i++ {
+				for j := 0; j < 2; j++ {
+					wij := wc[i] * wm[j]
+					for l := 0; l < 2; l++ {
+						wijl := wij * wy[l]
+						at := base + i*s[0] + j*s[1] + l*s[2]
+						for n := 0; n < 2; n++ {
+							w := wijl * wk[n]
+							corner := link.data[at+n*s[3] : at+n*s[3]+3]
+							rgb[0] += w * corner[0]
+							rgb[1] += w * corner[1]
+							rgb[2] += w * corner[2]
+						}
+					}
+				}
+			}
+			for i, v := range rgb {
+				dst[x*4+i] = uint8(clamp01(v)*255 + 0.5)
+			}
+			dst[x*4+3] = 0xFF
+		}
+	}
+	return out
+}
+
+// srgb converts normalized ink values, 0 meaning no ink as in image.CMYK,
+// to gamma-encoded sRGB.
+func (t *cmykTransform) srgb(in [4]float64) (float64, float64, float64) {
+	if t.aCurves != nil {
+		for i, curve := range t.aCurves {
+			in[i] = curve(in[i])
+		}
+	}
+	var pcs [3]float64
+	t.clut.eval(in[:], pcs[:])
+	if t.mCurves != nil {
+		for i, curve := range t.mCurves {
+			pcs[i] = curve(pcs[i])
+		}
+	}
+	if m := t.matrix; m != nil {
+		pcs = [3]float64{
+			m[0]*pcs[0] + m[1]*pcs[1] + m[2]*pcs[2] + m[9],
+			m[3]*pcs[0] + m[4]*pcs[1] + m[5]*pcs[2] + m[10],
+			m[6]*pcs[0] + m[7]*pcs[1] + m[8]*pcs[2] + m[11],
+		}
+	}
+	for i, curve := range t.bCurves {
+		pcs[i] = curve(pcs[i])
+	}
+
+	X, Y, Z := t.xyz(pcs)
+	// Bradford-adapted D50 XYZ to linear sRGB
+	r := 3.1338561*X - 1.6168667*Y - 0.4906146*Z
+	g := -0.9787684*X + 1.9161415*Y + 0.0334540*Z
+	b := 0.0719453*X - 0.2289914*Y + 1.4052427*Z
+	return srgbEncode(r), srgbEncode(g), srgbEncode(b)
+}
+
+// xyz decodes a normalized PCS value to D50 XYZ.
+func (t *cmykTransform) xyz(pcs [3]float64) (float64, float64, float64) {
+	if !t.pcsLab {
+		// u1Fixed15: 0x8000 is 1.0
+		const scale = 65535.0 / 32768
+		return pcs[0] * scale, pcs[1] * scale, pcs[2] * scale
+	}
+
+	var L, a, b float64
+	if t.legacyLab {
+		// 0xFF00 is L 100, 0x8000 is a and b 0
+		L = pcs[0] * 65535 / 65280 * 100
+		a = pcs[1]*65535/256 - 128
+		b = pcs[2]*65535/256 - 128
+	} else {
+		L = pcs[0] * 100
+		a = pcs[1]*255 - 128
+		b = pcs[2]*255 - 128
+	}
+
+	fy := (L + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	finv := func(f float64) float64 {
+		if f > 6.0/29 {
+			return f * f * f
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (f - 4.0/29)
+	}
+	return 0.9642 * finv(fx), finv(fy), 0.8249 * finv(fz)
+}
+
+func srgbEncode(linear float64) float64 {
+	v := clamp01(linear)
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func clamp01(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
+
+// ExifOrientation reads the orientation tag of a JPEG's EXIF block; 1,
+// the upright default, when there is none. data only has to reach past
+// the EXIF block, so the bytes image.DecodeConfig consumed are enough.
+func ExifOrientation(data []byte) int {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd+2 > int64(len(tiff)) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + int64(n)*12
+		if entry+12 > int64(len(tiff)) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
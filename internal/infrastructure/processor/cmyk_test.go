@@ -0,0 +1,170 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// dotGain is the ink model the test profile describes: a printed ink
+// covers more than its nominal value, so the same ink values look darker
+// than the plain CMYK formula makes them.
+func dotGain(ink float64) float64 {
+	return math.Pow(ink, 0.7)
+}
+
+// modelRGB is the gamma-encoded sRGB the test profile assigns to ink
+// values in [0, 1].
+func modelRGB(c, m, y, k float64) [3]float64 {
+	k = dotGain(k)
+	return [3]float64{(1 - dotGain(c)) * (1 - k), (1 - dotGain(m)) * (1 - k), (1 - dotGain(y)) * (1 - k)}
+}
+
+// srgbToLab converts gamma-encoded sRGB to D50 Lab, the inverse of what
+// cmykTransform.srgb does with a Lab PCS.
+func srgbToLab(rgb [3]float64) (float64, float64, float64) {
+	var lin [3]float64
+	for i, v := range rgb {
+		if v <= 0.04045 {
+			lin[i] = v / 12.92
+		} else {
+			lin[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	// linear sRGB to Bradford-adapted D50 XYZ
+	X := 0.4360747*lin[0] + 0.3850649*lin[1] + 0.1430804*lin[2]
+	Y := 0.2225045*lin[0] + 0.7168786*lin[1] + 0.0606169*lin[2]
+	Z := 0.0139322*lin[0] + 0.0971045*lin[1] + 0.7141733*lin[2]
+
+	f := func(t float64) float64 {
+		if t > math.Pow(6.0/29, 3) {
+			return math.Cbrt(t)
+		}
+		return t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+	}
+	fx, fy, fz := f(X/0.9642), f(Y), f(Z/0.8249)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// buildCMYKProfile returns a CMYK output profile whose A2B0 lut16Type table
+// samples modelRGB on a grid of the given size, in the legacy 16-bit Lab
+// encoding.
+func buildCMYKProfile(points int) []byte {
+	var lut bytes.Buffer
+	lut.WriteString("mft2")
+	lut.Write(make([]byte, 4))
+	lut.Write([]byte{4, 3, byte(points), 0})
+	// identity matrix, ignored for CMYK input
+	for i := 0; i < 9; i++ {
+		v := int32(0)
+		if i%4 == 0 {
+			v = 1 << 16
+		}
+		binary.Write(&lut, binary.BigEndian, v)
+	}
+	binary.Write(&lut, binary.BigEndian, [2]uint16{2, 2})
+	for i := 0; i < 4; i++ {
+		binary.Write(&lut, binary.BigEndian, [2]uint16{0, 0xFFFF})
+	}
+
+	step := 1 / float64(points-1)
+	for c := 0; c < points; c++ {
+		for m := 0; m < points; m++ {
+			for y := 0; y < points; y++ {
+				for k := 0; k < points; k++ {
+					L, a, b := srgbToLab(modelRGB(float64(c)*step, float64(m)*step, float64(y)*step, float64(k)*step))
+					binary.Write(&lut, binary.BigEndian, [3]uint16{
+						uint16(math.Round(L / 100 * 0xFF00)),
+						uint16(math.Round((a + 128) * 256)),
+						uint16(math.Round((b + 128) * 256)),
+					})
+				}
+			}
+		}
+	}
+	for i := 0; i < 3; i++ {
+		binary.Write(&lut, binary.BigEndian, [2]uint16{0, 0xFFFF})
+	}
+
+	const tagTable = 128 + 4 + 12
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header[0:], uint32(tagTable+lut.Len()))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "prtr")
+	copy(header[16:], "CMYK")
+	copy(header[20:], "Lab ")
+	copy(header[36:], "acsp")
+
+	profile := append(header, 0, 0, 0, 1)
+	profile = append(profile, "A2B0"...)
+	profile = binary.BigEndian.AppendUint32(profile, tagTable)
+	profile = binary.BigEndian.AppendUint32(profile, uint32(lut.Len()))
+	return append(profile, lut.Bytes()...)
+}
+
+func TestDecodeCMYKAppliesICCProfile(t *testing.T) {
+	data, err := os.ReadFile("testdata/video-001.cmyk.jpeg")
+	if err != nil {
+		t.Fatalf("read testdata: %v", err)
+	}
+	raw, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode testdata: %v", err)
+	}
+	inks, ok := raw.(*image.CMYK)
+	if !ok {
+		t.Fatalf("testdata decodes as %T, want *image.CMYK", raw)
+	}
+
+	tagged := embedICCProfile(data, buildCMYKProfile(17), imaging.JPEG)
+	img, err := decodeCMYK(tagged, 0)
+	if err != nil {
+		t.Fatalf("decodeCMYK: %v", err)
+	}
+	if img.Bounds().Size() != inks.Bounds().Size() {
+		t.Fatalf("decoded %v, want %v", img.Bounds().Size(), inks.Bounds().Size())
+	}
+	naive, err := decodeCMYK(data, 0)
+	if err != nil {
+		t.Fatalf("decodeCMYK without profile: %v", err)
+	}
+
+	// errors in 8-bit levels against the model, and how far the plain
+	// formula is from it
+	var sum, naiveSum, worst float64
+	var n int
+	converted, plainConverted := imaging.Clone(img), imaging.Clone(naive)
+	b := inks.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			ink := inks.CMYKAt(x, y)
+			want := modelRGB(float64(ink.C)/255, float64(ink.M)/255, float64(ink.Y)/255, float64(ink.K)/255)
+			got := converted.NRGBAAt(x-b.Min.X, y-b.Min.Y)
+			plain := plainConverted.NRGBAAt(x-b.Min.X, y-b.Min.Y)
+			for i, v := range []uint8{got.R, got.G, got.B} {
+				diff := math.Abs(float64(v) - want[i]*255)
+				sum += diff
+				worst = max(worst, diff)
+				naiveSum += math.Abs(float64([]uint8{plain.R, plain.G, plain.B}[i]) - want[i]*255)
+				n++
+			}
+		}
+	}
+	mean, naiveMean := sum/float64(n), naiveSum/float64(n)
+	t.Logf("mean error %.2f, worst %.0f levels; plain formula mean error %.2f", mean, worst, naiveMean)
+
+	// the profile grid and the 17-point device link both interpolate the
+	// steep start of the dot gain curve, which costs a few levels at worst
+	if mean > 1.5 || worst > 12 {
+		t.Fatalf("profile conversion off by %.2f levels on average, %.0f at worst", mean, worst)
+	}
+	if naiveMean < 5*mean {
+		t.Fatalf("plain formula error %.2f is not clearly worse than %.2f; the profile made no difference", naiveMean, mean)
+	}
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// cancelAfter reports cancellation from its n+1th Err call on.
+type cancelAfter struct {
+	context.Context
+	n, calls int
+}
+
+func (c *cancelAfter) Err() error {
+	c.calls++
+	if c.calls > c.n {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDenoiseRemovesOutlier(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 5, 5))
+	img.SetGray(2, 2, color.Gray{Y: 255})
+
+	out, err := denoise(context.Background(), img, 1)
+	if err != nil {
+		t.Fatalf("denoise: %v", err)
+	}
+	if r, _, _, _ := out.At(2, 2).RGBA(); r != 0 {
+		t.Fatalf("outlier survived with value %d", r>>8)
+	}
+}
+
+func TestDenoiseStopsWhenCancelled(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 50, 40))
+	ctx := &cancelAfter{Context: context.Background(), n: 3}
+
+	out, err := denoise(ctx, img, 2)
+	if !errors.Is(err, context.Canceled) || out != nil {
+		t.Fatalf("denoise = %v, %v, want context.Canceled", out, err)
+	}
+	// checked once per row: three rows were filtered, the fourth was not
+	if ctx.calls != 4 {
+		t.Fatalf("ctx checked %d times, want 4", ctx.calls)
+	}
+}
@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// DiffImage renders a heatmap of the per-pixel difference between original
+// and processed. The original is resized to the processed dimensions first,
+// so a resize alone shows up as resampling noise rather than a full-frame
+// mismatch. Identical pixels are black; growing differences go through red
+// and yellow to white.
+func DiffImage(original, processed image.Image) (*image.NRGBA, error) {
+	bounds := processed.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("processed image is empty")
+	}
+
+	a := imaging.Resize(original, width, height, imaging.Lanczos)
+	b := imaging.Clone(processed)
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*a.Stride + x*4
+			j := y*b.Stride + x*4
+			d := max(
+				absDiff(a.Pix[i], b.Pix[j]),
+				absDiff(a.Pix[i+1], b.Pix[j+1]),
+				absDiff(a.Pix[i+2], b.Pix[j+2]),
+				absDiff(a.Pix[i+3], b.Pix[j+3]),
+			)
+			out.SetNRGBA(x, y, heat(d))
+		}
+	}
+	return out, nil
+}
+
+// heat maps a 0-255 difference onto black-red-yellow-white.
+func heat(d int) color.NRGBA {
+	v := d * 3
+	return color.NRGBA{
+		R: uint8(clampInt(v, 0, 255)),
+		G: uint8(clampInt(v-255, 0, 255)),
+		B: uint8(clampInt(v-510, 0, 255)),
+		A: 255,
+	}
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
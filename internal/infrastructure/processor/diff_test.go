@@ -0,0 +1,61 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func solid(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestDiffImageHighlightsChangedRegion(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	original := solid(20, 10, red)
+	processed := solid(20, 10, red)
+	// the processing turned the right half blue
+	draw.Draw(processed, image.Rect(10, 0, 20, 10), image.NewUniform(color.NRGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+
+	diff, err := DiffImage(original, processed)
+	if err != nil {
+		t.Fatalf("DiffImage: %v", err)
+	}
+	if diff.Bounds() != processed.Bounds() {
+		t.Fatalf("diff bounds = %v, want %v", diff.Bounds(), processed.Bounds())
+	}
+	if got := diff.NRGBAAt(2, 5); got != (color.NRGBA{A: 255}) {
+		t.Errorf("unchanged pixel = %v, want black", got)
+	}
+	if got := diff.NRGBAAt(17, 5); got != (color.NRGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("fully changed pixel = %v, want white", got)
+	}
+}
+
+func TestDiffImageScalesOriginalToProcessedSize(t *testing.T) {
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+	diff, err := DiffImage(solid(64, 32, gray), solid(16, 8, gray))
+	if err != nil {
+		t.Fatalf("DiffImage: %v", err)
+	}
+	if b := diff.Bounds(); b.Dx() != 16 || b.Dy() != 8 {
+		t.Fatalf("diff bounds = %v, want the processed 16x8", b)
+	}
+	for y := range 8 {
+		for x := range 16 {
+			if got := diff.NRGBAAt(x, y); got.R > 10 {
+				t.Fatalf("pixel %d,%d = %v; a resize alone should show almost no difference", x, y, got)
+			}
+		}
+	}
+}
+
+func TestDiffImageRejectsEmptyProcessedImage(t *testing.T) {
+	if _, err := DiffImage(solid(4, 4, color.NRGBA{A: 255}), image.NewNRGBA(image.Rect(0, 0, 0, 0))); err == nil {
+		t.Fatal("DiffImage accepted an empty processed image")
+	}
+}
@@ -0,0 +1,244 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// MetadataICCProfile is the preserve_metadata entry that keeps the color
+// profile; every other entry names an EXIF tag from exifMetadataTags.
+const MetadataICCProfile = "icc_profile"
+
+// exifMetadataTags are the IFD0 text tags preserve_metadata can name.
+// Orientation is deliberately missing: the pixels are already upright.
+var exifMetadataTags = map[string]uint16{
+	"image_description": 0x010E,
+	"make":              0x010F,
+	"model":             0x0110,
+	"software":          0x0131,
+	"datetime":          0x0132,
+	"artist":            0x013B,
+	"copyright":         0x8298,
+}
+
+// exifASCII is the TIFF field type of text tags.
+const exifASCII = 2
+
+// maxExifSize keeps the rebuilt EXIF within one JPEG APP1 segment.
+const maxExifSize = 65533 - len("Exif\x00\x00")
+
+var exifJPEGPrefix = []byte("Exif\x00\x00")
+
+// PreservedExif reads the text tags named in names from the EXIF of a
+// JPEG (APP1) or PNG (eXIf) original and returns them as a fresh EXIF
+// block holding only those tags, or nil when none are present. Names that
+// are not EXIF tags, such as MetadataICCProfile, are ignored.
+func PreservedExif(data []byte, names []string) []byte {
+	wanted := map[uint16]bool{}
+	for _, name := range names {
+		if tag, ok := exifMetadataTags[name]; ok {
+			wanted[tag] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		tiff = jpegExif(data)
+	case bytes.HasPrefix(data, pngMagic):
+		tiff = pngExif(data)
+	}
+	tags := exifTextTags(tiff, wanted)
+	if len(tags) == 0 {
+		return nil
+	}
+	return buildExif(tags)
+}
+
+// jpegExif returns the TIFF structure of the Exif APP1 segment.
+func jpegExif(data []byte) []byte {
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // SOS, EOI
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifJPEGPrefix) {
+			return segment[len(exifJPEGPrefix):]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// pngExif returns the body of the eXIf chunk, which is a bare TIFF
+// structure.
+func pngExif(data []byte) []byte {
+	i := len(pngMagic)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) {
+			return nil
+		}
+		if chunkType == "eXIf" {
+			return data[i+8 : i+8+length]
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// exifTextTags collects the wanted ASCII tags of IFD0, values with their
+// NUL terminators as stored.
+func exifTextTags(tiff []byte, wanted map[uint16]bool) map[uint16][]byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd+2 > int64(len(tiff)) {
+		return nil
+	}
+	tags := map[uint16][]byte{}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + int64(n)*12
+		if entry+12 > int64(len(tiff)) {
+			break
+		}
+		tag := order.Uint16(tiff[entry:])
+		if !wanted[tag] || order.Uint16(tiff[entry+2:]) != exifASCII {
+			continue
+		}
+		size := int64(order.Uint32(tiff[entry+4:]))
+		value := entry + 8
+		if size > 4 {
+			value = int64(order.Uint32(tiff[entry+8:]))
+		}
+		if size == 0 || value+size > int64(len(tiff)) {
+			continue
+		}
+		tags[tag] = tiff[value : value+size]
+	}
+	return tags
+}
+
+// buildExif writes tags as a little-endian TIFF structure with a single
+// IFD; tags that would push it past one APP1 segment are left out.
+func buildExif(tags map[uint16][]byte) []byte {
+	ids := make([]uint16, 0, len(tags))
+	for tag := range tags {
+		ids = append(ids, tag)
+	}
+	// IFD entries must be sorted by tag
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for {
+		ifdSize := 2 + 12*len(ids) + 4
+		size := 8 + ifdSize
+		for _, tag := range ids {
+			if n := len(tags[tag]); n > 4 {
+				size += n + n%2
+			}
+		}
+		if size <= maxExifSize || len(ids) == 0 {
+			break
+		}
+		// drop the largest value until the rest fits
+		largest := 0
+		for i, tag := range ids {
+			if len(tags[tag]) > len(tags[ids[largest]]) {
+				largest = i
+			}
+		}
+		ids = append(ids[:largest], ids[largest+1:]...)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	order := binary.LittleEndian
+	out := []byte("II*\x00")
+	out = order.AppendUint32(out, 8)
+	out = order.AppendUint16(out, uint16(len(ids)))
+
+	dataOffset := 8 + 2 + 12*len(ids) + 4
+	var values []byte
+	for _, tag := range ids {
+		value := tags[tag]
+		out = order.AppendUint16(out, tag)
+		out = order.AppendUint16(out, exifASCII)
+		out = order.AppendUint32(out, uint32(len(value)))
+		if len(value) <= 4 {
+			inline := make([]byte, 4)
+			copy(inline, value)
+			out = append(out, inline...)
+			continue
+		}
+		out = order.AppendUint32(out, uint32(dataOffset+len(values)))
+		values = append(values, value...)
+		// offsets are word aligned
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	out = order.AppendUint32(out, 0) // no next IFD
+	return append(out, values...)
+}
+
+// embedExif inserts an EXIF block built by PreservedExif into encoded
+// JPEG or PNG output; other formats are returned unchanged.
+func embedExif(data, exif []byte, format imaging.Format) []byte {
+	switch format {
+	case imaging.JPEG:
+		if !bytes.HasPrefix(data, jpegMagic) {
+			return data
+		}
+		// APP1 goes first, before any APP2 color profile
+		out := make([]byte, 0, len(data)+len(exif)+10)
+		out = append(out, data[:2]...)
+		out = append(out, 0xFF, 0xE1)
+		out = binary.BigEndian.AppendUint16(out, uint16(2+len(exifJPEGPrefix)+len(exif)))
+		out = append(out, exifJPEGPrefix...)
+		out = append(out, exif...)
+		return append(out, data[2:]...)
+
+	case imaging.PNG:
+		ihdrEnd := len(pngMagic) + 12 + 13
+		if !bytes.HasPrefix(data, pngMagic) || len(data) < ihdrEnd {
+			return data
+		}
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(len(exif)))
+		chunk = append(chunk, "eXIf"...)
+		chunk = append(chunk, exif...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+		out := make([]byte, 0, len(data)+len(chunk))
+		out = append(out, data[:ihdrEnd]...)
+		out = append(out, chunk...)
+		return append(out, data[ihdrEnd:]...)
+	}
+	return data
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+type exifEntry struct {
+	tag, typ uint16
+	value    []byte
+}
+
+// exifTIFF lays entries out as a TIFF structure with a single IFD.
+func exifTIFF(order binary.AppendByteOrder, entries []exifEntry) []byte {
+	out := []byte("MM\x00\x2a")
+	if order == binary.LittleEndian {
+		out = []byte("II\x2a\x00")
+	}
+	out = order.AppendUint32(out, 8)
+	out = order.AppendUint16(out, uint16(len(entries)))
+	valueOffset := len(out) + 12*len(entries) + 4
+	var values []byte
+	for _, e := range entries {
+		out = order.AppendUint16(out, e.tag)
+		out = order.AppendUint16(out, e.typ)
+		out = order.AppendUint32(out, uint32(len(e.value)))
+		if len(e.value) <= 4 {
+			out = append(out, append(e.value, make([]byte, 4-len(e.value))...)...)
+			continue
+		}
+		out = order.AppendUint32(out, uint32(valueOffset+len(values)))
+		values = append(values, e.value...)
+	}
+	out = append(out, 0, 0, 0, 0)
+	return append(out, values...)
+}
+
+func TestPreservedExifKeepsOnlyNamedTags(t *testing.T) {
+	jpg := encodeTestJPEG(t, 16, 16)
+	entries := []exifEntry{
+		{0x010F, exifASCII, []byte("Acme\x00")},
+		{0x0112, 3, []byte{0, 6, 0, 0}}, // orientation
+		{0x013B, exifASCII, []byte("Jo\x00")},
+		{0x8298, exifASCII, []byte("(c) 2024 Jo\x00")},
+	}
+
+	for _, order := range []binary.AppendByteOrder{binary.BigEndian, binary.LittleEndian} {
+		original := jpegSegment(jpg, 0xE1, append([]byte("Exif\x00\x00"), exifTIFF(order, entries)...))
+
+		exif := PreservedExif(original, []string{"copyright", "artist", MetadataICCProfile})
+		got := exifTextTags(exif, map[uint16]bool{0x010F: true, 0x013B: true, 0x8298: true})
+		if len(got) != 2 || string(got[0x8298]) != "(c) 2024 Jo\x00" || string(got[0x013B]) != "Jo\x00" {
+			t.Errorf("%v: preserved %q, want copyright and artist only", order, got)
+		}
+
+		if exif := PreservedExif(original, []string{"model", MetadataICCProfile}); exif != nil {
+			t.Errorf("%v: preserved %q for tags the original lacks", order, exif)
+		}
+	}
+
+	if exif := PreservedExif(jpg, []string{"copyright"}); exif != nil {
+		t.Errorf("preserved %q from an original without EXIF", exif)
+	}
+}
+
+func TestEncodeEmbedsExif(t *testing.T) {
+	exif := buildExif(map[uint16][]byte{0x8298: []byte("(c) 2024 Jo\x00")})
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+
+	for _, format := range []imaging.Format{imaging.JPEG, imaging.PNG, imaging.GIF} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, format, EncodeOptions{Exif: exif}); err != nil {
+			t.Fatalf("%v: Encode: %v", format, err)
+		}
+		if _, err := imaging.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("%v: output does not decode: %v", format, err)
+		}
+
+		got := PreservedExif(buf.Bytes(), []string{"copyright"})
+		if format == imaging.GIF {
+			// GIF has no place for EXIF
+			if got != nil {
+				t.Errorf("GIF carries EXIF %q", got)
+			}
+			continue
+		}
+		if !bytes.Equal(got, exif) {
+			t.Errorf("%v: read back %q, want %q", format, got, exif)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// FitBox resizes img into the box of r. With only one side given, the
+// other follows the aspect ratio and every fit mode behaves the same.
+// Originals are never enlarged: imaging.Fit already stops at the original
+// size, and for the other modes a box bigger than img is first shrunk,
+// keeping its proportions, until it fits inside.
+func FitBox(img image.Image, r domain.ResizeRequest) image.Image {
+	if r.Fit != domain.FitContain || r.Width == 0 || r.Height == 0 {
+		r = shrinkToSource(img.Bounds(), r)
+	}
+
+	if r.Width == 0 || r.Height == 0 {
+		return imaging.Resize(img, r.Width, r.Height, imaging.Lanczos)
+	}
+
+	switch r.Fit {
+	case domain.FitCover:
+		return imaging.Fill(img, r.Width, r.Height, imaging.Center, imaging.Lanczos)
+	case domain.FitFill:
+		return imaging.Resize(img, r.Width, r.Height, imaging.Lanczos)
+	default:
+		return imaging.Fit(img, r.Width, r.Height, imaging.Lanczos)
+	}
+}
+
+func shrinkToSource(bounds image.Rectangle, r domain.ResizeRequest) domain.ResizeRequest {
+	scale := 1.0
+	if r.Width > bounds.Dx() {
+		scale = float64(bounds.Dx()) / float64(r.Width)
+	}
+	if r.Height > bounds.Dy() {
+		scale = min(scale, float64(bounds.Dy())/float64(r.Height))
+	}
+	if scale == 1 {
+		return r
+	}
+
+	if r.Width > 0 {
+		r.Width = max(1, int(float64(r.Width)*scale+0.5))
+	}
+	if r.Height > 0 {
+		r.Height = max(1, int(float64(r.Height)*scale+0.5))
+	}
+	return r
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// ErrDegenerateOutput is returned by VerifyOutput for encoded results that
+// are too small or do not decode back to the expected image.
+var ErrDegenerateOutput = errors.New("degenerate encoded output")
+
+// ErrNoEncoder is returned for formats that can be decoded or named in
+// config but have no encoder compiled into this build.
+var ErrNoEncoder = errors.New("no encoder available for output format")
+
+// WebP extends imaging's formats with lossless WebP, written by nativewebp.
+// imaging cannot encode it, so Encode handles it itself.
+const WebP imaging.Format = -1
+
+// ParseOutputFormat maps a configured format name to an encodable format.
+func ParseOutputFormat(name string) (imaging.Format, error) {
+	switch strings.ToLower(name) {
+	case "jpeg", "jpg":
+		return imaging.JPEG, nil
+	case "png":
+		return imaging.PNG, nil
+	case "gif":
+		return imaging.GIF, nil
+	case "webp":
+		return WebP, nil
+	case "avif":
+		return 0, fmt.Errorf("%w: %s", ErrNoEncoder, name)
+	default:
+		return 0, fmt.Errorf("unknown output format %q", name)
+	}
+}
+
+// OriginalFormat is the output_format value that keeps the source format.
+const OriginalFormat = "original"
+
+// SourceFormat guesses the format of an original from its filename,
+// falling back to JPEG for formats that cannot be encoded (e.g. WebP).
+func SourceFormat(filename string) imaging.Format {
+	format, err := imaging.FormatFromFilename(filename)
+	if err != nil {
+		return imaging.JPEG
+	}
+	switch format {
+	case imaging.JPEG, imaging.PNG, imaging.GIF:
+		return format
+	default:
+		return imaging.JPEG
+	}
+}
+
+// FormatExtension returns the file extension, with the dot, for format.
+func FormatExtension(format imaging.Format) string {
+	switch format {
+	case imaging.PNG:
+		return ".png"
+	case imaging.GIF:
+		return ".gif"
+	case WebP:
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
+
+// FormatContentType returns the MIME type served for format.
+func FormatContentType(format imaging.Format) string {
+	switch format {
+	case imaging.PNG:
+		return "image/png"
+	case imaging.GIF:
+		return "image/gif"
+	case WebP:
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// FormatName is the lower-case name stored with variants.
+func FormatName(format imaging.Format) string {
+	if format == WebP {
+		return "webp"
+	}
+	return strings.ToLower(format.String())
+}
+
+// VerifyOutput rejects encoded output shorter than min_output_bytes or whose
+// header does not decode to width x height. Some degenerate inputs encode
+// without error into a handful of bytes that no viewer can open.
+func (p *ImageProcessor) VerifyOutput(data []byte, width, height int) error {
+	if minBytes := p.cfg.MinOutputBytes; minBytes > 0 && len(data) < minBytes {
+		return fmt.Errorf("%w: %d bytes, expected at least %d", ErrDegenerateOutput, len(data), minBytes)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: re-decode failed: %v", ErrDegenerateOutput, err)
+	}
+	if cfg.Width != width || cfg.Height != height {
+		return fmt.Errorf("%w: decodes as %dx%d, expected %dx%d", ErrDegenerateOutput, cfg.Width, cfg.Height, width, height)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+func TestVerifyOutput(t *testing.T) {
+	jpg := encodeTestJPEG(t, 32, 16)
+	tests := []struct {
+		name          string
+		data          []byte
+		minBytes      int
+		width, height int
+		wantErr       bool
+	}{
+		{name: "valid", data: jpg, minBytes: 100, width: 32, height: 16},
+		{name: "check disabled", data: jpg, width: 32, height: 16},
+		{name: "below min_output_bytes", data: jpg, minBytes: len(jpg) + 1, width: 32, height: 16, wantErr: true},
+		{name: "not an image", data: []byte("\xff\xd8\xff\xd9"), width: 32, height: 16, wantErr: true},
+		{name: "truncated header", data: jpg[:8], width: 32, height: 16, wantErr: true},
+		{name: "wrong dimensions", data: jpg, width: 16, height: 32, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewImageProcessor(&config.ProcessingConfig{MinOutputBytes: tt.minBytes})
+			err := p.VerifyOutput(tt.data, tt.width, tt.height)
+			if tt.wantErr != errors.Is(err, ErrDegenerateOutput) {
+				t.Fatalf("VerifyOutput = %v, want degenerate %v", err, tt.wantErr)
+			}
+		})
+	}
+}
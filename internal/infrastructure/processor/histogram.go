@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// histogramSampleSize bounds the image before counting; the distribution of
+// a downscaled image is close enough for analytics and far cheaper.
+const histogramSampleSize = 512
+
+// ComputeHistogram returns R, G, B and luminance (Rec. 709) histograms of img
+// with the given number of buckets (1-256). Fully transparent pixels are skipped.
+func ComputeHistogram(img image.Image, buckets int) (*domain.Histogram, error) {
+	if buckets < 1 || buckets > 256 {
+		return nil, fmt.Errorf("buckets must be between 1 and 256, got %d", buckets)
+	}
+	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+
+	small := imaging.Fit(img, histogramSampleSize, histogramSampleSize, imaging.Box)
+
+	h := &domain.Histogram{
+		Buckets:  buckets,
+		Channels: make(map[string][]int, len(domain.HistogramChannels)),
+	}
+	for _, ch := range domain.HistogramChannels {
+		h.Channels[ch] = make([]int, buckets)
+	}
+
+	bucket := func(v uint8) int {
+		return int(v) * buckets / 256
+	}
+
+	r, g, b, l := h.Channels[domain.ChannelRed], h.Channels[domain.ChannelGreen], h.Channels[domain.ChannelBlue], h.Channels[domain.ChannelLuminance]
+	bounds := small.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := small.NRGBAAt(x, y)
+			if c.A == 0 {
+				continue
+			}
+			lum := uint8(0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B) + 0.5)
+
+			r[bucket(c.R)]++
+			g[bucket(c.G)]++
+			b[bucket(c.B)]++
+			l[bucket(lum)]++
+			h.Pixels++
+		}
+	}
+
+	return h, nil
+}
@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxICCProfileSize bounds an inflated PNG profile; real ones stay well
+// under a megabyte.
+const maxICCProfileSize = 4 << 20
+
+var iccJPEGPrefix = []byte("ICC_PROFILE\x00")
+
+// ICCProfile returns the RGB color profile embedded in a JPEG (APP2
+// segments) or PNG (iCCP chunk), or nil when there is none. Profiles of
+// other color spaces are ignored: decoded images are re-encoded as RGB,
+// where a CMYK or gray profile would be wrong.
+func ICCProfile(data []byte) []byte {
+	var profile []byte
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		profile = jpegICCProfile(data)
+	case bytes.HasPrefix(data, pngMagic):
+		profile = pngICCProfile(data)
+	}
+	// the header names the data color space at offset 16
+	if len(profile) < 128 || string(profile[16:20]) != "RGB " {
+		return nil
+	}
+	return profile
+}
+
+// jpegICCProfile joins the APP2 chunks of a profile, which is split into
+// numbered segments when it exceeds one segment's 64 KB.
+func jpegICCProfile(data []byte) []byte {
+	chunks := map[int][]byte{}
+	count := 0
+	i := 2
+	for {
+		for i+1 < len(data) && data[i] == 0xFF && data[i+1] == 0xFF {
+			i++
+		}
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // SOS, EOI
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE2 && bytes.HasPrefix(segment, iccJPEGPrefix) && len(segment) > len(iccJPEGPrefix)+2 {
+			seq := int(segment[len(iccJPEGPrefix)])
+			count = int(segment[len(iccJPEGPrefix)+1])
+			chunks[seq] = segment[len(iccJPEGPrefix)+2:]
+		}
+		i += 2 + length
+	}
+
+	if count == 0 || len(chunks) != count {
+		return nil
+	}
+	seqs := make([]int, 0, count)
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	var profile []byte
+	for n, seq := range seqs {
+		if seq != n+1 {
+			return nil
+		}
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile
+}
+
+// pngICCProfile inflates the iCCP chunk, which precedes the image data.
+func pngICCProfile(data []byte) []byte {
+	i := len(pngMagic)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if length < 0 || i+12+length > len(data) || chunkType == "IDAT" {
+			return nil
+		}
+		if chunkType == "iCCP" {
+			// profile name, NUL, compression method, zlib stream
+			body := data[i+8 : i+8+length]
+			nul := bytes.IndexByte(body, 0)
+			if nul < 0 || nul+2 > len(body) || body[nul+1] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(body[nul+2:]))
+			if err != nil {
+				return nil
+			}
+			defer zr.Close()
+			profile, err := io.ReadAll(io.LimitReader(zr, maxICCProfileSize+1))
+			if err != nil || len(profile) > maxICCProfileSize {
+				return nil
+			}
+			return profile
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// embedICCProfile inserts profile into encoded JPEG or PNG output; other
+// formats are returned unchanged.
+func embedICCProfile(data, profile []byte, format imaging.Format) []byte {
+	switch format {
+	case imaging.JPEG:
+		if !bytes.HasPrefix(data, jpegMagic) {
+			return data
+		}
+		// a segment holds at most 65533 bytes after its length field
+		const chunkSize = 65533 - 14
+		count := (len(profile) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return data
+		}
+		out := make([]byte, 0, len(data)+len(profile)+count*18)
+		out = append(out, data[:2]...)
+		for seq := 1; seq <= count; seq++ {
+			chunk := profile[(seq-1)*chunkSize : min(seq*chunkSize, len(profile))]
+			out = append(out, 0xFF, 0xE2)
+			out = binary.BigEndian.AppendUint16(out, uint16(2+len(iccJPEGPrefix)+2+len(chunk)))
+			out = append(out, iccJPEGPrefix...)
+			out = append(out, byte(seq), byte(count))
+			out = append(out, chunk...)
+		}
+		return append(out, data[2:]...)
+
+	case imaging.PNG:
+		// iCCP must come before PLTE and IDAT, so right after IHDR
+		ihdrEnd := len(pngMagic) + 12 + 13
+		if !bytes.HasPrefix(data, pngMagic) || len(data) < ihdrEnd {
+			return data
+		}
+		var body bytes.Buffer
+		body.WriteString("ICC Profile\x00\x00")
+		zw := zlib.NewWriter(&body)
+		zw.Write(profile)
+		zw.Close()
+
+		chunk := binary.BigEndian.AppendUint32(nil, uint32(body.Len()))
+		chunk = append(chunk, "iCCP"...)
+		chunk = append(chunk, body.Bytes()...)
+		chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+		out := make([]byte, 0, len(data)+len(chunk))
+		out = append(out, data[:ihdrEnd]...)
+		out = append(out, chunk...)
+		return append(out, data[ihdrEnd:]...)
+	}
+	return data
+}
@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// lqipMaxEdge is the longest edge of the placeholder in pixels.
+	lqipMaxEdge = 20
+	// lqipQuality keeps the data URI to a few hundred bytes; the client
+	// scales and blurs it anyway.
+	lqipQuality = 30
+)
+
+// LQIP renders img as a tiny, heavily compressed JPEG and returns it as a
+// data URI, a low-quality image placeholder clients can show inline while
+// the full image loads. Transparent areas are filled with white.
+func LQIP(img image.Image) (string, error) {
+	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
+		return "", fmt.Errorf("lqip: image is empty")
+	}
+
+	small := imaging.Fit(img, lqipMaxEdge, lqipMaxEdge, imaging.Box)
+	flat := imaging.New(small.Bounds().Dx(), small.Bounds().Dy(), color.White)
+	flat = imaging.Overlay(flat, small, image.Point{}, 1)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: lqipQuality}); err != nil {
+		return "", fmt.Errorf("lqip: encode: %w", err)
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// decodeLQIP checks uri is a base64 JPEG data URI and decodes it.
+func decodeLQIP(t *testing.T, uri string) (image.Image, int) {
+	t.Helper()
+	const prefix = "data:image/jpeg;base64,"
+	if !strings.HasPrefix(uri, prefix) {
+		t.Fatalf("LQIP %.40q... is not a JPEG data URI", uri)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(uri, prefix))
+	if err != nil {
+		t.Fatalf("decode base64: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode jpeg: %v", err)
+	}
+	return img, len(data)
+}
+
+func TestLQIPIsSmallJPEGDataURI(t *testing.T) {
+	tests := []struct {
+		w, h         int
+		wantW, wantH int
+	}{
+		{w: 1600, h: 1200, wantW: 20, wantH: 15},
+		{w: 600, h: 1800, wantW: 6, wantH: 20},
+		{w: 12, h: 8, wantW: 12, wantH: 8},
+	}
+	for _, tt := range tests {
+		uri, err := LQIP(gradient(tt.w, tt.h))
+		if err != nil {
+			t.Fatalf("LQIP %dx%d: %v", tt.w, tt.h, err)
+		}
+		img, size := decodeLQIP(t, uri)
+		if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("%dx%d: placeholder is %dx%d, want %dx%d", tt.w, tt.h, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+		}
+		if size > 1024 {
+			t.Errorf("%dx%d: placeholder is %d bytes, want it well under 1 KB", tt.w, tt.h, size)
+		}
+	}
+}
+
+func TestLQIPFillsTransparencyWithWhite(t *testing.T) {
+	uri, err := LQIP(image.NewNRGBA(image.Rect(0, 0, 40, 40)))
+	if err != nil {
+		t.Fatalf("LQIP: %v", err)
+	}
+	img, _ := decodeLQIP(t, uri)
+	r, g, b, _ := img.At(10, 10).RGBA()
+	if r>>8 < 245 || g>>8 < 245 || b>>8 < 245 {
+		t.Errorf("transparent area = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestLQIPRejectsEmptyImage(t *testing.T) {
+	if _, err := LQIP(image.NewNRGBA(image.Rect(0, 0, 0, 0))); err == nil {
+		t.Fatal("LQIP of an empty image succeeded")
+	}
+}
+
+func gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 90, A: 255})
+		}
+	}
+	return img
+}
@@ -0,0 +1,148 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// HasMetadata reports whether an encoded JPEG, PNG, GIF or TIFF may carry
+// metadata about the photo or its owner: EXIF (GPS, camera, timestamps),
+// XMP, IPTC or free-text comments. Color profiles do not count. TIFF keeps
+// such tags next to the pixels and is always reported, as is any file
+// that cannot be walked, so callers err on the side of re-encoding.
+// Other formats report false.
+func HasMetadata(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		return jpegHasMetadata(data)
+	case bytes.HasPrefix(data, pngMagic):
+		return pngHasMetadata(data)
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return gifHasMetadata(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return true
+	default:
+		return false
+	}
+}
+
+// jpegHasMetadata looks for APP1 (EXIF, XMP), APP13 (IPTC) and COM
+// segments. They all come before the first scan.
+func jpegHasMetadata(data []byte) bool {
+	i := 2
+	for {
+		for i+1 < len(data) && data[i] == 0xFF && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return true
+		}
+		marker := data[i+1]
+		i += 2
+
+		switch {
+		case marker == 0xDA, marker == 0xD9: // SOS, EOI
+			return false
+		case marker == 0xE1, marker == 0xED, marker == 0xFE:
+			return true
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			continue
+		}
+
+		if i+2 > len(data) {
+			return true
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 {
+			return true
+		}
+		i += length
+	}
+}
+
+// pngMetadataChunks may appear anywhere between IHDR and IEND.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"iTXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+func pngHasMetadata(data []byte) bool {
+	i := len(pngMagic)
+	for {
+		if i+8 > len(data) {
+			return true
+		}
+		length := int64(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		if pngMetadataChunks[chunkType] {
+			return true
+		}
+		if chunkType == "IEND" {
+			return false
+		}
+		next := int64(i) + 12 + length
+		if next > int64(len(data)) {
+			return true
+		}
+		i = int(next)
+	}
+}
+
+// gifHasMetadata looks for comment extensions and XMP application
+// extensions; NETSCAPE looping blocks are harmless.
+func gifHasMetadata(data []byte) bool {
+	if len(data) < 13 {
+		return true
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			return false
+		case 0x21:
+			if i+1 >= len(data) {
+				return true
+			}
+			switch data[i+1] {
+			case 0xFE: // comment
+				return true
+			case 0xFF: // application: 11 byte identifier in the first sub-block
+				if i+14 <= len(data) && bytes.Equal(data[i+3:i+11], []byte("XMP Data")) {
+					return true
+				}
+			}
+			i += 2
+		case 0x2C:
+			if i+10 > len(data) {
+				return true
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+		default:
+			return true
+		}
+
+		for {
+			if i >= len(data) {
+				return true
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	return true
+}
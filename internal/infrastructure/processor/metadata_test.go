@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// jpegSegment inserts a marker segment right after SOI.
+func jpegSegment(data []byte, marker byte, payload []byte) []byte {
+	out := []byte{0xFF, 0xD8, 0xFF, marker}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+// pngChunk inserts a chunk right after IHDR.
+func pngChunk(data []byte, chunkType string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := len(pngMagic) + 12 + 13
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestHasMetadata(t *testing.T) {
+	jpg := encodeTestJPEG(t, 16, 16)
+	png := encodeGrayPNG(t, 16, 16)
+	exif := append([]byte("Exif\x00\x00"), "MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "clean jpeg", data: jpg},
+		{name: "jpeg with exif", data: jpegSegment(jpg, 0xE1, exif), want: true},
+		{name: "jpeg with xmp", data: jpegSegment(jpg, 0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x/>")), want: true},
+		{name: "jpeg with iptc", data: jpegSegment(jpg, 0xED, []byte("Photoshop 3.0\x00")), want: true},
+		{name: "jpeg with comment", data: jpegSegment(jpg, 0xFE, []byte("shot at home")), want: true},
+		{name: "jpeg with color profile", data: jpegSegment(jpg, 0xE2, []byte("ICC_PROFILE\x00\x01\x01"))},
+		{name: "truncated jpeg header", data: jpg[:3], want: true},
+		{name: "clean png", data: png},
+		{name: "png with exif", data: pngChunk(png, "eXIf", exif[6:]), want: true},
+		{name: "png with text", data: pngChunk(png, "tEXt", []byte("Author\x00Jane Doe")), want: true},
+		{name: "png with gamma", data: pngChunk(png, "gAMA", []byte{0, 0, 0xB1, 0x8F})},
+		{name: "clean gif", data: encodeTestGIF(t)},
+		{name: "tiff", data: []byte("II*\x00\x08\x00\x00\x00"), want: true},
+		{name: "unknown format", data: []byte("RIFF....WEBP")},
+	}
+	for _, tt := range tests {
+		if got := HasMetadata(tt.data); got != tt.want {
+			t.Errorf("%s: HasMetadata = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
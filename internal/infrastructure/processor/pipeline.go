@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// HasPipeline reports whether name is a configured pipeline.
+func (p *ImageProcessor) HasPipeline(name string) bool {
+	_, ok := p.pipelines[name]
+	return ok
+}
+
+// EncodeQuality is the JPEG quality for an image processed with opts: the
+// requested Quality, else the last optimize or quality step of its
+// pipeline, else OutputQuality.
+func (p *ImageProcessor) EncodeQuality(opts domain.ProcessingOptions) int {
+	if opts.Quality != nil {
+		return *opts.Quality
+	}
+	quality := p.OutputQuality()
+	for _, step := range p.pipelines[opts.PipelineName()] {
+		switch step.Op {
+		case domain.StepOptimize:
+			quality = domain.OptimizeQuality
+		case domain.StepQuality:
+			quality = step.Value
+		}
+	}
+	return quality
+}
+
+// runPipeline applies the steps of the named pipeline to img in order.
+// Steps without a size fall back to the configured size of the same
+// single-step processing type.
+func (p *ImageProcessor) runPipeline(ctx context.Context, img image.Image, name string) (image.Image, error) {
+	steps, ok := p.pipelines[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", domain.ErrUnknownPipeline, name)
+	}
+
+	zlog.Logger.Info().Str("pipeline", name).Int("steps", len(steps)).Msg("Running processing pipeline")
+
+	var err error
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		switch step.Op {
+		case domain.StepResize:
+			img = p.resizeTo(img, step.Width, step.Height)
+		case domain.StepThumbnail:
+			if step.Width == 0 {
+				img = p.thumbnail(img)
+			} else {
+				img = p.resizeTo(img, step.Width, step.Height)
+			}
+		case domain.StepCrop:
+			if step.Width == 0 {
+				img = p.crop(img, p.cfg.CropWidth, p.cfg.CropHeight)
+			} else {
+				img = p.crop(img, step.Width, step.Height)
+			}
+		case domain.StepRotate:
+			img, err = rotate(img, step.Value)
+			if err != nil {
+				return nil, err
+			}
+		case domain.StepFlip:
+			img, err = flip(img, step.Axis)
+			if err != nil {
+				return nil, err
+			}
+		case domain.StepDenoise:
+			img, err = denoise(ctx, img, step.Value)
+			if err != nil {
+				return nil, err
+			}
+		case domain.StepWatermark:
+			img = p.watermark(img)
+		case domain.StepFlatten:
+			img = Flatten(img)
+		case domain.StepAutoOrient, domain.StepOptimize, domain.StepQuality:
+			// applied at decode / encode time
+		}
+	}
+
+	return img, nil
+}
+
+// resizeTo fits img into width x height. Without a width it uses the
+// configured resize box; without a height it scales to width and keeps
+// the aspect ratio.
+func (p *ImageProcessor) resizeTo(img image.Image, width, height int) image.Image {
+	if width == 0 {
+		return p.resize(img)
+	}
+	return p.scaleTo(img, width, height)
+}
+
+// fitSize applies the per-request box of opts in place of the configured one.
+func (p *ImageProcessor) fitSize(img image.Image, opts domain.ProcessingOptions) image.Image {
+	width, height := opts.Size()
+
+	zlog.Logger.Info().
+		Int("target_width", width).
+		Int("target_height", height).
+		Msg("Resizing to per-request dimensions")
+
+	return p.scaleTo(img, width, height)
+}
+
+// scaleTo fits img into width x height; a zero side follows the aspect
+// ratio. downscale_only leaves images that already fit untouched.
+func (p *ImageProcessor) scaleTo(img image.Image, width, height int) image.Image {
+	if width > 0 && height > 0 {
+		if p.passThrough(img, width, height) {
+			return img
+		}
+		return imaging.Fit(img, width, height, imaging.Lanczos)
+	}
+
+	if p.cfg.DownscaleOnly {
+		if (width > 0 && img.Bounds().Dx() <= width) || (height > 0 && img.Bounds().Dy() <= height) {
+			return img
+		}
+	}
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
@@ -0,0 +1,85 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func newPipelineProcessor() *ImageProcessor {
+	return NewImageProcessor(&config.ProcessingConfig{
+		ResizeWidth:   64,
+		ResizeHeight:  64,
+		OutputQuality: 90,
+		Pipelines: map[string][]string{
+			"resize_then_rotate": {"autoorient", "resize:40", "rotate:90"},
+			"rotate_then_resize": {"rotate:90", "resize:40"},
+			"optimized":          {"resize", "optimize"},
+			"fixed_quality":      {"optimize", "quality:70"},
+		},
+	})
+}
+
+func TestPipelineRunsStepsInOrder(t *testing.T) {
+	p := newPipelineProcessor()
+	tests := []struct {
+		pipeline     string
+		wantW, wantH int
+	}{
+		{"resize_then_rotate", 20, 40},
+		{"rotate_then_resize", 40, 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pipeline, func(t *testing.T) {
+			name := tt.pipeline
+			out, err := p.Process(context.Background(), bytes.NewReader(encodeTestJPEG(t, 80, 40)), domain.ProcessingPipeline, domain.ProcessingOptions{Pipeline: &name})
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if b := out.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Fatalf("bounds = %v, want %dx%d", b, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestPipelineUnknownName(t *testing.T) {
+	p := newPipelineProcessor()
+	name := "missing"
+	_, err := p.Process(context.Background(), bytes.NewReader(encodeTestJPEG(t, 8, 8)), domain.ProcessingPipeline, domain.ProcessingOptions{Pipeline: &name})
+	if !errors.Is(err, domain.ErrUnknownPipeline) {
+		t.Fatalf("err = %v, want ErrUnknownPipeline", err)
+	}
+	if p.HasPipeline(name) || !p.HasPipeline("optimized") {
+		t.Fatal("HasPipeline does not match the configured pipelines")
+	}
+}
+
+func TestPipelineEncodeQuality(t *testing.T) {
+	p := newPipelineProcessor()
+	named := func(name string) domain.ProcessingOptions { return domain.ProcessingOptions{Pipeline: &name} }
+	requested := 40
+
+	tests := []struct {
+		name string
+		opts domain.ProcessingOptions
+		want int
+	}{
+		{"no pipeline", domain.ProcessingOptions{}, 90},
+		{"optimize step", named("optimized"), domain.OptimizeQuality},
+		{"last quality step wins", named("fixed_quality"), 70},
+		{"pipeline without quality steps", named("resize_then_rotate"), 90},
+		{"requested quality", domain.ProcessingOptions{Pipeline: named("optimized").Pipeline, Quality: &requested}, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.EncodeQuality(tt.opts); got != tt.want {
+				t.Fatalf("EncodeQuality = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package processor
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+)
+
+const (
+	// placementMarginRatio is the gap between a placed watermark and the
+	// image edges, as a share of the shorter side.
+	placementMarginRatio = 0.03
+	// entropySamples bounds the pixels sampled per side of a region.
+	entropySamples = 64
+	entropyBins    = 32
+)
+
+// placeMark draws wm once, unrotated, in the least busy of eight candidate
+// regions: the corners and the middles of the edges, the center being
+// where the subject usually is. Busyness is the entropy of the region's
+// luminance histogram, so a flat sky or wall wins over detail. Ties keep
+// the earlier candidate, starting with the bottom-right corner.
+func (p *ImageProcessor) placeMark(img image.Image, wm image.Image) image.Image {
+	out := imaging.Clone(img)
+	bounds := out.Bounds()
+	if wm.Bounds().Dx() == 0 || wm.Bounds().Dy() == 0 || bounds.Dx() == 0 || bounds.Dy() == 0 {
+		zlog.Logger.Warn().Msg("watermark or image has zero size, returning original image")
+		return img
+	}
+	if wm.Bounds().Dx() > bounds.Dx() || wm.Bounds().Dy() > bounds.Dy() {
+		wm = imaging.Fit(wm, bounds.Dx(), bounds.Dy(), imaging.Lanczos)
+	}
+	w, h := wm.Bounds().Dx(), wm.Bounds().Dy()
+
+	margin := int(placementMarginRatio * float64(min(bounds.Dx(), bounds.Dy())))
+	xs := placementOffsets(bounds.Dx(), w, margin)
+	ys := placementOffsets(bounds.Dy(), h, margin)
+	// bottom-right first, then the other corners, then the edge middles
+	candidates := []image.Point{
+		{xs[2], ys[2]}, {xs[0], ys[2]}, {xs[2], ys[0]}, {xs[0], ys[0]},
+		{xs[1], ys[2]}, {xs[1], ys[0]}, {xs[0], ys[1]}, {xs[2], ys[1]},
+	}
+
+	best := candidates[0]
+	bestEntropy := math.Inf(1)
+	for _, pt := range candidates {
+		e := regionEntropy(out, image.Rect(pt.X, pt.Y, pt.X+w, pt.Y+h))
+		if e < bestEntropy {
+			best, bestEntropy = pt, e
+		}
+	}
+
+	out = imaging.Overlay(out, wm, best, p.watermarkOpacity())
+
+	zlog.Logger.Info().
+		Int("x", best.X).
+		Int("y", best.Y).
+		Float64("entropy", bestEntropy).
+		Int("opacity", p.cfg.WatermarkOpacity).
+		Msg("Watermark placed in least busy region")
+	return out
+}
+
+// placementOffsets returns the start, middle and end offsets of a mark of
+// size n along a side of size total.
+func placementOffsets(total, n, margin int) [3]int {
+	end := total - n - margin
+	if end < 0 {
+		end = 0
+	}
+	start := margin
+	if start > end {
+		start = end
+	}
+	return [3]int{start, (total - n) / 2, end}
+}
+
+// regionEntropy is the Shannon entropy in bits of the luminance histogram
+// of r, sampled on a grid of at most entropySamples per side. r is relative
+// to img's origin, which imaging.Clone puts at 0,0.
+func regionEntropy(img *image.NRGBA, r image.Rectangle) float64 {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return math.Inf(1)
+	}
+
+	stepX := max(1, r.Dx()/entropySamples)
+	stepY := max(1, r.Dy()/entropySamples)
+
+	var hist [entropyBins]int
+	total := 0
+	for y := r.Min.Y; y < r.Max.Y; y += stepY {
+		for x := r.Min.X; x < r.Max.X; x += stepX {
+			i := img.PixOffset(x, y)
+			px := img.Pix[i : i+3 : i+3]
+			lum := (299*int(px[0]) + 587*int(px[1]) + 114*int(px[2])) / 1000
+			hist[lum*entropyBins/256]++
+			total++
+		}
+	}
+
+	entropy := 0.0
+	for _, n := range hist {
+		if n == 0 {
+			continue
+		}
+		q := float64(n) / float64(total)
+		entropy -= q * math.Log2(q)
+	}
+	return entropy
+}
@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// busyRightHalf is a flat gray image whose right half is gray noise.
+func busyRightHalf(w, h int) *image.NRGBA {
+	rng := rand.New(rand.NewPCG(3, 4))
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			v := uint8(128)
+			if x >= w/2 {
+				v = uint8(rng.IntN(256))
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+// redBounds returns the bounding box of the pure red pixels of img, which
+// only the watermark contains.
+func redBounds(img image.Image) image.Rectangle {
+	var box image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.R > 200 && c.G < 60 && c.B < 60 {
+				box = box.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return box
+}
+
+func newMarkProcessor(smart bool) *ImageProcessor {
+	p := NewImageProcessor(&config.ProcessingConfig{WatermarkOpacity: 255, WatermarkSmartPlacement: smart})
+	mark := image.NewNRGBA(image.Rect(0, 0, 80, 40))
+	for i := 0; i < len(mark.Pix); i += 4 {
+		copy(mark.Pix[i:], []byte{255, 0, 0, 255})
+	}
+	p.watermarkImg = mark
+	return p
+}
+
+func TestSmartPlacementAvoidsBusyRegion(t *testing.T) {
+	const w, h = 400, 300
+	out := newMarkProcessor(true).watermark(busyRightHalf(w, h))
+
+	box := redBounds(out)
+	if box.Empty() {
+		t.Fatal("no watermark drawn")
+	}
+	if box.Max.X > w/2 {
+		t.Fatalf("watermark at %v reaches into the busy right half", box)
+	}
+	// scaled to a quarter of the width, drawn once
+	if box.Dx() != w/4 || box.Dy() != w/8 {
+		t.Fatalf("watermark covers %v, want a single %dx%d mark", box, w/4, w/8)
+	}
+	// of the flat candidates, the bottom-left corner comes first
+	if box.Min.X > w/10 || box.Max.Y < h*9/10 {
+		t.Errorf("watermark at %v, want the bottom-left corner", box)
+	}
+}
+
+func TestSmartPlacementPrefersBottomRightWhenAllFlat(t *testing.T) {
+	const w, h = 400, 300
+	flat := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range flat.Pix {
+		flat.Pix[i] = 128
+	}
+
+	box := redBounds(newMarkProcessor(true).watermark(flat))
+	if box.Min.X < w/2 || box.Min.Y < h/2 {
+		t.Errorf("watermark at %v, want the bottom-right corner", box)
+	}
+}
+
+func TestTiledWatermarkIgnoresBusyRegion(t *testing.T) {
+	const w, h = 400, 300
+	box := redBounds(newMarkProcessor(false).watermark(busyRightHalf(w, h)))
+
+	if box.Max.X <= w/2 {
+		t.Errorf("tiled watermark at %v, want tiles across the whole image", box)
+	}
+}
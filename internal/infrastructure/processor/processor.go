@@ -1,12 +1,18 @@
 package processor
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
 	"math"
+	"runtime"
+	"slices"
+	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/disintegration/imaging"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/config"
@@ -16,6 +22,16 @@ import (
 type ImageProcessor struct {
 	cfg          *config.ProcessingConfig
 	watermarkImg image.Image
+	// textMark is WatermarkText pre-rendered, used when no image is loaded
+	textMark  image.Image
+	pipelines map[string][]domain.PipelineStep
+
+	// decoders bounds the decodes running under max_decode_sec, abandoned
+	// ones included: a slot is freed when the decoder returns, not when
+	// Decode gives up on it
+	decoders chan struct{}
+	// decodeFn is the decode run under max_decode_sec
+	decodeFn func(r io.Reader, page int) (image.Image, error)
 }
 
 func NewImageProcessor(cfg *config.ProcessingConfig) *ImageProcessor {
@@ -44,7 +60,26 @@ func NewImageProcessor(cfg *config.ProcessingConfig) *ImageProcessor {
 		Str("watermark_text", cfg.WatermarkText).
 		Str("watermark_image", cfg.WatermarkImage).
 		Msg("ImageProcessor initialized")
-	p := &ImageProcessor{cfg: cfg}
+	p := &ImageProcessor{cfg: cfg, pipelines: make(map[string][]domain.PipelineStep, len(cfg.Pipelines))}
+	p.decodeFn = p.decode
+	if cfg.MaxDecodeSec > 0 {
+		n := cfg.MaxConcurrentProcessing
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		p.decoders = make(chan struct{}, n)
+	}
+
+	// steps were validated by config.Load; a bad one here means the config
+	// was built elsewhere, so the pipeline is left out and uploads reject it
+	for name, raw := range cfg.Pipelines {
+		steps, err := domain.ParsePipeline(raw)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Str("pipeline", name).Msg("invalid pipeline, skipping")
+			continue
+		}
+		p.pipelines[name] = steps
+	}
 
 	if cfg.WatermarkImage != "" {
 		img, err := imaging.Open(cfg.WatermarkImage)
@@ -56,6 +91,15 @@ func NewImageProcessor(cfg *config.ProcessingConfig) *ImageProcessor {
 		}
 	}
 
+	if p.watermarkImg == nil && cfg.WatermarkText != "" {
+		mark, err := p.renderConfiguredText()
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("watermark_text", cfg.WatermarkText).Msg("failed to render text watermark")
+		} else {
+			p.textMark = mark
+		}
+	}
+
 	return p
 }
 
@@ -75,10 +119,41 @@ func (p *ImageProcessor) ThumbnailHeight() int {
 	return p.cfg.ThumbnailHeight
 }
 
-func (p *ImageProcessor) Process(r io.Reader, processingType domain.ProcessingType) (image.Image, error) {
-	img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+// OutputQuality is the JPEG quality for processed images, see Quality.
+func (p *ImageProcessor) OutputQuality() int {
+	return Quality(p.cfg.OutputQuality)
+}
+
+// Quality clamps the configured processing.output_quality to 1-100. An
+// unset value keeps the previous default of 95.
+func Quality(q int) int {
+	switch {
+	case q == 0:
+		return 95
+	case q < 1:
+		return 1
+	case q > 100:
+		return 100
+	default:
+		return q
+	}
+}
+
+// Process decodes r and applies the requested operation. ctx is checked
+// between steps so abandoned work stops as soon as the current step ends.
+//
+// EXIF orientation is always applied while decoding, before any operation,
+// so every operation (including a manual rotate) works on the image as it
+// is displayed. The orientation tag is not carried into the output, which
+// keeps viewers from rotating the result a second time.
+func (p *ImageProcessor) Process(ctx context.Context, r io.Reader, processingType domain.ProcessingType, opts domain.ProcessingOptions) (image.Image, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img, err := p.Decode(ctx, r, opts.PageNumber())
 	if err != nil {
-		zlog.Logger.Error().Err(err).Msg("failed to decode image")
+		zlog.Logger.Error().Err(err).Int("page", opts.PageNumber()).Msg("failed to decode image")
 		return nil, fmt.Errorf("decode image: %w", err)
 	}
 	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
@@ -91,17 +166,311 @@ func (p *ImageProcessor) Process(r io.Reader, processingType domain.ProcessingTy
 		Str("processing_type", string(processingType)).
 		Msg("Image decoded successfully")
 
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	img = p.cropToAspect(img)
+
+	var out image.Image
 	switch processingType {
 	case domain.ProcessingResize:
-		return p.resize(img), nil
+		if opts.HasSize() {
+			out = p.fitSize(img, opts)
+		} else {
+			out = p.resize(img)
+		}
 	case domain.ProcessingThumbnail:
-		return p.thumbnail(img), nil
+		if opts.HasSize() {
+			out = p.fitSize(img, opts)
+			// a box with one side left open has no canvas to pad to
+			if width, height := opts.Size(); width > 0 && height > 0 {
+				out = p.padThumbnail(out, width, height)
+			}
+		} else {
+			out = p.thumbnail(img)
+		}
 	case domain.ProcessingWatermark:
-		return p.watermark(img), nil
+		if opts.WatermarkText != nil && *opts.WatermarkText != "" {
+			out, err = p.watermarkWithText(img, *opts.WatermarkText)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			out = p.watermark(img)
+		}
+	case domain.ProcessingCrop:
+		out = p.crop(img, p.cfg.CropWidth, p.cfg.CropHeight)
+	case domain.ProcessingDenoise:
+		out, err = denoise(ctx, img, p.cfg.DenoiseRadius)
+		if err != nil {
+			return nil, err
+		}
+	case domain.ProcessingRoundedCrop:
+		radius := p.cfg.RoundedCropRadius
+		if opts.Radius != nil {
+			radius = *opts.Radius
+		}
+		out = roundedCrop(img, radius)
+	case domain.ProcessingPipeline:
+		out, err = p.runPipeline(ctx, img, opts.PipelineName())
+		if err != nil {
+			return nil, err
+		}
+	case domain.ProcessingRotate:
+		angle := 0
+		if opts.Angle != nil {
+			angle = *opts.Angle
+		}
+		out, err = rotate(img, angle)
+		if err != nil {
+			return nil, err
+		}
+	case domain.ProcessingFlip:
+		axis := domain.FlipHorizontal
+		if opts.Flip != nil {
+			axis = *opts.Flip
+		}
+		out, err = flip(img, axis)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		zlog.Logger.Error().Str("processing_type", string(processingType)).Msg("unknown processing type")
 		return nil, fmt.Errorf("unknown processing type: %v", processingType)
 	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// crop cuts a cropW x cropH region from the center of img. A side larger
+// than the source falls back to the source size on that side.
+func (p *ImageProcessor) crop(img image.Image, cropW, cropH int) image.Image {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if cropW <= 0 || cropW > width {
+		cropW = width
+	}
+	if cropH <= 0 || cropH > height {
+		cropH = height
+	}
+
+	if cropW == width && cropH == height {
+		zlog.Logger.Info().
+			Int("width", width).
+			Int("height", height).
+			Msg("Crop covers the whole image, returning original bounds")
+		return img
+	}
+
+	zlog.Logger.Info().
+		Int("original_width", width).
+		Int("original_height", height).
+		Int("crop_width", cropW).
+		Int("crop_height", cropH).
+		Msg("Cropping image to center region")
+
+	return imaging.CropCenter(img, cropW, cropH)
+}
+
+// rotate turns img clockwise by angle degrees. Only multiples of 90 are
+// accepted, which keeps the rotation lossless.
+func rotate(img image.Image, angle int) (image.Image, error) {
+	normalized := ((angle % 360) + 360) % 360
+	if normalized%90 != 0 {
+		return nil, fmt.Errorf("%w: %d", domain.ErrInvalidAngle, angle)
+	}
+
+	zlog.Logger.Info().Int("angle", normalized).Msg("Rotating image clockwise")
+
+	// imaging rotates counter-clockwise
+	switch normalized {
+	case 90:
+		return imaging.Rotate270(img), nil
+	case 180:
+		return imaging.Rotate180(img), nil
+	case 270:
+		return imaging.Rotate90(img), nil
+	default:
+		return img, nil
+	}
+}
+
+// flip mirrors img across axis.
+func flip(img image.Image, axis domain.FlipAxis) (image.Image, error) {
+	zlog.Logger.Info().Str("axis", string(axis)).Msg("Flipping image")
+
+	switch axis {
+	case domain.FlipHorizontal:
+		return imaging.FlipH(img), nil
+	case domain.FlipVertical:
+		return imaging.FlipV(img), nil
+	case domain.FlipBoth:
+		return imaging.Rotate180(img), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", domain.ErrInvalidFlipAxis, axis)
+	}
+}
+
+// cropToAspect center-crops img to the required aspect ratio when the policy
+// is in crop mode and the image falls outside the tolerance.
+func (p *ImageProcessor) cropToAspect(img image.Image) image.Image {
+	policy := p.cfg.AspectRatioPolicy()
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if !policy.Enabled() || !policy.Crop || policy.Allows(width, height) {
+		return img
+	}
+
+	targetW, targetH := width, height
+	if float64(width)/float64(height) > policy.Ratio {
+		targetW = int(math.Round(float64(height) * policy.Ratio))
+	} else {
+		targetH = int(math.Round(float64(width) / policy.Ratio))
+	}
+	if targetW < 1 || targetH < 1 {
+		return img
+	}
+
+	zlog.Logger.Info().
+		Int("original_width", width).
+		Int("original_height", height).
+		Int("cropped_width", targetW).
+		Int("cropped_height", targetH).
+		Float64("required_aspect_ratio", policy.Ratio).
+		Msg("Image cropped to required aspect ratio")
+
+	return imaging.CropCenter(img, targetW, targetH)
+}
+
+// Decode decodes r like the package Decode, rejecting images over
+// max_pixels before decoding them. With cmyk_color_management, CMYK JPEGs
+// are converted through their embedded ICC profile. A decode running past
+// max_decode_sec is abandoned with domain.ErrDecodeTimeout; it keeps its
+// decoder slot until it returns, so crafted files cannot pile up runaway
+// decoders and new decodes wait for a slot instead. A decode whose ctx ends
+// while it waits for a slot, or while it decodes, is abandoned with the
+// context's error.
+func (p *ImageProcessor) Decode(ctx context.Context, r io.Reader, page int) (image.Image, error) {
+	if p.decoders == nil {
+		return p.decode(r, page)
+	}
+
+	// the decoder gets its own copy, as an abandoned decode keeps reading
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		img image.Image
+		err error
+	}
+	done := make(chan result, 1)
+	select {
+	case p.decoders <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	go func() {
+		defer func() { <-p.decoders }()
+		img, err := p.decodeFn(bytes.NewReader(data), page)
+		done <- result{img, err}
+	}()
+
+	limit := time.Duration(p.cfg.MaxDecodeSec) * time.Second
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.img, res.err
+	case <-timer.C:
+		// a decoder cannot be interrupted; it runs on and its result is dropped
+		return nil, fmt.Errorf("%w: still decoding after %s", domain.ErrDecodeTimeout, limit)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *ImageProcessor) decode(r io.Reader, page int) (image.Image, error) {
+	if !p.cfg.CMYKColorManagement || page > 1 {
+		return DecodeLimit(r, page, p.cfg.MaxPixels)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCMYK(data, p.cfg.MaxPixels)
+}
+
+// CanUseOriginal reports whether the stored original can serve as the
+// processed output unchanged: skip_within_bounds is on, the operation only
+// resizes, and img already fits its target box without needing a crop.
+func (p *ImageProcessor) CanUseOriginal(img image.Image, processingType domain.ProcessingType, opts domain.ProcessingOptions) bool {
+	// the stored original holds every page, not just the selected one
+	if !p.cfg.SkipWithinBounds || opts.PageNumber() > 1 {
+		return false
+	}
+
+	var width, height int
+	switch processingType {
+	case domain.ProcessingResize:
+		width, height = p.cfg.ResizeWidth, p.cfg.ResizeHeight
+	case domain.ProcessingThumbnail:
+		width, height = p.cfg.ThumbnailWidth, p.cfg.ThumbnailHeight
+	default:
+		return false
+	}
+	if opts.HasSize() {
+		width, height = opts.Size()
+	}
+	if _, pad, _ := p.cfg.ThumbnailPadRGBA(); pad && processingType == domain.ProcessingThumbnail && width > 0 && height > 0 {
+		// a padded thumbnail has the exact box size, which the original lacks
+		return false
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if (width > 0 && w > width) || (height > 0 && h > height) {
+		return false
+	}
+
+	policy := p.cfg.AspectRatioPolicy()
+	return !policy.Enabled() || policy.Allows(w, h)
+}
+
+func (p *ImageProcessor) Variants() []config.VariantConfig {
+	return p.cfg.Variants
+}
+
+// Variant renders one configured variant from the decoded original, applying
+// the same aspect policy as the main output.
+func (p *ImageProcessor) Variant(img image.Image, v config.VariantConfig) image.Image {
+	img = p.cropToAspect(img)
+	if p.passThrough(img, v.Width, v.Height) {
+		return img
+	}
+	return imaging.Fit(img, v.Width, v.Height, imaging.Lanczos)
+}
+
+// passThrough reports whether img can be used as is in downscale-only mode:
+// it already fits the target box, so Fit would only copy it.
+func (p *ImageProcessor) passThrough(img image.Image, width, height int) bool {
+	if !p.cfg.DownscaleOnly {
+		return false
+	}
+	if img.Bounds().Dx() > width || img.Bounds().Dy() > height {
+		return false
+	}
+
+	zlog.Logger.Debug().
+		Int("width", img.Bounds().Dx()).
+		Int("height", img.Bounds().Dy()).
+		Int("target_width", width).
+		Int("target_height", height).
+		Msg("Image already fits target, skipping resample")
+	return true
 }
 
 func (p *ImageProcessor) resize(img image.Image) image.Image {
@@ -113,6 +482,10 @@ func (p *ImageProcessor) resize(img image.Image) image.Image {
 		return img
 	}
 
+	if p.passThrough(img, p.cfg.ResizeWidth, p.cfg.ResizeHeight) {
+		return img
+	}
+
 	zlog.Logger.Info().
 		Int("resize_width", p.cfg.ResizeWidth).
 		Int("resize_height", p.cfg.ResizeHeight).
@@ -147,6 +520,10 @@ func (p *ImageProcessor) thumbnail(img image.Image) image.Image {
 		return img
 	}
 
+	if p.passThrough(img, p.cfg.ThumbnailWidth, p.cfg.ThumbnailHeight) {
+		return p.padThumbnail(img, p.cfg.ThumbnailWidth, p.cfg.ThumbnailHeight)
+	}
+
 	zlog.Logger.Info().
 		Int("thumbnail_width", p.cfg.ThumbnailWidth).
 		Int("thumbnail_height", p.cfg.ThumbnailHeight).
@@ -169,73 +546,266 @@ func (p *ImageProcessor) thumbnail(img image.Image) image.Image {
 		Int("thumbnail_height", thumb.Bounds().Dy()).
 		Msg("Thumbnail created successfully with aspect ratio preserved")
 
-	return thumb
+	return p.padThumbnail(thumb, p.cfg.ThumbnailWidth, p.cfg.ThumbnailHeight)
+}
+
+// padThumbnail centers thumb on a w x h canvas filled with the configured
+// pad color; without one thumb is returned as is.
+func (p *ImageProcessor) padThumbnail(thumb image.Image, w, h int) image.Image {
+	// validated by config.Load
+	pad, ok, _ := p.cfg.ThumbnailPadRGBA()
+	if !ok {
+		return thumb
+	}
+	if thumb.Bounds().Dx() == w && thumb.Bounds().Dy() == h {
+		return thumb
+	}
+
+	return imaging.PasteCenter(imaging.New(w, h, pad), thumb)
 }
 
 func (p *ImageProcessor) watermark(img image.Image) image.Image {
+	if p.watermarkImg != nil && p.cfg.WatermarkSmartPlacement {
+		return p.placeMark(img, scaleMark(img, p.watermarkImg))
+	}
 	if p.watermarkImg != nil {
-		bounds := img.Bounds()
-		width := bounds.Dx()
-		height := bounds.Dy()
+		out := p.tileWatermark(img, p.watermarkImg)
 
-		out := imaging.Clone(img)
+		zlog.Logger.Info().Str("watermark", p.cfg.WatermarkImage).Int("opacity", p.cfg.WatermarkOpacity).Msg("Image watermark applied (diagonal image-only)")
 
-		wm := p.watermarkImg
-		wmBounds := wm.Bounds()
-		wmW := wmBounds.Dx()
-		wmH := wmBounds.Dy()
+		return out
+	}
 
-		if wmW == 0 || wmH == 0 {
-			zlog.Logger.Warn().Msg("watermark image has zero size, returning original image")
-			return img
-		}
+	if p.textMark != nil && p.cfg.WatermarkSmartPlacement {
+		return p.placeMark(img, p.textMark)
+	}
+	if p.textMark != nil {
+		out := p.tileMark(img, p.textMark)
 
-		opacity := float64(p.cfg.WatermarkOpacity) / 255.0
-		if opacity < 0 {
-			opacity = 0
-		}
-		if opacity > 1 {
-			opacity = 1
-		}
+		zlog.Logger.Info().Str("watermark_text", p.cfg.WatermarkText).Int("opacity", p.cfg.WatermarkOpacity).Msg("Text watermark applied")
 
-		targetWidth := width / 4
-		if targetWidth < 10 {
-			targetWidth = 10
-		}
-		wmScaled := imaging.Resize(wm, targetWidth, 0, imaging.Lanczos)
+		return out
+	}
 
-		wmRot := imaging.Rotate(wmScaled, -45, color.NRGBA{0, 0, 0, 0})
-		rotW := wmRot.Bounds().Dx()
-		rotH := wmRot.Bounds().Dy()
+	zlog.Logger.Warn().Msg("No watermark image or text configured, returning original image")
+	return img
+}
 
-		diagLen := int(math.Hypot(float64(width), float64(height))) + rotW
-		spacing := rotW/2 + 20
-		if spacing < 10 {
-			spacing = 10
-		}
-		step := rotW + spacing
-		count := diagLen/step + 2
-		if count < 1 {
-			count = 1
-		}
+// tileWatermark scales wm to a quarter of the image width, rotates it and
+// repeats it along the diagonal with the configured opacity.
+func (p *ImageProcessor) tileWatermark(img image.Image, wm image.Image) image.Image {
+	if wm.Bounds().Dx() == 0 || wm.Bounds().Dy() == 0 {
+		zlog.Logger.Warn().Msg("watermark image has zero size, returning original image")
+		return img
+	}
+	return p.tileMark(img, scaleMark(img, wm))
+}
 
-		for i := 0; i <= count; i++ {
-			t := float64(i) / float64(count)
-			posX := int((1.0-t)*float64(-rotW) + t*float64(width))
-			posY := int((1.0-t)*float64(-rotH) + t*float64(height))
-			out = imaging.Overlay(out, wmRot, image.Pt(posX, posY), opacity)
-		}
+// scaleMark resizes an image watermark to a quarter of the image width.
+func scaleMark(img image.Image, wm image.Image) image.Image {
+	targetWidth := img.Bounds().Dx() / 4
+	if targetWidth < 10 {
+		targetWidth = 10
+	}
+	return imaging.Resize(wm, targetWidth, 0, imaging.Lanczos)
+}
 
-		zlog.Logger.Info().Str("watermark", p.cfg.WatermarkImage).Int("opacity", p.cfg.WatermarkOpacity).Msg("Image watermark applied (diagonal image-only)")
+// tileMark rotates wm as is and repeats it along the diagonal with the
+// configured opacity.
+func (p *ImageProcessor) tileMark(img image.Image, wm image.Image) image.Image {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	height := bounds.Dy()
 
-		return out
+	out := imaging.Clone(img)
+
+	opacity := p.watermarkOpacity()
+
+	wmRot := imaging.Rotate(wm, -45, color.NRGBA{0, 0, 0, 0})
+	rotW := wmRot.Bounds().Dx()
+	rotH := wmRot.Bounds().Dy()
+
+	diagLen := int(math.Hypot(float64(width), float64(height))) + rotW
+	spacing := rotW/2 + 20
+	if spacing < 10 {
+		spacing = 10
+	}
+	step := rotW + spacing
+	count := diagLen/step + 2
+	if count < 1 {
+		count = 1
 	}
 
-	zlog.Logger.Warn().Msg("No image watermark configured — image watermarking is required. Returning original image (no text watermark)")
-	return img
+	for i := 0; i <= count; i++ {
+		t := float64(i) / float64(count)
+		posX := int((1.0-t)*float64(-rotW) + t*float64(width))
+		posY := int((1.0-t)*float64(-rotH) + t*float64(height))
+		out = imaging.Overlay(out, wmRot, image.Pt(posX, posY), opacity)
+	}
+
+	return out
+}
+
+// watermarkOpacity is the configured opacity as a 0..1 factor.
+func (p *ImageProcessor) watermarkOpacity() float64 {
+	opacity := float64(p.cfg.WatermarkOpacity) / 255.0
+	if opacity < 0 {
+		opacity = 0
+	}
+	if opacity > 1 {
+		opacity = 1
+	}
+	return opacity
+}
+
+// MaxConcurrentProcessing is how many images may be processed at once
+// across all consumers; 0 means no limit beyond the consumer pools.
+func (p *ImageProcessor) MaxConcurrentProcessing() int {
+	return p.cfg.MaxConcurrentProcessing
+}
+
+// MaxCompressionRatio is the processed-to-original size ratio above which a
+// downscaled result is flagged; 0 disables the check.
+func (p *ImageProcessor) MaxCompressionRatio() float64 {
+	return p.cfg.MaxCompressionRatio
+}
+
+// MaxPixels bounds width*height of decoded images; 0 means no limit.
+func (p *ImageProcessor) MaxPixels() int64 {
+	return p.cfg.MaxPixels
+}
+
+// VariantLease is how long a processed variant may stay claimed before
+// another worker may take it over.
+func (p *ImageProcessor) VariantLease() time.Duration {
+	if p.cfg.VariantLeaseSec == 0 {
+		return 30 * time.Minute
+	}
+	return time.Duration(p.cfg.VariantLeaseSec) * time.Second
+}
+
+func (p *ImageProcessor) BlurhashEnabled() bool {
+	return p.cfg.BlurhashEnabled
+}
+
+func (p *ImageProcessor) LQIPEnabled() bool {
+	return p.cfg.LQIPEnabled
+}
+
+// StripMetadata reports whether processed images must not carry the
+// original's EXIF, XMP, IPTC or comments.
+func (p *ImageProcessor) StripMetadata() bool {
+	return p.cfg.StripMetadata
+}
+
+func (p *ImageProcessor) RepairCorruptImages() bool {
+	return p.cfg.RepairCorruptImages
+}
+
+// PreserveColorProfile is true with preserve_color_profile or with
+// icc_profile listed in preserve_metadata.
+func (p *ImageProcessor) PreserveColorProfile() bool {
+	return p.cfg.PreserveColorProfile || slices.Contains(p.cfg.PreserveMetadata, MetadataICCProfile)
+}
+
+// PreserveMetadata lists the metadata copied from originals to output.
+func (p *ImageProcessor) PreserveMetadata() []string {
+	return p.cfg.PreserveMetadata
+}
+
+// OutputFormat is the format processed images are encoded in. With
+// output_format "original", it follows the original's filename extension.
+func (p *ImageProcessor) OutputFormat(originalFilename string, processingType domain.ProcessingType) imaging.Format {
+	if processingType == domain.ProcessingRoundedCrop {
+		// the masked corners need an alpha channel
+		return imaging.PNG
+	}
+	switch p.cfg.OutputFormat {
+	case "":
+		return imaging.JPEG
+	case OriginalFormat:
+		return SourceFormat(originalFilename)
+	}
+	format, err := ParseOutputFormat(p.cfg.OutputFormat)
+	if err != nil {
+		return imaging.JPEG
+	}
+	return format
+}
+
+// ShouldFlatten resolves the per-request flatten override against the
+// configured default. The default only applies to JPEG output, so formats
+// that can carry transparency keep it unless a request asks otherwise.
+func (p *ImageProcessor) ShouldFlatten(opts domain.ProcessingOptions, format imaging.Format) bool {
+	if opts.Flatten != nil {
+		return *opts.Flatten
+	}
+	return p.cfg.FlattenAlpha && format == imaging.JPEG
+}
+
+// Flatten composites img onto an opaque white background, dropping the alpha channel.
+func Flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	bg := imaging.New(bounds.Dx(), bounds.Dy(), color.White)
+	return imaging.Overlay(bg, img, image.Pt(0, 0), 1.0)
 }
 
 func GetImageDimensions(img image.Image) (width, height int) {
 	bounds := img.Bounds()
 	return bounds.Dx(), bounds.Dy()
 }
+
+type EncodeOptions struct {
+	Quality int
+	Flatten bool
+	// ICCProfile is embedded in JPEG and PNG output, see ICCProfile;
+	// other formats cannot carry it and are written without.
+	ICCProfile []byte
+	// Exif is an EXIF block from PreservedExif, embedded like ICCProfile.
+	Exif []byte
+}
+
+// Encode writes img to w in the given format. Writing straight to the
+// destination (e.g. an HTTP response) avoids holding the whole result in memory.
+func Encode(w io.Writer, img image.Image, format imaging.Format, opts EncodeOptions) error {
+	if opts.Flatten {
+		img = Flatten(img)
+	}
+
+	if format == WebP {
+		// lossless, so Quality does not apply; metadata is not embedded
+		if err := nativewebp.Encode(w, img, nil); err != nil {
+			return fmt.Errorf("encode webp: %w", err)
+		}
+		return nil
+	}
+
+	var encodeOpts []imaging.EncodeOption
+	if format == imaging.JPEG && opts.Quality > 0 {
+		encodeOpts = append(encodeOpts, imaging.JPEGQuality(opts.Quality))
+	}
+
+	if (len(opts.ICCProfile) > 0 || len(opts.Exif) > 0) && (format == imaging.JPEG || format == imaging.PNG) {
+		// the encoders cannot write a profile or EXIF, so they are spliced in afterwards
+		var buf bytes.Buffer
+		if err := imaging.Encode(&buf, img, format, encodeOpts...); err != nil {
+			return fmt.Errorf("encode %s: %w", format, err)
+		}
+		data := buf.Bytes()
+		if len(opts.ICCProfile) > 0 {
+			data = embedICCProfile(data, opts.ICCProfile, format)
+		}
+		if len(opts.Exif) > 0 {
+			data = embedExif(data, opts.Exif, format)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("encode %s: %w", format, err)
+		}
+		return nil
+	}
+
+	if err := imaging.Encode(w, img, format, encodeOpts...); err != nil {
+		return fmt.Errorf("encode %s: %w", format, err)
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestDecodeTimeoutHoldsSlotUntilDecoderReturns(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{MaxDecodeSec: 1, MaxConcurrentProcessing: 1})
+
+	release := make(chan struct{})
+	p.decodeFn = func(r io.Reader, page int) (image.Image, error) {
+		// a pathological input the decoder cannot get through in time
+		<-release
+		return image.NewGray(image.Rect(0, 0, 1, 1)), nil
+	}
+
+	_, err := p.Decode(context.Background(), bytes.NewReader([]byte("stall")), 1)
+	if !errors.Is(err, domain.ErrDecodeTimeout) {
+		t.Fatalf("err = %v, want ErrDecodeTimeout", err)
+	}
+
+	// the abandoned decoder still holds the only slot
+	next := make(chan error, 1)
+	go func() {
+		_, err := p.Decode(context.Background(), bytes.NewReader([]byte("next")), 1)
+		next <- err
+	}()
+	select {
+	case err := <-next:
+		t.Fatalf("second decode returned %v while the abandoned one held the slot", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-next:
+		if err != nil {
+			t.Fatalf("second decode: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second decode did not start after the slot was freed")
+	}
+}
+
+func TestDecodeWaitingForSlotEndsWithContext(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{MaxDecodeSec: 60, MaxConcurrentProcessing: 1})
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	p.decodeFn = func(r io.Reader, page int) (image.Image, error) {
+		close(started)
+		<-release
+		return image.NewGray(image.Rect(0, 0, 1, 1)), nil
+	}
+	go p.Decode(context.Background(), bytes.NewReader([]byte("stall")), 1)
+	<-started
+
+	// the only slot is taken; a request that goes away must not wait for it
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Decode(ctx, bytes.NewReader([]byte("next")), 1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Decode kept waiting for a slot after its context ended")
+	}
+}
+
+func TestDecodeWithoutTimeoutDecodesDirectly(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{})
+
+	img, err := p.Decode(context.Background(), bytes.NewReader(encodeGrayPNG(t, 3, 2)), 1)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 3 || b.Dy() != 2 {
+		t.Fatalf("bounds = %v, want 3x2", b)
+	}
+}
+
+func TestThumbnailPaddedOnBothSizeBranches(t *testing.T) {
+	side := func(n int) *int { return &n }
+	tests := []struct {
+		name          string
+		opts          domain.ProcessingOptions
+		width, height int
+	}{
+		{name: "configured size", width: 60, height: 60},
+		{name: "requested size", opts: domain.ProcessingOptions{Width: side(40), Height: side(40)}, width: 40, height: 40},
+		// a single side leaves no box to pad to
+		{name: "requested width only", opts: domain.ProcessingOptions{Width: side(40)}, width: 40, height: 20},
+	}
+
+	p := NewImageProcessor(&config.ProcessingConfig{ThumbnailWidth: 60, ThumbnailHeight: 60, ThumbnailPadColor: "#ff0000"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a black 2:1 source
+			out, err := p.Process(context.Background(), bytes.NewReader(encodeGrayPNG(t, 200, 100)), domain.ProcessingThumbnail, tt.opts)
+			if err != nil {
+				t.Fatalf("Process: %v", err)
+			}
+			if b := out.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+				t.Fatalf("bounds = %v, want %dx%d", b, tt.width, tt.height)
+			}
+			if tt.width != tt.height {
+				return
+			}
+			b := out.Bounds()
+			if r, g, _, _ := out.At(b.Min.X, b.Min.Y).RGBA(); r>>8 != 0xff || g>>8 != 0 {
+				t.Errorf("corner is not the pad color")
+			}
+			if r, _, _, _ := out.At(b.Min.X+b.Dx()/2, b.Min.Y+b.Dy()/2).RGBA(); r>>8 > 0x10 {
+				t.Errorf("center is not the black source")
+			}
+		})
+	}
+}
+
+func TestCanUseOriginalNotForPaddedThumbnail(t *testing.T) {
+	side := func(n int) *int { return &n }
+	tests := []struct {
+		name string
+		opts domain.ProcessingOptions
+		want bool
+	}{
+		{name: "configured size"},
+		{name: "requested size", opts: domain.ProcessingOptions{Width: side(80), Height: side(80)}},
+		// a single side leaves no box to pad to
+		{name: "requested width only", opts: domain.ProcessingOptions{Width: side(80)}, want: true},
+	}
+
+	p := NewImageProcessor(&config.ProcessingConfig{ThumbnailWidth: 60, ThumbnailHeight: 60, ThumbnailPadColor: "#ff0000", SkipWithinBounds: true})
+	// fits every box above, but is no box's exact size
+	img := image.NewGray(image.Rect(0, 0, 40, 20))
+	for _, tt := range tests {
+		if got := p.CanUseOriginal(img, domain.ProcessingThumbnail, tt.opts); got != tt.want {
+			t.Errorf("%s: CanUseOriginal = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanUseOriginal(t *testing.T) {
+	side := func(n int) *int { return &n }
+	page := func(n int) *int { return &n }
+	base := config.ProcessingConfig{ResizeWidth: 100, ResizeHeight: 100, ThumbnailWidth: 30, ThumbnailHeight: 30, SkipWithinBounds: true}
+	tests := []struct {
+		name   string
+		mutate func(cfg *config.ProcessingConfig)
+		pt     domain.ProcessingType
+		opts   domain.ProcessingOptions
+		w, h   int
+		want   bool
+	}{
+		{name: "inside resize bounds", pt: domain.ProcessingResize, w: 80, h: 100, want: true},
+		{name: "wider than resize bounds", pt: domain.ProcessingResize, w: 101, h: 50},
+		{name: "taller than resize bounds", pt: domain.ProcessingResize, w: 50, h: 101},
+		{name: "outside thumbnail bounds", pt: domain.ProcessingThumbnail, w: 40, h: 20},
+		{name: "inside thumbnail bounds", pt: domain.ProcessingThumbnail, w: 30, h: 20, want: true},
+		{name: "inside requested size", pt: domain.ProcessingResize, opts: domain.ProcessingOptions{Width: side(50)}, w: 50, h: 300, want: true},
+		{name: "outside requested size", pt: domain.ProcessingResize, opts: domain.ProcessingOptions{Width: side(40), Height: side(40)}, w: 50, h: 30},
+		{name: "not a resize", pt: domain.ProcessingWatermark, w: 10, h: 10},
+		{name: "disabled", mutate: func(cfg *config.ProcessingConfig) { cfg.SkipWithinBounds = false }, pt: domain.ProcessingResize, w: 10, h: 10},
+		{name: "would be cropped to aspect", mutate: func(cfg *config.ProcessingConfig) {
+			cfg.RequiredAspect, cfg.AspectTolerance, cfg.AspectMode = 1, 0.01, "crop"
+		}, pt: domain.ProcessingResize, w: 80, h: 40},
+		{name: "padded thumbnail", mutate: func(cfg *config.ProcessingConfig) { cfg.ThumbnailPadColor = "#ffffff" }, pt: domain.ProcessingThumbnail, w: 30, h: 20},
+		{name: "page 1", pt: domain.ProcessingResize, opts: domain.ProcessingOptions{Page: page(1)}, w: 10, h: 10, want: true},
+	}
+	for _, tt := range tests {
+		cfg := base
+		if tt.mutate != nil {
+			tt.mutate(&cfg)
+		}
+		p := NewImageProcessor(&cfg)
+		img := image.NewGray(image.Rect(0, 0, tt.w, tt.h))
+		if got := p.CanUseOriginal(img, tt.pt, tt.opts); got != tt.want {
+			t.Errorf("%s: CanUseOriginal = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCanUseOriginalNotForLaterTIFFPage(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 100, ResizeHeight: 100, SkipWithinBounds: true})
+	data := threePageTIFF(t)
+
+	for _, tt := range []struct {
+		page int
+		want bool
+	}{{1, true}, {2, false}, {3, false}} {
+		img, err := p.Decode(context.Background(), bytes.NewReader(data), tt.page)
+		if err != nil {
+			t.Fatalf("Decode page %d: %v", tt.page, err)
+		}
+		// every page fits, but the stored original is the whole file
+		page := tt.page
+		if got := p.CanUseOriginal(img, domain.ProcessingResize, domain.ProcessingOptions{Page: &page}); got != tt.want {
+			t.Errorf("page %d: CanUseOriginal = %v, want %v", tt.page, got, tt.want)
+		}
+	}
+}
+
+func TestOutputQualityDrivesJPEGSize(t *testing.T) {
+	img := gradient(256, 192)
+	sizes := map[int]int{}
+	for _, quality := range []int{10, 95} {
+		p := NewImageProcessor(&config.ProcessingConfig{OutputQuality: quality})
+		var buf bytes.Buffer
+		if err := Encode(&buf, img, imaging.JPEG, EncodeOptions{Quality: p.OutputQuality()}); err != nil {
+			t.Fatalf("Encode at %d: %v", quality, err)
+		}
+		sizes[quality] = buf.Len()
+	}
+	if sizes[10] >= sizes[95] {
+		t.Fatalf("quality 10 gives %d bytes, quality 95 gives %d; want the lower quality smaller", sizes[10], sizes[95])
+	}
+}
+
+func TestQualityClamps(t *testing.T) {
+	for _, tt := range []struct{ in, want int }{
+		{0, 95}, // unset keeps the old default
+		{-5, 1},
+		{1, 1},
+		{10, 10},
+		{100, 100},
+		{150, 100},
+	} {
+		if got := Quality(tt.in); got != tt.want {
+			t.Errorf("Quality(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestProcessStopsWhenCancelledMidway(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 8, ResizeHeight: 8, MaxDecodeSec: 5, MaxConcurrentProcessing: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	decoded := false
+	p.decodeFn = func(r io.Reader, page int) (image.Image, error) {
+		// the client goes away while the image is being decoded
+		decoded = true
+		cancel()
+		return image.NewGray(image.Rect(0, 0, 64, 64)), nil
+	}
+
+	img, err := p.Process(ctx, bytes.NewReader(encodeGrayPNG(t, 64, 64)), domain.ProcessingResize, domain.ProcessingOptions{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Process = %v, %v; want context.Canceled", img, err)
+	}
+	if !decoded {
+		t.Fatal("decoder never ran")
+	}
+}
+
+func TestDownscaleOnlyPassesSmallImagesThrough(t *testing.T) {
+	tests := []struct {
+		name          string
+		downscaleOnly bool
+		w, h          int
+		wantSame      bool
+		wantW, wantH  int
+	}{
+		{name: "fits the box", downscaleOnly: true, w: 40, h: 30, wantSame: true, wantW: 40, wantH: 30},
+		{name: "exactly the box", downscaleOnly: true, w: 64, h: 64, wantSame: true, wantW: 64, wantH: 64},
+		{name: "one side over", downscaleOnly: true, w: 128, h: 32, wantW: 64, wantH: 16},
+		{name: "mode off", w: 40, h: 30, wantW: 40, wantH: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64, DownscaleOnly: tt.downscaleOnly})
+			src := gradient(tt.w, tt.h)
+
+			out := p.resize(src)
+			if same := out == image.Image(src); same != tt.wantSame {
+				t.Fatalf("passed through = %v, want %v", same, tt.wantSame)
+			}
+			if b := out.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Fatalf("bounds = %v, want %dx%d", b, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestDownscaleOnlyKeepsPixelsThroughProcess(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64, DownscaleOnly: true})
+	src := gradient(40, 30)
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, src, imaging.PNG); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+
+	out, err := p.Process(context.Background(), &buf, domain.ProcessingResize, domain.ProcessingOptions{})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if !bytes.Equal(imaging.Clone(out).Pix, src.Pix) {
+		t.Fatal("a small image was resampled in downscale-only mode")
+	}
+}
+
+func TestRotateAppliesAfterExifOrientation(t *testing.T) {
+	// stored 40x20 with orientation 6, so it is displayed 20x40 turned clockwise
+	orientation := exifTIFF(binary.BigEndian, []exifEntry{{0x0112, 3, []byte{0, 6}}})
+	jpg := jpegSegment(encodeTestJPEG(t, 40, 20), 0xE1, append([]byte("Exif\x00\x00"), orientation...))
+	p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64})
+
+	angle := 90
+	out, err := p.Process(context.Background(), bytes.NewReader(jpg), domain.ProcessingRotate, domain.ProcessingOptions{Angle: &angle})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if b := out.Bounds(); b.Dx() != 40 || b.Dy() != 20 {
+		t.Fatalf("bounds = %v, want 40x20 after both quarter turns", b)
+	}
+
+	// two clockwise quarter turns: the stored bottom-right corner, where red
+	// and green peak, ends up top-left
+	r, g, _, _ := out.At(1, 1).RGBA()
+	if r>>8 < 200 || g>>8 < 200 {
+		t.Fatalf("top-left = r %d, g %d; want the stored bottom-right corner", r>>8, g>>8)
+	}
+	r, g, _, _ = out.At(38, 18).RGBA()
+	if r>>8 > 50 || g>>8 > 50 {
+		t.Fatalf("bottom-right = r %d, g %d; want the stored top-left corner", r>>8, g>>8)
+	}
+}
+
+func TestRotateRejectsAnglesOffTheQuarterTurn(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64})
+	for _, angle := range []int{45, -100} {
+		_, err := p.Process(context.Background(), bytes.NewReader(encodeTestJPEG(t, 8, 8)), domain.ProcessingRotate, domain.ProcessingOptions{Angle: &angle})
+		if !errors.Is(err, domain.ErrInvalidAngle) {
+			t.Errorf("angle %d: err = %v, want ErrInvalidAngle", angle, err)
+		}
+	}
+	for _, angle := range []int{-90, 450} {
+		out, err := p.Process(context.Background(), bytes.NewReader(encodeTestJPEG(t, 8, 4)), domain.ProcessingRotate, domain.ProcessingOptions{Angle: &angle})
+		if err != nil {
+			t.Fatalf("angle %d: %v", angle, err)
+		}
+		if b := out.Bounds(); b.Dx() != 4 || b.Dy() != 8 {
+			t.Errorf("angle %d: bounds = %v, want 4x8", angle, b)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"bytes"
+	"image/jpeg"
+)
+
+// repairBytesPerBlock is how much zero padding RepairJPEG budgets for every
+// 8x8 block the scan may still be missing. Zero bits decode as the shortest
+// codes of the standard tables, which take about three bits per coefficient.
+const repairBytesPerBlock = 24
+
+// maxRepairPadding caps the padding, and so the copy, RepairJPEG allocates.
+// The block count comes from the header, which a damaged or hostile file
+// can set to 65535x65535 (about 6 GB of padding) whatever max_pixels says;
+// 64 MiB covers photos of about 40 megapixels.
+const maxRepairPadding = 64 << 20
+
+// RepairJPEG returns a copy of a truncated JPEG that decodes to the end:
+// the missing part of the scan is padded with zero bits and the end marker
+// is restored, so the image decodes with its lost tail in flat gray. It
+// returns false for anything else, including JPEGs that end properly or
+// lost their headers, as no padding brings those back, and ones whose
+// header asks for more than maxRepairPadding.
+func RepairJPEG(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, jpegMagic) || bytes.HasSuffix(data, []byte{0xFF, 0xD9}) {
+		return nil, false
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+
+	// four components covers CMYK; subsampled chroma needs less
+	blocks := int64((cfg.Width+7)/8) * int64((cfg.Height+7)/8) * 4
+	padding := blocks * repairBytesPerBlock
+	if padding > maxRepairPadding {
+		return nil, false
+	}
+	repaired := make([]byte, len(data), len(data)+int(padding)+2)
+	copy(repaired, data)
+	repaired = repaired[:cap(repaired)-2]
+	return append(repaired, 0xFF, 0xD9), true
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func encodeTestJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 90, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRepairJPEGRecoversTruncatedScan(t *testing.T) {
+	full := encodeTestJPEG(t, 64, 48)
+	truncated := full[:len(full)*3/4]
+	if _, err := jpeg.Decode(bytes.NewReader(truncated)); err == nil {
+		t.Fatal("truncated JPEG decodes without repair")
+	}
+
+	repaired, ok := RepairJPEG(truncated)
+	if !ok {
+		t.Fatal("RepairJPEG refused a truncated JPEG")
+	}
+	img, err := jpeg.Decode(bytes.NewReader(repaired))
+	if err != nil {
+		t.Fatalf("repaired JPEG does not decode: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("repaired JPEG is %v", img.Bounds())
+	}
+
+	// the part that survived keeps its pixels
+	want, _ := jpeg.Decode(bytes.NewReader(full))
+	wr, wg, wb, _ := want.At(4, 4).RGBA()
+	gr, gg, gb, _ := img.At(4, 4).RGBA()
+	if wr != gr || wg != gg || wb != gb {
+		t.Fatalf("top-left pixel changed by the repair")
+	}
+}
+
+func TestRepairJPEGRejectsIntactAndOversizedFiles(t *testing.T) {
+	full := encodeTestJPEG(t, 64, 48)
+	if _, ok := RepairJPEG(full); ok {
+		t.Fatal("RepairJPEG accepted a complete JPEG")
+	}
+	if _, ok := RepairJPEG([]byte("not a jpeg at all")); ok {
+		t.Fatal("RepairJPEG accepted a non-JPEG")
+	}
+
+	// a truncated file whose header claims 65535x65535 must not get the
+	// gigabytes of padding those dimensions would take
+	huge := bytes.Clone(full[:len(full)*3/4])
+	sof := bytes.Index(huge, []byte{0xFF, 0xC0})
+	if sof < 0 {
+		t.Fatal("no SOF0 marker")
+	}
+	binary.BigEndian.PutUint16(huge[sof+5:], 65535)
+	binary.BigEndian.PutUint16(huge[sof+7:], 65535)
+	if _, ok := RepairJPEG(huge); ok {
+		t.Fatal("RepairJPEG accepted a 65535x65535 header")
+	}
+}
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+)
+
+// roundedCrop masks the corners of img with the given radius in pixels,
+// leaving them transparent. A radius of 0 or less cuts the largest
+// centered circle instead. Edges are anti-aliased by pixel coverage.
+func roundedCrop(img image.Image, radius int) *image.NRGBA {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var r float64
+	if radius <= 0 {
+		side := min(width, height)
+		img = imaging.CropCenter(img, side, side)
+		width, height = side, side
+		r = float64(side) / 2
+	} else {
+		r = math.Min(float64(radius), float64(min(width, height))/2)
+	}
+
+	zlog.Logger.Info().
+		Int("width", width).
+		Int("height", height).
+		Float64("radius", r).
+		Bool("circle", radius <= 0).
+		Msg("Masking image corners")
+
+	dst := imaging.Clone(img)
+	w, h := float64(width), float64(height)
+	for y := 0; y < height; y++ {
+		py := float64(y) + 0.5
+		cy, inY := cornerCenter(py, h, r)
+		if !inY {
+			continue
+		}
+		for x := 0; x < width; x++ {
+			px := float64(x) + 0.5
+			cx, inX := cornerCenter(px, w, r)
+			if !inX {
+				continue
+			}
+
+			coverage := r - math.Hypot(px-cx, py-cy) + 0.5
+			if coverage >= 1 {
+				continue
+			}
+			i := y*dst.Stride + x*4 + 3
+			if coverage <= 0 {
+				dst.Pix[i] = 0
+			} else {
+				dst.Pix[i] = uint8(float64(dst.Pix[i]) * coverage)
+			}
+		}
+	}
+	return dst
+}
+
+// cornerCenter returns the center coordinate of the corner arc covering p
+// along an axis of the given length, and false when p is outside both
+// corner bands.
+func cornerCenter(p, length, r float64) (float64, bool) {
+	switch {
+	case p < r:
+		return r, true
+	case p > length-r:
+		return length - r, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func opaqueImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{200, 120, 40, 255})
+	}
+	return img
+}
+
+func alphaAt(img image.Image, x, y int) uint8 {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA).A
+}
+
+func TestRoundedCropCircleHasTransparentCorners(t *testing.T) {
+	out := roundedCrop(opaqueImage(120, 80), 0)
+
+	if b := out.Bounds(); b.Dx() != 80 || b.Dy() != 80 {
+		t.Fatalf("bounds = %v, want the centered 80x80 square", b)
+	}
+	for _, p := range []image.Point{{0, 0}, {79, 0}, {0, 79}, {79, 79}, {8, 8}} {
+		if a := alphaAt(out, p.X, p.Y); a != 0 {
+			t.Errorf("corner %v alpha = %d, want 0", p, a)
+		}
+	}
+	for _, p := range []image.Point{{40, 40}, {40, 1}, {1, 40}, {78, 40}, {40, 78}} {
+		if a := alphaAt(out, p.X, p.Y); a != 255 {
+			t.Errorf("inside %v alpha = %d, want 255", p, a)
+		}
+	}
+}
+
+func TestRoundedCropRadiusKeepsSize(t *testing.T) {
+	out := roundedCrop(opaqueImage(100, 60), 10)
+
+	if b := out.Bounds(); b.Dx() != 100 || b.Dy() != 60 {
+		t.Fatalf("bounds = %v, want 100x60", b)
+	}
+	for _, p := range []image.Point{{0, 0}, {99, 0}, {0, 59}, {99, 59}} {
+		if a := alphaAt(out, p.X, p.Y); a != 0 {
+			t.Errorf("corner %v alpha = %d, want 0", p, a)
+		}
+	}
+	// beyond the radius the edges are untouched
+	for _, p := range []image.Point{{10, 0}, {0, 10}, {50, 0}, {99, 30}, {50, 59}} {
+		if a := alphaAt(out, p.X, p.Y); a != 255 {
+			t.Errorf("edge %v alpha = %d, want 255", p, a)
+		}
+	}
+}
+
+func TestProcessRoundedCropEncodesPNG(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{OutputFormat: "jpeg"})
+	if f := p.OutputFormat("a.jpg", domain.ProcessingRoundedCrop); f != imaging.PNG {
+		t.Fatalf("OutputFormat = %v, want PNG for rounded_crop", f)
+	}
+
+	var src bytes.Buffer
+	if err := png.Encode(&src, opaqueImage(64, 64)); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	circle := 0
+	out, err := p.Process(context.Background(), &src, domain.ProcessingRoundedCrop, domain.ProcessingOptions{Radius: &circle})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if a := alphaAt(out, 0, 0); a != 0 {
+		t.Errorf("corner alpha = %d, want 0", a)
+	}
+	if a := alphaAt(out, 32, 32); a != 255 {
+		t.Errorf("center alpha = %d, want 255", a)
+	}
+}
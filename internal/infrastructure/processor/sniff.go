@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// SniffLen is how many leading bytes SniffFormat looks at.
+const SniffLen = 512
+
+// SniffFormat names the format of an image from its leading bytes, as the
+// canonical extension without the dot ("jpg", "png", "gif", "tiff", "webp"
+// or "bmp"), or returns "" when the bytes are not an image format.
+func SniffFormat(head []byte) string {
+	// http.DetectContentType does not know TIFF
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return "tiff"
+	}
+	switch http.DetectContentType(head) {
+	case "image/jpeg":
+		return "jpg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	case "image/bmp":
+		return "bmp"
+	default:
+		return ""
+	}
+}
+
+// CanonicalExtension maps a file extension, with or without the dot, to
+// the name SniffFormat uses for its format.
+func CanonicalExtension(ext string) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	switch ext {
+	case "jpeg", "jpe", "jfif":
+		return "jpg"
+	case "tif":
+		return "tiff"
+	default:
+		return ext
+	}
+}
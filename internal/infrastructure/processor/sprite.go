@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+const (
+	// MaxSpriteSide bounds both the inputs and the packed sheet.
+	MaxSpriteSide = 4096
+	// spritePadding keeps neighbouring sprites from bleeding into each
+	// other when the sheet is sampled with filtering.
+	spritePadding = 2
+)
+
+// PackSprites lays out rectangles of the given sizes with shelf packing:
+// tallest first, left to right, starting a new row when the next one would
+// pass a width chosen to keep the sheet roughly square. The returned
+// rectangles are in input order.
+func PackSprites(sizes []image.Point) ([]image.Rectangle, image.Point, error) {
+	if len(sizes) == 0 {
+		return nil, image.Point{}, fmt.Errorf("no sprites to pack")
+	}
+
+	area, widest := 0, 0
+	for _, s := range sizes {
+		if s.X <= 0 || s.Y <= 0 {
+			return nil, image.Point{}, fmt.Errorf("sprite has empty size %dx%d", s.X, s.Y)
+		}
+		area += (s.X + spritePadding) * (s.Y + spritePadding)
+		widest = max(widest, s.X)
+	}
+	rowWidth := max(widest, int(math.Ceil(math.Sqrt(float64(area)))))
+
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return sizes[order[a]].Y > sizes[order[b]].Y
+	})
+
+	rects := make([]image.Rectangle, len(sizes))
+	x, y, shelf := 0, 0, 0
+	var sheet image.Point
+	for _, i := range order {
+		s := sizes[i]
+		if x > 0 && x+s.X > rowWidth {
+			x, y = 0, y+shelf+spritePadding
+			shelf = 0
+		}
+		rects[i] = image.Rect(x, y, x+s.X, y+s.Y)
+		x += s.X + spritePadding
+		shelf = max(shelf, s.Y)
+		sheet.X = max(sheet.X, rects[i].Max.X)
+		sheet.Y = max(sheet.Y, rects[i].Max.Y)
+	}
+
+	if sheet.X > MaxSpriteSide || sheet.Y > MaxSpriteSide {
+		return nil, image.Point{}, fmt.Errorf("%w: %dx%d, limit %d per side", domain.ErrSpriteTooLarge, sheet.X, sheet.Y, MaxSpriteSide)
+	}
+	return rects, sheet, nil
+}
+
+// ComposeSprite draws each image at its rectangle on a transparent sheet.
+func ComposeSprite(imgs []image.Image, rects []image.Rectangle, size image.Point) *image.NRGBA {
+	sheet := imaging.New(size.X, size.Y, image.Transparent)
+	for i, img := range imgs {
+		sheet = imaging.Paste(sheet, img, rects[i].Min)
+	}
+	return sheet
+}
@@ -0,0 +1,162 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+var (
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// ValidateStructure walks the container structure of a JPEG, PNG or GIF
+// and rejects files that carry anything past the format's end marker,
+// which is where polyglots (an image that is also an archive, script or
+// HTML page) keep their second payload. Other formats are not checked.
+func ValidateStructure(data []byte) error {
+	var end int
+	var err error
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		end, err = jpegEnd(data)
+	case bytes.HasPrefix(data, pngMagic):
+		end, err = pngEnd(data)
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		end, err = gifEnd(data)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidImageStructure, err)
+	}
+
+	// some encoders pad the file; padding carries no payload
+	for _, b := range data[end:] {
+		if b != 0x00 && b != 0xFF {
+			return fmt.Errorf("%w: %d bytes after the end of the image", domain.ErrInvalidImageStructure, len(data)-end)
+		}
+	}
+	return nil
+}
+
+// jpegEnd returns the offset just past the EOI marker.
+func jpegEnd(data []byte) (int, error) {
+	i := 2
+	for {
+		// markers may be preceded by any number of 0xFF fill bytes
+		for i < len(data) && data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return 0, fmt.Errorf("jpeg: missing marker at offset %d", i)
+		}
+		marker := data[i+1]
+		i += 2
+
+		switch {
+		case marker == 0xD9: // EOI
+			return i, nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// standalone markers
+			continue
+		}
+
+		if i+2 > len(data) {
+			return 0, fmt.Errorf("jpeg: truncated segment at offset %d", i)
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return 0, fmt.Errorf("jpeg: bad segment length at offset %d", i)
+		}
+		i += length
+
+		if marker != 0xDA { // SOS
+			continue
+		}
+		// entropy-coded data runs to the next marker other than a
+		// stuffed 0xFF00 or a restart marker
+		for ; i+1 < len(data); i++ {
+			if data[i] != 0xFF {
+				continue
+			}
+			next := data[i+1]
+			if next != 0x00 && next != 0xFF && (next < 0xD0 || next > 0xD7) {
+				break
+			}
+		}
+		if i+1 >= len(data) {
+			return 0, fmt.Errorf("jpeg: missing EOI marker")
+		}
+	}
+}
+
+// pngEnd returns the offset just past the IEND chunk.
+func pngEnd(data []byte) (int, error) {
+	i := len(pngMagic)
+	for {
+		// length, type, data, CRC
+		if i+8 > len(data) {
+			return 0, fmt.Errorf("png: missing IEND chunk")
+		}
+		length := int64(binary.BigEndian.Uint32(data[i:]))
+		chunkType := string(data[i+4 : i+8])
+		next := int64(i) + 12 + length
+		if next > int64(len(data)) {
+			return 0, fmt.Errorf("png: chunk %q at offset %d is truncated", chunkType, i)
+		}
+		i = int(next)
+		if chunkType == "IEND" {
+			return i, nil
+		}
+	}
+}
+
+// gifEnd returns the offset just past the trailer byte.
+func gifEnd(data []byte) (int, error) {
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, fmt.Errorf("gif: truncated header")
+	}
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B: // trailer
+			return i + 1, nil
+		case 0x21: // extension: label, then data sub-blocks
+			i += 2
+		case 0x2C: // image descriptor, local color table, LZW code size
+			if i+10 > len(data) {
+				return 0, fmt.Errorf("gif: truncated image descriptor")
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i++
+		default:
+			return 0, fmt.Errorf("gif: unexpected block 0x%02X at offset %d", data[i], i)
+		}
+
+		// data sub-blocks end with a zero-length block
+		for {
+			if i >= len(data) {
+				return 0, fmt.Errorf("gif: truncated data sub-blocks")
+			}
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				break
+			}
+		}
+	}
+	return 0, fmt.Errorf("gif: missing trailer")
+}
@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func encodeTestGIF(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestValidateStructureAcceptsCleanImages(t *testing.T) {
+	jpegData := encodeTestJPEG(t, 32, 24)
+	tests := map[string][]byte{
+		"jpeg":               jpegData,
+		"png":                encodeGrayPNG(t, 8, 8),
+		"gif":                encodeTestGIF(t),
+		"jpeg with padding":  append(bytes.Clone(jpegData), 0x00, 0x00, 0xFF, 0xFF),
+		"unchecked format":   []byte("RIFF\x00\x00\x00\x00WEBPVP8 trailing"),
+		"jpeg restart marks": jpegWithRestartMarkers(t),
+	}
+	for name, data := range tests {
+		if err := ValidateStructure(data); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestValidateStructureRejectsAppendedData(t *testing.T) {
+	script := []byte("<script>alert(document.domain)</script>")
+	zipHeader := []byte("PK\x03\x04\x14\x00\x00\x00\x08\x00")
+	tests := map[string][]byte{
+		"jpeg + script": append(encodeTestJPEG(t, 32, 24), script...),
+		"jpeg + zip":    append(encodeTestJPEG(t, 32, 24), zipHeader...),
+		"png + script":  append(encodeGrayPNG(t, 8, 8), script...),
+		"gif + script":  append(encodeTestGIF(t), script...),
+	}
+	for name, data := range tests {
+		if err := ValidateStructure(data); !errors.Is(err, domain.ErrInvalidImageStructure) {
+			t.Errorf("%s: err = %v, want ErrInvalidImageStructure", name, err)
+		}
+	}
+}
+
+func TestValidateStructureRejectsTruncatedImages(t *testing.T) {
+	jpegData := encodeTestJPEG(t, 32, 24)
+	pngData := encodeGrayPNG(t, 8, 8)
+	tests := map[string][]byte{
+		"jpeg without EOI": jpegData[:len(jpegData)-2],
+		"png without IEND": pngData[:len(pngData)-12],
+	}
+	for name, data := range tests {
+		if err := ValidateStructure(data); !errors.Is(err, domain.ErrInvalidImageStructure) {
+			t.Errorf("%s: err = %v, want ErrInvalidImageStructure", name, err)
+		}
+	}
+}
+
+// jpegWithRestartMarkers inserts an RSTn marker and a stuffed 0xFF00 into
+// the scan data of a JPEG; the walk to EOI must step over both.
+func jpegWithRestartMarkers(t *testing.T) []byte {
+	t.Helper()
+	data := encodeTestJPEG(t, 32, 24)
+	eoi := len(data) - 2
+	out := append(bytes.Clone(data[:eoi]), 0xFF, 0xD0, 0x12, 0xFF, 0x00)
+	return append(out, data[eoi:]...)
+}
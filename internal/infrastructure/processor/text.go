@@ -0,0 +1,144 @@
+package processor
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/wb-go/wbf/zlog"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	textWatermarkFontSize = 48
+	maxWatermarkTextLen   = 64
+)
+
+// WatermarkText tiles text across img the same way the image watermark is tiled.
+func (p *ImageProcessor) WatermarkText(img image.Image, text string) (image.Image, error) {
+	mark, err := renderText(text, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, textWatermarkFontSize)
+	if err != nil {
+		return nil, err
+	}
+
+	out := p.tileWatermark(img, mark)
+	zlog.Logger.Info().Str("text", text).Int("opacity", p.cfg.WatermarkOpacity).Msg("Text watermark applied")
+	return out, nil
+}
+
+// watermarkWithText tiles text, requested per image in place of the
+// configured watermark, in the configured style.
+func (p *ImageProcessor) watermarkWithText(img image.Image, text string) (image.Image, error) {
+	text = sanitizeWatermarkText(text)
+	mark, err := p.renderStyledText(text)
+	if err != nil {
+		return nil, fmt.Errorf("render watermark text: %w", err)
+	}
+	if p.cfg.WatermarkSmartPlacement {
+		return p.placeMark(img, mark), nil
+	}
+
+	out := p.tileMark(img, mark)
+	zlog.Logger.Info().Str("watermark_text", text).Int("opacity", p.cfg.WatermarkOpacity).Msg("Requested text watermark applied")
+	return out, nil
+}
+
+// ResolveWatermarkTemplate substitutes {user}, {id} and {timestamp} in tmpl.
+// Values come from the request, so the result is sanitized to printable
+// characters and capped in length before it is rendered.
+func ResolveWatermarkTemplate(tmpl string, user, imageID string, now time.Time) string {
+	if user == "" {
+		user = "anonymous"
+	}
+
+	text := strings.NewReplacer(
+		"{user}", user,
+		"{id}", imageID,
+		"{timestamp}", now.UTC().Format(time.RFC3339),
+	).Replace(tmpl)
+
+	return sanitizeWatermarkText(text)
+}
+
+func sanitizeWatermarkText(text string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range text {
+		if n >= maxWatermarkTextLen {
+			break
+		}
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			sb.WriteRune(r)
+		case strings.ContainsRune(" -_.:@+", r):
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('_')
+		}
+		n++
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// renderConfiguredText renders WatermarkText with the configured font size
+// and color. Unlike the image watermark it is tiled at its rendered size, so
+// the font size sets how large the text appears.
+func (p *ImageProcessor) renderConfiguredText() (*image.NRGBA, error) {
+	return p.renderStyledText(p.cfg.WatermarkText)
+}
+
+// renderStyledText renders text with the configured font size and color.
+func (p *ImageProcessor) renderStyledText(text string) (*image.NRGBA, error) {
+	col, err := p.cfg.WatermarkRGBA()
+	if err != nil {
+		return nil, err
+	}
+	size := float64(p.cfg.WatermarkFontSize)
+	if size <= 0 {
+		size = textWatermarkFontSize
+	}
+	return renderText(text, col, size)
+}
+
+func renderText(text string, col color.Color, size float64) (*image.NRGBA, error) {
+	if text == "" {
+		return nil, fmt.Errorf("watermark text is empty")
+	}
+
+	f, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("parse font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create font face: %w", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	if width == 0 || height == 0 {
+		return nil, fmt.Errorf("watermark text renders empty")
+	}
+
+	canvas := image.NewNRGBA(image.Rect(0, 0, width, height))
+	d := &font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.Point26_6{X: 0, Y: metrics.Ascent},
+	}
+	d.DrawString(text)
+	return canvas, nil
+}
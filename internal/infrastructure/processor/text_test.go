@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+func TestResolveWatermarkTemplate(t *testing.T) {
+	now := time.Date(2024, 3, 9, 14, 5, 0, 0, time.FixedZone("MSK", 3*60*60))
+	tests := []struct {
+		name, tmpl, user, want string
+	}{
+		{"all placeholders", "{user}-{id}-{timestamp}", "alice", "alice-img1-2024-03-09T11:05:00Z"},
+		{"no user", "{user}", "", "anonymous"},
+		{"markup sanitized", "{user}", "<script>alert(1)</script>", "_script_alert_1___script_"},
+		{"control characters", "by {user}", "bob\r\nX-Injected: 1", "by bob__X-Injected: 1"},
+		{"non-ascii letters", "{user}", "Жанна", "_____"},
+		{"capped", "{user}", strings.Repeat("a", 100), strings.Repeat("a", maxWatermarkTextLen)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveWatermarkTemplate(tt.tmpl, tt.user, "img1", now); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatermarkTextRendersTemplate(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{WatermarkOpacity: 255})
+	src := imaging.New(400, 300, color.NRGBA{A: 255})
+
+	text := ResolveWatermarkTemplate("{user}-{timestamp}", "alice", "img1", time.Now())
+	out, err := p.WatermarkText(src, text)
+	if err != nil {
+		t.Fatalf("WatermarkText: %v", err)
+	}
+	if out.Bounds() != src.Bounds() {
+		t.Fatalf("bounds = %v, want %v", out.Bounds(), src.Bounds())
+	}
+
+	// the white text lightens part of the black image and leaves the rest
+	lit, dark := 0, 0
+	nrgba := imaging.Clone(out)
+	for y := range 300 {
+		for x := range 400 {
+			if nrgba.NRGBAAt(x, y).R > 128 {
+				lit++
+			} else {
+				dark++
+			}
+		}
+	}
+	if lit == 0 || dark == 0 {
+		t.Fatalf("%d lit and %d dark pixels, want text over the image", lit, dark)
+	}
+}
+
+func TestWatermarkTextRejectsEmptyText(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{})
+	if _, err := p.WatermarkText(image.NewNRGBA(image.Rect(0, 0, 10, 10)), ""); err == nil {
+		t.Fatal("empty watermark text was rendered")
+	}
+}
@@ -0,0 +1,124 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// Decode decodes r with EXIF orientation applied. A page above 1 selects
+// that page of a multi-page TIFF; other formats only have page 1.
+func Decode(r io.Reader, page int) (image.Image, error) {
+	return DecodeLimit(r, page, 0)
+}
+
+// DecodeLimit is Decode for untrusted input: the header is read first, and
+// an image of more than maxPixels (width*height) is rejected with
+// domain.ErrTooManyPixels before its pixels are allocated. A small file can
+// declare gigapixel dimensions. maxPixels 0 means no limit.
+func DecodeLimit(r io.Reader, page int, maxPixels int64) (image.Image, error) {
+	if page <= 1 {
+		if maxPixels > 0 {
+			var header bytes.Buffer
+			if err := checkPixels(io.TeeReader(r, &header), maxPixels); err != nil {
+				return nil, err
+			}
+			r = io.MultiReader(&header, r)
+		}
+		return imaging.Decode(r, imaging.AutoOrientation(true))
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err = selectTIFFPage(data, page)
+	if err != nil {
+		return nil, err
+	}
+	if maxPixels > 0 {
+		if err := checkPixels(bytes.NewReader(data), maxPixels); err != nil {
+			return nil, err
+		}
+	}
+	return imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+}
+
+func checkPixels(r io.Reader, maxPixels int64) error {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxPixels {
+		return fmt.Errorf("%w: %dx%d is %d pixels, at most %d allowed", domain.ErrTooManyPixels, cfg.Width, cfg.Height, pixels, maxPixels)
+	}
+	return nil
+}
+
+// selectTIFFPage returns a copy of a TIFF whose header points at the IFD
+// of the given 1-based page. Offsets in a TIFF are absolute, so the
+// decoder, which only reads the first IFD, then decodes that page.
+func selectTIFFPage(data []byte, page int) ([]byte, error) {
+	offsets, order, err := tiffIFDOffsets(data)
+	if err != nil {
+		return nil, err
+	}
+	if page < 1 || page > len(offsets) {
+		return nil, fmt.Errorf("%w: page %d of %d", domain.ErrInvalidPage, page, len(offsets))
+	}
+
+	out := bytes.Clone(data)
+	order.PutUint32(out[4:8], offsets[page-1])
+	return out, nil
+}
+
+// tiffIFDOffsets walks the IFD chain of a classic (non-BigTIFF) TIFF and
+// returns the offset of every page.
+func tiffIFDOffsets(data []byte) ([]uint32, binary.ByteOrder, error) {
+	if len(data) < 8 {
+		return nil, nil, fmt.Errorf("%w: not a TIFF file", domain.ErrInvalidPage)
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return nil, nil, fmt.Errorf("%w: only TIFF files have pages", domain.ErrInvalidPage)
+	}
+
+	var offsets []uint32
+	seen := make(map[uint32]bool)
+	for offset := order.Uint32(data[4:8]); offset != 0; {
+		if seen[offset] || len(offsets) >= domain.MaxPageNumber {
+			return nil, nil, fmt.Errorf("TIFF IFD chain loops or is too long")
+		}
+		seen[offset] = true
+
+		// an IFD is a 2-byte entry count, 12 bytes per entry, and the
+		// 4-byte offset of the next IFD
+		if int64(offset)+2 > int64(len(data)) {
+			return nil, nil, fmt.Errorf("TIFF IFD offset %d out of bounds", offset)
+		}
+		entries := int64(order.Uint16(data[offset:]))
+		next := int64(offset) + 2 + entries*12
+		if next+4 > int64(len(data)) {
+			return nil, nil, fmt.Errorf("TIFF IFD at %d is truncated", offset)
+		}
+
+		offsets = append(offsets, offset)
+		offset = order.Uint32(data[next:])
+	}
+
+	if len(offsets) == 0 {
+		return nil, nil, fmt.Errorf("TIFF file has no pages")
+	}
+	return offsets, order, nil
+}
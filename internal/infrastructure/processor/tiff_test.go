@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// hugePNG is a 1x1 PNG whose header claims w x h, so decoding its pixels
+// would allocate far more than the file size.
+func hugePNG(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	data := encodeGrayPNG(t, 1, 1)
+
+	// signature (8) + IHDR length (4) + type (4), then width and height
+	binary.BigEndian.PutUint32(data[16:20], w)
+	binary.BigEndian.PutUint32(data[20:24], h)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestDecodeLimitRejectsFromHeader(t *testing.T) {
+	data := hugePNG(t, 50000, 50000)
+
+	_, err := DecodeLimit(bytes.NewReader(data), 1, 10_000_000)
+	if !errors.Is(err, domain.ErrTooManyPixels) {
+		t.Fatalf("err = %v, want ErrTooManyPixels", err)
+	}
+}
+
+func encodeGrayPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeLimitAllowsWithinLimit(t *testing.T) {
+	img, err := DecodeLimit(bytes.NewReader(encodeGrayPNG(t, 10, 10)), 1, 100)
+	if err != nil {
+		t.Fatalf("DecodeLimit: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Fatalf("bounds = %v, want 10x10", b)
+	}
+}
+
+// encodeMultiPageTIFF writes an uncompressed 8-bit grayscale TIFF with one
+// page per image, each page's pixels followed by its IFD.
+func encodeMultiPageTIFF(t *testing.T, pages []*image.Gray) []byte {
+	t.Helper()
+	le := binary.LittleEndian
+	out := []byte("II*\x00\x00\x00\x00\x00")
+	prevNext := 4 // where the offset of the next IFD goes
+
+	for _, pg := range pages {
+		w, h := pg.Bounds().Dx(), pg.Bounds().Dy()
+		stripOffset := len(out)
+		for y := 0; y < h; y++ {
+			out = append(out, pg.Pix[y*pg.Stride:y*pg.Stride+w]...)
+		}
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+
+		le.PutUint32(out[prevNext:], uint32(len(out)))
+		entries := []struct {
+			tag, typ uint16
+			value    uint32
+		}{
+			{256, 4, uint32(w)},           // ImageWidth
+			{257, 4, uint32(h)},           // ImageLength
+			{258, 3, 8},                   // BitsPerSample
+			{259, 3, 1},                   // Compression: none
+			{262, 3, 1},                   // PhotometricInterpretation: BlackIsZero
+			{273, 4, uint32(stripOffset)}, // StripOffsets
+			{277, 3, 1},                   // SamplesPerPixel
+			{278, 4, uint32(h)},           // RowsPerStrip
+			{279, 4, uint32(w * h)},       // StripByteCounts
+		}
+		out = le.AppendUint16(out, uint16(len(entries)))
+		for _, e := range entries {
+			out = le.AppendUint16(out, e.tag)
+			out = le.AppendUint16(out, e.typ)
+			out = le.AppendUint32(out, 1)
+			if e.typ == 3 {
+				out = le.AppendUint16(out, uint16(e.value))
+				out = le.AppendUint16(out, 0)
+			} else {
+				out = le.AppendUint32(out, e.value)
+			}
+		}
+		prevNext = len(out)
+		out = le.AppendUint32(out, 0)
+	}
+	return out
+}
+
+func grayPage(w, h int, v uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	return img
+}
+
+func threePageTIFF(t *testing.T) []byte {
+	return encodeMultiPageTIFF(t, []*image.Gray{
+		grayPage(4, 4, 10),
+		grayPage(6, 5, 128),
+		grayPage(8, 3, 250),
+	})
+}
+
+func TestDecodeSelectsTIFFPage(t *testing.T) {
+	data := threePageTIFF(t)
+
+	tests := []struct {
+		page         int
+		wantW, wantH int
+		wantGray     uint8
+	}{
+		{page: 1, wantW: 4, wantH: 4, wantGray: 10},
+		{page: 2, wantW: 6, wantH: 5, wantGray: 128},
+		{page: 3, wantW: 8, wantH: 3, wantGray: 250},
+	}
+	for _, tt := range tests {
+		img, err := DecodeLimit(bytes.NewReader(data), tt.page, 1000)
+		if err != nil {
+			t.Fatalf("page %d: %v", tt.page, err)
+		}
+		if b := img.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+			t.Errorf("page %d: bounds = %v, want %dx%d", tt.page, b, tt.wantW, tt.wantH)
+		}
+		r, _, _, _ := img.At(1, 1).RGBA()
+		if got := uint8(r >> 8); got != tt.wantGray {
+			t.Errorf("page %d: gray = %d, want %d", tt.page, got, tt.wantGray)
+		}
+	}
+}
+
+func TestDecodeRejectsPageOutOfRange(t *testing.T) {
+	data := threePageTIFF(t)
+
+	if _, err := Decode(bytes.NewReader(data), 4); !errors.Is(err, domain.ErrInvalidPage) {
+		t.Errorf("page 4 of 3: err = %v, want ErrInvalidPage", err)
+	}
+	if _, err := Decode(bytes.NewReader(encodeGrayPNG(t, 4, 4)), 2); !errors.Is(err, domain.ErrInvalidPage) {
+		t.Errorf("page 2 of a PNG: err = %v, want ErrInvalidPage", err)
+	}
+}
+
+func TestProcessUsesRequestedTIFFPage(t *testing.T) {
+	p := NewImageProcessor(&config.ProcessingConfig{})
+	page := 2
+
+	out, err := p.Process(context.Background(), bytes.NewReader(threePageTIFF(t)), domain.ProcessingFlip, domain.ProcessingOptions{Page: &page})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if b := out.Bounds(); b.Dx() != 6 || b.Dy() != 5 {
+		t.Fatalf("bounds = %v, want page 2 (6x5)", b)
+	}
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+func readObject(t *testing.T, get func(context.Context, string) (io.ReadCloser, error), path string) []byte {
+	t.Helper()
+	file, err := get(context.Background(), path)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return data
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := NewMemoryStorage(&config.StorageConfig{})
+	st, err := WithEncryption(backend, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("WithEncryption: %v", err)
+	}
+	plain := []byte(strings.Repeat("sensitive pixels ", 64))
+
+	for name, save := range map[string]func(context.Context, string, io.Reader, int64) (string, error){
+		"original":  st.SaveOriginal,
+		"processed": st.SaveProcessed,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path, err := save(ctx, "photo.jpg", bytes.NewReader(plain), int64(len(plain)))
+			if err != nil {
+				t.Fatalf("save: %v", err)
+			}
+
+			get := st.GetOriginal
+			rawGet := backend.GetOriginal
+			if name == "processed" {
+				get, rawGet = st.GetProcessed, backend.GetProcessed
+			}
+
+			stored := readObject(t, rawGet, path)
+			if !bytes.HasPrefix(stored, encryptedMagic) || bytes.Contains(stored, []byte("sensitive pixels")) {
+				t.Fatalf("backend holds %q..., want the sealed object", stored[:16])
+			}
+
+			if got := readObject(t, get, path); !bytes.Equal(got, plain) {
+				t.Fatalf("read back %d bytes that differ from the %d written", len(got), len(plain))
+			}
+
+			// Range requests seek in the decrypted object
+			file, _ := get(ctx, path)
+			defer file.Close()
+			if _, err := file.(io.Seeker).Seek(int64(len(plain)-6), io.SeekStart); err != nil {
+				t.Fatalf("seek: %v", err)
+			}
+			if tail, _ := io.ReadAll(file); string(tail) != "ixels " {
+				t.Fatalf("read %q after seeking, want the plaintext tail", tail)
+			}
+		})
+	}
+}
+
+func TestEncryptionReadsPlaintextObjectsAndRejectsOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	backend, _ := NewMemoryStorage(&config.StorageConfig{})
+	legacy, _ := backend.SaveOriginal(ctx, "old.jpg", strings.NewReader("stored before encryption"), -1)
+
+	st, _ := WithEncryption(backend, bytes.Repeat([]byte{1}, 32))
+	if got := readObject(t, st.GetOriginal, legacy); string(got) != "stored before encryption" {
+		t.Fatalf("legacy object read as %q", got)
+	}
+
+	sealed, err := st.SaveOriginal(ctx, "new.jpg", strings.NewReader("secret"), 6)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	other, _ := WithEncryption(backend, bytes.Repeat([]byte{2}, 32))
+	if _, err := other.GetOriginal(ctx, sealed); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("read with another key: err = %v, want ErrDecrypt", err)
+	}
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	pathpkg "path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// defaultS3RetryInterval is how often a degraded fallbackStorage retries S3
+// when storage.s3_fallback_retry_sec is 0.
+const defaultS3RetryInterval = 30 * time.Second
+
+var errS3Degraded = fmt.Errorf("%w: s3 is unreachable, serving from local storage", ErrBackendUnavailable)
+
+// fallbackStorage is the S3 backend with storage.s3_fallback_local: when
+// S3 is unreachable at startup it writes to local storage instead and
+// retries S3 in the background until it can switch over. Objects written
+// while degraded stay on local disk, so reads, existence checks and
+// deletes of paths S3 does not have fall through to local storage, also
+// after a later restart. storage.s3_local_backend uses it without the
+// degraded start, as the two backends images are migrated between.
+type fallbackStorage struct {
+	local Storage
+	// originalDir tells originals from processed files when migrating
+	originalDir string
+
+	mu sync.RWMutex
+	// s3 is nil while degraded, when writes go to local storage
+	s3 Storage
+}
+
+// newS3WithFallback connects to S3, falling back to local storage and a
+// background reconnect when that fails at startup.
+func newS3WithFallback(cfg *config.StorageConfig) (Storage, error) {
+	local, err := NewLocalStorage(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("local fallback storage: %w", err)
+	}
+
+	s3, err := NewS3Storage(cfg)
+	if err == nil {
+		return &fallbackStorage{local: local, originalDir: cfg.OriginalDir, s3: s3}, nil
+	}
+
+	interval := time.Duration(cfg.S3FallbackRetrySec) * time.Second
+	if interval == 0 {
+		interval = defaultS3RetryInterval
+	}
+	zlog.Logger.Warn().
+		Err(err).
+		Str("local_path", cfg.LocalPath).
+		Dur("retry_interval", interval).
+		Msg("S3 unreachable at startup, storage degraded to local disk")
+
+	f := &fallbackStorage{local: local, originalDir: cfg.OriginalDir}
+	go f.reconnect(cfg, interval)
+	return f, nil
+}
+
+// newS3WithLocal spans S3 and local storage without the degraded start:
+// an unreachable S3 fails startup as it does without a second backend.
+func newS3WithLocal(cfg *config.StorageConfig) (Storage, error) {
+	local, err := NewLocalStorage(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("local secondary storage: %w", err)
+	}
+	s3, err := NewS3Storage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &fallbackStorage{local: local, originalDir: cfg.OriginalDir, s3: s3}, nil
+}
+
+// reconnect retries S3 every interval until it is reachable and then
+// sends new writes there.
+func (f *fallbackStorage) reconnect(cfg *config.StorageConfig, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s3, err := NewS3Storage(cfg)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Msg("S3 still unreachable, storage stays degraded")
+			continue
+		}
+
+		f.mu.Lock()
+		f.s3 = s3
+		f.mu.Unlock()
+		zlog.Logger.Info().Msg("S3 reachable again, storage switched back to S3")
+		return
+	}
+}
+
+func (f *fallbackStorage) remote() Storage {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.s3
+}
+
+// active is the backend new objects are written to.
+func (f *fallbackStorage) active() Storage {
+	if s3 := f.remote(); s3 != nil {
+		return s3
+	}
+	return f.local
+}
+
+func (f *fallbackStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return f.active().SaveOriginal(ctx, filename, reader, size)
+}
+
+func (f *fallbackStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return f.active().SaveProcessed(ctx, filename, reader, size)
+}
+
+// readOrder lists the backends reads try, the one ctx records the files
+// on first. Without a record S3 comes first: only objects written while
+// degraded, or migrated, are on local disk.
+func (f *fallbackStorage) readOrder(ctx context.Context) []Storage {
+	s3 := f.remote()
+	switch {
+	case s3 == nil:
+		return []Storage{f.local}
+	case backendFrom(ctx) == BackendLocal:
+		return []Storage{f.local, s3}
+	default:
+		return []Storage{s3, f.local}
+	}
+}
+
+// read returns the object from the first backend in readOrder that has it.
+func (f *fallbackStorage) read(ctx context.Context, get func(Storage) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	var file io.ReadCloser
+	var err error
+	for _, backend := range f.readOrder(ctx) {
+		file, err = get(backend)
+		if !errors.Is(err, ErrObjectNotFound) {
+			return file, err
+		}
+	}
+	return file, err
+}
+
+func (f *fallbackStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	return f.read(ctx, func(s Storage) (io.ReadCloser, error) { return s.GetOriginal(ctx, path) })
+}
+
+func (f *fallbackStorage) GetProcessed(ctx context.Context, path string) (io.ReadCloser, error) {
+	return f.read(ctx, func(s Storage) (io.ReadCloser, error) { return s.GetProcessed(ctx, path) })
+}
+
+// Delete removes path from S3 and, when it was written while degraded,
+// from local storage.
+func (f *fallbackStorage) Delete(ctx context.Context, path string) error {
+	if s3 := f.remote(); s3 != nil {
+		if err := s3.Delete(ctx, path); err != nil {
+			return err
+		}
+	}
+
+	exists, err := f.local.Exists(ctx, path)
+	if err != nil || !exists {
+		return err
+	}
+	return f.local.Delete(ctx, path)
+}
+
+func (f *fallbackStorage) DeleteAll(ctx context.Context, originalPath, processedPath string) error {
+	var lastErr error
+
+	if err := f.Delete(ctx, originalPath); err != nil {
+		lastErr = err
+	}
+	if err := f.Delete(ctx, processedPath); err != nil {
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+func (f *fallbackStorage) Exists(ctx context.Context, path string) (bool, error) {
+	for _, backend := range f.readOrder(ctx) {
+		exists, err := backend.Exists(ctx, path)
+		if err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+func (f *fallbackStorage) Ping(ctx context.Context) error {
+	return f.active().Ping(ctx)
+}
+
+// List reports the objects in S3 followed by those written to local
+// storage while degraded.
+func (f *fallbackStorage) List(ctx context.Context, fn func(StoredObject) error) error {
+	if s3 := f.remote(); s3 != nil {
+		if err := s3.List(ctx, fn); err != nil {
+			return err
+		}
+	}
+	return f.local.List(ctx, fn)
+}
+
+// PresignedURL presigns objects stored in S3. It fails while degraded and
+// for objects kept on local disk, which makes callers stream them instead.
+func (f *fallbackStorage) PresignedURL(ctx context.Context, path string, expiry time.Duration) (string, error) {
+	s3 := f.remote()
+	if s3 == nil {
+		return "", errS3Degraded
+	}
+	presigner, ok := s3.(Presigner)
+	if !ok {
+		return "", fmt.Errorf("s3 storage cannot presign")
+	}
+
+	exists, err := s3.Exists(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%w: %s is stored on local disk", ErrObjectNotFound, path)
+	}
+	return presigner.PresignedURL(ctx, path, expiry)
+}
+
+// ActiveBackend is s3, or local while degraded.
+func (f *fallbackStorage) ActiveBackend() string {
+	if f.remote() == nil {
+		return BackendLocal
+	}
+	return BackendS3
+}
+
+// MigrateObject copies path from the other backend to backend under the
+// same path and then deletes the source copy. An object missing from both
+// backends reports ErrObjectNotFound.
+func (f *fallbackStorage) MigrateObject(ctx context.Context, path, backend string) error {
+	s3 := f.remote()
+	if s3 == nil {
+		return errS3Degraded
+	}
+
+	var from, to Storage
+	switch backend {
+	case BackendS3:
+		from, to = f.local, s3
+	case BackendLocal:
+		from, to = s3, f.local
+	default:
+		return fmt.Errorf("%w: %q", ErrBackendNotConfigured, backend)
+	}
+
+	exists, err := from.Exists(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		moved, err := to.Exists(ctx, path)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			return fmt.Errorf("%w: %s", ErrObjectNotFound, path)
+		}
+		return nil
+	}
+
+	// both backends read any stored path, original or processed
+	file, err := from.GetOriginal(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	save := to.SaveProcessed
+	if strings.HasPrefix(path, f.originalDir+"/") {
+		save = to.SaveOriginal
+	}
+	saved, err := save(ctx, pathpkg.Base(path), file, -1)
+	if err != nil {
+		return fmt.Errorf("copy %s to %s: %w", path, backend, err)
+	}
+	if saved != path {
+		// records keep their paths, so a copy elsewhere would be lost
+		_ = to.Delete(ctx, saved)
+		return fmt.Errorf("copy %s to %s landed at %s", path, backend, saved)
+	}
+
+	if err := from.Delete(ctx, path); err != nil {
+		return fmt.Errorf("delete %s after copy to %s: %w", path, backend, err)
+	}
+
+	zlog.Logger.Info().Str("path", path).Str("backend", backend).Msg("object migrated")
+	return nil
+}
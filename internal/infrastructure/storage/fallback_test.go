@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// countingReads counts the reads and existence checks that reach a backend.
+type countingReads struct {
+	Storage
+	reads atomic.Int32
+}
+
+func (c *countingReads) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	c.reads.Add(1)
+	return c.Storage.GetOriginal(ctx, path)
+}
+
+func (c *countingReads) GetProcessed(ctx context.Context, path string) (io.ReadCloser, error) {
+	c.reads.Add(1)
+	return c.Storage.GetProcessed(ctx, path)
+}
+
+func (c *countingReads) Exists(ctx context.Context, path string) (bool, error) {
+	c.reads.Add(1)
+	return c.Storage.Exists(ctx, path)
+}
+
+// twoBackends is a fallbackStorage over two in-memory backends standing in
+// for local disk and S3.
+func twoBackends(t *testing.T) (f *fallbackStorage, local Storage, s3 *countingReads) {
+	t.Helper()
+	local, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	remote, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	s3 = &countingReads{Storage: remote}
+	return &fallbackStorage{local: local, originalDir: "original", s3: s3}, local, s3
+}
+
+func exists(t *testing.T, s Storage, path string) bool {
+	t.Helper()
+	ok, err := s.Exists(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Exists: %v", err)
+	}
+	return ok
+}
+
+func TestFallbackMigrateObjectMovesBetweenBackends(t *testing.T) {
+	f, local, s3 := twoBackends(t)
+	ctx := context.Background()
+
+	original, err := f.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	if err != nil {
+		t.Fatalf("SaveOriginal: %v", err)
+	}
+	processed, err := f.SaveProcessed(ctx, "a.png", strings.NewReader("processed"), 9)
+	if err != nil {
+		t.Fatalf("SaveProcessed: %v", err)
+	}
+	if got := f.ActiveBackend(); got != BackendS3 {
+		t.Fatalf("ActiveBackend = %q, want %q", got, BackendS3)
+	}
+
+	for _, path := range []string{original, processed} {
+		if err := f.MigrateObject(ctx, path, BackendLocal); err != nil {
+			t.Fatalf("migrate %s to local: %v", path, err)
+		}
+		if !exists(t, local, path) || exists(t, s3, path) {
+			t.Fatalf("%s not moved to local", path)
+		}
+	}
+	if got := string(readObject(t, local.GetOriginal, original)); got != "original" {
+		t.Errorf("local original = %q", got)
+	}
+	if got := string(readObject(t, f.GetProcessed, processed)); got != "processed" {
+		t.Errorf("processed read through fallback = %q", got)
+	}
+
+	// repeating a finished migration is a no-op
+	if err := f.MigrateObject(ctx, original, BackendLocal); err != nil {
+		t.Fatalf("repeat migration: %v", err)
+	}
+
+	if err := f.MigrateObject(ctx, original, BackendS3); err != nil {
+		t.Fatalf("migrate back to s3: %v", err)
+	}
+	if exists(t, local, original) || !exists(t, s3, original) {
+		t.Fatal("original not moved back to s3")
+	}
+	// the original kind survives the round trip
+	if !strings.HasPrefix(original, "original/") {
+		t.Fatalf("original path %q", original)
+	}
+
+	if err := f.MigrateObject(ctx, "original/missing.jpg", BackendS3); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("missing object: err = %v, want ErrObjectNotFound", err)
+	}
+	if err := f.MigrateObject(ctx, original, "gcs"); !errors.Is(err, ErrBackendNotConfigured) {
+		t.Errorf("unknown backend: err = %v, want ErrBackendNotConfigured", err)
+	}
+}
+
+func TestFallbackReadsRecordedBackendFirst(t *testing.T) {
+	f, _, s3 := twoBackends(t)
+	ctx := context.Background()
+
+	path, err := f.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	if err != nil {
+		t.Fatalf("SaveOriginal: %v", err)
+	}
+	if err := f.MigrateObject(ctx, path, BackendLocal); err != nil {
+		t.Fatalf("MigrateObject: %v", err)
+	}
+
+	s3.reads.Store(0)
+	hinted := WithBackend(ctx, BackendLocal)
+	getHinted := func(_ context.Context, path string) (io.ReadCloser, error) {
+		return f.GetOriginal(hinted, path)
+	}
+	if got := string(readObject(t, getHinted, path)); got != "original" {
+		t.Fatalf("hinted read = %q", got)
+	}
+	if !exists(t, f, path) {
+		t.Fatal("Exists = false")
+	}
+	if ok, err := f.Exists(hinted, path); err != nil || !ok {
+		t.Fatalf("hinted Exists = %v, %v", ok, err)
+	}
+	// only the unhinted Exists above may have probed S3
+	if n := s3.reads.Load(); n != 1 {
+		t.Errorf("s3 reads = %d, want 1 (the unhinted Exists)", n)
+	}
+
+	// a stale hint still finds the object on the other backend
+	if err := f.MigrateObject(ctx, path, BackendS3); err != nil {
+		t.Fatalf("MigrateObject: %v", err)
+	}
+	if got := string(readObject(t, getHinted, path)); got != "original" {
+		t.Errorf("read with stale hint = %q", got)
+	}
+}
+
+func TestFallbackDegradedWritesLocalAndRefusesMigration(t *testing.T) {
+	f, local, _ := twoBackends(t)
+	f.s3 = nil
+	ctx := context.Background()
+
+	if got := f.ActiveBackend(); got != BackendLocal {
+		t.Fatalf("ActiveBackend = %q, want %q", got, BackendLocal)
+	}
+	path, err := f.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	if err != nil {
+		t.Fatalf("SaveOriginal: %v", err)
+	}
+	if !exists(t, local, path) {
+		t.Fatal("degraded write did not land on local storage")
+	}
+	if err := f.MigrateObject(ctx, path, BackendS3); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("MigrateObject err = %v, want ErrBackendUnavailable", err)
+	}
+}
+
+func TestActiveBackendOfSingleBackendStorage(t *testing.T) {
+	st, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	if got := ActiveBackend(st); got != "" {
+		t.Errorf("ActiveBackend = %q, want empty", got)
+	}
+	if got := backendFrom(WithBackend(context.Background(), "")); got != "" {
+		t.Errorf("empty hint stored as %q", got)
+	}
+}
+
+// flakyS3 answers like an S3 bucket with no objects yet, or with 503 to
+// everything while down is set.
+func flakyS3(t *testing.T, down *atomic.Bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case down.Load():
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Method == http.MethodHead && strings.Trim(r.URL.Path, "/") != "images":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == http.MethodGet && strings.Trim(r.URL.Path, "/") != "images":
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewFallsBackToLocalWhileS3IsUnreachable(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	cfg := s3Config(flakyS3(t, &down))
+	cfg.LocalPath = t.TempDir()
+	cfg.S3FallbackLocal = true
+	cfg.S3FallbackRetrySec = 1
+	ctx := context.Background()
+
+	st, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New with S3 down: %v", err)
+	}
+	if got := ActiveBackend(st); got != BackendLocal {
+		t.Fatalf("ActiveBackend = %q, want %q while S3 is down", got, BackendLocal)
+	}
+	if _, err := st.(Presigner).PresignedURL(ctx, "original/a.jpg", time.Minute); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("PresignedURL err = %v, want ErrBackendUnavailable while degraded", err)
+	}
+
+	path, err := st.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	if err != nil {
+		t.Fatalf("SaveOriginal while degraded: %v", err)
+	}
+	local, err := NewLocalStorage(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	if !exists(t, local, path) {
+		t.Fatal("degraded write did not land on local disk")
+	}
+
+	down.Store(false)
+	deadline := time.Now().Add(10 * time.Second)
+	for ActiveBackend(st) != BackendS3 {
+		if time.Now().After(deadline) {
+			t.Fatal("storage did not switch back to S3 once it was reachable")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// S3 lacks the object written while degraded; local disk still serves it
+	if got := string(readObject(t, st.GetOriginal, path)); got != "original" {
+		t.Errorf("read after recovery = %q, want the degraded write", got)
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// latencySmoothing is the weight of the newest sample in the moving
+// average; about the last ten calls dominate it.
+const latencySmoothing = 0.2
+
+// LatencyTracker keeps an exponentially weighted moving average of
+// storage call latency and reports when it is above a threshold.
+type LatencyTracker struct {
+	threshold time.Duration
+
+	mu      sync.Mutex
+	average time.Duration
+	samples int
+}
+
+// NewLatencyTracker builds a tracker that reports Overloaded while the
+// average latency exceeds threshold.
+func NewLatencyTracker(threshold time.Duration) *LatencyTracker {
+	return &LatencyTracker{threshold: threshold}
+}
+
+// Observe records the duration of one storage call.
+func (t *LatencyTracker) Observe(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.samples == 0 {
+		t.average = d
+	} else {
+		t.average += time.Duration(latencySmoothing * float64(d-t.average))
+	}
+	t.samples++
+}
+
+// Average returns the current moving average.
+func (t *LatencyTracker) Average() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.average
+}
+
+// Overloaded reports whether the average latency exceeds the threshold.
+func (t *LatencyTracker) Overloaded() bool {
+	return t.Average() > t.threshold
+}
+
+// timedStorage reports the latency of every call on the wrapped Storage,
+// failed calls included: a dependency that times out is slow too.
+type timedStorage struct {
+	Storage
+	tracker *LatencyTracker
+}
+
+// WithLatencyTracking wraps s so that each call is observed by tracker.
+func WithLatencyTracking(s Storage, tracker *LatencyTracker) Storage {
+	return &timedStorage{Storage: s, tracker: tracker}
+}
+
+func (s *timedStorage) observe(start time.Time) {
+	s.tracker.Observe(time.Since(start))
+}
+
+func (s *timedStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	defer s.observe(time.Now())
+	return s.Storage.SaveOriginal(ctx, filename, reader, size)
+}
+
+func (s *timedStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	defer s.observe(time.Now())
+	return s.Storage.SaveProcessed(ctx, filename, reader, size)
+}
+
+func (s *timedStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	defer s.observe(time.Now())
+	return s.Storage.GetOriginal(ctx, path)
+}
+
+func (s *timedStorage) GetProcessed(ctx context.Context, path string) (io.ReadCloser, error) {
+	defer s.observe(time.Now())
+	return s.Storage.GetProcessed(ctx, path)
+}
+
+func (s *timedStorage) Exists(ctx context.Context, path string) (bool, error) {
+	defer s.observe(time.Now())
+	return s.Storage.Exists(ctx, path)
+}
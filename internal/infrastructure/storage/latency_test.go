@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// slowStorage delays every read by delay and fails it with err.
+type slowStorage struct {
+	Storage
+	delay time.Duration
+	err   error
+}
+
+func (s *slowStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	time.Sleep(s.delay)
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Storage.GetOriginal(ctx, path)
+}
+
+func TestLatencyTrackerMovingAverage(t *testing.T) {
+	tracker := NewLatencyTracker(50 * time.Millisecond)
+	if tracker.Overloaded() {
+		t.Fatal("tracker without samples is overloaded")
+	}
+
+	tracker.Observe(100 * time.Millisecond)
+	if tracker.Average() != 100*time.Millisecond || !tracker.Overloaded() {
+		t.Fatalf("average after one slow call = %v, want 100ms and overloaded", tracker.Average())
+	}
+
+	// one fast call does not hide a slow dependency, a run of them does
+	tracker.Observe(0)
+	if !tracker.Overloaded() {
+		t.Fatalf("average %v dropped below the threshold after a single fast call", tracker.Average())
+	}
+	for range 10 {
+		tracker.Observe(0)
+	}
+	if tracker.Overloaded() {
+		t.Fatalf("average %v still over the threshold after recovery", tracker.Average())
+	}
+}
+
+func TestLatencyTrackingObservesSlowStorage(t *testing.T) {
+	mem, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	path, err := mem.SaveOriginal(context.Background(), "a.jpg", strings.NewReader("data"), 4)
+	if err != nil {
+		t.Fatalf("SaveOriginal: %v", err)
+	}
+
+	tracker := NewLatencyTracker(10 * time.Millisecond)
+	slow := &slowStorage{Storage: mem, delay: 20 * time.Millisecond}
+	st := WithLatencyTracking(slow, tracker)
+
+	file, err := st.GetOriginal(context.Background(), path)
+	if err != nil {
+		t.Fatalf("GetOriginal: %v", err)
+	}
+	file.Close()
+	if !tracker.Overloaded() {
+		t.Fatalf("average %v after a 20ms read, want over the 10ms threshold", tracker.Average())
+	}
+
+	// failed calls count too: a dependency that times out is slow
+	tracker = NewLatencyTracker(10 * time.Millisecond)
+	slow.err = errors.New("timeout")
+	if _, err := WithLatencyTracking(slow, tracker).GetOriginal(context.Background(), path); err == nil {
+		t.Fatal("GetOriginal succeeded against a failing backend")
+	}
+	if !tracker.Overloaded() {
+		t.Fatalf("average %v after a slow failure, want overloaded", tracker.Average())
+	}
+}
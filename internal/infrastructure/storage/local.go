@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -47,11 +48,11 @@ func NewLocalStorage(cfg *config.StorageConfig) (Storage, error) {
 	return storage, nil
 }
 
-func (s *localStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader) (string, error) {
+func (s *localStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, _ int64) (string, error) {
 	return s.saveFile(ctx, s.originalDir, filename, reader)
 }
 
-func (s *localStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader) (string, error) {
+func (s *localStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, _ int64) (string, error) {
 	return s.saveFile(ctx, s.processedDir, filename, reader)
 }
 
@@ -77,10 +78,12 @@ func (s *localStorage) saveFile(ctx context.Context, dir, filename string, reade
 	written, err := io.Copy(file, reader)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("path", fullPath).Msg("failed to write file")
+		s.removePartial(fullPath)
 		return "", fmt.Errorf("write file %s: %w", fullPath, err)
 	}
 	if written == 0 {
 		zlog.Logger.Error().Str("path", fullPath).Msg("no bytes written to file")
+		s.removePartial(fullPath)
 		return "", fmt.Errorf("no bytes written to file %s", fullPath)
 	}
 
@@ -142,6 +145,22 @@ func (s *localStorage) Delete(ctx context.Context, path string) error {
 	return nil
 }
 
+func (s *localStorage) Exists(ctx context.Context, path string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+
+	fullPath := filepath.Join(s.basePath, path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat file %s: %w", fullPath, err)
+	}
+	return !info.IsDir(), nil
+}
+
 func (s *localStorage) DeleteAll(ctx context.Context, originalPath, processedPath string) error {
 	var lastErr error
 
@@ -157,3 +176,60 @@ func (s *localStorage) DeleteAll(ctx context.Context, originalPath, processedPat
 
 	return lastErr
 }
+
+// removePartial deletes a file whose write failed half way, e.g. an upload
+// cut off at the size limit, so no truncated original is left behind.
+func (s *localStorage) removePartial(fullPath string) {
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		zlog.Logger.Warn().Err(err).Str("path", fullPath).Msg("failed to remove partial file")
+	}
+}
+
+func (s *localStorage) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	probe := filepath.Join(s.basePath, s.originalDir, newHealthProbeName())
+	if err := os.WriteFile(probe, []byte("ok"), 0o644); err != nil {
+		return fmt.Errorf("write probe: %w", err)
+	}
+	if err := os.Remove(probe); err != nil {
+		return fmt.Errorf("remove probe: %w", err)
+	}
+	return nil
+}
+
+func (s *localStorage) List(ctx context.Context, fn func(StoredObject) error) error {
+	for _, dir := range []string{s.originalDir, s.processedDir} {
+		root := filepath.Join(s.basePath, dir)
+		err := filepath.WalkDir(root, func(fullPath string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.IsDir() || isHealthProbe(d.Name()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					// deleted while walking
+					return nil
+				}
+				return err
+			}
+			relativePath, err := filepath.Rel(s.basePath, fullPath)
+			if err != nil {
+				return err
+			}
+			return fn(StoredObject{Path: relativePath, ModTime: info.ModTime()})
+		})
+		if err != nil {
+			return fmt.Errorf("list %s: %w", root, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+func TestLocalPingConcurrentProbesDoNotCollide(t *testing.T) {
+	dir := t.TempDir()
+	// two replicas sharing one volume
+	replicas := make([]Storage, 2)
+	for i := range replicas {
+		st, err := NewLocalStorage(&config.StorageConfig{LocalPath: dir})
+		if err != nil {
+			t.Fatalf("NewLocalStorage: %v", err)
+		}
+		replicas[i] = st
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := range 40 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- replicas[i%2].Ping(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	}
+}
+
+func TestLocalListSkipsHealthProbes(t *testing.T) {
+	st, err := NewLocalStorage(&config.StorageConfig{LocalPath: t.TempDir()})
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	ctx := context.Background()
+	// a probe left behind by a replica that died between write and remove
+	if _, err := st.SaveOriginal(ctx, newHealthProbeName(), strings.NewReader("ok"), 2); err != nil {
+		t.Fatalf("save probe: %v", err)
+	}
+	if _, err := st.SaveOriginal(ctx, "photo.jpg", strings.NewReader("jpeg"), 4); err != nil {
+		t.Fatalf("save original: %v", err)
+	}
+
+	var listed []string
+	if err := st.List(ctx, func(obj StoredObject) error {
+		listed = append(listed, obj.Path)
+		return nil
+	}); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 1 || !strings.HasSuffix(listed[0], "photo.jpg") {
+		t.Fatalf("listed %v, want only the original", listed)
+	}
+}
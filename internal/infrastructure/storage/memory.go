@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// memoryStorage keeps objects in process memory. Everything is lost on
+// restart, so it is meant for tests and local development only.
+type memoryStorage struct {
+	originalDir  string
+	processedDir string
+
+	mu      sync.RWMutex
+	objects map[string][]byte
+	// modTimes holds when each object was last written, for List
+	modTimes map[string]time.Time
+}
+
+func NewMemoryStorage(cfg *config.StorageConfig) (Storage, error) {
+	originalDir := cfg.OriginalDir
+	if originalDir == "" {
+		originalDir = "original"
+	}
+	processedDir := cfg.ProcessedDir
+	if processedDir == "" {
+		processedDir = "processed"
+	}
+
+	return &memoryStorage{
+		originalDir:  originalDir,
+		processedDir: processedDir,
+		objects:      make(map[string][]byte),
+		modTimes:     make(map[string]time.Time),
+	}, nil
+}
+
+func (s *memoryStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, _ int64) (string, error) {
+	return s.save(ctx, s.originalDir, filename, reader)
+}
+
+func (s *memoryStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, _ int64) (string, error) {
+	return s.save(ctx, s.processedDir, filename, reader)
+}
+
+func (s *memoryStorage) save(ctx context.Context, dir, filename string, reader io.Reader) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if reader == nil {
+		zlog.Logger.Error().Str("filename", filename).Msg("reader is nil")
+		return "", fmt.Errorf("reader is nil")
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("read object %s: %w", filename, err)
+	}
+	if len(data) == 0 {
+		return "", fmt.Errorf("no bytes written to object %s", filename)
+	}
+
+	key := path.Join(dir, filename)
+	s.mu.Lock()
+	s.objects[key] = data
+	s.modTimes[key] = time.Now()
+	s.mu.Unlock()
+
+	zlog.Logger.Debug().Str("path", key).Int("bytes", len(data)).Msg("object stored in memory")
+	return key, nil
+}
+
+func (s *memoryStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.get(ctx, path)
+}
+
+func (s *memoryStorage) GetProcessed(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.get(ctx, path)
+}
+
+func (s *memoryStorage) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	data, ok := s.objects[path]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, path)
+	}
+
+	// stored slices are never modified, only replaced, so readers can
+	// share them; the reader stays seekable like an *os.File
+	return readSeekNopCloser{bytes.NewReader(data)}, nil
+}
+
+func (s *memoryStorage) Delete(ctx context.Context, path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.objects, path)
+	delete(s.modTimes, path)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStorage) Exists(ctx context.Context, path string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	_, ok := s.objects[path]
+	s.mu.RUnlock()
+	return ok, nil
+}
+
+func (s *memoryStorage) DeleteAll(ctx context.Context, originalPath, processedPath string) error {
+	var lastErr error
+
+	if err := s.Delete(ctx, originalPath); err != nil {
+		lastErr = err
+	}
+	if err := s.Delete(ctx, processedPath); err != nil {
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+func (s *memoryStorage) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *memoryStorage) List(ctx context.Context, fn func(StoredObject) error) error {
+	// fn may delete objects, so it runs on a snapshot
+	s.mu.RLock()
+	objects := make([]StoredObject, 0, len(s.modTimes))
+	for key, modTime := range s.modTimes {
+		if strings.HasPrefix(key, s.originalDir+"/") || strings.HasPrefix(key, s.processedDir+"/") {
+			objects = append(objects, StoredObject{Path: key, ModTime: modTime})
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
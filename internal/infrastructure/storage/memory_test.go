@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+func TestNewReturnsMemoryStorage(t *testing.T) {
+	st, err := New(&config.StorageConfig{Type: "memory"})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, ok := st.(*memoryStorage); !ok {
+		t.Fatalf("New returned %T, want *memoryStorage", st)
+	}
+}
+
+func TestMemoryStorageRoundTrip(t *testing.T) {
+	st, err := NewMemoryStorage(&config.StorageConfig{OriginalDir: "in", ProcessedDir: "out"})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	ctx := context.Background()
+
+	original, err := st.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	if err != nil {
+		t.Fatalf("SaveOriginal: %v", err)
+	}
+	processed, err := st.SaveProcessed(ctx, "a.png", strings.NewReader("processed"), 9)
+	if err != nil {
+		t.Fatalf("SaveProcessed: %v", err)
+	}
+	if original != "in/a.jpg" || processed != "out/a.png" {
+		t.Fatalf("paths = %q, %q", original, processed)
+	}
+	if got := string(readObject(t, st.GetOriginal, original)); got != "original" {
+		t.Errorf("original = %q", got)
+	}
+	if got := string(readObject(t, st.GetProcessed, processed)); got != "processed" {
+		t.Errorf("processed = %q", got)
+	}
+
+	var listed []string
+	if err := st.List(ctx, func(obj StoredObject) error {
+		listed = append(listed, obj.Path)
+		return st.Delete(ctx, obj.Path) // deleting while listing is allowed
+	}); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("listed %v, want both objects", listed)
+	}
+	if exists(t, st, original) || exists(t, st, processed) {
+		t.Error("objects deleted during List still exist")
+	}
+}
+
+func TestMemoryStorageDeleteAllAndMissingObjects(t *testing.T) {
+	st, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	ctx := context.Background()
+
+	original, _ := st.SaveOriginal(ctx, "a.jpg", strings.NewReader("original"), 8)
+	processed, _ := st.SaveProcessed(ctx, "a.jpg", strings.NewReader("processed"), 9)
+
+	if err := st.DeleteAll(ctx, original, processed); err != nil {
+		t.Fatalf("DeleteAll: %v", err)
+	}
+	for _, path := range []string{original, processed} {
+		if exists(t, st, path) {
+			t.Errorf("%s exists after DeleteAll", path)
+		}
+		if _, err := st.GetOriginal(ctx, path); !errors.Is(err, ErrObjectNotFound) {
+			t.Errorf("get %s: err = %v, want ErrObjectNotFound", path, err)
+		}
+	}
+	// deleting what is already gone, or nothing, is not an error
+	if err := st.DeleteAll(ctx, original, ""); err != nil {
+		t.Errorf("repeat DeleteAll: %v", err)
+	}
+	if _, err := st.SaveOriginal(ctx, "empty.jpg", strings.NewReader(""), 0); err == nil {
+		t.Error("empty object was stored")
+	}
+}
+
+func TestMemoryStorageConcurrentAccess(t *testing.T) {
+	st, err := NewMemoryStorage(&config.StorageConfig{})
+	if err != nil {
+		t.Fatalf("NewMemoryStorage: %v", err)
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("%d.jpg", i)
+			path, err := st.SaveOriginal(ctx, name, strings.NewReader(name), int64(len(name)))
+			if err != nil {
+				t.Errorf("SaveOriginal: %v", err)
+				return
+			}
+			rc, err := st.GetOriginal(ctx, path)
+			if err != nil {
+				t.Errorf("GetOriginal: %v", err)
+				return
+			}
+			got, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(got) != name {
+				t.Errorf("%s = %q", path, got)
+			}
+			st.List(ctx, func(StoredObject) error { return nil })
+			if err := st.Delete(ctx, path); err != nil {
+				t.Errorf("Delete: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"io"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
 )
 
 type s3Storage struct {
@@ -17,6 +20,12 @@ type s3Storage struct {
 	bucket       string
 	originalDir  string
 	processedDir string
+	// storage classes per object kind; empty leaves the bucket default
+	originalClass  string
+	processedClass string
+	// sealed is set when the encryption wrapper stores ciphertext here,
+	// which is never labelled as an image
+	sealed bool
 }
 
 func NewS3Storage(cfg *config.StorageConfig) (Storage, error) {
@@ -61,30 +70,40 @@ func NewS3Storage(cfg *config.StorageConfig) (Storage, error) {
 	}
 
 	return &s3Storage{
-		client:       client,
-		bucket:       cfg.S3Bucket,
-		originalDir:  cfg.OriginalDir,
-		processedDir: cfg.ProcessedDir,
+		client:         client,
+		bucket:         cfg.S3Bucket,
+		originalDir:    cfg.OriginalDir,
+		processedDir:   cfg.ProcessedDir,
+		originalClass:  cfg.S3OriginalStorageClass,
+		processedClass: cfg.S3ProcessedStorageClass,
+		sealed:         cfg.EncryptionEnabled,
 	}, nil
 }
 
-func (s *s3Storage) SaveOriginal(ctx context.Context, filename string, reader io.Reader) (string, error) {
-	return s.saveObject(ctx, s.originalDir, filename, reader)
+func (s *s3Storage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return s.saveObject(ctx, s.originalDir, filename, reader, size, s.originalClass)
 }
 
-func (s *s3Storage) SaveProcessed(ctx context.Context, filename string, reader io.Reader) (string, error) {
-	return s.saveObject(ctx, s.processedDir, filename, reader)
+func (s *s3Storage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return s.saveObject(ctx, s.processedDir, filename, reader, size, s.processedClass)
 }
 
-func (s *s3Storage) saveObject(ctx context.Context, dir, filename string, reader io.Reader) (string, error) {
+// saveObject uploads reader in one request when its size is known, and S3
+// rejects a body shorter than announced; without a size minio has to
+// buffer it in multipart chunks.
+func (s *s3Storage) saveObject(ctx context.Context, dir, filename string, reader io.Reader, size int64, storageClass string) (string, error) {
 	if reader == nil {
 		zlog.Logger.Error().Str("filename", filename).Msg("reader is nil")
 		return "", fmt.Errorf("reader is nil")
 	}
 
 	objectName := path.Join(dir, filename)
+	if size <= 0 {
+		// 0 would store an empty object whatever the reader holds
+		size = -1
+	}
 
-	_, err := s.client.PutObject(ctx, s.bucket, objectName, reader, -1, minio.PutObjectOptions{})
+	_, err := s.client.PutObject(ctx, s.bucket, objectName, reader, size, s.putOptions(storageClass, filename))
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("object", objectName).Msg("failed to put object to s3")
 		return "", fmt.Errorf("put object %s: %w", objectName, err)
@@ -130,6 +149,31 @@ func (s *s3Storage) Delete(ctx context.Context, objectPath string) error {
 	return nil
 }
 
+func (s *s3Storage) Exists(ctx context.Context, objectPath string) (bool, error) {
+	if objectPath == "" {
+		return false, nil
+	}
+
+	_, err := s.client.StatObject(ctx, s.bucket, objectPath, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("stat object %s: %w", objectPath, err)
+	}
+	return true, nil
+}
+
+// PresignedURL returns a GET URL for the object that is valid for expiry,
+// so clients can download it from the bucket directly.
+func (s *s3Storage) PresignedURL(ctx context.Context, objectPath string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, objectPath, expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("presign object %s: %w", objectPath, err)
+	}
+	return u.String(), nil
+}
+
 func (s *s3Storage) DeleteAll(ctx context.Context, originalPath, processedPath string) error {
 	var lastErr error
 
@@ -145,3 +189,52 @@ func (s *s3Storage) DeleteAll(ctx context.Context, originalPath, processedPath s
 
 	return lastErr
 }
+
+// putOptions labels objects with the image type of their extension, so
+// browsers and CDNs reading the bucket directly display them instead of
+// downloading them.
+func (s *s3Storage) putOptions(storageClass, filename string) minio.PutObjectOptions {
+	contentType := helpers.ContentTypeByExtension(filename)
+	if s.sealed {
+		contentType = "application/octet-stream"
+	}
+	return minio.PutObjectOptions{
+		StorageClass: storageClass,
+		ContentType:  contentType,
+	}
+}
+
+func (s *s3Storage) Ping(ctx context.Context) error {
+	probe := path.Join(s.originalDir, newHealthProbeName())
+	_, err := s.client.PutObject(ctx, s.bucket, probe, strings.NewReader("ok"), 2, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("put probe in bucket %s: %w", s.bucket, err)
+	}
+	if err := s.client.RemoveObject(ctx, s.bucket, probe, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("remove probe from bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+func (s *s3Storage) List(ctx context.Context, fn func(StoredObject) error) error {
+	for _, dir := range []string{s.originalDir, s.processedDir} {
+		// cancelling the listing context ends the channel early
+		listCtx, cancel := context.WithCancel(ctx)
+		objects := s.client.ListObjects(listCtx, s.bucket, minio.ListObjectsOptions{Prefix: dir + "/", Recursive: true})
+		for obj := range objects {
+			if obj.Err != nil {
+				cancel()
+				return fmt.Errorf("list bucket %s: %w", s.bucket, obj.Err)
+			}
+			if isHealthProbe(path.Base(obj.Key)) {
+				continue
+			}
+			if err := fn(StoredObject{Path: obj.Key, ModTime: obj.LastModified}); err != nil {
+				cancel()
+				return err
+			}
+		}
+		cancel()
+	}
+	return ctx.Err()
+}
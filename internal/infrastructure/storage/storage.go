@@ -2,33 +2,146 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"errors"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/config"
 )
 
 type Storage interface {
-	SaveOriginal(ctx context.Context, filename string, reader io.Reader) (string, error)
-	SaveProcessed(ctx context.Context, filename string, reader io.Reader) (string, error)
+	// SaveOriginal and SaveProcessed store reader under filename. size is
+	// its length in bytes, or -1 when unknown; backends that must announce
+	// the length, like S3, otherwise buffer the object in parts.
+	SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error)
+	SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error)
 	GetOriginal(ctx context.Context, path string) (io.ReadCloser, error)
 	GetProcessed(ctx context.Context, path string) (io.ReadCloser, error)
 	Delete(ctx context.Context, path string) error
 	DeleteAll(ctx context.Context, originalPath, processedPath string) error
+	// Exists reports whether an object is stored at path without opening
+	// it; a missing object is not an error.
+	Exists(ctx context.Context, path string) (bool, error)
+	// Ping verifies the backend is reachable and writable by writing and
+	// removing a small probe object.
+	Ping(ctx context.Context) error
+	// List calls fn for every object under the original and processed
+	// directories, skipping the health probe; an error from fn stops it.
+	List(ctx context.Context, fn func(StoredObject) error) error
+}
+
+// StoredObject is an object reported by Storage.List.
+type StoredObject struct {
+	// Path is what Save* returned for the object.
+	Path    string
+	ModTime time.Time
+}
+
+// Presigner is implemented by backends that can hand out time-limited
+// URLs for direct downloads, which only S3 does. The encryption wrapper
+// does not implement it: clients could not read the sealed bytes.
+type Presigner interface {
+	PresignedURL(ctx context.Context, path string, expiry time.Duration) (string, error)
+}
+
+// Backend names accepted by Migrator.MigrateObject.
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Migrator is implemented by storage spanning two backends, which the S3
+// backend does with s3_fallback_local or s3_local_backend. MigrateObject moves the
+// object at path to backend, keeping its path, and is a no-op when it is
+// already there. ActiveBackend names the backend new objects are written
+// to, which records keep as their backend marker. The encryption wrapper
+// passes both through: sealed bytes are copied as they are.
+type Migrator interface {
+	MigrateObject(ctx context.Context, path, backend string) error
+	ActiveBackend() string
+}
+
+// ActiveBackend is s's ActiveBackend, or "" for single-backend storage.
+func ActiveBackend(s Storage) string {
+	if migrator, ok := s.(Migrator); ok {
+		return migrator.ActiveBackend()
+	}
+	return ""
 }
 
+type backendKey struct{}
+
+// WithBackend tells reads under ctx which backend the files are recorded
+// on, so storage spanning two backends looks there first instead of
+// probing the other one. An empty backend leaves ctx as it is.
+func WithBackend(ctx context.Context, backend string) context.Context {
+	if backend == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, backendKey{}, backend)
+}
+
+func backendFrom(ctx context.Context) string {
+	backend, _ := ctx.Value(backendKey{}).(string)
+	return backend
+}
+
+// healthProbePrefix starts the probe objects Ping writes next to the
+// originals. Every Ping uses its own name, so replicas sharing a bucket or
+// directory, and concurrent probes of one replica, never remove each
+// other's probe.
+const healthProbePrefix = ".health-probe-"
+
+func newHealthProbeName() string {
+	return healthProbePrefix + uuid.NewString()
+}
+
+func isHealthProbe(name string) bool {
+	return strings.HasPrefix(name, healthProbePrefix)
+}
+
+// New builds the backend selected by cfg.Type: "local", "s3" or "memory", encrypted
+// at rest when cfg.EncryptionEnabled. The S3 fields are validated by
+// config.Load before this is called. With cfg.S3FallbackLocal an S3 that
+// is unreachable at startup is replaced by local storage until it is back;
+// with cfg.S3LocalBackend local storage is only kept as a second backend.
 func New(cfg *config.StorageConfig) (Storage, error) {
+	backend, err := newBackend(cfg)
+	if err != nil || !cfg.EncryptionEnabled {
+		return backend, err
+	}
+
+	key, err := cfg.EncryptionKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+	zlog.Logger.Info().Int("key_bits", len(key)*8).Msg("Storage encryption at rest enabled")
+	return WithEncryption(backend, key)
+}
+
+func newBackend(cfg *config.StorageConfig) (Storage, error) {
 	switch cfg.Type {
 	case "local":
 		zlog.Logger.Info().Msg("Initializing local storage")
 		return NewLocalStorage(cfg)
 	case "s3":
 		zlog.Logger.Info().Msg("Initializing S3 storage")
+		if cfg.S3FallbackLocal {
+			return newS3WithFallback(cfg)
+		}
+		if cfg.S3LocalBackend {
+			return newS3WithLocal(cfg)
+		}
 		return NewS3Storage(cfg)
+	case "memory":
+		zlog.Logger.Warn().Msg("Initializing in-memory storage, objects are lost on restart")
+		return NewMemoryStorage(cfg)
 	default:
-		zlog.Logger.Error().Str("type", cfg.Type).Msg("Unsupported storage type, use 'local' or 's3'")
+		zlog.Logger.Error().Str("type", cfg.Type).Msg("Unsupported storage type, use 'local', 's3' or 'memory'")
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.Type)
 	}
 }
@@ -37,3 +150,11 @@ func New(cfg *config.StorageConfig) (Storage, error) {
 // when an object (original/processed) cannot be found in the underlying
 // storage. Callers should use errors.Is(err, ErrObjectNotFound) to check.
 var ErrObjectNotFound = errors.New("storage: object not found")
+
+// ErrBackendNotConfigured is returned by Migrator.MigrateObject for a
+// backend the storage does not have.
+var ErrBackendNotConfigured = errors.New("storage: backend not configured")
+
+// ErrBackendUnavailable is returned by Migrator.MigrateObject while S3 is
+// unreachable.
+var ErrBackendUnavailable = errors.New("storage: backend unavailable")
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+)
+
+// fakeS3 answers the bucket check NewS3Storage makes on startup.
+func fakeS3(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func s3Config(srv *httptest.Server) *config.StorageConfig {
+	return &config.StorageConfig{
+		Type:        "s3",
+		S3Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		S3Bucket:    "images",
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+		S3Region:    "us-east-1",
+	}
+}
+
+func TestNewReturnsS3Storage(t *testing.T) {
+	srv := fakeS3(t, http.StatusOK)
+
+	st, err := New(s3Config(srv))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	s3, ok := st.(*s3Storage)
+	if !ok {
+		t.Fatalf("New returned %T, want *s3Storage", st)
+	}
+	if s3.bucket != "images" || s3.originalDir != "original" || s3.processedDir != "processed" {
+		t.Fatalf("s3 storage configured as %q %q %q", s3.bucket, s3.originalDir, s3.processedDir)
+	}
+}
+
+// recordingS3 accepts every request and records the given header of each
+// object put into the bucket, by object key.
+func recordingS3(t *testing.T, header string) (*httptest.Server, func() map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	types := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			mu.Lock()
+			types[strings.TrimPrefix(r.URL.Path, "/images/")] = r.Header.Get(header)
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return types
+	}
+}
+
+func TestS3PutsObjectsWithTheirContentType(t *testing.T) {
+	srv, putTypes := recordingS3(t, "Content-Type")
+	st, err := New(s3Config(srv))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx := context.Background()
+
+	saves := []struct {
+		save     func(context.Context, string, io.Reader, int64) (string, error)
+		filename string
+	}{
+		{st.SaveOriginal, "a.JPG"},
+		{st.SaveOriginal, "b.tiff"},
+		{st.SaveProcessed, "a_resize.jpg"},
+		{st.SaveProcessed, "a_rounded_crop.png"},
+		{st.SaveProcessed, "a_thumb.webp"},
+		{st.SaveProcessed, "a.gif"},
+		{st.SaveOriginal, "notes.txt"},
+	}
+	for _, s := range saves {
+		if _, err := s.save(ctx, s.filename, strings.NewReader("data"), 4); err != nil {
+			t.Fatalf("save %s: %v", s.filename, err)
+		}
+	}
+
+	want := map[string]string{
+		"original/a.JPG":               "image/jpeg",
+		"original/b.tiff":              "image/tiff",
+		"processed/a_resize.jpg":       "image/jpeg",
+		"processed/a_rounded_crop.png": "image/png",
+		"processed/a_thumb.webp":       "image/webp",
+		"processed/a.gif":              "image/gif",
+		"original/notes.txt":           "application/octet-stream",
+	}
+	got := putTypes()
+	for key, contentType := range want {
+		if got[key] != contentType {
+			t.Errorf("%s stored as %q, want %q", key, got[key], contentType)
+		}
+	}
+}
+
+func TestS3LabelsSealedObjectsAsBinary(t *testing.T) {
+	srv, putTypes := recordingS3(t, "Content-Type")
+	cfg := s3Config(srv)
+	// the encryption wrapper stores ciphertext in this backend
+	cfg.EncryptionEnabled = true
+	st, err := NewS3Storage(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Storage: %v", err)
+	}
+
+	if _, err := st.SaveProcessed(context.Background(), "a.jpg", strings.NewReader("sealed"), 6); err != nil {
+		t.Fatalf("SaveProcessed: %v", err)
+	}
+	if got := putTypes()["processed/a.jpg"]; got != "application/octet-stream" {
+		t.Errorf("sealed object stored as %q, want application/octet-stream", got)
+	}
+}
+
+func TestS3PutsObjectsWithTheStorageClassOfTheirKind(t *testing.T) {
+	tests := []struct {
+		name                      string
+		originalClass, processed  string
+		wantOriginal, wantProcess string
+	}{
+		{name: "per kind", originalClass: "STANDARD_IA", processed: "INTELLIGENT_TIERING", wantOriginal: "STANDARD_IA", wantProcess: "INTELLIGENT_TIERING"},
+		{name: "bucket default", wantOriginal: "", wantProcess: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, putClasses := recordingS3(t, "X-Amz-Storage-Class")
+			cfg := s3Config(srv)
+			cfg.S3OriginalStorageClass = tt.originalClass
+			cfg.S3ProcessedStorageClass = tt.processed
+			st, err := NewS3Storage(cfg)
+			if err != nil {
+				t.Fatalf("NewS3Storage: %v", err)
+			}
+
+			ctx := context.Background()
+			if _, err := st.SaveOriginal(ctx, "a.jpg", strings.NewReader("data"), 4); err != nil {
+				t.Fatalf("SaveOriginal: %v", err)
+			}
+			if _, err := st.SaveProcessed(ctx, "a_resize.jpg", strings.NewReader("data"), 4); err != nil {
+				t.Fatalf("SaveProcessed: %v", err)
+			}
+
+			got := putClasses()
+			if got["original/a.jpg"] != tt.wantOriginal || got["processed/a_resize.jpg"] != tt.wantProcess {
+				t.Fatalf("storage classes original %q, processed %q; want %q and %q",
+					got["original/a.jpg"], got["processed/a_resize.jpg"], tt.wantOriginal, tt.wantProcess)
+			}
+		})
+	}
+}
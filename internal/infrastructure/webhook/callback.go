@@ -0,0 +1,239 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
+)
+
+const (
+	// callbackBackoff is the delay before the second delivery attempt; it
+	// doubles for every attempt after that.
+	callbackBackoff = time.Second
+	// callbackQueueSize bounds the callbacks waiting for a sender; more are
+	// dropped rather than holding up processing.
+	callbackQueueSize = 1000
+	callbackSenders   = 4
+)
+
+var errCallbackQueueFull = errors.New("callback queue is full")
+
+var errBlockedCallback = errors.New("callback address is not publicly routable")
+
+// CallbackPayload is POSTed to the callback_url an image was uploaded with.
+// A failed image with NextAttemptAt set is retried and reported again.
+type CallbackPayload struct {
+	ID            string     `json:"id"`
+	Status        string     `json:"status"`
+	ProcessedURL  string     `json:"processed_url,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+}
+
+// CallbackNotifier reports processing results to the callback URL each
+// client gave at upload. Those URLs come from clients, so like URL
+// uploads it refuses to connect to addresses inside the network, and it
+// does not follow redirects. Deliveries are queued and sent by Run, so a
+// slow or failing endpoint never holds up processing.
+type CallbackNotifier struct {
+	client   *http.Client
+	secret   []byte
+	baseURL  string
+	attempts int
+	backoff  time.Duration
+	queue    chan callbackDelivery
+	// pending counts callbacks queued or being delivered, for Flush
+	pending sync.WaitGroup
+}
+
+type callbackDelivery struct {
+	imageID string
+	status  domain.ProcessingStatus
+	url     string
+	body    []byte
+}
+
+func NewCallbackNotifier(cfg *config.WebhookConfig) *CallbackNotifier {
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	attempts := cfg.CallbackAttempts
+	if attempts == 0 {
+		attempts = 3
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !helpers.PublicIP(ip) {
+				return fmt.Errorf("%w: %s", errBlockedCallback, host)
+			}
+			return nil
+		},
+	}
+
+	return &CallbackNotifier{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:       nil,
+				DialContext: dialer.DialContext,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret:   []byte(cfg.Secret),
+		baseURL:  strings.TrimSuffix(cfg.PublicBaseURL, "/"),
+		attempts: attempts,
+		backoff:  callbackBackoff,
+		queue:    make(chan callbackDelivery, callbackQueueSize),
+	}
+}
+
+// Run sends queued callbacks until ctx is done; callbacks still queued or
+// waiting for a retry then are dropped.
+func (n *CallbackNotifier) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range callbackSenders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-n.queue:
+					n.deliver(ctx, d)
+					n.pending.Done()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dropped := len(n.queue); dropped > 0 {
+		zlog.Logger.Warn().Int("dropped", dropped).Msg("callbacks still queued at shutdown were dropped")
+	}
+}
+
+// Flush waits until every queued callback has been delivered or given up,
+// so a stopping process reports the tasks it finished before Run's context
+// is cancelled.
+func (n *CallbackNotifier) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flush callbacks: %w", ctx.Err())
+	}
+}
+
+// NotifyProcessed queues the status of image for its CallbackURL; images
+// uploaded without one are skipped. It fails only when the queue is full.
+func (n *CallbackNotifier) NotifyProcessed(ctx context.Context, image *domain.Image) error {
+	if image.CallbackURL == "" {
+		return nil
+	}
+
+	payload := CallbackPayload{
+		ID:            image.ID,
+		Status:        string(image.Status),
+		Error:         image.ErrorMessage,
+		NextAttemptAt: image.NextAttemptAt,
+		Timestamp:     time.Now().UTC(),
+	}
+	if image.Status == domain.StatusCompleted {
+		payload.ProcessedURL = n.baseURL + "/image/" + image.ID
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal callback payload: %w", err)
+	}
+
+	n.pending.Add(1)
+	select {
+	case n.queue <- callbackDelivery{imageID: image.ID, status: image.Status, url: image.CallbackURL, body: body}:
+		return nil
+	default:
+		n.pending.Done()
+		return errCallbackQueueFull
+	}
+}
+
+// deliver posts one callback. Network errors, 429 and 5xx responses are
+// retried with a doubling delay, other responses are final.
+func (n *CallbackNotifier) deliver(ctx context.Context, d callbackDelivery) {
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := n.post(ctx, d.url, d.body)
+		if err == nil {
+			zlog.Logger.Debug().
+				Str("image_id", d.imageID).
+				Str("status", string(d.status)).
+				Int("attempt", attempt).
+				Msg("Callback delivered")
+			return
+		}
+		if !retryable || attempt >= n.attempts {
+			zlog.Logger.Warn().Err(err).Str("image_id", d.imageID).Int("attempts", attempt).Msg("failed to deliver callback")
+			return
+		}
+
+		zlog.Logger.Debug().Err(err).Str("image_id", d.imageID).Int("attempt", attempt).Msg("Callback failed, retrying")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post sends one attempt and reports whether a failure is worth retrying.
+func (n *CallbackNotifier) post(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("build callback request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, errBlockedCallback), fmt.Errorf("send callback: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("callback responded with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,134 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// testCallbackNotifier talks to loopback test servers, which the
+// production dialer refuses.
+func testCallbackNotifier(srv *httptest.Server, attempts int, backoff time.Duration) *CallbackNotifier {
+	n := NewCallbackNotifier(&config.WebhookConfig{Secret: "s3cret", CallbackAttempts: attempts})
+	n.client = srv.Client()
+	n.backoff = backoff
+	return n
+}
+
+func TestCallbackRetriesUntilDelivered(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	var payload CallbackPayload
+	var signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &payload)
+		signature = r.Header.Get(SignatureHeader)
+		if signature != Sign([]byte("s3cret"), body) {
+			t.Errorf("signature %q does not match the body", signature)
+		}
+	}))
+	defer srv.Close()
+
+	n := testCallbackNotifier(srv, 3, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	image := &domain.Image{ID: "img-1", Status: domain.StatusCompleted, CallbackURL: srv.URL}
+	if err := n.NotifyProcessed(context.Background(), image); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := n.Flush(flushCtx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 3 {
+		t.Fatalf("callback sent %d times, want 3", calls)
+	}
+	if payload.ID != "img-1" || payload.Status != string(domain.StatusCompleted) {
+		t.Fatalf("payload = %+v, want the completed image", payload)
+	}
+}
+
+func TestCallbackDoesNotBlockTheCaller(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := testCallbackNotifier(srv, 1, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	start := time.Now()
+	image := &domain.Image{ID: "img-2", Status: domain.StatusFailed, CallbackURL: srv.URL}
+	if err := n.NotifyProcessed(context.Background(), image); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("NotifyProcessed took %s with the endpoint hanging", elapsed)
+	}
+}
+
+func TestCallbackBackoffStopsWithContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	n := testCallbackNotifier(srv, 5, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		n.Run(ctx)
+		close(stopped)
+	}()
+
+	image := &domain.Image{ID: "img-3", Status: domain.StatusFailed, CallbackURL: srv.URL}
+	if err := n.NotifyProcessed(context.Background(), image); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+	// let the first attempt fail and the sender start its hour-long wait
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return while waiting to retry after cancel")
+	}
+}
+
+func TestCallbackSkipsImagesWithoutURL(t *testing.T) {
+	n := NewCallbackNotifier(&config.WebhookConfig{})
+	if err := n.NotifyProcessed(context.Background(), &domain.Image{ID: "img-4"}); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+	if len(n.queue) != 0 {
+		t.Fatalf("queued %d callbacks for an image without callback_url", len(n.queue))
+	}
+}
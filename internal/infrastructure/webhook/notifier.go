@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// SignatureHeader carries "sha256=<hex HMAC-SHA256 of the raw body>" keyed
+// with webhook.secret. Receivers should recompute it over the exact bytes
+// they received and compare in constant time.
+const SignatureHeader = "X-Signature"
+
+type Payload struct {
+	ImageID         string    `json:"image_id"`
+	Status          string    `json:"status"`
+	ProcessingType  string    `json:"processing_type"`
+	Width           int       `json:"width,omitempty"`
+	Height          int       `json:"height,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	FailureCategory string    `json:"failure_category,omitempty"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+type Notifier struct {
+	client *http.Client
+	url    string
+	secret []byte
+}
+
+func NewNotifier(cfg *config.WebhookConfig) *Notifier {
+	timeout := time.Duration(cfg.TimeoutSec) * time.Second
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	zlog.Logger.Info().
+		Str("url", cfg.URL).
+		Bool("signed", cfg.Secret != "").
+		Msg("Webhook notifier initialized")
+	return &Notifier{
+		client: &http.Client{Timeout: timeout},
+		url:    cfg.URL,
+		secret: []byte(cfg.Secret),
+	}
+}
+
+// Sign returns the value of SignatureHeader for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Notifier) NotifyProcessed(ctx context.Context, image *domain.Image) error {
+	body, err := json.Marshal(Payload{
+		ImageID:         image.ID,
+		Status:          string(image.Status),
+		ProcessingType:  string(image.ProcessingType),
+		Width:           image.Width,
+		Height:          image.Height,
+		Error:           image.ErrorMessage,
+		FailureCategory: string(image.FailureCategory),
+		Timestamp:       time.Now().UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	zlog.Logger.Debug().
+		Str("image_id", image.ID).
+		Str("status", string(image.Status)).
+		Msg("Webhook delivered")
+	return nil
+}
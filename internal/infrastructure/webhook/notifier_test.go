@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestSignKnownVector(t *testing.T) {
+	got := Sign([]byte("key"), []byte("The quick brown fox jumps over the lazy dog"))
+	want := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
+
+// received is one webhook delivery as the receiver saw it.
+type received struct {
+	body      []byte
+	signature string
+	signed    bool
+}
+
+func serveWebhook(t *testing.T, status int) (*httptest.Server, chan received) {
+	t.Helper()
+	deliveries := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, signed := r.Header[http.CanonicalHeaderKey(SignatureHeader)]
+		deliveries <- received{body: body, signature: r.Header.Get(SignatureHeader), signed: signed}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, deliveries
+}
+
+func TestNotifyProcessedSignatureVerifies(t *testing.T) {
+	srv, deliveries := serveWebhook(t, http.StatusOK)
+	n := NewNotifier(&config.WebhookConfig{URL: srv.URL, Secret: "s3cret"})
+
+	image := &domain.Image{ID: "img-1", Status: domain.StatusCompleted, ProcessingType: domain.ProcessingResize, Width: 64, Height: 48}
+	if err := n.NotifyProcessed(context.Background(), image); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+	got := <-deliveries
+
+	// verified the way the README tells receivers to
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(got.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(got.signature), []byte(want)) {
+		t.Fatalf("%s = %q, want %q", SignatureHeader, got.signature, want)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.ImageID != "img-1" || payload.Status != "completed" || payload.Width != 64 {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	// a forged body no longer matches the signature
+	forged := strings.Replace(string(got.body), `"completed"`, `"failed"`, 1)
+	if Sign([]byte("s3cret"), []byte(forged)) == got.signature {
+		t.Error("a tampered payload carries a valid signature")
+	}
+}
+
+func TestNotifyProcessedUnsignedWithoutSecret(t *testing.T) {
+	srv, deliveries := serveWebhook(t, http.StatusOK)
+	n := NewNotifier(&config.WebhookConfig{URL: srv.URL})
+
+	if err := n.NotifyProcessed(context.Background(), &domain.Image{ID: "img-1"}); err != nil {
+		t.Fatalf("NotifyProcessed: %v", err)
+	}
+	if got := <-deliveries; got.signed {
+		t.Fatalf("unsigned webhook carries %s %q", SignatureHeader, got.signature)
+	}
+}
+
+func TestNotifyProcessedFailsOnErrorStatus(t *testing.T) {
+	srv, _ := serveWebhook(t, http.StatusInternalServerError)
+	n := NewNotifier(&config.WebhookConfig{URL: srv.URL, Secret: "s3cret"})
+
+	if err := n.NotifyProcessed(context.Background(), &domain.Image{ID: "img-1"}); err == nil {
+		t.Fatal("NotifyProcessed succeeded against a failing receiver")
+	}
+}
@@ -3,7 +3,9 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/wb-go/wbf/dbpg"
 	"github.com/wb-go/wbf/retry"
@@ -28,11 +30,22 @@ func (r *imageRepository) Create(ctx context.Context, image *domain.Image) error
 		INSERT INTO images (
 			id, original_filename, original_path, processed_path,
 			mime_type, size, width, height, status, processing_type,
-			error_message, created_at, updated_at, processed_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			error_message, created_at, updated_at, processed_at,
+			blurhash, attempts, next_attempt_at, processing_options,
+			tenant_id, content_hash, failure_category,
+			processed_size, poor_compression, callback_url, lqip, repaired,
+			processing_duration_ms, thumbnail_path, storage_backend
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29)
+		RETURNING public_id
 	`
 
-	_, err := r.db.ExecWithRetry(ctx, r.strategy, query,
+	options, err := json.Marshal(image.Options)
+	if err != nil {
+		return fmt.Errorf("marshal processing options: %w", err)
+	}
+	// public_id is drawn from a sequence, so it is only known after the insert
+	var publicSeq int64
+	row, err := r.db.QueryRowWithRetry(ctx, r.strategy, query,
 		image.ID,
 		image.OriginalFilename,
 		image.OriginalPath,
@@ -47,12 +60,30 @@ func (r *imageRepository) Create(ctx context.Context, image *domain.Image) error
 		image.CreatedAt,
 		image.UpdatedAt,
 		image.ProcessedAt,
+		nullString(image.Blurhash),
+		image.Attempts,
+		image.NextAttemptAt,
+		options,
+		nullString(image.TenantID),
+		nullString(image.ContentHash),
+		nullString(string(image.FailureCategory)),
+		nullInt64(image.ProcessedSize),
+		image.PoorCompression,
+		nullString(image.CallbackURL),
+		nullString(image.LQIP),
+		image.Repaired,
+		nullInt64(image.ProcessingDurationMs),
+		nullString(image.ThumbnailPath),
+		nullString(image.StorageBackend),
 	)
-
+	if err == nil {
+		err = row.Scan(&publicSeq)
+	}
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to create image")
 		return fmt.Errorf("create image: %w", err)
 	}
+	image.PublicID = domain.EncodePublicID(publicSeq)
 
 	zlog.Logger.Info().Str("image_id", image.ID).Msg("image created successfully")
 	return nil
@@ -60,36 +91,12 @@ func (r *imageRepository) Create(ctx context.Context, image *domain.Image) error
 
 func (r *imageRepository) FindByID(ctx context.Context, id string) (*domain.Image, error) {
 	query := `
-		SELECT id, original_filename, original_path, processed_path,
-			   mime_type, size, width, height, status, processing_type,
-			   error_message, created_at, updated_at, processed_at
+		SELECT ` + imageColumns + `
 		FROM images
-		WHERE id = $1
+		WHERE id = $1 AND deleted_at IS NULL
 	`
 
-	var img domain.Image
-	var processedPath, errorMsg sql.NullString
-	var width, height sql.NullInt32
-	var processedAt sql.NullTime
-
-	row := r.db.Master.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&img.ID,
-		&img.OriginalFilename,
-		&img.OriginalPath,
-		&processedPath,
-		&img.MimeType,
-		&img.Size,
-		&width,
-		&height,
-		&img.Status,
-		&img.ProcessingType,
-		&errorMsg,
-		&img.CreatedAt,
-		&img.UpdatedAt,
-		&processedAt,
-	)
-
+	img, err := scanImage(r.db.Master.QueryRowContext(ctx, query, id))
 	if err == sql.ErrNoRows {
 		return nil, domain.ErrImageNotFound
 	}
@@ -98,48 +105,71 @@ func (r *imageRepository) FindByID(ctx context.Context, id string) (*domain.Imag
 		return nil, fmt.Errorf("find image: %w", err)
 	}
 
-	if processedPath.Valid {
-		img.ProcessedPath = processedPath.String
-	}
-	if errorMsg.Valid {
-		img.ErrorMessage = errorMsg.String
-	}
-	if width.Valid {
-		img.Width = int(width.Int32)
+	return img, nil
+}
+
+func (r *imageRepository) FindByPublicID(ctx context.Context, publicID string) (*domain.Image, error) {
+	seq, ok := domain.DecodePublicID(publicID)
+	if !ok {
+		return nil, domain.ErrImageNotFound
 	}
-	if height.Valid {
-		img.Height = int(height.Int32)
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE public_id = $1 AND deleted_at IS NULL
+	`
+
+	img, err := scanImage(r.db.Master.QueryRowContext(ctx, query, seq))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrImageNotFound
 	}
-	if processedAt.Valid {
-		img.ProcessedAt = &processedAt.Time
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("public_id", publicID).Msg("failed to find image by public id")
+		return nil, fmt.Errorf("find image by public id: %w", err)
 	}
 
-	return &img, nil
+	return img, nil
 }
 
-func (r *imageRepository) Update(ctx context.Context, image *domain.Image) error {
-	query := `
-		UPDATE images
-		SET original_filename = $2,
+// imageUpdateSet lists the columns Update and Complete set; updateArgs
+// supplies its parameters, the image id first. processed_path is left to
+// Complete: a run that loaded the image before another one completed must
+// not write the path it started from back.
+const imageUpdateSet = `
+		    original_filename = $2,
 		    original_path = $3,
-		    processed_path = $4,
-		    mime_type = $5,
-		    size = $6,
-		    width = $7,
-		    height = $8,
-		    status = $9,
-		    processing_type = $10,
-		    error_message = $11,
-		    processed_at = $12,
+		    mime_type = $4,
+		    size = $5,
+		    width = $6,
+		    height = $7,
+		    status = $8,
+		    processing_type = $9,
+		    error_message = $10,
+		    processed_at = $11,
+		    blurhash = $12,
+		    attempts = $13,
+		    next_attempt_at = $14,
+		    processing_options = $15,
+		    content_hash = $16,
+		    failure_category = $17,
+		    processed_size = $18,
+		    poor_compression = $19,
+		    lqip = $20,
+		    repaired = $21,
+		    processing_duration_ms = $22,
 		    updated_at = NOW()
-		WHERE id = $1
-	`
+`
 
-	result, err := r.db.ExecWithRetry(ctx, r.strategy, query,
+func updateArgs(image *domain.Image) ([]any, error) {
+	options, err := json.Marshal(image.Options)
+	if err != nil {
+		return nil, fmt.Errorf("marshal processing options: %w", err)
+	}
+	return []any{
 		image.ID,
 		image.OriginalFilename,
 		image.OriginalPath,
-		nullString(image.ProcessedPath),
 		image.MimeType,
 		image.Size,
 		nullInt(image.Width),
@@ -148,8 +178,28 @@ func (r *imageRepository) Update(ctx context.Context, image *domain.Image) error
 		image.ProcessingType,
 		nullString(image.ErrorMessage),
 		image.ProcessedAt,
-	)
+		nullString(image.Blurhash),
+		image.Attempts,
+		image.NextAttemptAt,
+		options,
+		nullString(image.ContentHash),
+		nullString(string(image.FailureCategory)),
+		nullInt64(image.ProcessedSize),
+		image.PoorCompression,
+		nullString(image.LQIP),
+		image.Repaired,
+		nullInt64(image.ProcessingDurationMs),
+	}, nil
+}
 
+func (r *imageRepository) Update(ctx context.Context, image *domain.Image) error {
+	query := `UPDATE images SET` + imageUpdateSet + `WHERE id = $1`
+
+	args, err := updateArgs(image)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query, args...)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to update image")
 		return fmt.Errorf("update image: %w", err)
@@ -168,8 +218,49 @@ func (r *imageRepository) Update(ctx context.Context, image *domain.Image) error
 	return nil
 }
 
+// Complete writes image like Update, processed_path included, and returns
+// the processed_path it replaced. The old row is locked and read in the
+// same statement, so of two reprocesses finishing together the later one
+// gets the path the earlier one just stored, never the path both started
+// from.
+func (r *imageRepository) Complete(ctx context.Context, image *domain.Image) (string, error) {
+	query := `
+		UPDATE images SET processed_path = $23,` + imageUpdateSet + `
+		FROM (SELECT id, processed_path FROM images WHERE id = $1 FOR UPDATE) previous
+		WHERE images.id = previous.id
+		RETURNING previous.processed_path
+	`
+
+	args, err := updateArgs(image)
+	if err != nil {
+		return "", err
+	}
+	args = append(args, nullString(image.ProcessedPath))
+	var previous sql.NullString
+	row, err := r.db.QueryRowWithRetry(ctx, r.strategy, query, args...)
+	if err == nil {
+		err = row.Scan(&previous)
+	}
+	if err == sql.ErrNoRows {
+		return "", domain.ErrImageNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to complete image")
+		return "", fmt.Errorf("complete image: %w", err)
+	}
+
+	zlog.Logger.Info().Str("image_id", image.ID).Msg("image completed successfully")
+	return previous.String, nil
+}
+
+// Delete soft-deletes the image: the row and its files stay until Purge,
+// but every lookup and listing treats it as gone.
 func (r *imageRepository) Delete(ctx context.Context, id string) error {
-	query := `DELETE FROM images WHERE id = $1`
+	query := `
+		UPDATE images
+		SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
 
 	result, err := r.db.ExecWithRetry(ctx, r.strategy, query, id)
 	if err != nil {
@@ -186,17 +277,60 @@ func (r *imageRepository) Delete(ctx context.Context, id string) error {
 		return domain.ErrImageNotFound
 	}
 
-	zlog.Logger.Info().Str("image_id", id).Msg("image deleted successfully")
+	zlog.Logger.Info().Str("image_id", id).Msg("image soft-deleted successfully")
+	return nil
+}
+
+// Purge removes the image row for good, whether or not it was
+// soft-deleted; its jobs and processed variant rows go with it.
+func (r *imageRepository) Purge(ctx context.Context, id string) error {
+	query := `DELETE FROM images WHERE id = $1`
+
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query, id)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to purge image")
+		return fmt.Errorf("purge image: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return domain.ErrImageNotFound
+	}
+
+	zlog.Logger.Info().Str("image_id", id).Msg("image purged successfully")
 	return nil
 }
 
+// FindDeletedBefore returns images soft-deleted before the given time,
+// oldest deletion first.
+func (r *imageRepository) FindDeletedBefore(ctx context.Context, before time.Time, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		ORDER BY deleted_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryWithRetry(ctx, r.strategy, query, before, limit)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Time("before", before).Msg("failed to find deleted images")
+		return nil, fmt.Errorf("find deleted images: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanImages(rows)
+}
+
 func (r *imageRepository) FindByStatus(ctx context.Context, status domain.ProcessingStatus, limit, offset int) ([]*domain.Image, error) {
 	query := `
-		SELECT id, original_filename, original_path, processed_path,
-			   mime_type, size, width, height, status, processing_type,
-			   error_message, created_at, updated_at, processed_at
+		SELECT ` + imageColumns + `
 		FROM images
-		WHERE status = $1
+		WHERE status = $1 AND deleted_at IS NULL
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`
@@ -211,13 +345,17 @@ func (r *imageRepository) FindByStatus(ctx context.Context, status domain.Proces
 	return r.scanImages(rows)
 }
 
-func (r *imageRepository) List(ctx context.Context, limit, offset int) ([]*domain.Image, error) {
+func (r *imageRepository) List(ctx context.Context, limit, offset int, sort domain.ListSort) ([]*domain.Image, error) {
+	orderBy, err := orderByClause(sort)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
-		SELECT id, original_filename, original_path, processed_path,
-			   mime_type, size, width, height, status, processing_type,
-			   error_message, created_at, updated_at, processed_at
+		SELECT ` + imageColumns + `
 		FROM images
-		ORDER BY created_at DESC
+		WHERE deleted_at IS NULL
+		ORDER BY ` + orderBy + `
 		LIMIT $1 OFFSET $2
 	`
 
@@ -231,6 +369,55 @@ func (r *imageRepository) List(ctx context.Context, limit, offset int) ([]*domai
 	return r.scanImages(rows)
 }
 
+// ListAfterID pages through the images matching filter in id order,
+// starting after afterID (empty for the first page). Keyset paging stays
+// cheap on large tables.
+func (r *imageRepository) ListAfterID(ctx context.Context, filter domain.ImageFilter, afterID string, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE id > $1 AND deleted_at IS NULL
+		  AND ($3 = '' OR status = $3)
+		  AND ($4 = '' OR processing_type = $4)
+		ORDER BY id
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryWithRetry(ctx, r.strategy, query, afterID, limit, string(filter.Status), string(filter.ProcessingType))
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("after_id", afterID).Msg("failed to list images after id")
+		return nil, fmt.Errorf("list images after id: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanImages(rows)
+}
+
+func (r *imageRepository) SetStorageBackend(ctx context.Context, id, backend string) error {
+	query := `
+		UPDATE images
+		SET storage_backend = $2, updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query, id, nullString(backend))
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to update storage backend")
+		return fmt.Errorf("update storage backend: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return domain.ErrImageNotFound
+	}
+
+	return nil
+}
+
 func (r *imageRepository) UpdateStatus(ctx context.Context, id string, status domain.ProcessingStatus) error {
 	query := `
 		UPDATE images
@@ -256,52 +443,371 @@ func (r *imageRepository) UpdateStatus(ctx context.Context, id string, status do
 	return nil
 }
 
-func (r *imageRepository) scanImages(rows *sql.Rows) ([]*domain.Image, error) {
-	var images []*domain.Image
+// ClaimDueForRetry locks the due rows with SKIP LOCKED, so a concurrent
+// claim passes over them instead of waiting, and clears their schedule in
+// the same statement. It is not retried: a claim that committed before
+// the connection failed would be lost along with its images.
+func (r *imageRepository) ClaimDueForRetry(ctx context.Context, now time.Time, limit int) ([]*domain.Image, error) {
+	query := `
+		UPDATE images
+		SET next_attempt_at = NULL, updated_at = NOW()
+		WHERE id IN (
+			SELECT id
+			FROM images
+			WHERE status = $1 AND next_attempt_at IS NOT NULL AND next_attempt_at <= $2
+			  AND deleted_at IS NULL
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + imageColumns
 
-	for rows.Next() {
-		var img domain.Image
-		var processedPath, errorMsg sql.NullString
-		var width, height sql.NullInt32
-		var processedAt sql.NullTime
-
-		err := rows.Scan(
-			&img.ID,
-			&img.OriginalFilename,
-			&img.OriginalPath,
-			&processedPath,
-			&img.MimeType,
-			&img.Size,
-			&width,
-			&height,
-			&img.Status,
-			&img.ProcessingType,
-			&errorMsg,
-			&img.CreatedAt,
-			&img.UpdatedAt,
-			&processedAt,
+	rows, err := r.db.Master.QueryContext(ctx, query, domain.StatusFailed, now, limit)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to claim images due for retry")
+		return nil, fmt.Errorf("claim images due for retry: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanImages(rows)
+}
+
+func (r *imageRepository) Count(ctx context.Context) (int, error) {
+	query := `SELECT COUNT(*) FROM images WHERE deleted_at IS NULL`
+
+	var count int
+	if err := r.db.Master.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to count images")
+		return 0, fmt.Errorf("count images: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *imageRepository) CountByStatus(ctx context.Context, status domain.ProcessingStatus) (int, error) {
+	query := `SELECT COUNT(*) FROM images WHERE status = $1 AND deleted_at IS NULL`
+
+	var count int
+	if err := r.db.Master.QueryRowContext(ctx, query, status).Scan(&count); err != nil {
+		zlog.Logger.Error().Err(err).Str("status", string(status)).Msg("failed to count images by status")
+		return 0, fmt.Errorf("count images by status: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *imageRepository) CountPendingBefore(ctx context.Context, createdAt time.Time) (int, error) {
+	query := `SELECT COUNT(*) FROM images WHERE status = $1 AND created_at < $2 AND deleted_at IS NULL`
+
+	var count int
+	if err := r.db.Master.QueryRowContext(ctx, query, domain.StatusPending, createdAt).Scan(&count); err != nil {
+		zlog.Logger.Error().Err(err).Time("created_at", createdAt).Msg("failed to count pending images")
+		return 0, fmt.Errorf("count pending images: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *imageRepository) CountOriginalsByTenant(ctx context.Context, tenantID string) (int, error) {
+	query := `SELECT COUNT(*) FROM images WHERE tenant_id = $1 AND original_path <> '' AND deleted_at IS NULL`
+
+	var count int
+	if err := r.db.Master.QueryRowContext(ctx, query, tenantID).Scan(&count); err != nil {
+		zlog.Logger.Error().Err(err).Str("tenant_id", tenantID).Msg("failed to count tenant originals")
+		return 0, fmt.Errorf("count tenant originals: %w", err)
+	}
+
+	return count, nil
+}
+
+// FindOldestPrunableByTenant returns the tenant's oldest completed images that
+// still have their original; pending ones are skipped as they need it, and so
+// are images whose processed output is the original itself.
+func (r *imageRepository) FindOldestPrunableByTenant(ctx context.Context, tenantID string, limit int) ([]*domain.Image, error) {
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE tenant_id = $1 AND status = $2 AND original_path <> ''
+		  AND processed_path IS DISTINCT FROM original_path AND deleted_at IS NULL
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryWithRetry(ctx, r.strategy, query, tenantID, domain.StatusCompleted, limit)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("tenant_id", tenantID).Msg("failed to find prunable images")
+		return nil, fmt.Errorf("find prunable images: %w", err)
+	}
+	defer rows.Close()
+
+	return r.scanImages(rows)
+}
+
+// FindDuplicate returns the oldest live image of the tenant with the same
+// content hash, processing type and options that is not failed, or
+// ErrImageNotFound.
+func (r *imageRepository) FindDuplicate(ctx context.Context, contentHash, tenantID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) (*domain.Image, error) {
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return nil, fmt.Errorf("marshal processing options: %w", err)
+	}
+
+	query := `
+		SELECT ` + imageColumns + `
+		FROM images
+		WHERE content_hash = $1 AND COALESCE(tenant_id, '') = $2
+		  AND processing_type = $3 AND processing_options = $4::jsonb
+		  AND status IN ($5, $6, $7) AND deleted_at IS NULL
+		ORDER BY created_at
+		LIMIT 1
+	`
+
+	img, err := scanImage(r.db.Master.QueryRowContext(ctx, query,
+		contentHash, tenantID, processingType, string(options),
+		domain.StatusPending, domain.StatusProcessing, domain.StatusCompleted,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrImageNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("content_hash", contentHash).Msg("failed to find duplicate image")
+		return nil, fmt.Errorf("find duplicate image: %w", err)
+	}
+
+	return img, nil
+}
+
+// UnreferencedPaths returns those of paths that no image, soft-deleted
+// ones included, uses as its original, processed image, thumbnail or
+// variant, and no processed variant uses as its result.
+func (r *imageRepository) UnreferencedPaths(ctx context.Context, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	list, err := json.Marshal(paths)
+	if err != nil {
+		return nil, fmt.Errorf("marshal paths: %w", err)
+	}
+
+	query := `
+		SELECT p.path
+		FROM jsonb_array_elements_text($1::jsonb) AS p(path)
+		WHERE NOT EXISTS (
+			SELECT 1 FROM images
+			WHERE original_path = p.path OR processed_path = p.path OR thumbnail_path = p.path
 		)
-		if err != nil {
-			return nil, fmt.Errorf("scan image: %w", err)
-		}
+		  AND NOT EXISTS (SELECT 1 FROM processed_variants WHERE processed_path = p.path)
+		  AND NOT EXISTS (SELECT 1 FROM image_variants WHERE path = p.path)
+	`
 
-		if processedPath.Valid {
-			img.ProcessedPath = processedPath.String
-		}
-		if errorMsg.Valid {
-			img.ErrorMessage = errorMsg.String
+	rows, err := r.db.QueryWithRetry(ctx, r.strategy, query, string(list))
+	if err != nil {
+		zlog.Logger.Error().Err(err).Int("paths", len(paths)).Msg("failed to find unreferenced paths")
+		return nil, fmt.Errorf("find unreferenced paths: %w", err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan unreferenced path: %w", err)
 		}
-		if width.Valid {
-			img.Width = int(width.Int32)
+		unreferenced = append(unreferenced, path)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate unreferenced paths: %w", err)
+	}
+	return unreferenced, nil
+}
+
+// PerformanceBuckets groups finished attempts by the hour they finished
+// in: processed_at for completed images, updated_at for failed ones.
+// Images processed before durations were recorded are left out.
+func (r *imageRepository) PerformanceBuckets(ctx context.Context, since time.Time) ([]domain.PerformanceBucket, error) {
+	query := `
+		SELECT date_trunc('hour', finished_at AT TIME ZONE 'UTC') AS bucket,
+		       COUNT(*) FILTER (WHERE status = $2),
+		       COUNT(*) FILTER (WHERE status <> $2),
+		       COALESCE(AVG(processing_duration_ms) FILTER (WHERE status = $2), 0)
+		FROM (
+			SELECT status, processing_duration_ms,
+			       CASE WHEN status = $2 THEN processed_at ELSE updated_at END AS finished_at
+			FROM images
+			WHERE processing_duration_ms IS NOT NULL
+			  AND status IN ($2, $3, $4)
+		) finished
+		WHERE finished_at >= $1
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+
+	rows, err := r.db.QueryWithRetry(ctx, r.strategy, query, since,
+		domain.StatusCompleted, domain.StatusFailed, domain.StatusDeadLettered)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Time("since", since).Msg("failed to aggregate processing performance")
+		return nil, fmt.Errorf("aggregate processing performance: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []domain.PerformanceBucket
+	for rows.Next() {
+		var b domain.PerformanceBucket
+		if err := rows.Scan(&b.Start, &b.Completed, &b.Failed, &b.AvgDurationMs); err != nil {
+			return nil, fmt.Errorf("scan performance bucket: %w", err)
 		}
-		if height.Valid {
-			img.Height = int(height.Int32)
+		// AT TIME ZONE yields a timestamp without zone, read back as UTC
+		b.Start = time.Date(b.Start.Year(), b.Start.Month(), b.Start.Day(), b.Start.Hour(), 0, 0, 0, time.UTC)
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate performance buckets: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// imageColumns selects an image for scanImage; its variants are gathered
+// from image_variants into the JSON array scanImage expects.
+const imageColumns = `id, original_filename, original_path, processed_path,
+			   mime_type, size, width, height, status, processing_type,
+			   error_message, created_at, updated_at, processed_at,
+			   blurhash, attempts, next_attempt_at, processing_options,
+			   tenant_id, content_hash, public_id,
+			   COALESCE((
+				   SELECT jsonb_agg(jsonb_build_object(
+					   'name', v.name, 'path', v.path, 'format', v.format,
+					   'content_type', v.content_type, 'width', v.width, 'height', v.height
+				   ) ORDER BY v.name)
+				   FROM image_variants v
+				   WHERE v.image_id = images.id
+			   ), '[]'::jsonb) AS variants,
+			   failure_category,
+			   processed_size, poor_compression, callback_url, lqip, repaired,
+			   processing_duration_ms, thumbnail_path, storage_backend`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanImage(row rowScanner) (*domain.Image, error) {
+	var img domain.Image
+	var processedPath, errorMsg, blurhash, tenantID, contentHash, failureCategory, callbackURL, lqip, thumbnailPath, storageBackend sql.NullString
+	var width, height sql.NullInt32
+	var processedAt, nextAttemptAt sql.NullTime
+	var processedSize, processingDuration sql.NullInt64
+	var publicSeq int64
+	var options, variants []byte
+
+	err := row.Scan(
+		&img.ID,
+		&img.OriginalFilename,
+		&img.OriginalPath,
+		&processedPath,
+		&img.MimeType,
+		&img.Size,
+		&width,
+		&height,
+		&img.Status,
+		&img.ProcessingType,
+		&errorMsg,
+		&img.CreatedAt,
+		&img.UpdatedAt,
+		&processedAt,
+		&blurhash,
+		&img.Attempts,
+		&nextAttemptAt,
+		&options,
+		&tenantID,
+		&contentHash,
+		&publicSeq,
+		&variants,
+		&failureCategory,
+		&processedSize,
+		&img.PoorCompression,
+		&callbackURL,
+		&lqip,
+		&img.Repaired,
+		&processingDuration,
+		&thumbnailPath,
+		&storageBackend,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if processedPath.Valid {
+		img.ProcessedPath = processedPath.String
+	}
+	if errorMsg.Valid {
+		img.ErrorMessage = errorMsg.String
+	}
+	if width.Valid {
+		img.Width = int(width.Int32)
+	}
+	if height.Valid {
+		img.Height = int(height.Int32)
+	}
+	if processedAt.Valid {
+		img.ProcessedAt = &processedAt.Time
+	}
+	if blurhash.Valid {
+		img.Blurhash = blurhash.String
+	}
+	if lqip.Valid {
+		img.LQIP = lqip.String
+	}
+	if nextAttemptAt.Valid {
+		img.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if tenantID.Valid {
+		img.TenantID = tenantID.String
+	}
+	if contentHash.Valid {
+		img.ContentHash = contentHash.String
+	}
+	if failureCategory.Valid {
+		img.FailureCategory = domain.FailureCategory(failureCategory.String)
+	}
+	if processedSize.Valid {
+		img.ProcessedSize = processedSize.Int64
+	}
+	if processingDuration.Valid {
+		img.ProcessingDurationMs = processingDuration.Int64
+	}
+	if thumbnailPath.Valid {
+		img.ThumbnailPath = thumbnailPath.String
+	}
+	if storageBackend.Valid {
+		img.StorageBackend = storageBackend.String
+	}
+	if callbackURL.Valid {
+		img.CallbackURL = callbackURL.String
+	}
+	img.PublicID = domain.EncodePublicID(publicSeq)
+	if len(variants) > 0 {
+		if err := json.Unmarshal(variants, &img.Variants); err != nil {
+			return nil, fmt.Errorf("unmarshal variants: %w", err)
 		}
-		if processedAt.Valid {
-			img.ProcessedAt = &processedAt.Time
+	}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &img.Options); err != nil {
+			return nil, fmt.Errorf("unmarshal processing options: %w", err)
 		}
+	}
+
+	return &img, nil
+}
 
-		images = append(images, &img)
+func (r *imageRepository) scanImages(rows *sql.Rows) ([]*domain.Image, error) {
+	var images []*domain.Image
+
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan image: %w", err)
+		}
+		images = append(images, img)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -311,6 +817,32 @@ func (r *imageRepository) scanImages(rows *sql.Rows) ([]*domain.Image, error) {
 	return images, nil
 }
 
+// sortColumns whitelists the columns that may appear in ORDER BY,
+// so user input is never interpolated into the query directly.
+var sortColumns = map[domain.SortField]string{
+	domain.SortByCreatedAt: "created_at",
+	domain.SortBySize:      "size",
+	domain.SortByStatus:    "status",
+}
+
+var sortDirections = map[domain.SortOrder]string{
+	domain.SortAsc:  "ASC",
+	domain.SortDesc: "DESC",
+}
+
+func orderByClause(sort domain.ListSort) (string, error) {
+	column, ok := sortColumns[sort.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: field %q", domain.ErrInvalidSort, sort.Field)
+	}
+	direction, ok := sortDirections[sort.Order]
+	if !ok {
+		return "", fmt.Errorf("%w: order %q", domain.ErrInvalidSort, sort.Order)
+	}
+	// id as a tie-breaker keeps pagination stable for equal values
+	return column + " " + direction + ", id " + direction, nil
+}
+
 // Helper functions
 func nullString(s string) sql.NullString {
 	if s == "" {
@@ -325,3 +857,10 @@ func nullInt(i int) sql.NullInt32 {
 	}
 	return sql.NullInt32{Int32: int32(i), Valid: true}
 }
+
+func nullInt64(i int64) sql.NullInt64 {
+	if i == 0 {
+		return sql.NullInt64{Valid: false}
+	}
+	return sql.NullInt64{Int64: i, Valid: true}
+}
@@ -0,0 +1,393 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+var errConnReset = errors.New("connection reset by peer")
+
+// flakyDB fails its first failures queries and then answers every query
+// with a single public_id row.
+type flakyDB struct {
+	mu       sync.Mutex
+	failures int
+	queries  int
+}
+
+func (d *flakyDB) Connect(context.Context) (driver.Conn, error) { return flakyConn{d}, nil }
+func (d *flakyDB) Driver() driver.Driver                        { return nil }
+
+type flakyConn struct {
+	db *flakyDB
+}
+
+func (flakyConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (flakyConn) Close() error                        { return nil }
+func (flakyConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+// CheckNamedValue passes every argument through unconverted.
+func (flakyConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c flakyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.queries++
+	if c.db.failures > 0 {
+		c.db.failures--
+		return nil, errConnReset
+	}
+	return &publicIDRows{}, nil
+}
+
+type publicIDRows struct {
+	done bool
+}
+
+func (*publicIDRows) Columns() []string { return []string{"public_id"} }
+func (*publicIDRows) Close() error      { return nil }
+
+func (r *publicIDRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(42)
+	return nil
+}
+
+func newFlakyRepository(failures int) (domain.ImageRepository, *flakyDB) {
+	fake := &flakyDB{failures: failures}
+	db := &dbpg.DB{Master: sql.OpenDB(fake)}
+	strategy := retry.Strategy{Attempts: 3, Delay: time.Millisecond, Backoff: 1}
+	return NewImageRepository(db, strategy), fake
+}
+
+func TestImageCreateRetriesTransientFailures(t *testing.T) {
+	repo, fake := newFlakyRepository(2)
+	image := &domain.Image{ID: uuid.NewString(), OriginalFilename: "a.jpg", Status: domain.StatusPending}
+
+	if err := repo.Create(context.Background(), image); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if fake.queries != 3 {
+		t.Errorf("queries = %d, want 2 failures and the insert", fake.queries)
+	}
+	if image.PublicID != domain.EncodePublicID(42) {
+		t.Errorf("PublicID = %q, want the one returned by the insert", image.PublicID)
+	}
+}
+
+func TestImageCreateGivesUpAfterStrategyAttempts(t *testing.T) {
+	repo, fake := newFlakyRepository(5)
+
+	err := repo.Create(context.Background(), &domain.Image{ID: uuid.NewString()})
+	if !errors.Is(err, errConnReset) {
+		t.Fatalf("err = %v, want the last connection error", err)
+	}
+	if fake.queries != 3 {
+		t.Errorf("queries = %d, want the 3 attempts of the strategy", fake.queries)
+	}
+}
+
+func TestClaimDueForRetryIsExclusive(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewImageRepository(db, retry.Strategy{Attempts: 1})
+
+	const due = 20
+	ids := make(map[string]bool)
+	for range due {
+		id := insertTestImage(t, db)
+		_, err := db.Master.ExecContext(context.Background(),
+			`UPDATE images SET status = $2, next_attempt_at = NOW() - INTERVAL '1 minute' WHERE id = $1`,
+			id, domain.StatusFailed)
+		if err != nil {
+			t.Fatalf("schedule retry: %v", err)
+		}
+		ids[id] = true
+	}
+
+	const schedulers = 8
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+	var wg sync.WaitGroup
+	for range schedulers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			images, err := repo.ClaimDueForRetry(context.Background(), time.Now(), 5)
+			if err != nil {
+				t.Errorf("claim: %v", err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for _, img := range images {
+				if img.NextAttemptAt != nil {
+					t.Errorf("claimed image %s still scheduled", img.ID)
+				}
+				claimed[img.ID]++
+			}
+		}()
+	}
+	wg.Wait()
+
+	for id, n := range claimed {
+		if ids[id] && n > 1 {
+			t.Errorf("image %s claimed %d times", id, n)
+		}
+	}
+	images, err := repo.ClaimDueForRetry(context.Background(), time.Now(), due)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	for _, img := range images {
+		if ids[img.ID] && claimed[img.ID] > 0 {
+			t.Errorf("image %s claimed again after its schedule was cleared", img.ID)
+		}
+	}
+}
+
+func TestOrderByClause(t *testing.T) {
+	tests := []struct {
+		sort domain.ListSort
+		want string
+	}{
+		{domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortDesc}, "created_at DESC, id DESC"},
+		{domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortAsc}, "created_at ASC, id ASC"},
+		{domain.ListSort{Field: domain.SortBySize, Order: domain.SortAsc}, "size ASC, id ASC"},
+		{domain.ListSort{Field: domain.SortBySize, Order: domain.SortDesc}, "size DESC, id DESC"},
+		{domain.ListSort{Field: domain.SortByStatus, Order: domain.SortAsc}, "status ASC, id ASC"},
+		{domain.ListSort{Field: domain.SortByStatus, Order: domain.SortDesc}, "status DESC, id DESC"},
+	}
+	for _, tt := range tests {
+		got, err := orderByClause(tt.sort)
+		if err != nil {
+			t.Errorf("orderByClause(%+v): %v", tt.sort, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("orderByClause(%+v) = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestListRejectsSortOutsideWhitelist(t *testing.T) {
+	repo, fake := newFlakyRepository(0)
+
+	for _, sort := range []domain.ListSort{
+		{Field: "size; DROP TABLE images", Order: domain.SortAsc},
+		{Field: domain.SortBySize, Order: "ASC NULLS FIRST"},
+	} {
+		if _, err := repo.List(context.Background(), 10, 0, sort); !errors.Is(err, domain.ErrInvalidSort) {
+			t.Errorf("List(%+v) err = %v, want ErrInvalidSort", sort, err)
+		}
+	}
+	if fake.queries != 0 {
+		t.Errorf("queries = %d, want none for a rejected sort", fake.queries)
+	}
+}
+
+func TestListSortsByEachField(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewImageRepository(db, retry.Strategy{Attempts: 1})
+	ctx := context.Background()
+
+	// inserted oldest first; sizes and statuses are in neither that order
+	// nor its reverse
+	rows := []struct {
+		size   int
+		status domain.ProcessingStatus
+	}{
+		{size: 300, status: domain.StatusFailed},
+		{size: 100, status: domain.StatusCompleted},
+		{size: 200, status: domain.StatusProcessing},
+	}
+	created := time.Now().Add(-time.Hour)
+	var ids []string
+	for i, row := range rows {
+		id := insertTestImage(t, db)
+		_, err := db.Master.ExecContext(ctx,
+			`UPDATE images SET size = $2, status = $3, created_at = $4 WHERE id = $1`,
+			id, row.size, row.status, created.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("update image: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	tests := []struct {
+		sort domain.ListSort
+		want []int // indexes into rows
+	}{
+		{domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortAsc}, []int{0, 1, 2}},
+		{domain.ListSort{Field: domain.SortByCreatedAt, Order: domain.SortDesc}, []int{2, 1, 0}},
+		{domain.ListSort{Field: domain.SortBySize, Order: domain.SortAsc}, []int{1, 2, 0}},
+		{domain.ListSort{Field: domain.SortBySize, Order: domain.SortDesc}, []int{0, 2, 1}},
+		{domain.ListSort{Field: domain.SortByStatus, Order: domain.SortAsc}, []int{1, 0, 2}},
+		{domain.ListSort{Field: domain.SortByStatus, Order: domain.SortDesc}, []int{2, 0, 1}},
+	}
+	for _, tt := range tests {
+		images, err := repo.List(ctx, 100000, 0, tt.sort)
+		if err != nil {
+			t.Fatalf("List(%+v): %v", tt.sort, err)
+		}
+		// the database is shared, so only the relative order of our rows counts
+		var got []string
+		for _, img := range images {
+			for _, id := range ids {
+				if img.ID == id {
+					got = append(got, id)
+				}
+			}
+		}
+		if len(got) != len(ids) {
+			t.Fatalf("List(%+v) returned %d of our %d images", tt.sort, len(got), len(ids))
+		}
+		for i, idx := range tt.want {
+			if got[i] != ids[idx] {
+				t.Errorf("List(%+v): position %d is image %s, want row %d", tt.sort, i, got[i], idx)
+				break
+			}
+		}
+	}
+}
+
+func TestPerformanceBucketsAggregatesByHour(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewImageRepository(db, retry.Strategy{Attempts: 1})
+	ctx := context.Background()
+
+	// long past, so rows of other tests stay out of these hours
+	first := time.Date(2001, 2, 3, 10, 0, 0, 0, time.UTC)
+	third := first.Add(2 * time.Hour)
+	rows := []struct {
+		status      domain.ProcessingStatus
+		durationMs  sql.NullInt64
+		processedAt sql.NullTime
+		updatedAt   time.Time
+	}{
+		// first hour: two completed, one failed
+		{domain.StatusCompleted, sql.NullInt64{Int64: 100, Valid: true}, sql.NullTime{Time: first.Add(5 * time.Minute), Valid: true}, third.Add(50 * time.Minute)},
+		{domain.StatusCompleted, sql.NullInt64{Int64: 300, Valid: true}, sql.NullTime{Time: first.Add(59 * time.Minute), Valid: true}, first.Add(59 * time.Minute)},
+		{domain.StatusFailed, sql.NullInt64{Int64: 9000, Valid: true}, sql.NullTime{}, first.Add(20 * time.Minute)},
+		// left out: processed before durations were recorded, still running
+		{domain.StatusCompleted, sql.NullInt64{}, sql.NullTime{Time: first.Add(10 * time.Minute), Valid: true}, first.Add(10 * time.Minute)},
+		{domain.StatusProcessing, sql.NullInt64{Int64: 50, Valid: true}, sql.NullTime{}, first.Add(time.Minute)},
+		// third hour: a failure that completed once before counts when it failed
+		{domain.StatusDeadLettered, sql.NullInt64{Int64: 10, Valid: true}, sql.NullTime{}, third.Add(time.Minute)},
+		{domain.StatusFailed, sql.NullInt64{Int64: 20, Valid: true}, sql.NullTime{Time: first.Add(30 * time.Minute), Valid: true}, third.Add(30 * time.Minute)},
+		// before the window
+		{domain.StatusCompleted, sql.NullInt64{Int64: 70, Valid: true}, sql.NullTime{Time: first.Add(-time.Minute), Valid: true}, first.Add(-time.Minute)},
+	}
+	for _, row := range rows {
+		id := insertTestImage(t, db)
+		_, err := db.Master.ExecContext(ctx,
+			`UPDATE images SET status = $2, processing_duration_ms = $3, processed_at = $4, updated_at = $5 WHERE id = $1`,
+			id, row.status, row.durationMs, row.processedAt, row.updatedAt)
+		if err != nil {
+			t.Fatalf("update image: %v", err)
+		}
+	}
+
+	buckets, err := repo.PerformanceBuckets(ctx, first)
+	if err != nil {
+		t.Fatalf("PerformanceBuckets: %v", err)
+	}
+	got := map[time.Time]domain.PerformanceBucket{}
+	for _, b := range buckets {
+		if b.Start.Before(first) {
+			t.Errorf("bucket %v starts before the window", b.Start)
+		}
+		if !b.Start.After(third) {
+			got[b.Start] = b
+		}
+	}
+
+	want := map[time.Time]domain.PerformanceBucket{
+		first: {Start: first, Completed: 2, Failed: 1, AvgDurationMs: 200},
+		third: {Start: third, Completed: 0, Failed: 2, AvgDurationMs: 0},
+	}
+	if len(got) != len(want) {
+		t.Errorf("got buckets %+v, want %+v", got, want)
+	}
+	for start, w := range want {
+		if g := got[start]; g != w {
+			t.Errorf("bucket %v = %+v, want %+v", start, g, w)
+		}
+	}
+}
+
+func TestCompleteReturnsThePathItReplaced(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewImageRepository(db, retry.Strategy{Attempts: 1})
+	ctx := context.Background()
+
+	id := insertTestImage(t, db)
+	image, err := repo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	image.MarkAsCompleted("processed/v0.jpg", 10, 10)
+	if _, err := repo.Complete(ctx, image); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	// two reprocesses that both started from v0 finish together
+	results := make(chan string, 2)
+	for _, path := range []string{"processed/v1.jpg", "processed/v2.jpg"} {
+		go func() {
+			img := *image
+			img.MarkAsCompleted(path, 10, 10)
+			previous, err := repo.Complete(ctx, &img)
+			if err != nil {
+				t.Errorf("Complete %s: %v", path, err)
+			}
+			results <- previous
+		}()
+	}
+	first, second := <-results, <-results
+	if first == second {
+		t.Fatalf("both completions replaced %q; one of them must replace the other's path", first)
+	}
+	for _, previous := range []string{first, second} {
+		if previous != "processed/v0.jpg" && previous != "processed/v1.jpg" && previous != "processed/v2.jpg" {
+			t.Errorf("replaced path %q was never stored", previous)
+		}
+	}
+	if first != "processed/v0.jpg" && second != "processed/v0.jpg" {
+		t.Errorf("neither completion replaced v0: %q, %q", first, second)
+	}
+
+	// a run that loaded the image before both completions cannot roll the
+	// path back
+	stale := *image
+	stale.MarkAsProcessing()
+	if err := repo.Update(ctx, &stale); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	stored, err := repo.FindByID(ctx, id)
+	if err != nil {
+		t.Fatalf("FindByID: %v", err)
+	}
+	if stored.ProcessedPath == "processed/v0.jpg" {
+		t.Error("Update wrote back the processed path the stale copy was loaded with")
+	}
+
+	missing := *image
+	missing.ID = uuid.New().String()
+	if _, err := repo.Complete(ctx, &missing); !errors.Is(err, domain.ErrImageNotFound) {
+		t.Errorf("Complete of a missing image err = %v, want ErrImageNotFound", err)
+	}
+}
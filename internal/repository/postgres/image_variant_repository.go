@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type imageVariantRepository struct {
+	db       *dbpg.DB
+	strategy retry.Strategy
+}
+
+func NewImageVariantRepository(db *dbpg.DB, strategy retry.Strategy) domain.ImageVariantRepository {
+	return &imageVariantRepository{
+		db:       db,
+		strategy: strategy,
+	}
+}
+
+// Replace makes variants the image's complete set in one statement:
+// variants of other names are removed and the rest inserted or updated.
+func (r *imageVariantRepository) Replace(ctx context.Context, imageID string, variants []domain.ImageVariant) error {
+	data, err := marshalVariants(variants)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		WITH incoming AS (
+			SELECT *
+			FROM jsonb_to_recordset($2::jsonb)
+			     AS v(name TEXT, path TEXT, format TEXT, content_type TEXT, width INTEGER, height INTEGER)
+		), removed AS (
+			DELETE FROM image_variants
+			WHERE image_id = $1 AND name NOT IN (SELECT name FROM incoming)
+		)
+		INSERT INTO image_variants (image_id, name, path, format, content_type, width, height)
+		SELECT $1, name, path, format, content_type, width, height
+		FROM incoming
+		ON CONFLICT (image_id, name) DO UPDATE
+		SET path = EXCLUDED.path,
+		    format = EXCLUDED.format,
+		    content_type = EXCLUDED.content_type,
+		    width = EXCLUDED.width,
+		    height = EXCLUDED.height
+	`
+
+	if _, err := r.db.ExecWithRetry(ctx, r.strategy, query, imageID, string(data)); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to replace image variants")
+		return fmt.Errorf("replace image variants: %w", err)
+	}
+	return nil
+}
+
+// marshalVariants encodes variants as a JSON array, empty rather than
+// null for none.
+func marshalVariants(variants []domain.ImageVariant) ([]byte, error) {
+	if variants == nil {
+		variants = []domain.ImageVariant{}
+	}
+	data, err := json.Marshal(variants)
+	if err != nil {
+		return nil, fmt.Errorf("marshal variants: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type jobRepository struct {
+	db       *dbpg.DB
+	strategy retry.Strategy
+}
+
+func NewJobRepository(db *dbpg.DB, strategy retry.Strategy) domain.JobRepository {
+	return &jobRepository{
+		db:       db,
+		strategy: strategy,
+	}
+}
+
+func (r *jobRepository) Create(ctx context.Context, job *domain.Job) error {
+	query := `
+		INSERT INTO jobs (
+			id, image_id, state, attempts, error_message,
+			created_at, updated_at, started_at, finished_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err := r.db.ExecWithRetry(ctx, r.strategy, query,
+		job.ID,
+		job.ImageID,
+		job.State,
+		job.Attempts,
+		nullString(job.ErrorMessage),
+		job.CreatedAt,
+		job.UpdatedAt,
+		job.StartedAt,
+		job.FinishedAt,
+	)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("job_id", job.ID).Str("image_id", job.ImageID).Msg("failed to create job")
+		return fmt.Errorf("create job: %w", err)
+	}
+
+	return nil
+}
+
+func (r *jobRepository) FindByID(ctx context.Context, id string) (*domain.Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE id = $1
+	`
+
+	job, err := scanJob(r.db.Master.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrJobNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("job_id", id).Msg("failed to find job")
+		return nil, fmt.Errorf("find job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (r *jobRepository) FindLatestByImageID(ctx context.Context, imageID string) (*domain.Job, error) {
+	query := `
+		SELECT ` + jobColumns + `
+		FROM jobs
+		WHERE image_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	job, err := scanJob(r.db.Master.QueryRowContext(ctx, query, imageID))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrJobNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to find latest job")
+		return nil, fmt.Errorf("find latest job: %w", err)
+	}
+
+	return job, nil
+}
+
+func (r *jobRepository) Update(ctx context.Context, job *domain.Job) error {
+	query := `
+		UPDATE jobs
+		SET state = $2,
+		    attempts = $3,
+		    error_message = $4,
+		    started_at = $5,
+		    finished_at = $6,
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query,
+		job.ID,
+		job.State,
+		job.Attempts,
+		nullString(job.ErrorMessage),
+		job.StartedAt,
+		job.FinishedAt,
+	)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("job_id", job.ID).Msg("failed to update job")
+		return fmt.Errorf("update job: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return domain.ErrJobNotFound
+	}
+
+	return nil
+}
+
+const jobColumns = `id, image_id, state, attempts, error_message,
+			   created_at, updated_at, started_at, finished_at`
+
+func scanJob(row rowScanner) (*domain.Job, error) {
+	var job domain.Job
+	var errorMsg sql.NullString
+	var startedAt, finishedAt sql.NullTime
+
+	err := row.Scan(
+		&job.ID,
+		&job.ImageID,
+		&job.State,
+		&job.Attempts,
+		&errorMsg,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&startedAt,
+		&finishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if errorMsg.Valid {
+		job.ErrorMessage = errorMsg.String
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+
+	return &job, nil
+}
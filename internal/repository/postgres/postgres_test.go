@@ -0,0 +1,50 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/database"
+)
+
+// openTestDB connects to the database in TEST_POSTGRES_DSN and applies the
+// migrations; the tests are skipped when it is unset. Each test works on
+// rows it creates itself, so the database can be shared.
+func openTestDB(t *testing.T) *dbpg.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := dbpg.New(dsn, nil, &dbpg.Options{MaxOpenConns: 20})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Master.Close() })
+
+	if err := database.RunMigrations(db, "../../../migrations"); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+// insertTestImage stores a bare pending image row and returns its id.
+func insertTestImage(t *testing.T, db *dbpg.DB) string {
+	t.Helper()
+	id := uuid.New().String()
+	_, err := db.Master.ExecContext(context.Background(), `
+		INSERT INTO images (id, original_filename, original_path, mime_type, size, processing_type)
+		VALUES ($1, 'test.jpg', $2, 'image/jpeg', 1, 'resize')
+	`, id, "originals/"+id+".jpg")
+	if err != nil {
+		t.Fatalf("insert image: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Master.ExecContext(context.Background(), `DELETE FROM images WHERE id = $1`, id)
+	})
+	return id
+}
@@ -0,0 +1,293 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/wb-go/wbf/dbpg"
+	"github.com/wb-go/wbf/retry"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type processedVariantRepository struct {
+	db       *dbpg.DB
+	strategy retry.Strategy
+}
+
+func NewProcessedVariantRepository(db *dbpg.DB, strategy retry.Strategy) domain.ProcessedVariantRepository {
+	return &processedVariantRepository{
+		db:       db,
+		strategy: strategy,
+	}
+}
+
+// Request inserts the variant, or resets an existing finished variant of the
+// same name to pending with the new type and options. The conflict update
+// only applies to finished rows, so of two concurrent requests for one name
+// exactly one wins and the other gets ErrAlreadyProcessing. The previous
+// processed path is kept until the new run replaces it.
+func (r *processedVariantRepository) Request(ctx context.Context, variant *domain.ProcessedVariant) (*domain.ProcessedVariant, error) {
+	options, err := json.Marshal(variant.Options)
+	if err != nil {
+		return nil, fmt.Errorf("marshal processing options: %w", err)
+	}
+
+	query := `
+		INSERT INTO processed_variants (
+			id, image_id, name, processing_type, processing_options,
+			status, created_at, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (image_id, name) DO UPDATE
+		SET processing_type = EXCLUDED.processing_type,
+		    processing_options = EXCLUDED.processing_options,
+		    status = EXCLUDED.status,
+		    error_message = NULL,
+		    updated_at = EXCLUDED.updated_at
+		WHERE processed_variants.status NOT IN ('pending', 'processing')
+		RETURNING ` + processedVariantColumns
+
+	saved, err := scanProcessedVariant(r.db.Master.QueryRowContext(ctx, query,
+		variant.ID,
+		variant.ImageID,
+		variant.Name,
+		variant.ProcessingType,
+		options,
+		variant.Status,
+		variant.CreatedAt,
+		variant.UpdatedAt,
+	))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrAlreadyProcessing
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", variant.ImageID).Str("variant", variant.Name).Msg("failed to request processed variant")
+		return nil, fmt.Errorf("request processed variant: %w", err)
+	}
+
+	return saved, nil
+}
+
+func (r *processedVariantRepository) FindByID(ctx context.Context, id string) (*domain.ProcessedVariant, error) {
+	query := `
+		SELECT ` + processedVariantColumns + `
+		FROM processed_variants
+		WHERE id = $1
+	`
+
+	variant, err := scanProcessedVariant(r.db.Master.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrProcessedVariantNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("variant_id", id).Msg("failed to find processed variant")
+		return nil, fmt.Errorf("find processed variant: %w", err)
+	}
+
+	return variant, nil
+}
+
+func (r *processedVariantRepository) FindByName(ctx context.Context, imageID, name string) (*domain.ProcessedVariant, error) {
+	query := `
+		SELECT ` + processedVariantColumns + `
+		FROM processed_variants
+		WHERE image_id = $1 AND name = $2
+	`
+
+	variant, err := scanProcessedVariant(r.db.Master.QueryRowContext(ctx, query, imageID, name))
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrProcessedVariantNotFound
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Str("variant", name).Msg("failed to find processed variant")
+		return nil, fmt.Errorf("find processed variant: %w", err)
+	}
+
+	return variant, nil
+}
+
+func (r *processedVariantRepository) ListByImageID(ctx context.Context, imageID string) ([]*domain.ProcessedVariant, error) {
+	query := `
+		SELECT ` + processedVariantColumns + `
+		FROM processed_variants
+		WHERE image_id = $1
+		ORDER BY name
+	`
+
+	rows, err := r.db.Master.QueryContext(ctx, query, imageID)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to list processed variants")
+		return nil, fmt.Errorf("list processed variants: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []*domain.ProcessedVariant
+	for rows.Next() {
+		variant, err := scanProcessedVariant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan processed variant: %w", err)
+		}
+		variants = append(variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	return variants, nil
+}
+
+// Claim moves a pending variant to processing and stamps claimed_at. A
+// variant still processing under a claim older than expiredBefore is taken
+// over, as its worker died or lost the task. It reports false otherwise,
+// e.g. when a redelivered task finds the variant claimed, so only one
+// worker at a time processes a request.
+func (r *processedVariantRepository) Claim(ctx context.Context, id string, expiredBefore time.Time) (bool, error) {
+	query := `
+		UPDATE processed_variants
+		SET status = 'processing',
+		    claimed_at = NOW(),
+		    updated_at = NOW()
+		WHERE id = $1
+		  AND (status = 'pending' OR (status = 'processing' AND claimed_at < $2))
+	`
+
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query, id, expiredBefore)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("variant_id", id).Msg("failed to claim processed variant")
+		return false, fmt.Errorf("claim processed variant: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+func (r *processedVariantRepository) FindExpiredClaims(ctx context.Context, expiredBefore time.Time, limit int) ([]*domain.ProcessedVariant, error) {
+	query := `
+		SELECT ` + processedVariantColumns + `
+		FROM processed_variants
+		WHERE status = 'processing' AND claimed_at < $1
+		ORDER BY claimed_at
+		LIMIT $2
+	`
+
+	rows, err := r.db.Master.QueryContext(ctx, query, expiredBefore, limit)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to find expired processed variant claims")
+		return nil, fmt.Errorf("find expired claims: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []*domain.ProcessedVariant
+	for rows.Next() {
+		variant, err := scanProcessedVariant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan processed variant: %w", err)
+		}
+		variants = append(variants, variant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	return variants, nil
+}
+
+func (r *processedVariantRepository) Update(ctx context.Context, variant *domain.ProcessedVariant) error {
+	query := `
+		UPDATE processed_variants
+		SET status = $2,
+		    processed_path = $3,
+		    width = $4,
+		    height = $5,
+		    error_message = $6,
+		    processed_at = $7,
+		    updated_at = NOW()
+		WHERE id = $1
+	`
+
+	result, err := r.db.ExecWithRetry(ctx, r.strategy, query,
+		variant.ID,
+		variant.Status,
+		nullString(variant.ProcessedPath),
+		nullInt(variant.Width),
+		nullInt(variant.Height),
+		nullString(variant.ErrorMessage),
+		variant.ProcessedAt,
+	)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("variant_id", variant.ID).Msg("failed to update processed variant")
+		return fmt.Errorf("update processed variant: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("get rows affected: %w", err)
+	}
+
+	if rows == 0 {
+		return domain.ErrProcessedVariantNotFound
+	}
+
+	return nil
+}
+
+const processedVariantColumns = `id, image_id, name, processing_type, processing_options,
+			   status, processed_path, width, height, error_message,
+			   created_at, updated_at, processed_at`
+
+func scanProcessedVariant(row rowScanner) (*domain.ProcessedVariant, error) {
+	var variant domain.ProcessedVariant
+	var processedPath, errorMsg sql.NullString
+	var width, height sql.NullInt32
+	var processedAt sql.NullTime
+	var options []byte
+
+	err := row.Scan(
+		&variant.ID,
+		&variant.ImageID,
+		&variant.Name,
+		&variant.ProcessingType,
+		&options,
+		&variant.Status,
+		&processedPath,
+		&width,
+		&height,
+		&errorMsg,
+		&variant.CreatedAt,
+		&variant.UpdatedAt,
+		&processedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if processedPath.Valid {
+		variant.ProcessedPath = processedPath.String
+	}
+	if width.Valid {
+		variant.Width = int(width.Int32)
+	}
+	if height.Valid {
+		variant.Height = int(height.Int32)
+	}
+	if errorMsg.Valid {
+		variant.ErrorMessage = errorMsg.String
+	}
+	if processedAt.Valid {
+		variant.ProcessedAt = &processedAt.Time
+	}
+	if len(options) > 0 {
+		if err := json.Unmarshal(options, &variant.Options); err != nil {
+			return nil, fmt.Errorf("unmarshal processing options: %w", err)
+		}
+	}
+
+	return &variant, nil
+}
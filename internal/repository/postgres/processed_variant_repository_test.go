@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/retry"
+)
+
+func requestTestVariant(t *testing.T, repo domain.ProcessedVariantRepository, imageID string) *domain.ProcessedVariant {
+	t.Helper()
+	now := time.Now()
+	variant, err := repo.Request(context.Background(), &domain.ProcessedVariant{
+		ID:             uuid.New().String(),
+		ImageID:        imageID,
+		Name:           "avatar",
+		ProcessingType: domain.ProcessingThumbnail,
+		Status:         domain.StatusPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		t.Fatalf("request variant: %v", err)
+	}
+	return variant
+}
+
+func TestProcessedVariantClaimIsExclusive(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewProcessedVariantRepository(db, retry.DefaultStrategy)
+	variant := requestTestVariant(t, repo, insertTestImage(t, db))
+
+	const workers = 16
+	var wg sync.WaitGroup
+	var won atomic.Int32
+	expiredBefore := time.Now().Add(-time.Hour)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := repo.Claim(context.Background(), variant.ID, expiredBefore)
+			if err != nil {
+				t.Errorf("claim: %v", err)
+				return
+			}
+			if claimed {
+				won.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := won.Load(); got != 1 {
+		t.Fatalf("%d of %d concurrent claims won, want exactly 1", got, workers)
+	}
+}
+
+func TestProcessedVariantExpiredClaimIsTakenOver(t *testing.T) {
+	db := openTestDB(t)
+	repo := NewProcessedVariantRepository(db, retry.DefaultStrategy)
+	variant := requestTestVariant(t, repo, insertTestImage(t, db))
+	ctx := context.Background()
+
+	if claimed, err := repo.Claim(ctx, variant.ID, time.Now().Add(-time.Hour)); err != nil || !claimed {
+		t.Fatalf("first claim = %v, %v; want true", claimed, err)
+	}
+	// the claim is still within its lease
+	if claimed, err := repo.Claim(ctx, variant.ID, time.Now().Add(-time.Hour)); err != nil || claimed {
+		t.Fatalf("claim within lease = %v, %v; want false", claimed, err)
+	}
+
+	// a cutoff in the future makes the first claim expired
+	expiredBefore := time.Now().Add(time.Minute)
+	expired, err := repo.FindExpiredClaims(ctx, expiredBefore, 100)
+	if err != nil {
+		t.Fatalf("find expired claims: %v", err)
+	}
+	found := false
+	for _, v := range expired {
+		found = found || v.ID == variant.ID
+	}
+	if !found {
+		t.Fatalf("expired claims %v do not include the stuck variant", expired)
+	}
+
+	if claimed, err := repo.Claim(ctx, variant.ID, expiredBefore); err != nil || !claimed {
+		t.Fatalf("claim after lease = %v, %v; want true", claimed, err)
+	}
+}
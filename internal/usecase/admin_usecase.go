@@ -0,0 +1,136 @@
+package usecase
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+const manifestPageSize = 500
+
+type AdminUsecase struct {
+	repo           domain.ImageRepository
+	manifestSecret []byte
+}
+
+func NewAdminUsecase(repo domain.ImageRepository, manifestSecret string) *AdminUsecase {
+	return &AdminUsecase{
+		repo:           repo,
+		manifestSecret: []byte(manifestSecret),
+	}
+}
+
+// WriteManifest streams every image record to w as
+//
+//	{"version":1,"generated_at":...,"images":[...],"count":N,"signature":"sha256=..."}
+//
+// reading the table page by page so memory use does not grow with it. When a
+// manifest secret is configured, signature is the hex HMAC-SHA256 of the exact
+// bytes of the "images" array, from '[' through ']'.
+func (u *AdminUsecase) WriteManifest(ctx context.Context, w io.Writer) error {
+	header := fmt.Sprintf(`{"version":1,"generated_at":%q,"images":`, time.Now().UTC().Format(time.RFC3339))
+	if _, err := io.WriteString(w, header); err != nil {
+		return fmt.Errorf("write manifest header: %w", err)
+	}
+
+	var mac hash.Hash
+	body := w
+	if len(u.manifestSecret) > 0 {
+		mac = hmac.New(sha256.New, u.manifestSecret)
+		body = io.MultiWriter(w, mac)
+	}
+
+	if _, err := io.WriteString(body, "["); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	count := 0
+	afterID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		images, err := u.repo.ListAfterID(ctx, domain.ImageFilter{}, afterID, manifestPageSize)
+		if err != nil {
+			return fmt.Errorf("list images: %w", err)
+		}
+
+		for _, image := range images {
+			entry, err := json.Marshal(domain.NewManifestEntry(image))
+			if err != nil {
+				return fmt.Errorf("marshal manifest entry %s: %w", image.ID, err)
+			}
+			if count > 0 {
+				if _, err := io.WriteString(body, ","); err != nil {
+					return fmt.Errorf("write manifest: %w", err)
+				}
+			}
+			if _, err := body.Write(entry); err != nil {
+				return fmt.Errorf("write manifest: %w", err)
+			}
+			count++
+		}
+
+		if len(images) < manifestPageSize {
+			break
+		}
+		afterID = images[len(images)-1].ID
+	}
+
+	if _, err := io.WriteString(body, "]"); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	trailer := fmt.Sprintf(`,"count":%d`, count)
+	if mac != nil {
+		trailer += fmt.Sprintf(`,"signature":"sha256=%s"`, hex.EncodeToString(mac.Sum(nil)))
+	}
+	if _, err := io.WriteString(w, trailer+"}"); err != nil {
+		return fmt.Errorf("write manifest trailer: %w", err)
+	}
+
+	zlog.Logger.Info().Int("count", count).Bool("signed", mac != nil).Msg("manifest written")
+	return nil
+}
+
+// Performance reports the hourly processing series for the last window,
+// which must be a whole number of hours. The current, partial hour is the
+// last bucket.
+func (u *AdminUsecase) Performance(ctx context.Context, window time.Duration) (*domain.PerformanceReport, error) {
+	to := time.Now().UTC().Truncate(time.Hour).Add(time.Hour)
+	from := to.Add(-window)
+
+	found, err := u.repo.PerformanceBuckets(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("performance buckets: %w", err)
+	}
+	byStart := make(map[time.Time]domain.PerformanceBucket, len(found))
+	for _, b := range found {
+		byStart[b.Start] = b
+	}
+
+	report := &domain.PerformanceReport{
+		From:    from,
+		To:      to,
+		Buckets: make([]domain.PerformanceBucket, 0, int(window/time.Hour)),
+	}
+	for start := from; start.Before(to); start = start.Add(time.Hour) {
+		b, ok := byStart[start]
+		if !ok {
+			b = domain.PerformanceBucket{Start: start}
+		}
+		report.Buckets = append(report.Buckets, b)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,162 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// performanceRepo returns fixed buckets and records the since it was asked for.
+type performanceRepo struct {
+	domain.ImageRepository
+	buckets []domain.PerformanceBucket
+	since   time.Time
+}
+
+func (r *performanceRepo) PerformanceBuckets(ctx context.Context, since time.Time) ([]domain.PerformanceBucket, error) {
+	r.since = since
+	return r.buckets, nil
+}
+
+func TestPerformanceFillsEmptyHours(t *testing.T) {
+	current := time.Now().UTC().Truncate(time.Hour)
+	repo := &performanceRepo{buckets: []domain.PerformanceBucket{
+		{Start: current.Add(-4 * time.Hour), Completed: 3, Failed: 1, AvgDurationMs: 120},
+		{Start: current, Completed: 1, AvgDurationMs: 80},
+	}}
+	u := NewAdminUsecase(repo, "")
+
+	report, err := u.Performance(context.Background(), 6*time.Hour)
+	if err != nil {
+		t.Fatalf("Performance: %v", err)
+	}
+	// the current, partial hour is the last of six
+	if want := current.Add(-5 * time.Hour); !report.From.Equal(want) || !repo.since.Equal(want) {
+		t.Errorf("From = %v, since = %v, want %v", report.From, repo.since, want)
+	}
+	if want := current.Add(time.Hour); !report.To.Equal(want) {
+		t.Errorf("To = %v, want %v", report.To, want)
+	}
+	if len(report.Buckets) != 6 {
+		t.Fatalf("got %d buckets, want 6", len(report.Buckets))
+	}
+	for i, b := range report.Buckets {
+		if want := report.From.Add(time.Duration(i) * time.Hour); !b.Start.Equal(want) {
+			t.Errorf("bucket %d starts at %v, want %v", i, b.Start, want)
+		}
+		switch i {
+		case 1:
+			if b.Completed != 3 || b.Failed != 1 || b.FailureRate() != 0.25 || b.AvgDurationMs != 120 {
+				t.Errorf("bucket 1 = %+v, want the stored one", b)
+			}
+		case 5:
+			if b.Completed != 1 || b.AvgDurationMs != 80 {
+				t.Errorf("bucket 5 = %+v, want the stored one", b)
+			}
+		default:
+			if b.Completed != 0 || b.Failed != 0 || b.FailureRate() != 0 {
+				t.Errorf("bucket %d = %+v, want empty", i, b)
+			}
+		}
+	}
+}
+
+// pagedRepo serves images in id order the way ListAfterID pages them and
+// counts the pages asked for.
+type pagedRepo struct {
+	domain.ImageRepository
+	images []*domain.Image
+	pages  int
+}
+
+func (r *pagedRepo) ListAfterID(ctx context.Context, filter domain.ImageFilter, afterID string, limit int) ([]*domain.Image, error) {
+	r.pages++
+	start, _ := slices.BinarySearchFunc(r.images, afterID, func(img *domain.Image, id string) int { return strings.Compare(img.ID, id) })
+	if start < len(r.images) && r.images[start].ID == afterID {
+		start++
+	}
+	return r.images[start:min(start+limit, len(r.images))], nil
+}
+
+type manifest struct {
+	Version     int             `json:"version"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Images      json.RawMessage `json:"images"`
+	Count       int             `json:"count"`
+	Signature   string          `json:"signature"`
+}
+
+func TestManifestListsEveryImage(t *testing.T) {
+	repo := &pagedRepo{}
+	// more than a page, so the scan has to continue after the first one
+	for i := range manifestPageSize + 3 {
+		repo.images = append(repo.images, &domain.Image{
+			ID:               fmt.Sprintf("img-%04d", i),
+			OriginalFilename: "photo.jpg",
+			OriginalPath:     fmt.Sprintf("originals/img-%04d.jpg", i),
+			ContentHash:      fmt.Sprintf("%064x", i),
+			Size:             int64(i + 1),
+			Status:           domain.StatusCompleted,
+			ProcessingType:   domain.ProcessingResize,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := NewAdminUsecase(repo, "").WriteManifest(context.Background(), &buf); err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+	if m.Version != 1 || m.GeneratedAt.IsZero() || m.Signature != "" {
+		t.Fatalf("header = version %d, generated %v, signature %q", m.Version, m.GeneratedAt, m.Signature)
+	}
+	var entries []domain.ManifestEntry
+	if err := json.Unmarshal(m.Images, &entries); err != nil {
+		t.Fatalf("decode images: %v", err)
+	}
+	if len(entries) != len(repo.images) || m.Count != len(repo.images) {
+		t.Fatalf("%d entries, count %d; want %d", len(entries), m.Count, len(repo.images))
+	}
+	if repo.pages != 2 {
+		t.Errorf("read %d pages, want 2", repo.pages)
+	}
+	last := entries[len(entries)-1]
+	if want := domain.NewManifestEntry(repo.images[len(repo.images)-1]); last != want {
+		t.Errorf("last entry = %+v, want %+v", last, want)
+	}
+}
+
+func TestManifestSignatureCoversImagesArray(t *testing.T) {
+	repo := &pagedRepo{images: []*domain.Image{
+		{ID: "a", OriginalPath: "originals/a.jpg", ContentHash: "aa", Status: domain.StatusCompleted},
+		{ID: "b", OriginalPath: "originals/b.jpg", ContentHash: "bb", Status: domain.StatusPending},
+	}}
+
+	var buf bytes.Buffer
+	if err := NewAdminUsecase(repo, "backup-key").WriteManifest(context.Background(), &buf); err != nil {
+		t.Fatalf("WriteManifest: %v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("backup-key"))
+	mac.Write(m.Images)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); m.Signature != want {
+		t.Fatalf("signature = %q, want %q over %s", m.Signature, want, m.Images)
+	}
+}
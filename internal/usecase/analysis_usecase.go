@@ -0,0 +1,114 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+// AnalysisUsecase computes read-only statistics over stored images.
+type AnalysisUsecase struct {
+	repo      domain.ImageRepository
+	storage   storage.Storage
+	maxPixels int64
+}
+
+func NewAnalysisUsecase(repo domain.ImageRepository, storage storage.Storage, maxPixels int64) *AnalysisUsecase {
+	return &AnalysisUsecase{
+		repo:      repo,
+		storage:   storage,
+		maxPixels: maxPixels,
+	}
+}
+
+// GetHistogram computes the histogram of the processed image, or of the
+// original while processing hasn't finished.
+func (u *AnalysisUsecase) GetHistogram(ctx context.Context, id string, buckets int) (*domain.Histogram, error) {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+
+	var file io.ReadCloser
+	if image.IsProcessed() {
+		file, err = u.storage.GetProcessed(ctx, image.ProcessedPath)
+	} else {
+		file, err = u.storage.GetOriginal(ctx, image.OriginalPath)
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to open image for histogram")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrImageNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := processor.DecodeLimit(file, 1, u.maxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+	}
+
+	return processor.ComputeHistogram(img, buckets)
+}
+
+// WriteDiff renders the difference between the original and the processed
+// image as a PNG heatmap.
+func (u *AnalysisUsecase) WriteDiff(ctx context.Context, id string, w io.Writer) error {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+	if !image.HasProcessedFile() {
+		return domain.ErrImageNotProcessed
+	}
+	if !image.HasOriginal() {
+		return domain.ErrImageNotFound
+	}
+
+	original, err := u.decode(ctx, id, image.OriginalPath, u.storage.GetOriginal)
+	if err != nil {
+		return err
+	}
+	processed, err := u.decode(ctx, id, image.ProcessedPath, u.storage.GetProcessed)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	diff, err := processor.DiffImage(original, processed)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidImageData, err)
+	}
+	return imaging.Encode(w, diff, imaging.PNG)
+}
+
+func (u *AnalysisUsecase) decode(ctx context.Context, id, path string, open func(context.Context, string) (io.ReadCloser, error)) (image.Image, error) {
+	file, err := open(ctx, path)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", path).Msg("failed to open image for diff")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrImageNotFound
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := processor.DecodeLimit(file, 1, u.maxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+	}
+	return img, nil
+}
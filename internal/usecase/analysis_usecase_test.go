@@ -0,0 +1,44 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/png"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+func TestWriteDiffOfUnchangedImageIsBlack(t *testing.T) {
+	const id = "5d0c3f9e-2b7a-4f61-8e2d-9a4b6c1d7e30"
+	ctx := context.Background()
+	repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+	store := newMemStorage()
+
+	data := encodePNG(t, 24, 16)
+	original, _ := store.SaveOriginal(ctx, "a.png", bytes.NewReader(data), int64(len(data)))
+	processed, _ := store.SaveProcessed(ctx, "a_resize.png", bytes.NewReader(data), int64(len(data)))
+	repo.images[id] = &domain.Image{ID: id, OriginalPath: original, ProcessedPath: processed, Status: domain.StatusCompleted}
+	u := NewAnalysisUsecase(repo, store, 0)
+
+	var buf bytes.Buffer
+	if err := u.WriteDiff(ctx, id, &buf); err != nil {
+		t.Fatalf("WriteDiff: %v", err)
+	}
+	diff, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode diff: %v", err)
+	}
+	if b := diff.Bounds(); b.Dx() != 24 || b.Dy() != 16 {
+		t.Fatalf("diff bounds = %v, want 24x16", b)
+	}
+	if r, _, _, _ := diff.At(12, 8).RGBA(); r != 0 {
+		t.Fatalf("unchanged pixel has heat %d, want black", r>>8)
+	}
+
+	repo.images[id].Status = domain.StatusPending
+	if err := u.WriteDiff(ctx, id, &bytes.Buffer{}); !errors.Is(err, domain.ErrImageNotProcessed) {
+		t.Fatalf("diff of a pending image: err = %v, want ErrImageNotProcessed", err)
+	}
+}
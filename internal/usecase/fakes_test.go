@@ -0,0 +1,323 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+// eventLog records the order in which fakes are written to.
+type eventLog struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, fmt.Sprintf(format, args...))
+}
+
+func (l *eventLog) index(event string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, e := range l.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+type fakeImageRepo struct {
+	domain.ImageRepository
+	mu     sync.Mutex
+	images map[string]*domain.Image
+	log    *eventLog
+}
+
+func (r *fakeImageRepo) FindByID(ctx context.Context, id string) (*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return nil, domain.ErrImageNotFound
+	}
+	copied := *img
+	return &copied, nil
+}
+
+// Update leaves ProcessedPath alone; only Complete writes it.
+func (r *fakeImageRepo) Update(ctx context.Context, image *domain.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *image
+	if stored, ok := r.images[image.ID]; ok {
+		copied.ProcessedPath = stored.ProcessedPath
+	}
+	r.images[image.ID] = &copied
+	r.log.add("image %s", image.Status)
+	return nil
+}
+
+func (r *fakeImageRepo) Complete(ctx context.Context, image *domain.Image) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var previous string
+	if stored, ok := r.images[image.ID]; ok {
+		previous = stored.ProcessedPath
+	}
+	copied := *image
+	r.images[image.ID] = &copied
+	r.log.add("image %s", image.Status)
+	return previous, nil
+}
+
+func (r *fakeImageRepo) Create(ctx context.Context, image *domain.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *image
+	r.images[image.ID] = &copied
+	r.log.add("image created")
+	return nil
+}
+
+func (r *fakeImageRepo) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.images, id)
+	r.log.add("image deleted")
+	return nil
+}
+
+func (r *fakeImageRepo) SetStorageBackend(ctx context.Context, id, backend string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return domain.ErrImageNotFound
+	}
+	img.StorageBackend = backend
+	r.log.add("storage backend %s", backend)
+	return nil
+}
+
+// FindDuplicate matches on content hash and tenant only, enough for the
+// tests that use it.
+func (r *fakeImageRepo) FindDuplicate(ctx context.Context, contentHash, tenantID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) (*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.log.add("duplicate lookup")
+	for _, img := range r.images {
+		if img.ContentHash == contentHash && img.TenantID == tenantID {
+			copied := *img
+			return &copied, nil
+		}
+	}
+	return nil, domain.ErrImageNotFound
+}
+
+func (r *fakeImageRepo) CountOriginalsByTenant(ctx context.Context, tenantID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, img := range r.images {
+		if img.TenantID == tenantID && img.HasOriginal() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// FindOldestPrunableByTenant applies the filter and order of the Postgres
+// query.
+func (r *fakeImageRepo) FindOldestPrunableByTenant(ctx context.Context, tenantID string, limit int) ([]*domain.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []*domain.Image
+	for _, img := range r.images {
+		if img.TenantID == tenantID && img.Status == domain.StatusCompleted && img.HasOriginal() && img.ProcessedPath != img.OriginalPath {
+			copied := *img
+			found = append(found, &copied)
+		}
+	}
+	slices.SortFunc(found, func(a, b *domain.Image) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return found[:min(limit, len(found))], nil
+}
+
+func (r *fakeImageRepo) CountPendingBefore(ctx context.Context, before time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, img := range r.images {
+		if img.Status == domain.StatusPending && img.CreatedAt.Before(before) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+type fakeJobRepo struct {
+	domain.JobRepository
+}
+
+func (fakeJobRepo) FindLatestByImageID(ctx context.Context, imageID string) (*domain.Job, error) {
+	return nil, domain.ErrJobNotFound
+}
+
+func (fakeJobRepo) Create(ctx context.Context, job *domain.Job) error {
+	return nil
+}
+
+type fakeQueue struct {
+	domain.QueueService
+	mu        sync.Mutex
+	published []string
+}
+
+// PublishProcessingTask fails like the Kafka writer does once ctx is done.
+func (q *fakeQueue) PublishProcessingTask(ctx context.Context, imageID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.published = append(q.published, imageID)
+	return nil
+}
+
+// PublishVariantTask records the variant id next to image ids.
+func (q *fakeQueue) PublishVariantTask(ctx context.Context, variant *domain.ProcessedVariant) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.published = append(q.published, variant.ID)
+	return nil
+}
+
+type fakeImageVariantRepo struct {
+	stored []domain.ImageVariant
+	log    *eventLog
+}
+
+func (r *fakeImageVariantRepo) Replace(ctx context.Context, imageID string, variants []domain.ImageVariant) error {
+	r.stored = variants
+	r.log.add("variants %d", len(variants))
+	return nil
+}
+
+// fakeProcessedVariantRepo holds no processed variants.
+type fakeProcessedVariantRepo struct {
+	domain.ProcessedVariantRepository
+}
+
+func (fakeProcessedVariantRepo) ListByImageID(ctx context.Context, imageID string) ([]*domain.ProcessedVariant, error) {
+	return nil, nil
+}
+
+// memStorage keeps objects in memory under the paths a local backend
+// would use.
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string][]byte)}
+}
+
+func (s *memStorage) save(path string, reader io.Reader) (string, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[path] = data
+	return path, nil
+}
+
+func (s *memStorage) get(path string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.objects[path]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", storage.ErrObjectNotFound, path)
+	}
+	return memObject{bytes.NewReader(data)}, nil
+}
+
+// memObject is seekable like the files and S3 objects storage returns.
+type memObject struct {
+	*bytes.Reader
+}
+
+func (memObject) Close() error {
+	return nil
+}
+
+func (s *memStorage) SaveOriginal(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return s.save("originals/"+filename, reader)
+}
+
+func (s *memStorage) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return s.save("processed/"+filename, reader)
+}
+
+func (s *memStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.get(path)
+}
+
+func (s *memStorage) GetProcessed(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.get(path)
+}
+
+func (s *memStorage) Delete(ctx context.Context, path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, path)
+	return nil
+}
+
+func (s *memStorage) DeleteAll(ctx context.Context, originalPath, processedPath string) error {
+	s.Delete(ctx, originalPath)
+	return s.Delete(ctx, processedPath)
+}
+
+func (s *memStorage) Exists(ctx context.Context, path string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[path]
+	return ok, nil
+}
+
+func (s *memStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *memStorage) List(ctx context.Context, fn func(storage.StoredObject) error) error {
+	s.mu.Lock()
+	paths := make([]string, 0, len(s.objects))
+	for p := range s.objects {
+		paths = append(paths, p)
+	}
+	s.mu.Unlock()
+	for _, p := range paths {
+		if err := fn(storage.StoredObject{Path: p}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,34 +1,96 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
-	"errors"
 
+	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
 	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/cache"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/metrics"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
 )
 
 type ImageUsecase struct {
-	repo    domain.ImageRepository
-	storage storage.Storage
-	queue   domain.QueueService
+	repo              domain.ImageRepository
+	jobs              domain.JobRepository
+	processedVariants domain.ProcessedVariantRepository
+	storage           storage.Storage
+	queue             domain.QueueService
+	cfg               *config.ProcessingConfig
+	aspect            domain.AspectRatioPolicy
+	retention         domain.TenantRetentionPolicy
+	resize            domain.ResizePolicy
+	// resizeSlots bounds the on-the-fly resizes decoding at once
+	resizeSlots chan struct{}
+	// variantCache and resizeCache are nil when disabled
+	variantCache *cache.DiskCache
+	resizeCache  *cache.DiskCache
+	// presignExpiry is how long presigned download URLs stay valid;
+	// 0 streams every file through the API
+	presignExpiry time.Duration
+	// inline processes small uploads in the request in hybrid mode; nil
+	// when processing.sync_max_bytes is 0
+	inline domain.ProcessorService
+	// thumbnails renders the thumbnail returned with the upload response;
+	// nil unless processing.sync_thumbnail is on
+	thumbnails domain.PreviewService
 }
 
 func NewImageUsecase(
 	repo domain.ImageRepository,
+	jobs domain.JobRepository,
+	processedVariants domain.ProcessedVariantRepository,
 	storage storage.Storage,
 	queue domain.QueueService,
+	cfg *config.ProcessingConfig,
+	variantCache *cache.DiskCache,
+	resizeCache *cache.DiskCache,
+	presignExpiry time.Duration,
+	inline domain.ProcessorService,
+	thumbnails domain.PreviewService,
 ) *ImageUsecase {
+	// validated when the config is loaded
+	resize, _ := cfg.ResizePolicy()
+	slots := cfg.OnTheFlyMaxConcurrent
+	if slots <= 0 {
+		slots = runtime.GOMAXPROCS(0)
+	}
+
 	return &ImageUsecase{
-		repo:    repo,
-		storage: storage,
-		queue:   queue,
+		repo:              repo,
+		jobs:              jobs,
+		processedVariants: processedVariants,
+		storage:           storage,
+		queue:             queue,
+		cfg:               cfg,
+		aspect:            cfg.AspectRatioPolicy(),
+		retention:         cfg.TenantRetentionPolicy(),
+		resize:            resize,
+		resizeSlots:       make(chan struct{}, slots),
+		variantCache:      variantCache,
+		resizeCache:       resizeCache,
+		presignExpiry:     presignExpiry,
+		inline:            inline,
+		thumbnails:        thumbnails,
 	}
 }
 
@@ -39,107 +101,595 @@ func (u *ImageUsecase) UploadImage(
 	size int64,
 	reader io.Reader,
 	processingType domain.ProcessingType,
+	opts domain.ProcessingOptions,
+	tenantID string,
 ) (*domain.Image, error) {
+	if processingType == domain.ProcessingPipeline {
+		if _, ok := u.cfg.Pipelines[opts.PipelineName()]; !ok {
+			return nil, fmt.Errorf("%w: %q", domain.ErrUnknownPipeline, opts.PipelineName())
+		}
+	}
+
+	if tenantID != "" && u.retention.Enabled() && u.retention.Reject {
+		count, err := u.repo.CountOriginalsByTenant(ctx, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		if count >= u.retention.MaxOriginals {
+			zlog.Logger.Warn().Str("tenant_id", tenantID).Int("originals", count).Msg("upload rejected by tenant quota")
+			return nil, domain.ErrTenantQuotaExceeded
+		}
+	}
+
+	reader, err := u.checkMagic(reader, filename)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("filename", filename).Msg("upload rejected by content sniffing")
+		return nil, err
+	}
+
+	if u.cfg.StrictImageStructure {
+		// the handler has already bounded the upload size
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		if err := processor.ValidateStructure(data); err != nil {
+			zlog.Logger.Warn().Err(err).Str("filename", filename).Msg("upload rejected by structure check")
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	if u.cfg.NormalizeOriginals {
+		normalized, err := u.normalizeOriginal(reader, filename)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("filename", filename).Msg("failed to normalize original")
+			return nil, err
+		}
+		reader = normalized
+		size = int64(normalized.Len())
+	}
+
+	if u.aspect.Enabled() && !u.aspect.Crop {
+		var err error
+		reader, err = u.checkAspectRatio(reader)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("filename", filename).Msg("upload rejected by aspect ratio policy")
+			return nil, err
+		}
+	}
+
 	imageID := uuid.New().String()
 	ext := filepath.Ext(filename)
+	if u.cfg.TransliterateFilenames && ext != "" {
+		ext = filepath.Ext(helpers.ASCIIFilename("x" + ext))
+	}
 	uniqueFilename := fmt.Sprintf("%s%s", imageID, ext)
 
-	originalPath, err := u.storage.SaveOriginal(ctx, uniqueFilename, reader)
+	// an upload with a callback_url is never deduplicated: the earlier
+	// image would not report to it
+	if u.cfg.DedupUploads && opts.CallbackURL == "" {
+		// hashed before storing, so a duplicate is answered without writing
+		// its bytes; the handler has already bounded the upload size
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		contentHash := hex.EncodeToString(sum[:])
+		if existing, ok := u.findDuplicate(ctx, contentHash, tenantID, processingType, opts); ok {
+			zlog.Logger.Info().
+				Str("image_id", existing.ID).
+				Str("filename", filename).
+				Str("content_hash", contentHash).
+				Msg("duplicate upload answered with existing image")
+			return existing, nil
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	hasher := sha256.New()
+	originalPath, err := u.storage.SaveOriginal(ctx, uniqueFilename, io.TeeReader(reader, hasher), size)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("filename", filename).Msg("failed to save original file")
 		return nil, fmt.Errorf("save original: %w", err)
 	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
+
+	thumbnailPath := u.renderThumbnail(ctx, imageID, originalPath)
 
 	now := time.Now()
 	image := &domain.Image{
 		ID:               imageID,
+		TenantID:         tenantID,
+		CallbackURL:      opts.CallbackURL,
 		OriginalFilename: filename,
 		OriginalPath:     originalPath,
 		MimeType:         mimeType,
 		Size:             size,
+		ContentHash:      contentHash,
+		ThumbnailPath:    thumbnailPath,
+		StorageBackend:   storage.ActiveBackend(u.storage),
 		Status:           domain.StatusPending,
 		ProcessingType:   processingType,
+		Options:          opts,
 		CreatedAt:        now,
 		UpdatedAt:        now,
 	}
 
 	if err := u.repo.Create(ctx, image); err != nil {
 		_ = u.storage.Delete(ctx, originalPath)
+		if thumbnailPath != "" {
+			_ = u.storage.Delete(ctx, thumbnailPath)
+		}
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to create image record")
 		return nil, fmt.Errorf("create image: %w", err)
 	}
 
-	if err := u.queue.PublishProcessingTask(ctx, imageID, processingType); err != nil {
-		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to publish processing task")
+	job := &domain.Job{
+		ID:        uuid.New().String(),
+		ImageID:   imageID,
+		State:     domain.JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := u.jobs.Create(ctx, job); err != nil {
+		// the image is still processed; only job tracking is unavailable
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to create job")
+	} else {
+		image.JobID = job.ID
+	}
+
+	processed, publish := u.processInline(ctx, image, opts)
+	if processed != nil {
+		image = processed
+	}
+	if publish {
+		if err := u.queue.PublishProcessingTask(context.WithoutCancel(ctx), imageID, processingType, opts); err != nil {
+			// the original and the record are stored; a client that disconnects
+			// now must not leave the image pending without a task
+			zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to publish processing task")
+		}
+	}
+
+	if tenantID != "" && u.retention.Enabled() && !u.retention.Reject {
+		u.pruneTenantOriginals(ctx, tenantID)
 	}
 
 	zlog.Logger.Info().
 		Str("image_id", imageID).
 		Str("filename", filename).
 		Str("processing_type", string(processingType)).
+		Str("job_id", image.JobID).
 		Msg("image uploaded successfully")
+	metrics.Uploads.WithLabelValues(string(processingType)).Inc()
 
 	return image, nil
 }
 
+// renderThumbnail stores a thumbnail of the just saved original so the
+// upload response can link to it before the queued processing is done. It
+// returns "" when sync thumbnails are off or rendering fails; the upload
+// goes on without one.
+func (u *ImageUsecase) renderThumbnail(ctx context.Context, imageID, originalPath string) string {
+	if u.thumbnails == nil {
+		return ""
+	}
+
+	original, err := u.storage.GetOriginal(ctx, originalPath)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", imageID).Msg("failed to open original for thumbnail")
+		return ""
+	}
+	defer original.Close()
+
+	var buf bytes.Buffer
+	if err := u.thumbnails.Preview(ctx, original, domain.ProcessingThumbnail, domain.ProcessingOptions{}, &buf); err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", imageID).Msg("failed to render sync thumbnail")
+		return ""
+	}
+
+	path, err := u.storage.SaveProcessed(ctx, imageID+"_sync_thumbnail.jpg", &buf, int64(buf.Len()))
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", imageID).Msg("failed to save sync thumbnail")
+		return ""
+	}
+	return path
+}
+
+// findDuplicate looks up an earlier upload for dedup_uploads. A failed
+// lookup only costs the deduplication, so it is logged and ignored.
+func (u *ImageUsecase) findDuplicate(ctx context.Context, contentHash, tenantID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) (*domain.Image, bool) {
+	existing, err := u.repo.FindDuplicate(ctx, contentHash, tenantID, processingType, opts)
+	if err != nil {
+		if !errors.Is(err, domain.ErrImageNotFound) {
+			zlog.Logger.Error().Err(err).Str("content_hash", contentHash).Msg("failed to look up duplicate upload")
+		}
+		return nil, false
+	}
+
+	if job, err := u.jobs.FindLatestByImageID(ctx, existing.ID); err == nil {
+		existing.JobID = job.ID
+	}
+	return existing, true
+}
+
+// processInline processes a just stored image in the request when hybrid
+// mode is on, the upload is small enough and the queue ahead of it is
+// short. It returns the reloaded image when it ran, and whether the task
+// must still be published: only a completed image or a terminal failure
+// needs no worker. Anything else, e.g. an image left processing by a
+// cancelled request or failed with a retry scheduled, is queued, and the
+// worker skips it again while a retry is not due.
+func (u *ImageUsecase) processInline(ctx context.Context, image *domain.Image, opts domain.ProcessingOptions) (*domain.Image, bool) {
+	if u.inline == nil || image.Size > u.cfg.SyncMaxBytes {
+		return nil, true
+	}
+
+	queued, err := u.repo.CountPendingBefore(ctx, image.CreatedAt)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to check queue depth, processing async")
+		return nil, true
+	}
+	if queued >= u.cfg.SyncMaxPending {
+		return nil, true
+	}
+
+	if err := u.inline.ProcessImage(ctx, image.ID, opts); err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("inline processing failed")
+	}
+
+	processed, err := u.repo.FindByID(context.WithoutCancel(ctx), image.ID)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to reload inline processed image")
+		return nil, true
+	}
+
+	processed.JobID = image.JobID
+	done := processed.IsProcessed() || (processed.IsFailed() && processed.NextAttemptAt == nil)
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("status", string(processed.Status)).
+		Int("queued_ahead", queued).
+		Bool("queued", !done).
+		Msg("image processed inline")
+	return processed, !done
+}
+
 func (u *ImageUsecase) GetImage(ctx context.Context, id string) (*domain.Image, error) {
-	return u.repo.FindByID(ctx, id)
+	return findImage(ctx, u.repo, id)
 }
 
-func (u *ImageUsecase) GetImageFile(ctx context.Context, id string, useOriginal bool) (io.ReadCloser, string, error) {
-	image, err := u.repo.FindByID(ctx, id)
+func (u *ImageUsecase) GetImageFile(ctx context.Context, id string, useOriginal bool) (*domain.ImageFile, error) {
+	image, err := findImage(ctx, u.repo, id)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to find image by ID")
-		return nil, "", err
+		return nil, err
 	}
-
-	var file io.ReadCloser
-	var filename string
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
 
 	if useOriginal {
-		file, err = u.storage.GetOriginal(ctx, image.OriginalPath)
-		filename = image.OriginalFilename
+		if !image.HasOriginal() {
+			return nil, domain.ErrImageNotFound
+		}
+		imageFile := &domain.ImageFile{
+			Filename:  u.downloadName(image.OriginalFilename),
+			ETag:      image.OriginalETag(),
+			ModTime:   image.CreatedAt,
+			Immutable: true,
+		}
+		if url, ok := u.presignedURL(ctx, image.OriginalPath); ok {
+			imageFile.RedirectURL = url
+			return imageFile, nil
+		}
+
+		file, err := u.storage.GetOriginal(ctx, image.OriginalPath)
 		if err != nil {
 			zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", image.OriginalPath).Msg("failed to get original file")
 			if errors.Is(err, storage.ErrObjectNotFound) {
-				return nil, "", domain.ErrImageNotFound
+				return nil, domain.ErrImageNotFound
 			}
+			return nil, err
+		}
+		imageFile.Body = file
+		return imageFile, nil
+	}
+
+	if !image.HasProcessedFile() {
+		zlog.Logger.Warn().Str("image_id", id).Msg("image not processed yet")
+		return nil, fmt.Errorf("image not processed yet")
+	}
+
+	// Берем ext из реального ProcessedPath, чтобы избежать mismatch
+	ext := filepath.Ext(image.ProcessedPath)
+	baseName := image.OriginalFilename[:len(image.OriginalFilename)-len(filepath.Ext(image.OriginalFilename))]
+
+	imageFile := &domain.ImageFile{
+		Filename: u.downloadName(fmt.Sprintf("%s_%s%s", baseName, image.ProcessingType, ext)),
+		ETag:     image.ProcessedETag(),
+	}
+	if image.ProcessedAt != nil {
+		imageFile.ModTime = *image.ProcessedAt
+	}
+	if url, ok := u.presignedURL(ctx, image.ProcessedPath); ok {
+		imageFile.RedirectURL = url
+		return imageFile, nil
+	}
+
+	file, err := u.storage.GetProcessed(ctx, image.ProcessedPath)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", image.ProcessedPath).Msg("failed to get processed file")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrImageNotFound
+		}
+		return nil, err
+	}
+	imageFile.Body = file
+	return imageFile, nil
+}
+
+// GetThumbnailFile opens the thumbnail rendered at upload. Images uploaded
+// without one report ErrThumbnailNotFound.
+func (u *ImageUsecase) GetThumbnailFile(ctx context.Context, id string) (*domain.ImageFile, error) {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+	if image.ThumbnailPath == "" {
+		return nil, domain.ErrThumbnailNotFound
+	}
+
+	baseName := strings.TrimSuffix(image.OriginalFilename, filepath.Ext(image.OriginalFilename))
+	imageFile := &domain.ImageFile{
+		Filename:  u.downloadName(baseName + "_thumbnail.jpg"),
+		ETag:      image.ThumbnailETag(),
+		ModTime:   image.CreatedAt,
+		Immutable: true,
+	}
+	if url, ok := u.presignedURL(ctx, image.ThumbnailPath); ok {
+		imageFile.RedirectURL = url
+		return imageFile, nil
+	}
+
+	file, err := u.storage.GetProcessed(ctx, image.ThumbnailPath)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", image.ThumbnailPath).Msg("failed to get thumbnail file")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrThumbnailNotFound
+		}
+		return nil, err
+	}
+	imageFile.Body = file
+	return imageFile, nil
+}
+
+// presignedURL returns a direct download URL for path when presigned URLs
+// are enabled and the storage can issue them. A failure is logged and the
+// file is streamed instead.
+func (u *ImageUsecase) presignedURL(ctx context.Context, path string) (string, bool) {
+	if u.presignExpiry == 0 {
+		return "", false
+	}
+	presigner, ok := u.storage.(storage.Presigner)
+	if !ok {
+		return "", false
+	}
+
+	url, err := presigner.PresignedURL(ctx, path, u.presignExpiry)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("path", path).Msg("failed to presign object, streaming it instead")
+		return "", false
+	}
+	return url, true
+}
+
+// downloadName is the name offered to clients for a stored file.
+func (u *ImageUsecase) downloadName(name string) string {
+	if u.cfg.TransliterateFilenames {
+		return helpers.ASCIIFilename(name)
+	}
+	return name
+}
+
+// GetVariantFile opens the named variant of the image, through the local
+// variant cache when one is configured.
+func (u *ImageUsecase) GetVariantFile(ctx context.Context, id string, name string) (*domain.ImageFile, error) {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+
+	variant, ok := image.Variant(name)
+	if !ok {
+		return nil, domain.ErrVariantNotFound
+	}
+
+	baseName := strings.TrimSuffix(image.OriginalFilename, filepath.Ext(image.OriginalFilename))
+	imageFile := &domain.ImageFile{
+		Filename: u.downloadName(fmt.Sprintf("%s_%s%s", baseName, variant.Name, filepath.Ext(variant.Path))),
+		ETag:     image.VariantETag(variant),
+	}
+	if image.ProcessedAt != nil {
+		imageFile.ModTime = *image.ProcessedAt
+	}
+
+	file, err := u.openCachedVariant(ctx, image.ID, variant)
+	if err != nil {
+		return nil, err
+	}
+	imageFile.Body = file
+	return imageFile, nil
+}
+
+func (u *ImageUsecase) openCachedVariant(ctx context.Context, imageID string, variant domain.ImageVariant) (io.ReadCloser, error) {
+	if u.variantCache == nil {
+		return u.openVariant(ctx, imageID, variant)
+	}
+	if file, ok := u.variantCache.Open(variant.Path); ok {
+		return file, nil
+	}
+
+	file, err := u.openVariant(ctx, imageID, variant)
+	if err != nil {
+		return nil, err
+	}
+	err = u.variantCache.Put(variant.Path, file)
+	file.Close()
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", imageID).Str("variant", variant.Name).Msg("failed to cache variant")
+	}
+	if cached, ok := u.variantCache.Open(variant.Path); ok {
+		return cached, nil
+	}
+
+	// not cached (write failed or larger than the cap), serve from storage
+	return u.openVariant(ctx, imageID, variant)
+}
+
+func (u *ImageUsecase) openVariant(ctx context.Context, imageID string, variant domain.ImageVariant) (io.ReadCloser, error) {
+	file, err := u.storage.GetProcessed(ctx, variant.Path)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", imageID).Str("variant", variant.Name).Str("path", variant.Path).Msg("failed to get variant file")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrVariantNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// GetResizedOriginal serves the original fitted into the requested box,
+// never enlarged. Renditions are cached by image and parameters when the
+// resize cache is enabled; originals never change, so cached entries only
+// go away when the image is deleted. Cache misses wait for one of
+// resizeSlots before touching the original.
+func (u *ImageUsecase) GetResizedOriginal(ctx context.Context, id string, req domain.ResizeRequest) (*domain.ImageFile, error) {
+	if !u.resize.Allows(req) {
+		return nil, domain.ErrResizeNotAllowed
+	}
+
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+	if !image.HasOriginal() {
+		return nil, domain.ErrImageNotFound
+	}
+
+	format := processor.SourceFormat(image.OriginalFilename)
+	var vary []string
+	if u.cfg.OnTheFlyWebP {
+		// the format follows Accept, so caches must key on it either way
+		vary = []string{"Accept"}
+		if req.WebP {
+			format = processor.WebP
 		}
 	} else {
-		if !image.IsProcessed() {
-			zlog.Logger.Warn().Str("image_id", id).Msg("image not processed yet")
-			return nil, "", fmt.Errorf("image not processed yet")
+		req.WebP = false
+	}
+	baseName := strings.TrimSuffix(image.OriginalFilename, filepath.Ext(image.OriginalFilename))
+	resized := &domain.ImageFile{
+		Filename:  u.downloadName(fmt.Sprintf("%s_%s%s", baseName, req.Key(), processor.FormatExtension(format))),
+		ETag:      image.ResizedETag(req),
+		ModTime:   image.CreatedAt,
+		Immutable: true,
+		Vary:      vary,
+	}
+
+	cacheKey := image.ID + "/" + req.Key() + processor.FormatExtension(format)
+	if u.resizeCache != nil {
+		if file, ok := u.resizeCache.Open(cacheKey); ok {
+			zlog.Logger.Debug().Str("image_id", image.ID).Str("resize", req.Key()).Msg("resize cache hit")
+			resized.Body = file
+			return resized, nil
 		}
-		file, err = u.storage.GetProcessed(ctx, image.ProcessedPath)
-		if err != nil {
-			zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", image.ProcessedPath).Msg("failed to get processed file")
-			if errors.Is(err, storage.ErrObjectNotFound) {
-				return nil, "", domain.ErrImageNotFound
-			}
-			return nil, "", err
+	}
+
+	select {
+	case u.resizeSlots <- struct{}{}:
+		defer func() { <-u.resizeSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	original, err := u.storage.GetOriginal(ctx, image.OriginalPath)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", image.OriginalPath).Msg("failed to get original file")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrImageNotFound
 		}
+		return nil, err
+	}
+	defer original.Close()
+
+	img, err := processor.DecodeLimit(original, 1, u.cfg.MaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+	}
 
-		// Берем ext из реального ProcessedPath, чтобы избежать mismatch
-		ext := filepath.Ext(image.ProcessedPath)
-		baseName := image.OriginalFilename[:len(image.OriginalFilename)-len(filepath.Ext(image.OriginalFilename))]
-		filename = fmt.Sprintf("%s_%s%s", baseName, image.ProcessingType, ext)
+	var buf bytes.Buffer
+	opts := processor.EncodeOptions{Quality: processor.Quality(u.cfg.OutputQuality), Flatten: format == imaging.JPEG}
+	if err := processor.Encode(&buf, processor.FitBox(img, req), format, opts); err != nil {
+		return nil, fmt.Errorf("encode resized image: %w", err)
 	}
 
-	return file, filename, nil
+	if u.resizeCache != nil {
+		if err := u.resizeCache.Put(cacheKey, bytes.NewReader(buf.Bytes())); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("resize", req.Key()).Msg("failed to cache resized image")
+		}
+	}
+
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("resize", req.Key()).
+		Int("bytes", buf.Len()).
+		Msg("original resized on the fly")
+
+	resized.Body = bytesFile{bytes.NewReader(buf.Bytes())}
+	return resized, nil
+}
+
+// bytesFile serves an in-memory result as a seekable ImageFile body.
+type bytesFile struct {
+	*bytes.Reader
 }
 
-func (u *ImageUsecase) DeleteImage(ctx context.Context, id string) error {
-	image, err := u.repo.FindByID(ctx, id)
+func (bytesFile) Close() error { return nil }
+
+// DeleteImage soft-deletes the image, keeping the record and files until
+// the worker purges them; with hard, both are removed right away.
+func (u *ImageUsecase) DeleteImage(ctx context.Context, id string, hard bool) error {
+	image, err := findImage(ctx, u.repo, id)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to find image for delete")
 		return err
 	}
 
-	if err := u.storage.DeleteAll(ctx, image.OriginalPath, image.ProcessedPath); err != nil {
-		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to delete files")
+	if u.resizeCache != nil {
+		// deleted images are not served, so their renditions only take space
+		u.resizeCache.RemoveDir(image.ID)
+	}
+
+	if !hard {
+		if err := u.repo.Delete(ctx, image.ID); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to soft-delete image")
+			return err
+		}
+		zlog.Logger.Info().Str("image_id", id).Msg("image soft-deleted")
+		return nil
+	}
+
+	if u.variantCache != nil {
+		for _, v := range image.Variants {
+			u.variantCache.Remove(v.Path)
+		}
 	}
+	deleteImageFiles(ctx, u.storage, u.processedVariants, image)
 
-	if err := u.repo.Delete(ctx, id); err != nil {
+	if err := u.repo.Purge(ctx, image.ID); err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to delete image record")
 		return err
 	}
@@ -148,18 +698,303 @@ func (u *ImageUsecase) DeleteImage(ctx context.Context, id string) error {
 	return nil
 }
 
-func (u *ImageUsecase) ListImages(ctx context.Context, limit, offset int) ([]*domain.Image, error) {
-	if limit <= 0 {
-		limit = 10
+// MigrateImage moves every file of the image (original, processed image,
+// upload thumbnail, variants and processed variants) to backend, "local" or
+// "s3", and records backend on the image so later reads ask it first. Paths
+// stay the same and reads fall back to the other backend, so an image left
+// half migrated by a failure is still served and can simply be migrated
+// again.
+func (u *ImageUsecase) MigrateImage(ctx context.Context, id, backend string) (*domain.Image, error) {
+	migrator, ok := u.storage.(storage.Migrator)
+	if !ok {
+		return nil, domain.ErrBackendNotConfigured
+	}
+
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{image.OriginalPath, image.ProcessedPath, image.ThumbnailPath}
+	for _, v := range image.Variants {
+		paths = append(paths, v.Path)
+	}
+	processed, err := u.processedVariants.ListByImageID(ctx, image.ID)
+	if err != nil {
+		return nil, fmt.Errorf("list processed variants: %w", err)
+	}
+	for _, v := range processed {
+		paths = append(paths, v.ProcessedPath)
+	}
+
+	moved := 0
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		err := migrator.MigrateObject(ctx, path, backend)
+		switch {
+		case err == nil:
+			moved++
+		case errors.Is(err, storage.ErrObjectNotFound):
+			zlog.Logger.Warn().Str("image_id", image.ID).Str("path", path).Msg("file missing from both backends, skipping migration")
+		case errors.Is(err, storage.ErrBackendNotConfigured):
+			return nil, domain.ErrBackendNotConfigured
+		case errors.Is(err, storage.ErrBackendUnavailable):
+			return nil, fmt.Errorf("%w: %v", domain.ErrBackendUnavailable, err)
+		default:
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Str("path", path).Msg("failed to migrate file")
+			return nil, fmt.Errorf("migrate %s: %w", path, err)
+		}
+	}
+
+	if err := u.repo.SetStorageBackend(ctx, image.ID, backend); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to record storage backend")
+		return nil, fmt.Errorf("set storage backend: %w", err)
+	}
+	image.StorageBackend = backend
+
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("backend", backend).
+		Int("files", moved).
+		Msg("image migrated")
+	return image, nil
+}
+
+// deleteImageFiles removes the original, the processed image, the upload
+// thumbnail and every variant file of image. Failures are logged and
+// otherwise ignored, so a missing file never keeps the record around.
+func deleteImageFiles(ctx context.Context, store storage.Storage, processedVariants domain.ProcessedVariantRepository, image *domain.Image) {
+	if err := store.DeleteAll(ctx, image.OriginalPath, image.ProcessedPath); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to delete files")
+	}
+	if image.ThumbnailPath != "" {
+		if err := store.Delete(ctx, image.ThumbnailPath); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to delete thumbnail file")
+		}
+	}
+	for _, v := range image.Variants {
+		if err := store.Delete(ctx, v.Path); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Str("variant", v.Name).Msg("failed to delete variant file")
+		}
+	}
+	// processed variant rows go with the image (ON DELETE CASCADE), their files don't
+	processed, err := processedVariants.ListByImageID(ctx, image.ID)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to list processed variants for delete")
 	}
-	if limit > 100 {
-		limit = 100
+	for _, v := range processed {
+		if v.ProcessedPath == "" {
+			continue
+		}
+		if err := store.Delete(ctx, v.ProcessedPath); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Str("variant", v.Name).Msg("failed to delete processed variant file")
+		}
+	}
+}
+
+func (u *ImageUsecase) ListImages(ctx context.Context, limit, offset int, sort domain.ListSort) ([]*domain.Image, int, error) {
+	if sort.Field == "" {
+		sort.Field = domain.DefaultListSort.Field
+	}
+	if sort.Order == "" {
+		sort.Order = domain.DefaultListSort.Order
+	}
+	if !sort.IsValid() {
+		return nil, 0, domain.ErrInvalidSort
+	}
+
+	if limit <= 0 {
+		return nil, 0, fmt.Errorf("page limit must be positive, got %d", limit)
 	}
 
-	images, err := u.repo.List(ctx, limit, offset)
+	images, err := u.repo.List(ctx, limit, offset, sort)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Msg("failed to list images")
+		return nil, 0, err
+	}
+
+	total, err := u.repo.Count(ctx)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to count images")
+		return nil, 0, err
+	}
+	return images, total, nil
+}
+
+// streamPageSize is how many images StreamImages reads per query.
+const streamPageSize = 500
+
+func (u *ImageUsecase) StreamImages(ctx context.Context, filter domain.ImageFilter, fn func(*domain.Image) error) error {
+	afterID := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		images, err := u.repo.ListAfterID(ctx, filter, afterID, streamPageSize)
+		if err != nil {
+			return fmt.Errorf("list images: %w", err)
+		}
+		for _, image := range images {
+			if err := fn(image); err != nil {
+				return err
+			}
+		}
+
+		if len(images) < streamPageSize {
+			return nil
+		}
+		afterID = images[len(images)-1].ID
+	}
+}
+
+func (u *ImageUsecase) GetQueuePosition(ctx context.Context, id string) (*domain.QueuePosition, error) {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Msg("failed to find image for queue position")
+		return nil, err
+	}
+
+	if image.Status != domain.StatusPending {
+		return nil, domain.ErrImageNotPending
+	}
+
+	ahead, err := u.repo.CountPendingBefore(ctx, image.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := u.repo.CountByStatus(ctx, domain.StatusPending)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.QueuePosition{
+		ImageID:      image.ID,
+		Position:     ahead + 1,
+		PendingTotal: total,
+	}, nil
+}
+
+// pruneTenantOriginals deletes the tenant's oldest processed originals until
+// it is back under the cap. Images still waiting for processing are never
+// pruned, so the tenant may stay over the cap until they complete.
+func (u *ImageUsecase) pruneTenantOriginals(ctx context.Context, tenantID string) {
+	count, err := u.repo.CountOriginalsByTenant(ctx, tenantID)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("tenant_id", tenantID).Msg("failed to count tenant originals")
+		return
+	}
+
+	excess := count - u.retention.MaxOriginals
+	if excess <= 0 {
+		return
+	}
+
+	images, err := u.repo.FindOldestPrunableByTenant(ctx, tenantID, excess)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("tenant_id", tenantID).Msg("failed to find prunable originals")
+		return
+	}
+
+	for _, image := range images {
+		path := image.OriginalPath
+		if err := u.storage.Delete(ctx, path); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("path", path).Msg("failed to delete pruned original")
+			continue
+		}
+
+		image.MarkOriginalPruned()
+		if err := u.repo.Update(ctx, image); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to record pruned original")
+			continue
+		}
+
+		zlog.Logger.Info().
+			Str("tenant_id", tenantID).
+			Str("image_id", image.ID).
+			Str("path", path).
+			Msg("original pruned by tenant retention cap")
+	}
+}
+
+// checkMagic sniffs the leading bytes of an upload and rejects it unless
+// they are a supported image format matching the filename extension; the
+// extension alone lets an archive or SVG through as a .jpg. The returned
+// reader replays the sniffed bytes.
+func (u *ImageUsecase) checkMagic(reader io.Reader, filename string) (io.Reader, error) {
+	head := make([]byte, processor.SniffLen)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
 		return nil, err
 	}
-	return images, nil
+	head = head[:n]
+
+	sniffed := processor.SniffFormat(head)
+	if sniffed == "" {
+		return nil, fmt.Errorf("%w: content is not an image", domain.ErrInvalidFormat)
+	}
+	if ext := processor.CanonicalExtension(filepath.Ext(filename)); ext != sniffed {
+		return nil, fmt.Errorf("%w: content is %s, extension says %q", domain.ErrInvalidFormat, sniffed, ext)
+	}
+
+	supported := false
+	for _, format := range u.cfg.SupportedFormats {
+		if processor.CanonicalExtension(format) == sniffed {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("%w: %s is not a supported format", domain.ErrInvalidFormat, sniffed)
+	}
+
+	return io.MultiReader(bytes.NewReader(head), reader), nil
+}
+
+// checkAspectRatio reads just the image header to get the dimensions and
+// returns a reader that replays the consumed bytes followed by the rest.
+// The dimensions are those of the image as displayed: a JPEG whose EXIF
+// orientation turns it by 90 degrees is checked with them swapped.
+func (u *ImageUsecase) checkAspectRatio(reader io.Reader) (io.Reader, error) {
+	var header bytes.Buffer
+	cfg, format, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidImageData, err)
+	}
+
+	width, height := cfg.Width, cfg.Height
+	// orientations 5-8 transpose the stored pixels
+	if format == "jpeg" && processor.ExifOrientation(header.Bytes()) >= 5 {
+		width, height = height, width
+	}
+	if !u.aspect.Allows(width, height) {
+		return nil, fmt.Errorf("%w: %dx%d, required ratio %.3f", domain.ErrInvalidAspectRatio, width, height, u.aspect.Ratio)
+	}
+
+	return io.MultiReader(&header, reader), nil
+}
+
+// normalizeOriginal bakes the EXIF orientation into the pixels and re-encodes
+// the image in its own format, which also drops the EXIF block, so every
+// consumer of the stored original sees the same upright image.
+func (u *ImageUsecase) normalizeOriginal(reader io.Reader, filename string) (*bytes.Buffer, error) {
+	format, err := imaging.FormatFromFilename(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidFormat, err)
+	}
+
+	img, err := processor.DecodeLimit(reader, 1, u.cfg.MaxPixels)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+	}
+
+	var buf bytes.Buffer
+	if err := processor.Encode(&buf, img, format, processor.EncodeOptions{Quality: processor.Quality(u.cfg.OutputQuality)}); err != nil {
+		return nil, fmt.Errorf("re-encode original: %w", err)
+	}
+
+	return &buf, nil
 }
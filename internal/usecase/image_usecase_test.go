@@ -0,0 +1,898 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/cache"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+// fakeInline stands in for inline processing, leaving the image in the
+// state under test.
+type fakeInline struct {
+	domain.ProcessorService
+	repo   *fakeImageRepo
+	result func(img *domain.Image)
+}
+
+func (p *fakeInline) ProcessImage(ctx context.Context, imageID string, opts domain.ProcessingOptions) error {
+	p.result(p.repo.images[imageID])
+	return nil
+}
+
+func TestProcessInlinePublishesUnlessFinished(t *testing.T) {
+	retryAt := time.Now().Add(time.Minute)
+	tests := []struct {
+		name        string
+		result      func(img *domain.Image)
+		wantPublish bool
+	}{
+		{"completed", func(img *domain.Image) { img.Status = domain.StatusCompleted }, false},
+		{"terminal failure", func(img *domain.Image) { img.MarkAsFailed("decode failed") }, false},
+		{"failed with retry", func(img *domain.Image) { img.MarkForRetry("storage down", retryAt) }, true},
+		{"left processing", func(img *domain.Image) { img.Status = domain.StatusProcessing }, true},
+		{"still pending", func(img *domain.Image) {}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &domain.Image{ID: "img-1", Size: 10, Status: domain.StatusPending, JobID: "job-1"}
+			repo := &fakeImageRepo{images: map[string]*domain.Image{image.ID: {ID: image.ID, Status: domain.StatusPending}}}
+			u := &ImageUsecase{
+				repo:   repo,
+				cfg:    &config.ProcessingConfig{SyncMaxBytes: 100, SyncMaxPending: 5},
+				inline: &fakeInline{repo: repo, result: tt.result},
+			}
+
+			processed, publish := u.processInline(context.Background(), image, domain.ProcessingOptions{})
+			if publish != tt.wantPublish {
+				t.Fatalf("publish = %v, want %v", publish, tt.wantPublish)
+			}
+			if processed == nil || processed.JobID != "job-1" {
+				t.Fatalf("processed = %+v, want the reloaded image with its job", processed)
+			}
+		})
+	}
+}
+
+func TestProcessInlineSkippedPublishes(t *testing.T) {
+	u := &ImageUsecase{cfg: &config.ProcessingConfig{SyncMaxBytes: 100}}
+
+	processed, publish := u.processInline(context.Background(), &domain.Image{ID: "img-1", Size: 10}, domain.ProcessingOptions{})
+	if processed != nil || !publish {
+		t.Fatalf("without inline processing got (%v, %v), want (nil, true)", processed, publish)
+	}
+}
+
+func TestUploadHybridMode(t *testing.T) {
+	small := encodeJPEG(t, 32, 24)
+	tests := []struct {
+		name    string
+		data    []byte
+		queued  int // pending uploads ahead of this one
+		hybrid  bool
+		wantNow bool
+	}{
+		{name: "small with a short queue", data: small, queued: 2, hybrid: true, wantNow: true},
+		{name: "queue at the threshold", data: small, queued: 3, hybrid: true},
+		{name: "too large", data: encodeNoiseJPEG(t, 256, 256, 90), hybrid: true},
+		{name: "hybrid mode off", data: small},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ProcessingConfig{ResizeWidth: 16, ResizeHeight: 16, SyncMaxBytes: 4 << 10, SyncMaxPending: 3}
+			u, repo, store, queue := newUploadUsecase(cfg)
+			if tt.hybrid {
+				u.inline = NewProcessorUsecase(repo, fakeJobRepo{}, nil, &fakeImageVariantRepo{log: repo.log}, store, processor.NewImageProcessor(cfg), domain.RetryPolicy{}, nil, nil)
+			}
+			for i := range tt.queued {
+				id := fmt.Sprintf("queued-%d", i)
+				repo.images[id] = &domain.Image{ID: id, Status: domain.StatusPending, CreatedAt: time.Now().Add(-time.Minute)}
+			}
+
+			image, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(tt.data)), bytes.NewReader(tt.data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+			if err != nil {
+				t.Fatalf("UploadImage: %v", err)
+			}
+			if tt.wantNow {
+				if image.Status != domain.StatusCompleted || image.Width != 16 || image.ProcessedPath == "" {
+					t.Fatalf("returned %s %dx%d at %q, want the completed 16px result", image.Status, image.Width, image.Height, image.ProcessedPath)
+				}
+				if len(queue.published) != 0 {
+					t.Errorf("published %v for an image processed inline", queue.published)
+				}
+				return
+			}
+			if image.Status != domain.StatusPending {
+				t.Errorf("returned status %s, want pending", image.Status)
+			}
+			if stored := repo.images[image.ID]; stored.Status != domain.StatusPending {
+				t.Errorf("stored status %s, want pending for the worker", stored.Status)
+			}
+			if len(queue.published) != 1 || queue.published[0] != image.ID {
+				t.Errorf("published %v, want [%s]", queue.published, image.ID)
+			}
+		})
+	}
+}
+func newUploadUsecase(cfg *config.ProcessingConfig) (*ImageUsecase, *fakeImageRepo, *memStorage, *fakeQueue) {
+	repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+	store := newMemStorage()
+	queue := &fakeQueue{}
+	cfg.SupportedFormats = []string{"jpg", "jpeg", "png"}
+	u := NewImageUsecase(repo, fakeJobRepo{}, nil, store, queue, cfg, nil, nil, 0, nil, nil)
+	return u, repo, store, queue
+}
+
+func TestUploadDuplicateIsNotStored(t *testing.T) {
+	u, repo, store, queue := newUploadUsecase(&config.ProcessingConfig{DedupUploads: true})
+	data := encodeJPEG(t, 32, 24)
+
+	first, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	stored := len(store.objects)
+
+	second, err := u.UploadImage(context.Background(), "b.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("second upload: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("duplicate answered with %s, want the first image %s", second.ID, first.ID)
+	}
+	if len(store.objects) != stored {
+		t.Fatalf("duplicate wrote to storage: %d objects, want %d", len(store.objects), stored)
+	}
+	if len(repo.images) != 1 || len(queue.published) != 1 {
+		t.Fatalf("got %d images and %d tasks, want 1 of each", len(repo.images), len(queue.published))
+	}
+}
+
+func TestUploadWithCallbackIsNotDeduplicated(t *testing.T) {
+	u, repo, _, queue := newUploadUsecase(&config.ProcessingConfig{DedupUploads: true})
+	data := encodeJPEG(t, 32, 24)
+
+	first, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+
+	opts := domain.ProcessingOptions{CallbackURL: "https://client.example/hook"}
+	second, err := u.UploadImage(context.Background(), "b.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, opts, "")
+	if err != nil {
+		t.Fatalf("second upload: %v", err)
+	}
+	if second.ID == first.ID {
+		t.Fatal("upload with a callback_url was answered with the earlier image")
+	}
+	if second.CallbackURL != opts.CallbackURL {
+		t.Fatalf("callback_url = %q, want %q", second.CallbackURL, opts.CallbackURL)
+	}
+	if len(repo.images) != 2 || len(queue.published) != 2 {
+		t.Fatalf("got %d images and %d tasks, want 2 of each", len(repo.images), len(queue.published))
+	}
+}
+
+// countingStorage counts reads of originals and holds each one until
+// release is closed, recording how many were open at once.
+type countingStorage struct {
+	*memStorage
+	release chan struct{}
+	mu      sync.Mutex
+	reads   int
+	active  int
+	peak    int
+}
+
+func (s *countingStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.reads++
+	s.active++
+	s.peak = max(s.peak, s.active)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+
+	if s.release != nil {
+		<-s.release
+	}
+	return s.memStorage.GetOriginal(ctx, path)
+}
+
+func TestUploadNormalizeUsesOutputQuality(t *testing.T) {
+	data := encodeJPEG(t, 200, 150)
+	sizes := make(map[int]int)
+	for _, quality := range []int{10, 95} {
+		u, _, store, _ := newUploadUsecase(&config.ProcessingConfig{NormalizeOriginals: true, OutputQuality: quality})
+		img, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+		if err != nil {
+			t.Fatalf("upload at quality %d: %v", quality, err)
+		}
+		sizes[quality] = len(store.objects[img.OriginalPath])
+	}
+
+	if sizes[10] >= sizes[95] {
+		t.Fatalf("quality 10 stored %d bytes, quality 95 %d; want the configured quality to shrink the original", sizes[10], sizes[95])
+	}
+}
+
+// withExifOrientation inserts an EXIF block carrying orientation right
+// after the SOI marker of a JPEG.
+func withExifOrientation(data []byte, orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1}
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation>>8), byte(orientation), 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	out := append([]byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestUploadAspectRatioFollowsExifOrientation(t *testing.T) {
+	// stored 200x100, displayed 100x200 when turned by orientation 6
+	landscape := encodeJPEG(t, 200, 100)
+	tests := []struct {
+		name        string
+		orientation uint16
+		wantErr     bool
+	}{
+		{name: "no exif", wantErr: true},
+		{name: "upright", orientation: 1, wantErr: true},
+		{name: "rotated 90", orientation: 6},
+		{name: "transposed", orientation: 5},
+		{name: "rotated 180", orientation: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := landscape
+			if tt.orientation != 0 {
+				data = withExifOrientation(landscape, tt.orientation)
+			}
+			u, _, _, _ := newUploadUsecase(&config.ProcessingConfig{RequiredAspect: 0.5, AspectTolerance: 0.01})
+			_, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+			if tt.wantErr != errors.Is(err, domain.ErrInvalidAspectRatio) {
+				t.Fatalf("err = %v, want aspect ratio rejection %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("UploadImage: %v", err)
+			}
+		})
+	}
+}
+
+// fakePreview renders every preview as the same few bytes.
+type fakePreview struct{}
+
+func (fakePreview) Preview(ctx context.Context, reader io.Reader, processingType domain.ProcessingType, opts domain.ProcessingOptions, w io.Writer) error {
+	_, err := w.Write([]byte("thumbnail"))
+	return err
+}
+
+func TestUploadResponseLinksSyncThumbnailAndStatus(t *testing.T) {
+	data := encodeJPEG(t, 32, 24)
+	for _, syncThumbnail := range []bool{false, true} {
+		repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+		store := newMemStorage()
+		cfg := &config.ProcessingConfig{SupportedFormats: []string{"jpg"}, SyncThumbnail: syncThumbnail}
+		var thumbnails domain.PreviewService
+		if syncThumbnail {
+			thumbnails = fakePreview{}
+		}
+		u := NewImageUsecase(repo, fakeJobRepo{}, nil, store, &fakeQueue{}, cfg, nil, nil, 0, nil, thumbnails)
+
+		img, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+		if err != nil {
+			t.Fatalf("UploadImage: %v", err)
+		}
+		resp := dto.MapImageToResponse(img, "http://api")
+
+		if !syncThumbnail {
+			if resp.ThumbnailURL != "" || resp.StatusURL != "" {
+				t.Fatalf("without sync thumbnails got thumbnail_url %q and status_url %q", resp.ThumbnailURL, resp.StatusURL)
+			}
+			continue
+		}
+		if resp.Status != string(domain.StatusPending) {
+			t.Fatalf("status = %s, want pending while the full processing is queued", resp.Status)
+		}
+		if want := "http://api/image/" + img.ID + "/thumbnail"; resp.ThumbnailURL != want {
+			t.Fatalf("thumbnail_url = %q, want %q", resp.ThumbnailURL, want)
+		}
+		if want := "http://api/image/" + img.ID + "/status"; resp.StatusURL != want {
+			t.Fatalf("status_url = %q, want %q", resp.StatusURL, want)
+		}
+		if got := string(store.objects[img.ThumbnailPath]); got != "thumbnail" {
+			t.Fatalf("stored thumbnail = %q", got)
+		}
+	}
+}
+
+func TestUploadTransliteratesKeysAndDownloadNames(t *testing.T) {
+	data := encodeJPEG(t, 32, 24)
+	for _, tt := range []struct {
+		filename string
+		wantName string
+	}{
+		{filename: "Отпуск на море.jpg", wantName: "Otpusk_na_more.jpg"},
+		{filename: "東京の夜.jpg", wantName: "file.jpg"},
+	} {
+		for _, transliterate := range []bool{false, true} {
+			u, repo, _, _ := newUploadUsecase(&config.ProcessingConfig{TransliterateFilenames: transliterate})
+
+			img, err := u.UploadImage(context.Background(), tt.filename, "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+			if err != nil {
+				t.Fatalf("UploadImage(%q): %v", tt.filename, err)
+			}
+			// the database keeps the name as uploaded, for display
+			if got := repo.images[img.ID].OriginalFilename; got != tt.filename {
+				t.Errorf("stored filename = %q, want %q", got, tt.filename)
+			}
+			for _, r := range img.OriginalPath {
+				if r > '~' {
+					t.Errorf("storage key %q is not ASCII", img.OriginalPath)
+					break
+				}
+			}
+
+			file, err := u.GetImageFile(context.Background(), img.ID, true)
+			if err != nil {
+				t.Fatalf("GetImageFile: %v", err)
+			}
+			file.Body.Close()
+			want := tt.filename
+			if transliterate {
+				want = tt.wantName
+			}
+			if file.Filename != want {
+				t.Errorf("transliterate=%v: download name = %q, want %q", transliterate, file.Filename, want)
+			}
+		}
+	}
+}
+
+func TestUploadStrictStructureRejectsPolyglot(t *testing.T) {
+	polyglot := append(encodeJPEG(t, 32, 24), []byte("<?php system($_GET['c']); ?>")...)
+
+	u, repo, store, queue := newUploadUsecase(&config.ProcessingConfig{StrictImageStructure: true})
+	_, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(polyglot)), bytes.NewReader(polyglot), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if !errors.Is(err, domain.ErrInvalidImageStructure) {
+		t.Fatalf("err = %v, want ErrInvalidImageStructure", err)
+	}
+	if len(store.objects) != 0 || len(repo.images) != 0 || len(queue.published) != 0 {
+		t.Fatalf("rejected upload left %d objects, %d images, %d tasks", len(store.objects), len(repo.images), len(queue.published))
+	}
+
+	// the same file is stored as uploaded when the check is off
+	u, _, store, _ = newUploadUsecase(&config.ProcessingConfig{})
+	img, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(polyglot)), bytes.NewReader(polyglot), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("UploadImage without the check: %v", err)
+	}
+	if !bytes.Equal(store.objects[img.OriginalPath], polyglot) {
+		t.Fatal("stored original differs from the upload")
+	}
+
+	// a clean image passes the check unchanged
+	clean := encodeJPEG(t, 32, 24)
+	u, _, store, _ = newUploadUsecase(&config.ProcessingConfig{StrictImageStructure: true})
+	img, err = u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(clean)), bytes.NewReader(clean), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("clean upload: %v", err)
+	}
+	if !bytes.Equal(store.objects[img.OriginalPath], clean) {
+		t.Fatal("stored original differs from the clean upload")
+	}
+}
+
+// cancelAtEOF cancels its context once the upload body has been read, like
+// a client that disconnects as soon as it has sent the file.
+type cancelAtEOF struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (r cancelAtEOF) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestUploadPublishesAfterClientDisconnects(t *testing.T) {
+	u, repo, _, queue := newUploadUsecase(&config.ProcessingConfig{})
+	data := encodeJPEG(t, 32, 24)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	img, err := u.UploadImage(ctx, "a.jpg", "image/jpeg", int64(len(data)), cancelAtEOF{bytes.NewReader(data), cancel}, domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Fatal("request context was not cancelled during the upload")
+	}
+	if _, ok := repo.images[img.ID]; !ok {
+		t.Fatal("image record not stored")
+	}
+	if len(queue.published) != 1 || queue.published[0] != img.ID {
+		t.Fatalf("published = %v, want the stored image's task", queue.published)
+	}
+}
+
+func newResizeUsecase(t *testing.T, cfg *config.ProcessingConfig, resizeCache *cache.DiskCache) (*ImageUsecase, *countingStorage, string) {
+	t.Helper()
+	repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+	store := &countingStorage{memStorage: newMemStorage()}
+
+	id := uuid.NewString()
+	data := encodeJPEG(t, 100, 80)
+	path, err := store.SaveOriginal(context.Background(), id+".jpg", bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("save original: %v", err)
+	}
+	repo.images[id] = &domain.Image{ID: id, OriginalFilename: "photo.jpg", OriginalPath: path, Status: domain.StatusCompleted}
+
+	u := NewImageUsecase(repo, fakeJobRepo{}, nil, store, &fakeQueue{}, cfg, nil, resizeCache, 0, nil, nil)
+	return u, store, id
+}
+
+func decodeResized(t *testing.T, file *domain.ImageFile) stdimage.Config {
+	t.Helper()
+	defer file.Body.Close()
+	cfg, _, err := stdimage.DecodeConfig(file.Body)
+	if err != nil {
+		t.Fatalf("decode resized: %v", err)
+	}
+	return cfg
+}
+
+func TestGetResizedOriginalValidatesParameters(t *testing.T) {
+	u, store, id := newResizeUsecase(t, &config.ProcessingConfig{
+		OnTheFlyMaxWidth:  500,
+		OnTheFlyMaxHeight: 500,
+		OnTheFlySizes:     []string{"50x40", "60x0"},
+	}, nil)
+
+	tests := []struct {
+		name string
+		req  domain.ResizeRequest
+		ok   bool
+	}{
+		{"allowlisted box", domain.ResizeRequest{Width: 50, Height: 40, Fit: domain.FitCover}, true},
+		{"allowlisted width only", domain.ResizeRequest{Width: 60, Fit: domain.FitContain}, true},
+		{"not allowlisted", domain.ResizeRequest{Width: 51, Height: 40, Fit: domain.FitCover}, false},
+		{"over the maximum", domain.ResizeRequest{Width: 600, Fit: domain.FitContain}, false},
+		{"no side", domain.ResizeRequest{Fit: domain.FitContain}, false},
+		{"unknown fit", domain.ResizeRequest{Width: 50, Height: 40, Fit: "stretch"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := u.GetResizedOriginal(context.Background(), id, tt.req)
+			if !tt.ok {
+				if !errors.Is(err, domain.ErrResizeNotAllowed) {
+					t.Fatalf("err = %v, want ErrResizeNotAllowed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetResizedOriginal: %v", err)
+			}
+			file.Body.Close()
+		})
+	}
+
+	if store.reads != 2 {
+		t.Fatalf("original read %d times, want only for the 2 allowed requests", store.reads)
+	}
+}
+
+func TestGetResizedOriginalDoesNotUpscale(t *testing.T) {
+	u, _, id := newResizeUsecase(t, &config.ProcessingConfig{}, nil)
+
+	tests := []struct {
+		req          domain.ResizeRequest
+		wantW, wantH int
+	}{
+		{domain.ResizeRequest{Width: 50, Height: 50, Fit: domain.FitContain}, 50, 40},
+		{domain.ResizeRequest{Width: 400, Height: 400, Fit: domain.FitContain}, 100, 80},
+		{domain.ResizeRequest{Width: 400, Height: 200, Fit: domain.FitCover}, 100, 50},
+		{domain.ResizeRequest{Width: 400, Height: 200, Fit: domain.FitFill}, 100, 50},
+		{domain.ResizeRequest{Width: 1000, Fit: domain.FitContain}, 100, 80},
+		{domain.ResizeRequest{Height: 40, Fit: domain.FitContain}, 50, 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.req.Key(), func(t *testing.T) {
+			file, err := u.GetResizedOriginal(context.Background(), id, tt.req)
+			if err != nil {
+				t.Fatalf("GetResizedOriginal: %v", err)
+			}
+			if got := decodeResized(t, file); got.Width != tt.wantW || got.Height != tt.wantH {
+				t.Fatalf("resized to %dx%d, want %dx%d", got.Width, got.Height, tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestGetResizedOriginalCache(t *testing.T) {
+	resizeCache, err := cache.NewDiskCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	u, store, id := newResizeUsecase(t, &config.ProcessingConfig{}, resizeCache)
+	req := domain.ResizeRequest{Width: 50, Height: 40, Fit: domain.FitCover}
+
+	for i := range 2 {
+		file, err := u.GetResizedOriginal(context.Background(), id, req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if got := decodeResized(t, file); got.Width != 50 || got.Height != 40 {
+			t.Fatalf("request %d resized to %dx%d", i, got.Width, got.Height)
+		}
+	}
+	if store.reads != 1 {
+		t.Fatalf("original read %d times, want 1 (miss, then hit)", store.reads)
+	}
+
+	other := domain.ResizeRequest{Width: 30, Fit: domain.FitContain}
+	file, err := u.GetResizedOriginal(context.Background(), id, other)
+	if err != nil {
+		t.Fatalf("other size: %v", err)
+	}
+	file.Body.Close()
+	if store.reads != 2 {
+		t.Fatalf("original read %d times, want a miss for new parameters", store.reads)
+	}
+
+	if err := u.DeleteImage(context.Background(), id, false); err != nil {
+		t.Fatalf("DeleteImage: %v", err)
+	}
+	for _, r := range []domain.ResizeRequest{req, other} {
+		if file, ok := resizeCache.Open(id + "/" + r.Key() + ".jpg"); ok {
+			file.Close()
+			t.Fatalf("rendition %s still cached after delete", r.Key())
+		}
+	}
+}
+
+func TestGetResizedOriginalBoundsConcurrentRenders(t *testing.T) {
+	u, store, id := newResizeUsecase(t, &config.ProcessingConfig{OnTheFlyMaxConcurrent: 2}, nil)
+	store.release = make(chan struct{})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, err := u.GetResizedOriginal(context.Background(), id, domain.ResizeRequest{Width: 10 + i, Fit: domain.FitContain})
+			if err == nil {
+				file.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	// give every request the chance to start rendering
+	time.Sleep(50 * time.Millisecond)
+	close(store.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("GetResizedOriginal: %v", err)
+		}
+	}
+	if store.peak != 2 {
+		t.Fatalf("%d renders ran at once, want the cap of 2", store.peak)
+	}
+}
+
+func TestGetResizedOriginalGivesUpWaitingWithContext(t *testing.T) {
+	u, store, id := newResizeUsecase(t, &config.ProcessingConfig{OnTheFlyMaxConcurrent: 1}, nil)
+	store.release = make(chan struct{})
+	defer close(store.release)
+
+	go u.GetResizedOriginal(context.Background(), id, domain.ResizeRequest{Width: 10, Fit: domain.FitContain})
+	for {
+		store.mu.Lock()
+		started := store.active == 1
+		store.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := u.GetResizedOriginal(ctx, id, domain.ResizeRequest{Width: 20, Fit: domain.FitContain}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context deadline while the only slot is taken", err)
+	}
+}
+
+func TestGetResizedOriginalNegotiatesWebP(t *testing.T) {
+	tests := []struct {
+		name       string
+		enabled    bool
+		acceptWebP bool
+		wantFormat string
+		wantVary   []string
+	}{
+		{name: "disabled", acceptWebP: true, wantFormat: "jpeg"},
+		{name: "accepted", enabled: true, acceptWebP: true, wantFormat: "webp", wantVary: []string{"Accept"}},
+		{name: "not accepted", enabled: true, wantFormat: "jpeg", wantVary: []string{"Accept"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _, id := newResizeUsecase(t, &config.ProcessingConfig{OnTheFlyWebP: tt.enabled}, nil)
+			file, err := u.GetResizedOriginal(context.Background(), id, domain.ResizeRequest{Width: 50, Fit: domain.FitContain, WebP: tt.acceptWebP})
+			if err != nil {
+				t.Fatalf("GetResizedOriginal: %v", err)
+			}
+			defer file.Body.Close()
+			_, format, err := stdimage.DecodeConfig(file.Body)
+			if err != nil {
+				t.Fatalf("decode resized: %v", err)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("format = %s, want %s", format, tt.wantFormat)
+			}
+			if fmt.Sprint(file.Vary) != fmt.Sprint(tt.wantVary) {
+				t.Errorf("Vary = %v, want %v", file.Vary, tt.wantVary)
+			}
+		})
+	}
+
+	// the two formats are different representations of one URL
+	u, _, id := newResizeUsecase(t, &config.ProcessingConfig{OnTheFlyWebP: true}, nil)
+	jpeg, err := u.GetResizedOriginal(context.Background(), id, domain.ResizeRequest{Width: 50, Fit: domain.FitContain})
+	if err != nil {
+		t.Fatalf("GetResizedOriginal: %v", err)
+	}
+	webp, err := u.GetResizedOriginal(context.Background(), id, domain.ResizeRequest{Width: 50, Fit: domain.FitContain, WebP: true})
+	if err != nil {
+		t.Fatalf("GetResizedOriginal: %v", err)
+	}
+	if jpeg.ETag == webp.ETag {
+		t.Error("JPEG and WebP renditions share an ETag")
+	}
+}
+
+// twoBackendStorage writes to its s3 memStorage and reads through to local,
+// migrating objects between the two like storage with s3_local_backend.
+type twoBackendStorage struct {
+	*memStorage
+	local *memStorage
+}
+
+func (s *twoBackendStorage) GetOriginal(ctx context.Context, path string) (io.ReadCloser, error) {
+	file, err := s.memStorage.GetOriginal(ctx, path)
+	if errors.Is(err, storage.ErrObjectNotFound) {
+		return s.local.GetOriginal(ctx, path)
+	}
+	return file, err
+}
+
+func (s *twoBackendStorage) ActiveBackend() string {
+	return storage.BackendS3
+}
+
+func (s *twoBackendStorage) MigrateObject(ctx context.Context, path, backend string) error {
+	from, to := s.memStorage, s.local
+	switch backend {
+	case storage.BackendS3:
+		from, to = s.local, s.memStorage
+	case storage.BackendLocal:
+	default:
+		return fmt.Errorf("%w: %q", storage.ErrBackendNotConfigured, backend)
+	}
+	from.mu.Lock()
+	data, ok := from.objects[path]
+	delete(from.objects, path)
+	from.mu.Unlock()
+	if ok {
+		to.mu.Lock()
+		to.objects[path] = data
+		to.mu.Unlock()
+	}
+	return nil
+}
+
+func TestMigrateImageMovesFilesAndRecordsBackend(t *testing.T) {
+	repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+	store := &twoBackendStorage{memStorage: newMemStorage(), local: newMemStorage()}
+	cfg := &config.ProcessingConfig{SupportedFormats: []string{"jpg"}}
+	u := NewImageUsecase(repo, fakeJobRepo{}, fakeProcessedVariantRepo{}, store, &fakeQueue{}, cfg, nil, nil, 0, nil, nil)
+	ctx := context.Background()
+
+	data := encodeJPEG(t, 16, 16)
+	img, err := u.UploadImage(ctx, "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	if got := repo.images[img.ID].StorageBackend; got != storage.BackendS3 {
+		t.Fatalf("uploaded image recorded on %q, want %q", got, storage.BackendS3)
+	}
+
+	migrated, err := u.MigrateImage(ctx, img.ID, storage.BackendLocal)
+	if err != nil {
+		t.Fatalf("MigrateImage: %v", err)
+	}
+	if migrated.StorageBackend != storage.BackendLocal || repo.images[img.ID].StorageBackend != storage.BackendLocal {
+		t.Fatalf("backend marker = %q (stored %q), want %q", migrated.StorageBackend, repo.images[img.ID].StorageBackend, storage.BackendLocal)
+	}
+	if _, ok := store.local.objects[img.OriginalPath]; !ok {
+		t.Fatal("original not on the local backend")
+	}
+	if _, ok := store.objects[img.OriginalPath]; ok {
+		t.Fatal("original left on s3")
+	}
+
+	file, err := u.GetImageFile(ctx, img.ID, true)
+	if err != nil {
+		t.Fatalf("GetImageFile: %v", err)
+	}
+	defer file.Body.Close()
+	got, err := io.ReadAll(file.Body)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("migrated original reads back %d bytes (err %v), want %d", len(got), err, len(data))
+	}
+
+	if _, err := u.MigrateImage(ctx, img.ID, "gcs"); !errors.Is(err, domain.ErrBackendNotConfigured) {
+		t.Fatalf("unknown backend: err = %v, want ErrBackendNotConfigured", err)
+	}
+}
+
+func TestMigrateImageNeedsTwoBackends(t *testing.T) {
+	u, repo, _, _ := newUploadUsecase(&config.ProcessingConfig{})
+	repo.images["a"] = &domain.Image{ID: "a", OriginalPath: "originals/a.jpg"}
+	if _, err := u.MigrateImage(context.Background(), "a", storage.BackendS3); !errors.Is(err, domain.ErrBackendNotConfigured) {
+		t.Fatalf("err = %v, want ErrBackendNotConfigured", err)
+	}
+}
+
+func TestUploadNormalizesOriginalOrientation(t *testing.T) {
+	// stored 200x100, displayed 100x200 when turned by orientation 6
+	rotated := withExifOrientation(encodeJPEG(t, 200, 100), 6)
+
+	for _, normalize := range []bool{true, false} {
+		t.Run(fmt.Sprintf("normalize_originals=%v", normalize), func(t *testing.T) {
+			u, _, store, _ := newUploadUsecase(&config.ProcessingConfig{NormalizeOriginals: normalize})
+
+			img, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(rotated)), bytes.NewReader(rotated), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+			if err != nil {
+				t.Fatalf("UploadImage: %v", err)
+			}
+			stored := store.objects[img.OriginalPath]
+
+			if !normalize {
+				if !bytes.Equal(stored, rotated) {
+					t.Fatal("original was rewritten with normalization off")
+				}
+				return
+			}
+			cfg, format, err := stdimage.DecodeConfig(bytes.NewReader(stored))
+			if err != nil {
+				t.Fatalf("decode stored original: %v", err)
+			}
+			if format != "jpeg" || cfg.Width != 100 || cfg.Height != 200 {
+				t.Errorf("stored original is a %dx%d %s, want the upright 100x200 jpeg", cfg.Width, cfg.Height, format)
+			}
+			if o := processor.ExifOrientation(stored); o > 1 {
+				t.Errorf("stored original still has orientation %d", o)
+			}
+		})
+	}
+}
+
+// addTenantImage stores a completed image of tenant created age ago.
+func addTenantImage(t *testing.T, repo *fakeImageRepo, store *memStorage, tenantID, id string, age time.Duration, status domain.ProcessingStatus) {
+	t.Helper()
+	original, err := store.SaveOriginal(context.Background(), id+".jpg", bytes.NewReader([]byte("original")), 8)
+	if err != nil {
+		t.Fatalf("save original: %v", err)
+	}
+	processed, err := store.SaveProcessed(context.Background(), id+"_resize.jpg", bytes.NewReader([]byte("processed")), 9)
+	if err != nil {
+		t.Fatalf("save processed: %v", err)
+	}
+	repo.images[id] = &domain.Image{
+		ID:            id,
+		TenantID:      tenantID,
+		OriginalPath:  original,
+		ProcessedPath: processed,
+		Status:        status,
+		CreatedAt:     time.Now().Add(-age),
+	}
+}
+
+func TestUploadPrunesOldestOriginalsOverTenantCap(t *testing.T) {
+	u, repo, store, _ := newUploadUsecase(&config.ProcessingConfig{TenantMaxOriginals: 3, TenantOverCap: "prune"})
+	addTenantImage(t, repo, store, "acme", "oldest", 3*time.Hour, domain.StatusCompleted)
+	addTenantImage(t, repo, store, "acme", "older-pending", 4*time.Hour, domain.StatusPending)
+	addTenantImage(t, repo, store, "acme", "newer", time.Hour, domain.StatusCompleted)
+	addTenantImage(t, repo, store, "other", "foreign", 5*time.Hour, domain.StatusCompleted)
+	oldest := *repo.images["oldest"]
+
+	data := encodeJPEG(t, 32, 24)
+	if _, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "acme"); err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+
+	// four originals against a cap of three: the oldest completed one goes,
+	// the even older pending one is still needed
+	pruned := repo.images["oldest"]
+	if pruned.HasOriginal() {
+		t.Fatal("the oldest completed original was kept")
+	}
+	if _, ok := store.objects[oldest.OriginalPath]; ok {
+		t.Error("the pruned original is still in storage")
+	}
+	if _, ok := store.objects[pruned.ProcessedPath]; !ok || pruned.Status != domain.StatusCompleted {
+		t.Error("pruning the original removed the processed image")
+	}
+	for _, id := range []string{"older-pending", "newer", "foreign"} {
+		if !repo.images[id].HasOriginal() {
+			t.Errorf("original of %s was pruned", id)
+		}
+	}
+	if count, _ := repo.CountOriginalsByTenant(context.Background(), "acme"); count != 3 {
+		t.Errorf("acme keeps %d originals, want 3", count)
+	}
+}
+
+func TestUploadRejectedOverTenantCap(t *testing.T) {
+	u, repo, store, queue := newUploadUsecase(&config.ProcessingConfig{TenantMaxOriginals: 2, TenantOverCap: "reject"})
+	addTenantImage(t, repo, store, "acme", "first", 2*time.Hour, domain.StatusCompleted)
+	addTenantImage(t, repo, store, "acme", "second", time.Hour, domain.StatusCompleted)
+	stored := len(store.objects)
+
+	data := encodeJPEG(t, 32, 24)
+	_, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "acme")
+	if !errors.Is(err, domain.ErrTenantQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrTenantQuotaExceeded", err)
+	}
+	if len(store.objects) != stored || len(repo.images) != 2 || len(queue.published) != 0 {
+		t.Fatal("the rejected upload was stored")
+	}
+	if !repo.images["first"].HasOriginal() {
+		t.Error("reject policy pruned an original")
+	}
+
+	// other tenants have their own quota
+	if _, err := u.UploadImage(context.Background(), "a.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "other"); err != nil {
+		t.Fatalf("upload of another tenant: %v", err)
+	}
+}
+
+func TestGetImageFileRefusesCompletedRowWithoutFile(t *testing.T) {
+	u, repo, _, _ := newUploadUsecase(&config.ProcessingConfig{})
+	const id = "0b8f2a52-8a0c-4c5e-9d59-2f1a7f3b6c10"
+	repo.images[id] = &domain.Image{ID: id, OriginalFilename: "photo.jpg", OriginalPath: "originals/photo.jpg", Status: domain.StatusCompleted}
+
+	if file, err := u.GetImageFile(context.Background(), id, false); err == nil {
+		t.Fatalf("GetImageFile served %+v for a legacy row without a processed file", file)
+	}
+}
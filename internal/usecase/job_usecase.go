@@ -0,0 +1,21 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type JobUsecase struct {
+	jobs domain.JobRepository
+}
+
+func NewJobUsecase(jobs domain.JobRepository) *JobUsecase {
+	return &JobUsecase{
+		jobs: jobs,
+	}
+}
+
+func (u *JobUsecase) GetJob(ctx context.Context, id string) (*domain.Job, error) {
+	return u.jobs.FindByID(ctx, id)
+}
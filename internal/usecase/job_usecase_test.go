@@ -0,0 +1,154 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+// memJobRepo keeps jobs in memory and records every state they pass through.
+type memJobRepo struct {
+	domain.JobRepository
+	mu     sync.Mutex
+	jobs   map[string]*domain.Job
+	states map[string][]domain.JobState
+}
+
+func newMemJobRepo() *memJobRepo {
+	return &memJobRepo{jobs: map[string]*domain.Job{}, states: map[string][]domain.JobState{}}
+}
+
+func (r *memJobRepo) Create(ctx context.Context, job *domain.Job) error {
+	return r.Update(ctx, job)
+}
+
+func (r *memJobRepo) Update(ctx context.Context, job *domain.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *job
+	r.jobs[job.ID] = &copied
+	r.states[job.ID] = append(r.states[job.ID], job.State)
+	return nil
+}
+
+func (r *memJobRepo) FindByID(ctx context.Context, id string) (*domain.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, domain.ErrJobNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (r *memJobRepo) FindLatestByImageID(ctx context.Context, imageID string) (*domain.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var latest *domain.Job
+	for _, job := range r.jobs {
+		if job.ImageID == imageID && (latest == nil || job.CreatedAt.After(latest.CreatedAt)) {
+			latest = job
+		}
+	}
+	if latest == nil {
+		return nil, domain.ErrJobNotFound
+	}
+	copied := *latest
+	return &copied, nil
+}
+
+// uploadWithJobs uploads data and returns the job issued for it, along with
+// a processor sharing the image, storage and job stores.
+func uploadWithJobs(t *testing.T, data []byte) (*domain.Image, *memJobRepo, *ProcessorUsecase, *fakeImageRepo) {
+	t.Helper()
+	cfg := &config.ProcessingConfig{ResizeWidth: 32, ResizeHeight: 32, SupportedFormats: []string{"jpg"}}
+	repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+	store := newMemStorage()
+	jobs := newMemJobRepo()
+
+	u := NewImageUsecase(repo, jobs, nil, store, &fakeQueue{}, cfg, nil, nil, 0, nil, nil)
+	image, err := u.UploadImage(context.Background(), "photo.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data), domain.ProcessingResize, domain.ProcessingOptions{}, "")
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	if image.JobID == "" {
+		t.Fatal("upload returned no job id")
+	}
+
+	processing := NewProcessorUsecase(repo, jobs, nil, &fakeImageVariantRepo{log: repo.log}, store, processor.NewImageProcessor(cfg), domain.RetryPolicy{MaxAttempts: 2, Delays: []time.Duration{time.Minute}}, nil, nil)
+	return image, jobs, processing, repo
+}
+
+func TestJobLifecycleOfSuccessfulProcessing(t *testing.T) {
+	image, jobs, processing, _ := uploadWithJobs(t, encodeJPEG(t, 128, 96))
+
+	job, err := NewJobUsecase(jobs).GetJob(context.Background(), image.JobID)
+	if err != nil {
+		t.Fatalf("GetJob: %v", err)
+	}
+	if job.State != domain.JobQueued || job.ImageID != image.ID || job.StartedAt != nil {
+		t.Fatalf("job after upload = %+v, want queued and not started", job)
+	}
+
+	if err := processing.ProcessImage(context.Background(), image.ID, domain.ProcessingOptions{}); err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+
+	job, _ = NewJobUsecase(jobs).GetJob(context.Background(), image.JobID)
+	if want := []domain.JobState{domain.JobQueued, domain.JobRunning, domain.JobCompleted}; !slices.Equal(jobs.states[job.ID], want) {
+		t.Fatalf("job went through %v, want %v", jobs.states[job.ID], want)
+	}
+	if job.StartedAt == nil || job.FinishedAt == nil || job.FinishedAt.Before(*job.StartedAt) || job.StartedAt.Before(job.CreatedAt) {
+		t.Fatalf("timings created %v, started %v, finished %v are out of order", job.CreatedAt, job.StartedAt, job.FinishedAt)
+	}
+	if job.Attempts != 1 {
+		t.Errorf("attempts = %d, want 1", job.Attempts)
+	}
+}
+
+func TestJobLifecycleOfFailingProcessing(t *testing.T) {
+	jpg := encodeJPEG(t, 128, 96)
+	image, jobs, processing, repo := uploadWithJobs(t, jpg[:len(jpg)/2])
+
+	if err := processing.ProcessImage(context.Background(), image.ID, domain.ProcessingOptions{}); err == nil {
+		t.Fatal("first attempt succeeded")
+	}
+	job, _ := jobs.FindByID(context.Background(), image.JobID)
+	if job.State != domain.JobRetrying || job.FinishedAt != nil || job.ErrorMessage == "" {
+		t.Fatalf("job after a failed attempt = %+v, want retrying with the error", job)
+	}
+	firstStart := *job.StartedAt
+
+	// the retry comes due
+	repo.images[image.ID].NextAttemptAt = nil
+	if err := processing.ProcessImage(context.Background(), image.ID, domain.ProcessingOptions{}); err == nil {
+		t.Fatal("second attempt succeeded")
+	}
+
+	job, _ = jobs.FindByID(context.Background(), image.JobID)
+	want := []domain.JobState{domain.JobQueued, domain.JobRunning, domain.JobRetrying, domain.JobRunning, domain.JobFailed}
+	if !slices.Equal(jobs.states[job.ID], want) {
+		t.Fatalf("job went through %v, want %v", jobs.states[job.ID], want)
+	}
+	if job.Attempts != 2 || job.FinishedAt == nil {
+		t.Fatalf("job = %+v, want finished after 2 attempts", job)
+	}
+	if !job.StartedAt.Equal(firstStart) {
+		t.Errorf("started_at moved from %v to %v; it should cover every attempt", firstStart, *job.StartedAt)
+	}
+}
+
+func TestGetJobNotFound(t *testing.T) {
+	if _, err := NewJobUsecase(newMemJobRepo()).GetJob(context.Background(), "missing"); !errors.Is(err, domain.ErrJobNotFound) {
+		t.Fatalf("err = %v, want ErrJobNotFound", err)
+	}
+}
@@ -0,0 +1,17 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+// findImage resolves an image by either its UUID or its short public ID,
+// so every /image/:id route accepts both.
+func findImage(ctx context.Context, repo domain.ImageRepository, id string) (*domain.Image, error) {
+	if _, err := uuid.Parse(id); err == nil {
+		return repo.FindByID(ctx, id)
+	}
+	return repo.FindByPublicID(ctx, id)
+}
@@ -0,0 +1,55 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+// PreviewUsecase processes an image synchronously without storing anything.
+type PreviewUsecase struct {
+	processor *processor.ImageProcessor
+}
+
+func NewPreviewUsecase(processor *processor.ImageProcessor) *PreviewUsecase {
+	return &PreviewUsecase{
+		processor: processor,
+	}
+}
+
+// Preview processes reader and streams the encoded JPEG into w. Nothing is
+// written to w unless processing succeeded, so callers can still report
+// processing errors in their own format.
+func (u *PreviewUsecase) Preview(
+	ctx context.Context,
+	reader io.Reader,
+	processingType domain.ProcessingType,
+	opts domain.ProcessingOptions,
+	w io.Writer,
+) error {
+	processedImg, err := u.processor.Process(ctx, reader, processingType, opts)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("processing_type", string(processingType)).Msg("preview processing failed")
+		return fmt.Errorf("process preview: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err = processor.Encode(w, processedImg, imaging.JPEG, processor.EncodeOptions{
+		Quality: u.processor.EncodeQuality(opts),
+		Flatten: u.processor.ShouldFlatten(opts, imaging.JPEG),
+	})
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to encode preview")
+		return fmt.Errorf("encode preview: %w", err)
+	}
+
+	return nil
+}
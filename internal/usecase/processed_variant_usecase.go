@@ -0,0 +1,167 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/helpers"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+// ProcessedVariantUsecase queues and serves named variants processed from
+// an uploaded original, each with its own type, options and status.
+type ProcessedVariantUsecase struct {
+	repo     domain.ImageRepository
+	variants domain.ProcessedVariantRepository
+	storage  storage.Storage
+	queue    domain.QueueService
+	cfg      *config.ProcessingConfig
+}
+
+func NewProcessedVariantUsecase(
+	repo domain.ImageRepository,
+	variants domain.ProcessedVariantRepository,
+	storage storage.Storage,
+	queue domain.QueueService,
+	cfg *config.ProcessingConfig,
+) *ProcessedVariantUsecase {
+	return &ProcessedVariantUsecase{
+		repo:     repo,
+		variants: variants,
+		storage:  storage,
+		queue:    queue,
+		cfg:      cfg,
+	}
+}
+
+// RequestVariant queues processing of the original into the variant name.
+// Requesting a finished variant again replaces it once the new run
+// completes; a variant still pending or processing yields ErrAlreadyProcessing.
+func (u *ProcessedVariantUsecase) RequestVariant(
+	ctx context.Context,
+	imageID string,
+	name string,
+	processingType domain.ProcessingType,
+	opts domain.ProcessingOptions,
+) (*domain.ProcessedVariant, error) {
+	if !domain.ValidVariantName(name) {
+		return nil, domain.ErrInvalidVariantName
+	}
+	if !processingType.IsValid() {
+		return nil, domain.ErrInvalidProcessingType
+	}
+	if processingType == domain.ProcessingPipeline {
+		if _, ok := u.cfg.Pipelines[opts.PipelineName()]; !ok {
+			return nil, fmt.Errorf("%w: %q", domain.ErrUnknownPipeline, opts.PipelineName())
+		}
+	}
+
+	image, err := findImage(ctx, u.repo, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if !image.HasOriginal() {
+		return nil, domain.ErrImageNotFound
+	}
+
+	now := time.Now()
+	variant, err := u.variants.Request(ctx, &domain.ProcessedVariant{
+		ID:             uuid.New().String(),
+		ImageID:        image.ID,
+		Name:           name,
+		ProcessingType: processingType,
+		Options:        opts,
+		Status:         domain.StatusPending,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.queue.PublishVariantTask(ctx, variant); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Str("variant", name).Msg("failed to publish variant task")
+		// left pending, the variant could never be requested again
+		variant.MarkAsFailed("failed to queue processing task")
+		if err := u.variants.Update(ctx, variant); err != nil {
+			zlog.Logger.Error().Err(err).Str("variant_id", variant.ID).Msg("failed to mark unqueued variant as failed")
+		}
+		return nil, fmt.Errorf("%w: %v", domain.ErrQueueFailed, err)
+	}
+
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("variant", name).
+		Str("processing_type", string(processingType)).
+		Msg("processed variant requested")
+
+	return variant, nil
+}
+
+func (u *ProcessedVariantUsecase) GetVariant(ctx context.Context, imageID string, name string) (*domain.ProcessedVariant, error) {
+	image, err := findImage(ctx, u.repo, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return u.variants.FindByName(ctx, image.ID, name)
+}
+
+func (u *ProcessedVariantUsecase) ListVariants(ctx context.Context, imageID string) ([]*domain.ProcessedVariant, error) {
+	image, err := findImage(ctx, u.repo, imageID)
+	if err != nil {
+		return nil, err
+	}
+	return u.variants.ListByImageID(ctx, image.ID)
+}
+
+// GetVariantFile opens the processed file of a completed variant; while the
+// variant is not completed it returns ErrImageNotProcessed.
+func (u *ProcessedVariantUsecase) GetVariantFile(ctx context.Context, imageID string, name string) (*domain.ImageFile, error) {
+	image, err := findImage(ctx, u.repo, imageID)
+	if err != nil {
+		return nil, err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+
+	variant, err := u.variants.FindByName(ctx, image.ID, name)
+	if err != nil {
+		return nil, err
+	}
+	if !variant.IsProcessed() {
+		return nil, domain.ErrImageNotProcessed
+	}
+
+	file, err := u.storage.GetProcessed(ctx, variant.ProcessedPath)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Str("variant", name).Str("path", variant.ProcessedPath).Msg("failed to get variant file")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, domain.ErrProcessedVariantNotFound
+		}
+		return nil, err
+	}
+
+	baseName := strings.TrimSuffix(image.OriginalFilename, filepath.Ext(image.OriginalFilename))
+	filename := fmt.Sprintf("%s_%s%s", baseName, variant.Name, filepath.Ext(variant.ProcessedPath))
+	if u.cfg.TransliterateFilenames {
+		filename = helpers.ASCIIFilename(filename)
+	}
+
+	imageFile := &domain.ImageFile{
+		Body:     file,
+		Filename: filename,
+		ETag:     variant.ETag(),
+	}
+	if variant.ProcessedAt != nil {
+		imageFile.ModTime = *variant.ProcessedAt
+	}
+
+	return imageFile, nil
+}
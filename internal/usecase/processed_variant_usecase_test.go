@@ -0,0 +1,240 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	stdimage "image"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+// memVariantRepo keeps processed variants in memory with the request and
+// claim rules of the Postgres repository.
+type memVariantRepo struct {
+	domain.ProcessedVariantRepository
+	mu       sync.Mutex
+	variants map[string]*domain.ProcessedVariant
+	// lookups, when set, holds FindByID until that many callers arrived
+	lookups *sync.WaitGroup
+}
+
+func (r *memVariantRepo) Request(ctx context.Context, variant *domain.ProcessedVariant) (*domain.ProcessedVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.variants {
+		if v.ImageID != variant.ImageID || v.Name != variant.Name {
+			continue
+		}
+		if v.IsActive() {
+			return nil, domain.ErrAlreadyProcessing
+		}
+		v.ProcessingType, v.Options, v.Status = variant.ProcessingType, variant.Options, domain.StatusPending
+		copied := *v
+		return &copied, nil
+	}
+	copied := *variant
+	r.variants[variant.ID] = &copied
+	return variant, nil
+}
+
+func (r *memVariantRepo) FindByID(ctx context.Context, id string) (*domain.ProcessedVariant, error) {
+	r.mu.Lock()
+	v, ok := r.variants[id]
+	var copied domain.ProcessedVariant
+	if ok {
+		copied = *v
+	}
+	r.mu.Unlock()
+	if r.lookups != nil {
+		r.lookups.Done()
+		r.lookups.Wait()
+	}
+	if !ok {
+		return nil, domain.ErrProcessedVariantNotFound
+	}
+	return &copied, nil
+}
+
+func (r *memVariantRepo) FindByName(ctx context.Context, imageID, name string) (*domain.ProcessedVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range r.variants {
+		if v.ImageID == imageID && v.Name == name {
+			copied := *v
+			return &copied, nil
+		}
+	}
+	return nil, domain.ErrProcessedVariantNotFound
+}
+
+func (r *memVariantRepo) Claim(ctx context.Context, id string, expiredBefore time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.variants[id]
+	if !ok || !(v.Status == domain.StatusPending || (v.Status == domain.StatusProcessing && v.UpdatedAt.Before(expiredBefore))) {
+		return false, nil
+	}
+	v.Status = domain.StatusProcessing
+	v.UpdatedAt = time.Now()
+	return true, nil
+}
+
+func (r *memVariantRepo) Update(ctx context.Context, variant *domain.ProcessedVariant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *variant
+	r.variants[variant.ID] = &copied
+	return nil
+}
+
+// countingSaves counts processed files written.
+type countingSaves struct {
+	*memStorage
+	saves atomic.Int32
+}
+
+func (s *countingSaves) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	s.saves.Add(1)
+	return s.memStorage.SaveProcessed(ctx, filename, reader, size)
+}
+
+// imageID is a UUID, so lookups go by id rather than public id.
+const imageID = "6f1c7a52-3d0e-4b8a-9f21-5c4e8d2a7b10"
+
+type variantHarness struct {
+	requests  *ProcessedVariantUsecase
+	processor *ProcessorUsecase
+	variants  *memVariantRepo
+	storage   *countingSaves
+	queue     *fakeQueue
+}
+
+func newVariantHarness(t *testing.T) *variantHarness {
+	t.Helper()
+	cfg := &config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64}
+	h := newProcessorHarness(t, cfg)
+	h.addImage(t, imageID, "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 128, 96))
+	h.repo.images[imageID].Status = domain.StatusCompleted
+
+	v := &variantHarness{
+		variants: &memVariantRepo{variants: map[string]*domain.ProcessedVariant{}},
+		storage:  &countingSaves{memStorage: h.storage},
+		queue:    &fakeQueue{},
+	}
+	v.requests = NewProcessedVariantUsecase(h.repo, v.variants, v.storage, v.queue, cfg)
+	v.processor = NewProcessorUsecase(h.repo, fakeJobRepo{}, v.variants, h.variants, v.storage, processor.NewImageProcessor(cfg), domain.RetryPolicy{}, nil, nil)
+	return v
+}
+
+// concurrently runs fn once per argument, all at the same time.
+func concurrently[T any](args []T, fn func(T)) {
+	var wg sync.WaitGroup
+	for _, arg := range args {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(arg)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentRequestsForOneVariantRenderItOnce(t *testing.T) {
+	h := newVariantHarness(t)
+	ctx := context.Background()
+	width := 32
+	opts := domain.ProcessingOptions{Width: &width}
+
+	var accepted, refused atomic.Int32
+	concurrently([]int{1, 2}, func(int) {
+		_, err := h.requests.RequestVariant(ctx, imageID, "small", domain.ProcessingResize, opts)
+		switch {
+		case err == nil:
+			accepted.Add(1)
+		case errors.Is(err, domain.ErrAlreadyProcessing):
+			refused.Add(1)
+		default:
+			t.Errorf("RequestVariant: %v", err)
+		}
+	})
+	if accepted.Load() != 1 || refused.Load() != 1 {
+		t.Fatalf("accepted %d, refused %d requests; want one of each", accepted.Load(), refused.Load())
+	}
+	if len(h.queue.published) != 1 {
+		t.Fatalf("published %v, want one task", h.queue.published)
+	}
+
+	// the task is delivered twice and both deliveries load it before
+	// either claims it
+	taskID := h.queue.published[0]
+	h.variants.lookups = &sync.WaitGroup{}
+	h.variants.lookups.Add(2)
+	concurrently([]string{taskID, taskID}, func(id string) {
+		if err := h.processor.ProcessVariant(ctx, id); err != nil {
+			t.Errorf("ProcessVariant: %v", err)
+		}
+	})
+
+	if n := h.storage.saves.Load(); n != 1 {
+		t.Errorf("variant stored %d times, want once", n)
+	}
+	variant, err := h.requests.GetVariant(ctx, imageID, "small")
+	if err != nil {
+		t.Fatalf("GetVariant: %v", err)
+	}
+	if variant.Status != domain.StatusCompleted || variant.Width != 32 {
+		t.Fatalf("variant %s %dpx wide, want completed at 32", variant.Status, variant.Width)
+	}
+}
+
+func TestConcurrentVariantsOfOneOriginal(t *testing.T) {
+	h := newVariantHarness(t)
+	ctx := context.Background()
+	widths := map[string]int{"small": 24, "large": 96}
+
+	var mu sync.Mutex
+	ids := map[string]string{}
+	concurrently([]string{"small", "large"}, func(name string) {
+		width := widths[name]
+		variant, err := h.requests.RequestVariant(ctx, imageID, name, domain.ProcessingResize, domain.ProcessingOptions{Width: &width})
+		if err != nil {
+			t.Errorf("RequestVariant %s: %v", name, err)
+			return
+		}
+		mu.Lock()
+		ids[name] = variant.ID
+		mu.Unlock()
+	})
+	concurrently([]string{ids["small"], ids["large"]}, func(id string) {
+		if err := h.processor.ProcessVariant(ctx, id); err != nil {
+			t.Errorf("ProcessVariant: %v", err)
+		}
+	})
+
+	for name, width := range widths {
+		file, err := h.requests.GetVariantFile(ctx, imageID, name)
+		if err != nil {
+			t.Fatalf("GetVariantFile %s: %v", name, err)
+		}
+		data, err := io.ReadAll(file.Body)
+		file.Body.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		img, _, err := stdimage.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode %s: %v", name, err)
+		}
+		if img.Bounds().Dx() != width {
+			t.Errorf("%s is %dpx wide, want %d", name, img.Bounds().Dx(), width)
+		}
+	}
+}
@@ -3,35 +3,97 @@ package usecase
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	stdimage "image"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/wb-go/wbf/zlog"
 	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/metrics"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
 	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
 )
 
 type ProcessorUsecase struct {
 	repo      domain.ImageRepository
+	jobs      domain.JobRepository
+	variants  domain.ProcessedVariantRepository
 	storage   storage.Storage
 	processor *processor.ImageProcessor
+	retry     domain.RetryPolicy
+	notifier  domain.ProcessingNotifier
+	// imageVariants holds the configured variants rendered with the image
+	imageVariants domain.ImageVariantRepository
+	// callbacks reports every completion and failure to the callback URL
+	// the image was uploaded with
+	callbacks domain.ProcessingNotifier
+	// slots bounds how many images and variants are processed at once;
+	// nil when max_concurrent_processing is 0
+	slots chan struct{}
 }
 
 func NewProcessorUsecase(
 	repo domain.ImageRepository,
+	jobs domain.JobRepository,
+	variants domain.ProcessedVariantRepository,
+	imageVariants domain.ImageVariantRepository,
 	storage storage.Storage,
 	processor *processor.ImageProcessor,
+	retry domain.RetryPolicy,
+	notifier domain.ProcessingNotifier,
+	callbacks domain.ProcessingNotifier,
 ) *ProcessorUsecase {
+	var slots chan struct{}
+	if n := processor.MaxConcurrentProcessing(); n > 0 {
+		slots = make(chan struct{}, n)
+	}
+
 	return &ProcessorUsecase{
-		repo:      repo,
-		storage:   storage,
-		processor: processor,
+		repo:          repo,
+		jobs:          jobs,
+		variants:      variants,
+		imageVariants: imageVariants,
+		storage:       storage,
+		processor:     processor,
+		retry:         retry,
+		notifier:      notifier,
+		callbacks:     callbacks,
+		slots:         slots,
+	}
+}
+
+// acquireSlot blocks until fewer than max_concurrent_processing tasks are
+// decoding, and returns the func that frees the slot. Waiting ends with
+// the context, in which case the task has not started and no state is
+// changed.
+func (u *ProcessorUsecase) acquireSlot(ctx context.Context) (func(), error) {
+	if u.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case u.slots <- struct{}{}:
+		return func() { <-u.slots }, nil
+	default:
+	}
+
+	waitStart := time.Now()
+	select {
+	case u.slots <- struct{}{}:
+		zlog.Logger.Debug().Dur("waited", time.Since(waitStart)).Msg("processing slot acquired")
+		return func() { <-u.slots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for processing slot: %w", ctx.Err())
 	}
 }
 
-func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) error {
+func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string, opts domain.ProcessingOptions) (err error) {
 	image, err := u.repo.FindByID(ctx, imageID)
 	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to find image")
@@ -46,36 +108,72 @@ func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) err
 		return nil
 	}
 
+	if image.NextAttemptAt != nil && time.Now().Before(*image.NextAttemptAt) {
+		zlog.Logger.Info().
+			Str("image_id", imageID).
+			Time("next_attempt_at", *image.NextAttemptAt).
+			Msg("retry not due yet, skipping")
+		return nil
+	}
+
+	// options from the task win; anything it leaves out comes from the
+	// options stored at upload, so reprocessing behaves like the first run
+	opts = opts.WithDefaults(image.Options)
+
+	// the slot is held until the result is stored: the decoded original
+	// and the encoded output stay in memory until then
+	release, err := u.acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	image.MarkAsProcessing()
 	if err := u.repo.Update(ctx, image); err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to update status to processing")
 		return fmt.Errorf("update status to processing: %w", err)
 	}
+	u.syncJob(ctx, image)
+
+	start := time.Now()
+	defer func() {
+		metrics.ProcessingDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.ProcessingResults.WithLabelValues("failure").Inc()
+		} else {
+			metrics.ProcessingResults.WithLabelValues("success").Inc()
+		}
+	}()
 
 	zlog.Logger.Info().
 		Str("image_id", imageID).
 		Str("processing_type", string(image.ProcessingType)).
 		Msg("starting image processing")
 
-	originalFile, err := u.storage.GetOriginal(ctx, image.OriginalPath)
+	originalFile, err := u.storage.GetOriginal(storage.WithBackend(ctx, image.StorageBackend), image.OriginalPath)
 	if err != nil {
-		image.MarkAsFailed(fmt.Sprintf("failed to get original file: %v", err))
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, classifyFailure(err, domain.FailureStorage), fmt.Sprintf("failed to get original file: %v", err))
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Str("path", image.OriginalPath).Msg("failed to get original file")
 		return fmt.Errorf("get original file: %w", err)
 	}
 	defer originalFile.Close()
 
-	img, err := imaging.Decode(originalFile, imaging.AutoOrientation(true))
+	var source io.Reader = originalFile
+	image.Repaired = false
+	img, err := u.processor.Decode(ctx, originalFile, opts.PageNumber())
+	if err != nil && u.processor.RepairCorruptImages() && !errors.Is(err, domain.ErrTooManyPixels) && !errors.Is(err, domain.ErrDecodeTimeout) {
+		if repaired, ok := u.repairOriginal(ctx, image, originalFile, err); ok {
+			img, source, image.Repaired = repaired.img, bytes.NewReader(repaired.data), true
+			err = nil
+		}
+	}
 	if err != nil {
-		image.MarkAsFailed(fmt.Sprintf("failed to decode original file: %v", err))
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, classifyFailure(err, domain.FailureDecode), fmt.Sprintf("failed to decode original file: %v", err))
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Str("path", image.OriginalPath).Msg("failed to decode original image")
 		return fmt.Errorf("decode original image: %w", err)
 	}
 	if img.Bounds().Dx() == 0 || img.Bounds().Dy() == 0 {
-		image.MarkAsFailed("original image is empty")
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, domain.FailureDecode, "original image is empty")
 		zlog.Logger.Error().Str("image_id", imageID).Str("path", image.OriginalPath).Msg("original image is empty")
 		return fmt.Errorf("original image is empty")
 	}
@@ -85,20 +183,25 @@ func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) err
 		Int("original_height", img.Bounds().Dy()).
 		Msg("Original image decoded successfully")
 
-	if seeker, ok := originalFile.(io.Seeker); ok {
+	// a repaired original is still truncated in storage
+	if !image.Repaired && u.processor.CanUseOriginal(img, image.ProcessingType, opts) && u.originalIsClean(image, originalFile) {
+		return u.completeWithOriginal(ctx, image, img)
+	}
+
+	profile, exif := u.preservedMetadata(source)
+
+	if seeker, ok := source.(io.Seeker); ok {
 		_, err = seeker.Seek(0, io.SeekStart)
 		if err != nil {
-			image.MarkAsFailed(fmt.Sprintf("failed to seek original file: %v", err))
-			_ = u.repo.Update(ctx, image)
+			u.markFailed(ctx, image, classifyFailure(err, domain.FailureStorage), fmt.Sprintf("failed to seek original file: %v", err))
 			zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to seek original file")
 			return fmt.Errorf("seek original file: %w", err)
 		}
 	}
 
-	processedImg, err := u.processor.Process(originalFile, image.ProcessingType)
+	processedImg, err := u.processor.Process(ctx, source, image.ProcessingType, opts)
 	if err != nil {
-		image.MarkAsFailed(fmt.Sprintf("processing failed: %v", err))
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, classifyFailure(err, domain.FailureUnknown), fmt.Sprintf("processing failed: %v", err))
 		zlog.Logger.Error().
 			Err(err).
 			Str("image_id", imageID).
@@ -109,8 +212,7 @@ func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) err
 
 	width, height := processor.GetImageDimensions(processedImg)
 	if width == 0 || height == 0 {
-		image.MarkAsFailed("processed image is empty")
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, domain.FailureUnknown, "processed image is empty")
 		zlog.Logger.Error().
 			Str("image_id", imageID).
 			Str("processing_type", string(image.ProcessingType)).
@@ -122,17 +224,21 @@ func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) err
 		return fmt.Errorf("processed image is empty")
 	}
 
+	format := u.processor.OutputFormat(image.OriginalFilename, image.ProcessingType)
+	if u.processor.ShouldFlatten(opts, format) {
+		processedImg = processor.Flatten(processedImg)
+		zlog.Logger.Debug().Str("image_id", imageID).Msg("alpha channel flattened before encoding")
+	}
+
 	var buf bytes.Buffer
-	if err := imaging.Encode(&buf, processedImg, imaging.JPEG, imaging.JPEGQuality(95)); err != nil {
-		image.MarkAsFailed(fmt.Sprintf("encoding failed: %v", err))
-		_ = u.repo.Update(ctx, image)
+	if err := processor.Encode(&buf, processedImg, format, processor.EncodeOptions{Quality: u.processor.EncodeQuality(opts), ICCProfile: profile, Exif: exif}); err != nil {
+		u.markFailed(ctx, image, classifyFailure(err, domain.FailureUnknown), fmt.Sprintf("encoding failed: %v", err))
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to encode image")
 		return fmt.Errorf("encode image: %w", err)
 	}
 
 	if buf.Len() == 0 {
-		image.MarkAsFailed("empty buffer after encoding")
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, domain.FailureUnknown, "empty buffer after encoding")
 		zlog.Logger.Error().
 			Str("image_id", imageID).
 			Str("processing_type", string(image.ProcessingType)).
@@ -142,28 +248,641 @@ func (u *ProcessorUsecase) ProcessImage(ctx context.Context, imageID string) err
 		return fmt.Errorf("empty buffer after encoding")
 	}
 
-	processedFilename := fmt.Sprintf("%s_%s.jpg", image.ID, image.ProcessingType)
-	processedPath, err := u.storage.SaveProcessed(ctx, processedFilename, &buf)
+	if err := u.processor.VerifyOutput(buf.Bytes(), width, height); err != nil {
+		u.markFailed(ctx, image, domain.FailureUnknown, fmt.Sprintf("encoded output rejected: %v", err))
+		zlog.Logger.Error().
+			Err(err).
+			Str("image_id", imageID).
+			Str("processing_type", string(image.ProcessingType)).
+			Int("buffer_size", buf.Len()).
+			Msg("encoded output failed verification")
+		return fmt.Errorf("verify encoded image: %w", err)
+	}
+
+	// The content hash in the name keeps concurrent reprocessing runs from
+	// overwriting each other's output; the record only ever points at the
+	// version that was committed last.
+	sum := sha256.Sum256(buf.Bytes())
+	processedFilename := fmt.Sprintf("%s_%s_%s%s", image.ID, image.ProcessingType, hex.EncodeToString(sum[:6]), processor.FormatExtension(format))
+	// saving drains buf
+	processedSize := int64(buf.Len())
+	processedPath, err := u.storage.SaveProcessed(ctx, processedFilename, &buf, processedSize)
 	if err != nil {
-		image.MarkAsFailed(fmt.Sprintf("failed to save processed file: %v", err))
-		_ = u.repo.Update(ctx, image)
+		u.markFailed(ctx, image, classifyFailure(err, domain.FailureStorage), fmt.Sprintf("failed to save processed file: %v", err))
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Str("path", processedFilename).Msg("failed to save processed file")
 		return fmt.Errorf("save processed file: %w", err)
 	}
 
+	u.checkCompression(image, img, width, height, processedSize)
+
+	previousVariants := image.Variants
+	image.Variants = u.renderVariants(ctx, image, img)
+
+	u.setPlaceholders(image, processedImg)
+	u.storeVariants(ctx, image, previousVariants)
+
 	image.MarkAsCompleted(processedPath, width, height)
-	if err := u.repo.Update(ctx, image); err != nil {
+	// read with the write: a concurrent reprocess may have replaced the
+	// path this run started from
+	previousPath, err := u.repo.Complete(ctx, image)
+	if err != nil {
 		zlog.Logger.Error().Err(err).Str("image_id", imageID).Msg("failed to update status to completed")
 		return fmt.Errorf("update status to completed: %w", err)
 	}
+	u.syncJob(ctx, image)
+
+	if previousPath != "" && previousPath != processedPath && previousPath != image.OriginalPath {
+		if err := u.storage.Delete(ctx, previousPath); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", imageID).Str("path", previousPath).Msg("failed to delete previous processed file")
+		}
+	}
+	u.deleteStaleVariants(ctx, image, previousVariants)
 
 	zlog.Logger.Info().
 		Str("image_id", imageID).
 		Str("processed_path", processedPath).
 		Int("width", width).
 		Int("height", height).
-		Int("buffer_size", buf.Len()).
+		Int64("buffer_size", processedSize).
 		Msg("image processed successfully")
 
+	u.notify(ctx, image)
+	u.callback(ctx, image)
+
+	return nil
+}
+
+func (u *ProcessorUsecase) RejectTask(ctx context.Context, imageID string, reason string, deadLetter bool) error {
+	image, err := u.repo.FindByID(ctx, imageID)
+	if err != nil {
+		return fmt.Errorf("find image: %w", err)
+	}
+
+	if image.IsProcessed() {
+		// a valid task already produced a result; leave it alone
+		return nil
+	}
+
+	image.FailureCategory = domain.FailureUnknown
+	if deadLetter {
+		image.MarkAsDeadLettered(reason)
+	} else {
+		image.MarkAsFailed(reason)
+		image.NextAttemptAt = nil
+	}
+
+	if err := u.repo.Update(ctx, image); err != nil {
+		return fmt.Errorf("update rejected image: %w", err)
+	}
+	u.syncJob(ctx, image)
+
+	if image.Status == domain.StatusDeadLettered {
+		u.notify(ctx, image)
+	}
+	u.callback(ctx, image)
+
+	return nil
+}
+
+// PurgeDeleted removes the files and records of up to limit images
+// soft-deleted before the given time and returns how many it purged.
+func (u *ProcessorUsecase) PurgeDeleted(ctx context.Context, before time.Time, limit int) (int, error) {
+	images, err := u.repo.FindDeletedBefore(ctx, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("find deleted images: %w", err)
+	}
+
+	purged := 0
+	for _, image := range images {
+		deleteImageFiles(ctx, u.storage, u.variants, image)
+		if err := u.repo.Purge(ctx, image.ID); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to purge deleted image")
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// ProcessVariant renders a processed variant from the original with the
+// variant's own type and options. It only touches the variant record, so
+// variants of one image run concurrently with each other and with the
+// image's own processing. Failures are recorded on the variant and not
+// retried; the client requests the variant again.
+func (u *ProcessorUsecase) ProcessVariant(ctx context.Context, variantID string) error {
+	variant, err := u.variants.FindByID(ctx, variantID)
+	if err != nil {
+		if errors.Is(err, domain.ErrProcessedVariantNotFound) {
+			// deleted together with its image after the task was queued
+			zlog.Logger.Warn().Str("variant_id", variantID).Msg("processed variant no longer exists, skipping")
+			return nil
+		}
+		return fmt.Errorf("find processed variant: %w", err)
+	}
+
+	release, err := u.acquireSlot(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	claimed, err := u.variants.Claim(ctx, variant.ID, time.Now().Add(-u.processor.VariantLease()))
+	if err != nil {
+		return fmt.Errorf("claim processed variant: %w", err)
+	}
+	if !claimed {
+		zlog.Logger.Info().
+			Str("variant_id", variant.ID).
+			Str("status", string(variant.Status)).
+			Msg("processed variant is not pending or claimed by another worker, skipping")
+		return nil
+	}
+	variant.Status = domain.StatusProcessing
+
+	image, err := u.repo.FindByID(ctx, variant.ImageID)
+	if err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("failed to find image: %v", err))
+	}
+	if !image.HasOriginal() {
+		return u.failVariant(ctx, variant, "original is no longer stored")
+	}
+
+	originalFile, err := u.storage.GetOriginal(storage.WithBackend(ctx, image.StorageBackend), image.OriginalPath)
+	if err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("failed to get original file: %v", err))
+	}
+	defer originalFile.Close()
+
+	profile, exif := u.preservedMetadata(originalFile)
+
+	processedImg, err := u.processor.Process(ctx, originalFile, variant.ProcessingType, variant.Options)
+	if err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("processing failed: %v", err))
+	}
+
+	width, height := processor.GetImageDimensions(processedImg)
+	if width == 0 || height == 0 {
+		return u.failVariant(ctx, variant, "processed image is empty")
+	}
+
+	format := u.processor.OutputFormat(image.OriginalFilename, variant.ProcessingType)
+	if u.processor.ShouldFlatten(variant.Options, format) {
+		processedImg = processor.Flatten(processedImg)
+	}
+
+	var buf bytes.Buffer
+	if err := processor.Encode(&buf, processedImg, format, processor.EncodeOptions{Quality: u.processor.EncodeQuality(variant.Options), ICCProfile: profile, Exif: exif}); err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("encoding failed: %v", err))
+	}
+	if err := u.processor.VerifyOutput(buf.Bytes(), width, height); err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("encoded output rejected: %v", err))
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	filename := fmt.Sprintf("%s_v_%s_%s%s", image.ID, variant.Name, hex.EncodeToString(sum[:6]), processor.FormatExtension(format))
+	processedPath, err := u.storage.SaveProcessed(ctx, filename, &buf, int64(buf.Len()))
+	if err != nil {
+		return u.failVariant(ctx, variant, fmt.Sprintf("failed to save processed file: %v", err))
+	}
+
+	previousPath := variant.ProcessedPath
+	variant.MarkAsCompleted(processedPath, width, height)
+	if err := u.variants.Update(ctx, variant); err != nil {
+		return fmt.Errorf("update processed variant to completed: %w", err)
+	}
+
+	if previousPath != "" && previousPath != processedPath {
+		if err := u.storage.Delete(ctx, previousPath); err != nil {
+			zlog.Logger.Warn().Err(err).Str("variant_id", variant.ID).Str("path", previousPath).Msg("failed to delete previous variant file")
+		}
+	}
+
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("variant", variant.Name).
+		Str("processing_type", string(variant.ProcessingType)).
+		Str("processed_path", processedPath).
+		Int("width", width).
+		Int("height", height).
+		Msg("processed variant completed")
+
+	return nil
+}
+
+// failVariant records a failed variant run. Only a failure to persist it
+// is returned, so the task is redelivered instead of lost.
+func (u *ProcessorUsecase) failVariant(ctx context.Context, variant *domain.ProcessedVariant, errMsg string) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interruptedUpdateTimeout)
+		defer cancel()
+
+		variant.MarkAsInterrupted()
+		if err := u.variants.Update(ctx, variant); err != nil {
+			return fmt.Errorf("release interrupted processed variant: %w", err)
+		}
+		zlog.Logger.Warn().
+			Str("image_id", variant.ImageID).
+			Str("variant", variant.Name).
+			Msg("processed variant interrupted by shutdown, released to pending")
+		return nil
+	}
+
+	zlog.Logger.Error().
+		Str("image_id", variant.ImageID).
+		Str("variant", variant.Name).
+		Str("error", errMsg).
+		Msg("processed variant failed")
+
+	variant.MarkAsFailed(errMsg)
+	if err := u.variants.Update(ctx, variant); err != nil {
+		return fmt.Errorf("update processed variant to failed: %w", err)
+	}
 	return nil
 }
+
+// checkCompression records the processed size and flags the image when
+// processing reduced the pixel count but kept most of the file size, which
+// usually points at a quality or format setting that defeats the downscale.
+func (u *ProcessorUsecase) checkCompression(image *domain.Image, original stdimage.Image, width, height int, processedSize int64) {
+	image.ProcessedSize = processedSize
+	image.PoorCompression = false
+
+	threshold := u.processor.MaxCompressionRatio()
+	if threshold <= 0 || image.Size <= 0 {
+		return
+	}
+	originalPixels := original.Bounds().Dx() * original.Bounds().Dy()
+	if width*height >= originalPixels {
+		return
+	}
+
+	ratio := float64(processedSize) / float64(image.Size)
+	if ratio <= threshold {
+		return
+	}
+
+	image.PoorCompression = true
+	zlog.Logger.Warn().
+		Str("image_id", image.ID).
+		Str("processing_type", string(image.ProcessingType)).
+		Int64("original_size", image.Size).
+		Int64("processed_size", processedSize).
+		Float64("ratio", ratio).
+		Float64("threshold", threshold).
+		Msg("processed image is barely smaller than the original")
+}
+
+// setPlaceholders computes the enabled placeholders of the result img.
+// They are optional, so a failure only leaves the placeholder empty.
+func (u *ProcessorUsecase) setPlaceholders(image *domain.Image, img stdimage.Image) {
+	if u.processor.BlurhashEnabled() {
+		hash, err := processor.Blurhash(img)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to compute blurhash")
+		} else {
+			image.Blurhash = hash
+		}
+	}
+	if u.processor.LQIPEnabled() {
+		lqip, err := processor.LQIP(img)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to render lqip")
+		} else {
+			image.LQIP = lqip
+		}
+	}
+}
+
+// repairedOriginal is an original that only decoded after RepairJPEG.
+type repairedOriginal struct {
+	data []byte
+	img  stdimage.Image
+}
+
+// repairOriginal retries an original that failed to decode with decodeErr
+// as a truncated JPEG. It reports false when the original is not one, or
+// does not decode even after the repair.
+func (u *ProcessorUsecase) repairOriginal(ctx context.Context, image *domain.Image, originalFile io.Reader, decodeErr error) (*repairedOriginal, bool) {
+	seeker, ok := originalFile.(io.Seeker)
+	if !ok {
+		return nil, false
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, false
+	}
+	data, err := io.ReadAll(originalFile)
+	if err != nil {
+		return nil, false
+	}
+
+	data, ok = processor.RepairJPEG(data)
+	if !ok {
+		return nil, false
+	}
+	img, err := u.processor.Decode(ctx, bytes.NewReader(data), 1)
+	if err != nil || img.Bounds().Empty() {
+		zlog.Logger.Debug().Err(err).Str("image_id", image.ID).Msg("repair pass did not recover original")
+		return nil, false
+	}
+
+	zlog.Logger.Warn().
+		Err(decodeErr).
+		Str("image_id", image.ID).
+		Str("path", image.OriginalPath).
+		Msg("Original was truncated, processing repaired copy")
+	return &repairedOriginal{data: data, img: img}, true
+}
+
+// preservedMetadata reads the ICC profile and EXIF tags to embed in the
+// output when preserve_color_profile or preserve_metadata ask for them,
+// leaving original at its start for decoding.
+func (u *ProcessorUsecase) preservedMetadata(original io.Reader) (profile, exif []byte) {
+	keepProfile := u.processor.PreserveColorProfile()
+	names := u.processor.PreserveMetadata()
+	if !keepProfile && len(names) == 0 {
+		return nil, nil
+	}
+	seeker, ok := original.(io.Seeker)
+	if !ok {
+		return nil, nil
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(original)
+	if _, seekErr := seeker.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+		return nil, nil
+	}
+	if keepProfile {
+		profile = processor.ICCProfile(data)
+	}
+	return profile, processor.PreservedExif(data, names)
+}
+
+// originalIsClean reports whether the original can be served as the
+// processed image without leaking metadata; with strip_metadata on, an
+// original carrying EXIF or similar is re-encoded instead.
+func (u *ProcessorUsecase) originalIsClean(image *domain.Image, originalFile io.Reader) bool {
+	if !u.processor.StripMetadata() {
+		return true
+	}
+	seeker, ok := originalFile.(io.Seeker)
+	if !ok {
+		return false
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	data, err := io.ReadAll(originalFile)
+	if err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to read original for metadata check")
+		return false
+	}
+	if processor.HasMetadata(data) {
+		zlog.Logger.Info().Str("image_id", image.ID).Msg("Original carries metadata, re-encoding instead of serving it as-is")
+		return false
+	}
+	return true
+}
+
+// completeWithOriginal finishes an image whose original already satisfies
+// the target by pointing the processed path at the original itself.
+func (u *ProcessorUsecase) completeWithOriginal(ctx context.Context, image *domain.Image, img stdimage.Image) error {
+	width, height := processor.GetImageDimensions(img)
+
+	previousVariants := image.Variants
+	image.Variants = u.renderVariants(ctx, image, img)
+
+	u.setPlaceholders(image, img)
+
+	image.ProcessedSize = image.Size
+	image.PoorCompression = false
+	u.storeVariants(ctx, image, previousVariants)
+	image.MarkAsCompleted(image.OriginalPath, width, height)
+	previousPath, err := u.repo.Complete(ctx, image)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to update status to completed")
+		return fmt.Errorf("update status to completed: %w", err)
+	}
+	u.syncJob(ctx, image)
+
+	if previousPath != "" && previousPath != image.OriginalPath {
+		if err := u.storage.Delete(ctx, previousPath); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("path", previousPath).Msg("failed to delete previous processed file")
+		}
+	}
+	u.deleteStaleVariants(ctx, image, previousVariants)
+
+	zlog.Logger.Info().
+		Str("image_id", image.ID).
+		Str("processing_type", string(image.ProcessingType)).
+		Int("width", width).
+		Int("height", height).
+		Msg("original already within target bounds, used as processed output")
+
+	u.notify(ctx, image)
+	u.callback(ctx, image)
+
+	return nil
+}
+
+// renderVariants generates every configured variant from the decoded
+// original. A variant that fails is logged and left out; it never fails
+// the main processing run.
+func (u *ProcessorUsecase) renderVariants(ctx context.Context, image *domain.Image, img stdimage.Image) []domain.ImageVariant {
+	configured := u.processor.Variants()
+	if len(configured) == 0 {
+		return nil
+	}
+
+	variants := make([]domain.ImageVariant, 0, len(configured))
+	for _, vc := range configured {
+		format, err := processor.ParseOutputFormat(vc.Format)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("variant", vc.Name).Msg("skipping variant")
+			continue
+		}
+
+		out := u.processor.Variant(img, vc)
+
+		var buf bytes.Buffer
+		opts := processor.EncodeOptions{Quality: u.processor.OutputQuality(), Flatten: format == imaging.JPEG}
+		if err := processor.Encode(&buf, out, format, opts); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("variant", vc.Name).Msg("failed to encode variant")
+			continue
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		filename := fmt.Sprintf("%s_%s_%s%s", image.ID, vc.Name, hex.EncodeToString(sum[:6]), processor.FormatExtension(format))
+		path, err := u.storage.SaveProcessed(ctx, filename, &buf, int64(buf.Len()))
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("variant", vc.Name).Msg("failed to save variant")
+			continue
+		}
+
+		width, height := processor.GetImageDimensions(out)
+		variants = append(variants, domain.ImageVariant{
+			Name:        vc.Name,
+			Path:        path,
+			Format:      processor.FormatName(format),
+			ContentType: processor.FormatContentType(format),
+			Width:       width,
+			Height:      height,
+		})
+	}
+
+	return variants
+}
+
+// storeVariants saves the variants rendered in this run as the image's
+// set. It runs before the image is marked completed, so a completed image
+// never lacks its variants; the files of the replaced ones are deleted
+// with deleteStaleVariants once the image is updated. When the set cannot
+// be saved, the image keeps its previous variants and the new files go.
+func (u *ProcessorUsecase) storeVariants(ctx context.Context, image *domain.Image, previous []domain.ImageVariant) {
+	if len(image.Variants) == 0 && len(previous) == 0 {
+		return
+	}
+	if err := u.imageVariants.Replace(ctx, image.ID, image.Variants); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to store image variants")
+		rendered := image.Variants
+		image.Variants = previous
+		u.deleteStaleVariants(ctx, image, rendered)
+	}
+}
+
+// deleteStaleVariants removes variant files from an earlier run that the
+// image no longer references.
+func (u *ProcessorUsecase) deleteStaleVariants(ctx context.Context, image *domain.Image, previous []domain.ImageVariant) {
+	current := make(map[string]bool, len(image.Variants))
+	for _, v := range image.Variants {
+		current[v.Path] = true
+	}
+
+	for _, v := range previous {
+		if current[v.Path] {
+			continue
+		}
+		if err := u.storage.Delete(ctx, v.Path); err != nil {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Str("path", v.Path).Msg("failed to delete previous variant")
+		}
+	}
+}
+
+// classifyFailure maps err to a failure category. Causes recognisable from
+// the error itself (deadlines, unknown formats, allocation failures) win over
+// fallback, which describes the step that failed.
+func classifyFailure(err error, fallback domain.FailureCategory) domain.FailureCategory {
+	switch {
+	case errors.Is(err, domain.ErrDecodeTimeout):
+		return domain.FailureDecodeTimeout
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return domain.FailureTimeout
+	case errors.Is(err, stdimage.ErrFormat), errors.Is(err, processor.ErrNoEncoder):
+		return domain.FailureUnsupportedFormat
+	case errors.Is(err, domain.ErrTooManyPixels):
+		return domain.FailureTooLarge
+	case strings.Contains(err.Error(), "out of memory"):
+		return domain.FailureOOM
+	default:
+		return fallback
+	}
+}
+
+// markFailed records a failed attempt. While attempts remain, the image is
+// scheduled for a delayed retry; after the last one it is dead-lettered.
+func (u *ProcessorUsecase) markFailed(ctx context.Context, image *domain.Image, category domain.FailureCategory, errMsg string) {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		u.markInterrupted(ctx, image)
+		return
+	}
+
+	image.FailureCategory = category
+	if delay, ok := u.retry.NextDelay(image.Attempts); ok && category.Retryable() {
+		image.MarkForRetry(errMsg, time.Now().Add(delay))
+		zlog.Logger.Warn().
+			Str("image_id", image.ID).
+			Int("attempt", image.Attempts).
+			Str("failure_category", string(category)).
+			Time("next_attempt_at", *image.NextAttemptAt).
+			Msg("processing failed, retry scheduled")
+	} else {
+		image.MarkAsDeadLettered(errMsg)
+		zlog.Logger.Error().
+			Str("image_id", image.ID).
+			Int("attempts", image.Attempts).
+			Str("failure_category", string(category)).
+			Msg("processing failed on last attempt, image dead-lettered")
+	}
+
+	if err := u.repo.Update(ctx, image); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to persist failed status")
+		return
+	}
+	u.syncJob(ctx, image)
+
+	if image.Status == domain.StatusDeadLettered {
+		u.notify(ctx, image)
+	}
+	u.callback(ctx, image)
+}
+
+// interruptedUpdateTimeout bounds the write that releases an interrupted
+// image or variant; the task context is already cancelled by then.
+const interruptedUpdateTimeout = 5 * time.Second
+
+// markInterrupted puts an image whose task was cancelled by a worker
+// shutdown back to pending instead of failing it. The task is left
+// uncommitted, and its redelivery would skip an image left in processing.
+func (u *ProcessorUsecase) markInterrupted(ctx context.Context, image *domain.Image) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), interruptedUpdateTimeout)
+	defer cancel()
+
+	image.MarkAsInterrupted()
+	if err := u.repo.Update(ctx, image); err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", image.ID).Msg("failed to release interrupted image")
+		return
+	}
+	u.syncJob(ctx, image)
+
+	zlog.Logger.Warn().Str("image_id", image.ID).Msg("processing interrupted by shutdown, image released to pending")
+}
+
+// syncJob mirrors the image status onto the job issued at upload. Images
+// uploaded before jobs existed have none, which is not an error.
+func (u *ProcessorUsecase) syncJob(ctx context.Context, image *domain.Image) {
+	job, err := u.jobs.FindLatestByImageID(ctx, image.ID)
+	if err != nil {
+		if !errors.Is(err, domain.ErrJobNotFound) {
+			zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to load job")
+		}
+		return
+	}
+
+	job.SyncWithImage(image)
+	if err := u.jobs.Update(ctx, job); err != nil {
+		zlog.Logger.Warn().Err(err).Str("job_id", job.ID).Str("image_id", image.ID).Msg("failed to update job state")
+	}
+}
+
+// notify reports a final status to the webhook, if one is configured.
+// Delivery is best effort and never fails the processing run.
+func (u *ProcessorUsecase) notify(ctx context.Context, image *domain.Image) {
+	if u.notifier == nil {
+		return
+	}
+	if err := u.notifier.NotifyProcessed(ctx, image); err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to deliver webhook")
+	}
+}
+
+// callback reports the status to the image's callback URL, if it was
+// uploaded with one. Like notify it never fails the processing run.
+func (u *ProcessorUsecase) callback(ctx context.Context, image *domain.Image) {
+	if u.callbacks == nil {
+		return
+	}
+	if err := u.callbacks.NotifyProcessed(ctx, image); err != nil {
+		zlog.Logger.Warn().Err(err).Str("image_id", image.ID).Msg("failed to queue callback")
+	}
+}
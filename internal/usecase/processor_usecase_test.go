@@ -0,0 +1,770 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+	_ "golang.org/x/image/webp"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      domain.FailureCategory
+		retryable bool
+	}{
+		{"decode timeout", fmt.Errorf("decode: %w", domain.ErrDecodeTimeout), domain.FailureDecodeTimeout, false},
+		{"task deadline", fmt.Errorf("process: %w", context.DeadlineExceeded), domain.FailureTimeout, true},
+		{"too many pixels", fmt.Errorf("decode: %w", domain.ErrTooManyPixels), domain.FailureTooLarge, false},
+		{"fallback", fmt.Errorf("upload failed"), domain.FailureStorage, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyFailure(tt.err, domain.FailureStorage)
+			if got != tt.want {
+				t.Fatalf("classifyFailure = %q, want %q", got, tt.want)
+			}
+			if got.Retryable() != tt.retryable {
+				t.Fatalf("%q.Retryable() = %v, want %v", got, got.Retryable(), tt.retryable)
+			}
+		})
+	}
+}
+
+// processorHarness runs ProcessImage against in-memory fakes.
+type processorHarness struct {
+	usecase  *ProcessorUsecase
+	repo     *fakeImageRepo
+	storage  *memStorage
+	variants *fakeImageVariantRepo
+	log      *eventLog
+}
+
+func newProcessorHarness(t *testing.T, cfg *config.ProcessingConfig) *processorHarness {
+	t.Helper()
+	log := &eventLog{}
+	h := &processorHarness{
+		repo:     &fakeImageRepo{images: make(map[string]*domain.Image), log: log},
+		storage:  newMemStorage(),
+		variants: &fakeImageVariantRepo{log: log},
+		log:      log,
+	}
+	h.usecase = NewProcessorUsecase(h.repo, fakeJobRepo{}, nil, h.variants, h.storage, processor.NewImageProcessor(cfg), domain.RetryPolicy{}, nil, nil)
+	return h
+}
+
+// addImage stores data as the original of a pending image.
+func (h *processorHarness) addImage(t *testing.T, id, filename string, pt domain.ProcessingType, data []byte) {
+	t.Helper()
+	path, err := h.storage.SaveOriginal(context.Background(), id+"_"+filename, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("save original: %v", err)
+	}
+	h.repo.images[id] = &domain.Image{
+		ID:               id,
+		OriginalFilename: filename,
+		OriginalPath:     path,
+		MimeType:         "image/jpeg",
+		Size:             int64(len(data)),
+		Status:           domain.StatusPending,
+		ProcessingType:   pt,
+		CreatedAt:        time.Now(),
+	}
+}
+
+func encodeJPEG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageStoresVariantsBeforeCompleting(t *testing.T) {
+	h := newProcessorHarness(t, &config.ProcessingConfig{
+		ResizeWidth:  64,
+		ResizeHeight: 64,
+		Variants:     []config.VariantConfig{{Name: "small", Width: 16, Height: 16, Format: "jpeg"}},
+	})
+	h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 128, 96))
+
+	if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+
+	stored := h.log.index("variants 1")
+	completed := h.log.index("image " + string(domain.StatusCompleted))
+	if stored < 0 || completed < 0 {
+		t.Fatalf("events %v lack the variant write or the completion", h.log.events)
+	}
+	if stored > completed {
+		t.Fatalf("variants stored after the image was completed: %v", h.log.events)
+	}
+	if ok, _ := h.storage.Exists(context.Background(), h.variants.stored[0].Path); !ok {
+		t.Fatalf("variant file %s is not stored", h.variants.stored[0].Path)
+	}
+}
+
+func TestProcessImageStoresEachVariantInItsFormat(t *testing.T) {
+	h := newProcessorHarness(t, &config.ProcessingConfig{
+		ResizeWidth:  64,
+		ResizeHeight: 64,
+		Variants: []config.VariantConfig{
+			{Name: "thumb", Width: 32, Height: 24, Format: "webp"},
+			{Name: "small", Width: 16, Height: 16, Format: "png"},
+			{Name: "preview", Width: 48, Height: 48, Format: "jpeg"},
+		},
+	})
+	h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 128, 96))
+
+	if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+
+	want := map[string]struct{ format, contentType, ext string }{
+		"thumb":   {"webp", "image/webp", ".webp"},
+		"small":   {"png", "image/png", ".png"},
+		"preview": {"jpeg", "image/jpeg", ".jpg"},
+	}
+	if len(h.variants.stored) != len(want) {
+		t.Fatalf("stored %d variants, want %d", len(h.variants.stored), len(want))
+	}
+	for _, v := range h.variants.stored {
+		w, ok := want[v.Name]
+		if !ok {
+			t.Fatalf("unexpected variant %q", v.Name)
+		}
+		if v.Format != w.format || v.ContentType != w.contentType || !strings.HasSuffix(v.Path, w.ext) {
+			t.Errorf("variant %s stored as %s (%s) at %s, want %s (%s) with %s", v.Name, v.Format, v.ContentType, v.Path, w.format, w.contentType, w.ext)
+		}
+
+		_, decoded, err := stdimage.DecodeConfig(bytes.NewReader(h.storage.objects[v.Path]))
+		if err != nil {
+			t.Fatalf("variant %s does not decode: %v", v.Name, err)
+		}
+		if decoded != w.format {
+			t.Errorf("variant %s decodes as %s, want %s", v.Name, decoded, w.format)
+		}
+	}
+}
+
+func TestProcessImageRepairsTruncatedJPEG(t *testing.T) {
+	full := encodeJPEG(t, 64, 48)
+	truncated := full[:len(full)*3/4]
+
+	for _, repair := range []bool{true, false} {
+		t.Run(fmt.Sprintf("repair=%v", repair), func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 32, ResizeHeight: 32, RepairCorruptImages: repair})
+			h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, truncated)
+
+			err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{})
+			image := h.repo.images["img-1"]
+			if !repair {
+				if err == nil || image.Status == domain.StatusCompleted {
+					t.Fatalf("without repair got err %v and status %s, want a failure", err, image.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			if image.Status != domain.StatusCompleted || !image.Repaired {
+				t.Fatalf("status %s, repaired %v; want a completed image marked repaired", image.Status, image.Repaired)
+			}
+		})
+	}
+}
+
+// encodeNoiseJPEG encodes w x h of random pixels, which JPEG compresses
+// poorly, at the given quality.
+func encodeNoiseJPEG(t *testing.T, w, h, quality int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := stdimage.NewNRGBA(stdimage.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.IntN(256))
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageFlagsPoorCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		original []byte
+		ratio    float64
+		wantFlag bool
+	}{
+		// a heavily compressed original, barely downscaled and
+		// re-encoded at quality 100, grows
+		{name: "poorly compressing", original: encodeNoiseJPEG(t, 128, 96, 20), ratio: 0.9, wantFlag: true},
+		{name: "check disabled", original: encodeNoiseJPEG(t, 128, 96, 20), ratio: 0},
+		// a high-quality original shrinks well at a quarter of its pixels
+		{name: "well compressing", original: encodeNoiseJPEG(t, 512, 384, 100), ratio: 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{
+				ResizeWidth:         120,
+				ResizeHeight:        120,
+				OutputQuality:       100,
+				MaxCompressionRatio: tt.ratio,
+			})
+			h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, tt.original)
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			image := h.repo.images["img-1"]
+			if image.Status != domain.StatusCompleted {
+				t.Fatalf("status = %s, want completed", image.Status)
+			}
+			if stored := int64(len(h.storage.objects[image.ProcessedPath])); image.ProcessedSize != stored {
+				t.Errorf("ProcessedSize = %d, want the stored %d bytes", image.ProcessedSize, stored)
+			}
+			if image.PoorCompression != tt.wantFlag {
+				t.Errorf("PoorCompression = %v, want %v (%d of %d bytes)", image.PoorCompression, tt.wantFlag, image.ProcessedSize, image.Size)
+			}
+		})
+	}
+}
+
+func TestProcessImageStoresLQIP(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64, LQIPEnabled: enabled})
+		h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 128, 96))
+
+		if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+			t.Fatalf("ProcessImage: %v", err)
+		}
+		image := h.repo.images["img-1"]
+		if !enabled {
+			if image.LQIP != "" {
+				t.Fatalf("LQIP %q stored while disabled", image.LQIP)
+			}
+			continue
+		}
+		if !strings.HasPrefix(image.LQIP, "data:image/jpeg;base64,") {
+			t.Fatalf("LQIP = %.40q..., want a JPEG data URI", image.LQIP)
+		}
+		if resp := dto.MapImageToResponse(image, "http://api"); resp.LQIP != image.LQIP {
+			t.Errorf("response lqip = %.40q..., want the stored one", resp.LQIP)
+		}
+	}
+}
+
+// withExifText puts an Exif APP1 segment holding the given ASCII tags in
+// front of an encoded JPEG.
+func withExifText(data []byte, tags map[uint16]string) []byte {
+	ids := make([]uint16, 0, len(tags))
+	for tag := range tags {
+		ids = append(ids, tag)
+	}
+	slices.Sort(ids)
+
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(ids)))
+	valueOffset := len(tiff) + 12*len(ids) + 4
+	var values []byte
+	for _, tag := range ids {
+		value := append([]byte(tags[tag]), 0)
+		tiff = binary.BigEndian.AppendUint16(tiff, tag)
+		tiff = binary.BigEndian.AppendUint16(tiff, 2) // ASCII
+		tiff = binary.BigEndian.AppendUint32(tiff, uint32(len(value)))
+		if len(value) <= 4 {
+			tiff = append(tiff, append(value, make([]byte, 4-len(value))...)...)
+			continue
+		}
+		tiff = binary.BigEndian.AppendUint32(tiff, uint32(valueOffset+len(values)))
+		values = append(values, value...)
+	}
+	tiff = append(tiff, 0, 0, 0, 0)
+	tiff = append(tiff, values...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+// cameraExif is what a phone might write: camera, owner and timestamp.
+var cameraExif = map[uint16]string{
+	0x010F: "Acme",                // Make
+	0x0110: "Phone 9 Pro",         // Model
+	0x0132: "2024:05:01 12:00:00", // DateTime
+	0x013B: "Jane Doe",            // Artist
+	0x8298: "(c) 2024 Jane Doe",   // Copyright
+}
+
+func TestProcessImageStripsMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.ProcessingConfig
+		strip bool
+		// wantOriginal is set when the original is served as-is
+		wantOriginal bool
+	}{
+		{name: "re-encoded", cfg: config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64}, strip: true},
+		{name: "within bounds", cfg: config.ProcessingConfig{ResizeWidth: 400, ResizeHeight: 400, SkipWithinBounds: true}, strip: true},
+		{name: "within bounds, stripping off", cfg: config.ProcessingConfig{ResizeWidth: 400, ResizeHeight: 400, SkipWithinBounds: true}, wantOriginal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.StripMetadata = tt.strip
+			h := newProcessorHarness(t, &cfg)
+			original := withExifText(encodeJPEG(t, 128, 96), cameraExif)
+			if !processor.HasMetadata(original) {
+				t.Fatal("test original carries no metadata")
+			}
+			h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, original)
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			image := h.repo.images["img-1"]
+			if image.Status != domain.StatusCompleted {
+				t.Fatalf("status = %s, want completed", image.Status)
+			}
+			if served := image.ProcessedPath == image.OriginalPath; served != tt.wantOriginal {
+				t.Fatalf("original served as-is = %v, want %v", served, tt.wantOriginal)
+			}
+			if tt.wantOriginal {
+				return
+			}
+
+			processed := h.storage.objects[image.ProcessedPath]
+			if processor.HasMetadata(processed) {
+				t.Error("processed image still carries metadata")
+			}
+			for _, value := range cameraExif {
+				if bytes.Contains(processed, []byte(value)) {
+					t.Errorf("processed image contains %q", value)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessImageRecordsDuration(t *testing.T) {
+	// an original served as-is finishes well within a millisecond
+	for _, skip := range []bool{false, true} {
+		h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 400, ResizeHeight: 400, SkipWithinBounds: skip})
+		h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 32, 32))
+
+		if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+			t.Fatalf("ProcessImage: %v", err)
+		}
+		if image := h.repo.images["img-1"]; image.ProcessingDurationMs <= 0 {
+			t.Errorf("skip_within_bounds %v: ProcessingDurationMs = %d, want a recorded duration", skip, image.ProcessingDurationMs)
+		}
+	}
+}
+
+func TestProcessImagePreservesCopyright(t *testing.T) {
+	for _, format := range []string{"jpeg", "png"} {
+		t.Run(format, func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{
+				ResizeWidth:      64,
+				ResizeHeight:     64,
+				OutputFormat:     format,
+				StripMetadata:    true,
+				PreserveMetadata: []string{"copyright"},
+			})
+			h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, withExifText(encodeJPEG(t, 128, 96), cameraExif))
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			image := h.repo.images["img-1"]
+			if image.Status != domain.StatusCompleted {
+				t.Fatalf("status = %s, want completed", image.Status)
+			}
+			processed := h.storage.objects[image.ProcessedPath]
+			if _, _, err := stdimage.Decode(bytes.NewReader(processed)); err != nil {
+				t.Fatalf("processed image does not decode: %v", err)
+			}
+
+			copyright := processor.PreservedExif(processed, []string{"copyright"})
+			if !bytes.Contains(copyright, []byte(cameraExif[0x8298]+"\x00")) {
+				t.Errorf("copyright did not survive processing: %q", copyright)
+			}
+			if others := processor.PreservedExif(processed, []string{"make", "model", "datetime", "artist"}); others != nil {
+				t.Errorf("unlisted tags survived processing: %q", others)
+			}
+		})
+	}
+}
+
+func TestProcessImageUsesOriginalWithinBounds(t *testing.T) {
+	side := func(n int) *int { return &n }
+	tests := []struct {
+		name          string
+		cfg           config.ProcessingConfig
+		pt            domain.ProcessingType
+		opts          domain.ProcessingOptions
+		wantOriginal  bool
+		width, height int
+	}{
+		{name: "already small", cfg: config.ProcessingConfig{ResizeWidth: 400, ResizeHeight: 400, SkipWithinBounds: true}, pt: domain.ProcessingResize, wantOriginal: true, width: 40, height: 20},
+		{name: "larger than the target", cfg: config.ProcessingConfig{ResizeWidth: 20, ResizeHeight: 20, SkipWithinBounds: true}, pt: domain.ProcessingResize, width: 20, height: 10},
+		{name: "skipping off", cfg: config.ProcessingConfig{ResizeWidth: 400, ResizeHeight: 400}, pt: domain.ProcessingResize, width: 40, height: 20},
+		{name: "padded to a requested box", cfg: config.ProcessingConfig{ThumbnailWidth: 60, ThumbnailHeight: 60, ThumbnailPadColor: "#ffffff", SkipWithinBounds: true},
+			pt: domain.ProcessingThumbnail, opts: domain.ProcessingOptions{Width: side(80), Height: side(80)}, width: 80, height: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProcessorHarness(t, &tt.cfg)
+			h.addImage(t, "img-1", "photo.jpg", tt.pt, encodeJPEG(t, 40, 20))
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", tt.opts); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+			image := h.repo.images["img-1"]
+			if image.Status != domain.StatusCompleted {
+				t.Fatalf("status = %s, want completed", image.Status)
+			}
+			if image.Width != tt.width || image.Height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", image.Width, image.Height, tt.width, tt.height)
+			}
+
+			served := image.ProcessedPath == image.OriginalPath
+			if served != tt.wantOriginal {
+				t.Fatalf("original served as-is = %v, want %v", served, tt.wantOriginal)
+			}
+			if !tt.wantOriginal {
+				return
+			}
+			// nothing is re-encoded or stored next to the original
+			for path := range h.storage.objects {
+				if path != image.OriginalPath {
+					t.Errorf("stored %s for an original used as-is", path)
+				}
+			}
+			if image.ProcessedSize != image.Size {
+				t.Errorf("ProcessedSize = %d, want the original's %d", image.ProcessedSize, image.Size)
+			}
+		})
+	}
+}
+
+// lockstepRepo holds the first n lookups until all n have arrived, so
+// that many runs start from the same stored image.
+type lockstepRepo struct {
+	*fakeImageRepo
+	arrived sync.WaitGroup
+}
+
+func (r *lockstepRepo) FindByID(ctx context.Context, id string) (*domain.Image, error) {
+	image, err := r.fakeImageRepo.FindByID(ctx, id)
+	r.arrived.Done()
+	r.arrived.Wait()
+	return image, err
+}
+
+func TestConcurrentReprocessLeavesNoOrphanedOutput(t *testing.T) {
+	h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64})
+	h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 128, 96))
+	// the output of an earlier run, now being reprocessed
+	stale, _ := h.storage.SaveProcessed(context.Background(), "img-1_resize_stale.jpg", bytes.NewReader(encodeJPEG(t, 64, 48)), 0)
+	h.repo.images["img-1"].ProcessedPath = stale
+
+	repo := &lockstepRepo{fakeImageRepo: h.repo}
+	repo.arrived.Add(2)
+	u := NewProcessorUsecase(repo, fakeJobRepo{}, nil, h.variants, h.storage, processor.NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64}), domain.RetryPolicy{}, nil, nil)
+
+	// different sizes, so the two runs store different files
+	var wg sync.WaitGroup
+	for _, width := range []int{32, 48} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := u.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{Width: &width}); err != nil {
+				t.Errorf("ProcessImage width %d: %v", width, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	image := h.repo.images["img-1"]
+	if image.Status != domain.StatusCompleted {
+		t.Fatalf("status = %s, want completed", image.Status)
+	}
+	var processed []string
+	for path := range h.storage.objects {
+		if path != image.OriginalPath {
+			processed = append(processed, path)
+		}
+	}
+	if len(processed) != 1 || processed[0] != image.ProcessedPath {
+		t.Fatalf("processed files %v, want only the recorded %s", processed, image.ProcessedPath)
+	}
+	img, _, err := stdimage.Decode(bytes.NewReader(h.storage.objects[image.ProcessedPath]))
+	if err != nil {
+		t.Fatalf("recorded output does not decode: %v", err)
+	}
+	if img.Bounds().Dx() != image.Width {
+		t.Errorf("recorded output is %dpx wide, record says %d", img.Bounds().Dx(), image.Width)
+	}
+}
+
+// failingSaves is storage whose processed writes fail.
+type failingSaves struct {
+	*memStorage
+}
+
+func (failingSaves) SaveProcessed(ctx context.Context, filename string, reader io.Reader, size int64) (string, error) {
+	return "", errors.New("bucket unavailable")
+}
+
+func TestProcessImageFailureCategories(t *testing.T) {
+	jpg := encodeJPEG(t, 128, 96)
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name     string
+		original []byte
+		// setup breaks the harness in the way under test
+		setup      func(h *processorHarness)
+		ctx        context.Context
+		attempts   int // made before this one
+		want       domain.FailureCategory
+		wantStatus domain.ProcessingStatus
+	}{
+		{name: "corrupt image", original: jpg[:len(jpg)/2], want: domain.FailureDecode, wantStatus: domain.StatusFailed},
+		{name: "not an image", original: []byte("plain text, not pixels"), want: domain.FailureUnsupportedFormat, wantStatus: domain.StatusFailed},
+		{name: "original missing", original: jpg, setup: func(h *processorHarness) {
+			h.storage.objects = map[string][]byte{}
+		}, want: domain.FailureStorage, wantStatus: domain.StatusFailed},
+		{name: "save fails", original: jpg, setup: func(h *processorHarness) {
+			h.usecase.storage = failingSaves{h.storage}
+		}, want: domain.FailureStorage, wantStatus: domain.StatusFailed},
+		{name: "task deadline", original: jpg, ctx: expired, want: domain.FailureTimeout, wantStatus: domain.StatusFailed},
+		{name: "too many pixels", original: jpg, setup: func(h *processorHarness) {
+			h.usecase.processor = processor.NewImageProcessor(&config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64, MaxPixels: 1000})
+		}, want: domain.FailureTooLarge, wantStatus: domain.StatusDeadLettered},
+		{name: "corrupt image on the last attempt", original: jpg[:len(jpg)/2], attempts: 2, want: domain.FailureDecode, wantStatus: domain.StatusDeadLettered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64})
+			h.usecase.retry = domain.RetryPolicy{MaxAttempts: 3, Delays: []time.Duration{time.Minute}}
+			h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, tt.original)
+			h.repo.images["img-1"].Attempts = tt.attempts
+			if tt.setup != nil {
+				tt.setup(h)
+			}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			if err := h.usecase.ProcessImage(ctx, "img-1", domain.ProcessingOptions{}); err == nil {
+				t.Fatal("ProcessImage succeeded")
+			}
+			image := h.repo.images["img-1"]
+			if image.FailureCategory != tt.want {
+				t.Errorf("failure_category = %q, want %q", image.FailureCategory, tt.want)
+			}
+			if image.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", image.Status, tt.wantStatus)
+			}
+			if retry := image.NextAttemptAt != nil; retry != (tt.wantStatus == domain.StatusFailed) {
+				t.Errorf("retry scheduled = %v for status %s", retry, image.Status)
+			}
+			if image.ErrorMessage == "" {
+				t.Error("error_message is empty")
+			}
+		})
+	}
+}
+
+func TestProcessImageFlattenOverride(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		configured bool
+		flatten    *bool
+		wantOpaque bool
+	}{
+		{"png keeps alpha by default", true, nil, false},
+		{"request flattens", false, &yes, true},
+		{"request keeps alpha", true, &no, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 32, ResizeHeight: 32, OutputFormat: "png", FlattenAlpha: tt.configured})
+			h.addImage(t, "img-1", "logo.png", domain.ProcessingResize, encodePNG(t, 64, 64))
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{Flatten: tt.flatten}); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+
+			img, format, err := stdimage.Decode(bytes.NewReader(h.storage.objects[h.repo.images["img-1"].ProcessedPath]))
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+			if format != "png" {
+				t.Fatalf("output is %s, want png", format)
+			}
+			r, g, b, a := img.At(0, 0).RGBA()
+			if opaque := a == 0xffff; opaque != tt.wantOpaque {
+				t.Fatalf("corner is %d,%d,%d alpha %d, want opaque %v", r>>8, g>>8, b>>8, a>>8, tt.wantOpaque)
+			}
+			if tt.wantOpaque && (r>>8 != 0xff || g>>8 != 0xff || b>>8 != 0xff) {
+				t.Errorf("flattened corner is %d,%d,%d, want white", r>>8, g>>8, b>>8)
+			}
+		})
+	}
+}
+
+func TestProcessImageFlattenDefaultAppliesToJPEG(t *testing.T) {
+	for _, configured := range []bool{true, false} {
+		t.Run(fmt.Sprintf("flatten_alpha=%v", configured), func(t *testing.T) {
+			h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 32, ResizeHeight: 32, FlattenAlpha: configured})
+			h.addImage(t, "img-1", "logo.png", domain.ProcessingResize, encodePNG(t, 64, 64))
+
+			if err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{}); err != nil {
+				t.Fatalf("ProcessImage: %v", err)
+			}
+
+			img, err := jpeg.Decode(bytes.NewReader(h.storage.objects[h.repo.images["img-1"].ProcessedPath]))
+			if err != nil {
+				t.Fatalf("decode jpeg output: %v", err)
+			}
+			// transparent black comes out white when flattened, black otherwise
+			if r, _, _, _ := img.At(0, 0).RGBA(); (r>>8 > 0x80) != configured {
+				t.Errorf("jpeg corner red is %d, want flattened %v", r>>8, configured)
+			}
+		})
+	}
+}
+
+func TestReprocessReusesUploadOptions(t *testing.T) {
+	cfg := &config.ProcessingConfig{ResizeWidth: 64, ResizeHeight: 64}
+	u, repo, store, _ := newUploadUsecase(cfg)
+	data := encodeJPEG(t, 160, 120)
+	width := 40
+	image, err := u.UploadImage(context.Background(), "photo.jpg", "image/jpeg", int64(len(data)), bytes.NewReader(data),
+		domain.ProcessingResize, domain.ProcessingOptions{Width: &width}, "")
+	if err != nil {
+		t.Fatalf("UploadImage: %v", err)
+	}
+	processing := NewProcessorUsecase(repo, fakeJobRepo{}, nil, &fakeImageVariantRepo{log: repo.log}, store, processor.NewImageProcessor(cfg), domain.RetryPolicy{}, nil, nil)
+
+	outputSize := func() (int, int) {
+		t.Helper()
+		cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(store.objects[repo.images[image.ID].ProcessedPath]))
+		if err != nil {
+			t.Fatalf("decode output: %v", err)
+		}
+		return cfg.Width, cfg.Height
+	}
+
+	// the task of a reprocess carries no options of its own
+	for run := 1; run <= 2; run++ {
+		if run > 1 {
+			// picked up again the way a retry of a failed run is
+			repo.images[image.ID].Status = domain.StatusFailed
+		}
+		if err := processing.ProcessImage(context.Background(), image.ID, domain.ProcessingOptions{}); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if w, h := outputSize(); w != 40 || h != 30 {
+			t.Fatalf("run %d produced %dx%d, want the stored options' 40x30", run, w, h)
+		}
+	}
+
+	// options given with the task still take precedence
+	override := 20
+	repo.images[image.ID].Status = domain.StatusFailed
+	if err := processing.ProcessImage(context.Background(), image.ID, domain.ProcessingOptions{Width: &override}); err != nil {
+		t.Fatalf("override run: %v", err)
+	}
+	if w, h := outputSize(); w != 20 || h != 15 {
+		t.Fatalf("override produced %dx%d, want 20x15", w, h)
+	}
+}
+
+func TestRejectTaskFailsImageWithoutRetry(t *testing.T) {
+	for _, deadLetter := range []bool{false, true} {
+		h := newProcessorHarness(t, &config.ProcessingConfig{})
+		h.addImage(t, "img-1", "photo.jpg", "sepia", encodeJPEG(t, 8, 8))
+
+		if err := h.usecase.RejectTask(context.Background(), "img-1", `unsupported processing type "sepia"`, deadLetter); err != nil {
+			t.Fatalf("RejectTask: %v", err)
+		}
+
+		image := h.repo.images["img-1"]
+		want := domain.StatusFailed
+		if deadLetter {
+			want = domain.StatusDeadLettered
+		}
+		if image.Status != want || image.NextAttemptAt != nil || image.ErrorMessage == "" {
+			t.Fatalf("image = status %s, next attempt %v, error %q; want %s with no retry", image.Status, image.NextAttemptAt, image.ErrorMessage, want)
+		}
+	}
+}
+
+func TestRejectTaskLeavesProcessedImageAlone(t *testing.T) {
+	h := newProcessorHarness(t, &config.ProcessingConfig{})
+	h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 8, 8))
+	h.repo.images["img-1"].MarkAsCompleted("processed/img-1.jpg", 8, 8)
+
+	if err := h.usecase.RejectTask(context.Background(), "img-1", "stale task", false); err != nil {
+		t.Fatalf("RejectTask: %v", err)
+	}
+	if got := h.repo.images["img-1"].Status; got != domain.StatusCompleted {
+		t.Fatalf("status = %s, want the completed result kept", got)
+	}
+}
+
+func TestProcessImageRejectsDegenerateOutput(t *testing.T) {
+	h := newProcessorHarness(t, &config.ProcessingConfig{ResizeWidth: 32, ResizeHeight: 32, MinOutputBytes: 1 << 20})
+	h.addImage(t, "img-1", "photo.jpg", domain.ProcessingResize, encodeJPEG(t, 64, 64))
+	before := len(h.storage.objects)
+
+	err := h.usecase.ProcessImage(context.Background(), "img-1", domain.ProcessingOptions{})
+	if !errors.Is(err, processor.ErrDegenerateOutput) {
+		t.Fatalf("ProcessImage = %v, want ErrDegenerateOutput", err)
+	}
+
+	image := h.repo.images["img-1"]
+	if image.Status == domain.StatusCompleted || image.ProcessedPath != "" || image.ErrorMessage == "" {
+		t.Fatalf("image = status %s, processed %q, error %q; want it failed with the reason", image.Status, image.ProcessedPath, image.ErrorMessage)
+	}
+	if len(h.storage.objects) != before {
+		t.Fatal("rejected output was stored")
+	}
+}
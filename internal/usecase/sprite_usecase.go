@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+// SpriteUsecase packs uploaded images into a sprite sheet synchronously;
+// like previews, nothing is stored.
+type SpriteUsecase struct {
+	maxPixels int64
+}
+
+func NewSpriteUsecase(maxPixels int64) *SpriteUsecase {
+	return &SpriteUsecase{maxPixels: maxPixels}
+}
+
+func (u *SpriteUsecase) BuildSprite(ctx context.Context, inputs []domain.SpriteInput, w io.Writer) (*domain.SpriteAtlas, error) {
+	imgs := make([]image.Image, 0, len(inputs))
+	sizes := make([]image.Point, 0, len(inputs))
+
+	for _, in := range inputs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// reject oversized inputs from the header, before their pixels are
+		// allocated; the check holds whatever the EXIF orientation
+		var header bytes.Buffer
+		cfg, _, err := image.DecodeConfig(io.TeeReader(in.Reader, &header))
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("sprite", in.Name).Msg("failed to read sprite input header")
+			return nil, fmt.Errorf("%w: %s: %w", domain.ErrInvalidImageData, in.Name, err)
+		}
+		if cfg.Width > processor.MaxSpriteSide || cfg.Height > processor.MaxSpriteSide {
+			return nil, fmt.Errorf("%w: %s is %dx%d", domain.ErrSpriteTooLarge, in.Name, cfg.Width, cfg.Height)
+		}
+
+		img, err := processor.DecodeLimit(io.MultiReader(&header, in.Reader), 1, u.maxPixels)
+		if err != nil {
+			zlog.Logger.Warn().Err(err).Str("sprite", in.Name).Msg("failed to decode sprite input")
+			return nil, fmt.Errorf("%w: %s: %w", domain.ErrInvalidImageData, in.Name, err)
+		}
+
+		b := img.Bounds()
+
+		imgs = append(imgs, img)
+		sizes = append(sizes, image.Pt(b.Dx(), b.Dy()))
+	}
+
+	rects, size, err := processor.PackSprites(sizes)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sheet := processor.ComposeSprite(imgs, rects, size)
+
+	atlas := &domain.SpriteAtlas{
+		Width:  size.X,
+		Height: size.Y,
+		Frames: make([]domain.SpriteFrame, len(inputs)),
+	}
+	for i, r := range rects {
+		atlas.Frames[i] = domain.SpriteFrame{
+			Name:   inputs[i].Name,
+			X:      r.Min.X,
+			Y:      r.Min.Y,
+			Width:  r.Dx(),
+			Height: r.Dy(),
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := processor.Encode(bw, sheet, imaging.PNG, processor.EncodeOptions{}); err != nil {
+		return nil, fmt.Errorf("encode sprite sheet: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("write sprite sheet: %w", err)
+	}
+
+	zlog.Logger.Info().
+		Int("sprites", len(inputs)).
+		Int("width", size.X).
+		Int("height", size.Y).
+		Msg("sprite sheet built")
+
+	return atlas, nil
+}
@@ -0,0 +1,71 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildSpriteRejectsTooManyPixels(t *testing.T) {
+	u := NewSpriteUsecase(100)
+	inputs := []domain.SpriteInput{
+		{Name: "small.png", Reader: bytes.NewReader(encodePNG(t, 8, 8))},
+		{Name: "large.png", Reader: bytes.NewReader(encodePNG(t, 20, 20))},
+	}
+
+	var sheet bytes.Buffer
+	_, err := u.BuildSprite(context.Background(), inputs, &sheet)
+	if !errors.Is(err, domain.ErrTooManyPixels) {
+		t.Fatalf("err = %v, want ErrTooManyPixels", err)
+	}
+	if !errors.Is(err, domain.ErrInvalidImageData) {
+		t.Fatalf("err = %v, want it to also match ErrInvalidImageData", err)
+	}
+}
+
+func TestBuildSpriteWithinLimit(t *testing.T) {
+	u := NewSpriteUsecase(100)
+	inputs := []domain.SpriteInput{
+		{Name: "a.png", Reader: bytes.NewReader(encodePNG(t, 8, 8))},
+		{Name: "b.png", Reader: bytes.NewReader(encodePNG(t, 4, 6))},
+	}
+
+	var sheet bytes.Buffer
+	atlas, err := u.BuildSprite(context.Background(), inputs, &sheet)
+	if err != nil {
+		t.Fatalf("BuildSprite: %v", err)
+	}
+	if len(atlas.Frames) != 2 || sheet.Len() == 0 {
+		t.Fatalf("got %d frames and %d bytes, want 2 frames and a sheet", len(atlas.Frames), sheet.Len())
+	}
+}
+
+func TestBuildSpriteRejectsOversizedSideFromHeader(t *testing.T) {
+	// no pixel limit, so only the header side check can reject it
+	u := NewSpriteUsecase(0)
+	inputs := []domain.SpriteInput{
+		{Name: "a.png", Reader: bytes.NewReader(encodePNG(t, 8, 8))},
+		{Name: "wide.png", Reader: bytes.NewReader(encodePNG(t, processor.MaxSpriteSide+1, 1))},
+	}
+
+	var sheet bytes.Buffer
+	_, err := u.BuildSprite(context.Background(), inputs, &sheet)
+	if !errors.Is(err, domain.ErrSpriteTooLarge) {
+		t.Fatalf("err = %v, want ErrSpriteTooLarge", err)
+	}
+}
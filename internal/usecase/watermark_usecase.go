@@ -0,0 +1,85 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+// WatermarkUsecase stamps per-request text (e.g. the downloader's identity)
+// onto an image at download time, for traceable distribution.
+type WatermarkUsecase struct {
+	repo      domain.ImageRepository
+	storage   storage.Storage
+	processor *processor.ImageProcessor
+	template  string
+}
+
+func NewWatermarkUsecase(
+	repo domain.ImageRepository,
+	storage storage.Storage,
+	processor *processor.ImageProcessor,
+	template string,
+) *WatermarkUsecase {
+	return &WatermarkUsecase{
+		repo:      repo,
+		storage:   storage,
+		processor: processor,
+		template:  template,
+	}
+}
+
+func (u *WatermarkUsecase) GetWatermarked(ctx context.Context, id string, user string) (*bytes.Buffer, string, error) {
+	image, err := findImage(ctx, u.repo, id)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx = storage.WithBackend(ctx, image.StorageBackend)
+
+	// prefer the processed output, fall back to the original while pending
+	path := image.OriginalPath
+	if image.IsProcessed() {
+		path = image.ProcessedPath
+	}
+
+	file, err := u.storage.GetOriginal(ctx, path)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Str("image_id", id).Str("path", path).Msg("failed to open image for watermarking")
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			return nil, "", domain.ErrImageNotFound
+		}
+		return nil, "", err
+	}
+	defer file.Close()
+
+	img, err := processor.DecodeLimit(file, 1, u.processor.MaxPixels())
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", domain.ErrInvalidImageData, err)
+	}
+
+	text := processor.ResolveWatermarkTemplate(u.template, user, image.ID, time.Now())
+	marked, err := u.processor.WatermarkText(img, text)
+	if err != nil {
+		return nil, "", fmt.Errorf("watermark image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := processor.Encode(&buf, marked, imaging.JPEG, processor.EncodeOptions{Quality: u.processor.OutputQuality(), Flatten: true}); err != nil {
+		return nil, "", fmt.Errorf("encode image: %w", err)
+	}
+
+	zlog.Logger.Info().Str("image_id", id).Str("watermark", text).Msg("served watermarked image")
+
+	baseName := strings.TrimSuffix(image.OriginalFilename, filepath.Ext(image.OriginalFilename))
+	return &buf, baseName + "_watermarked.jpg", nil
+}
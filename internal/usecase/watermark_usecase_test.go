@@ -0,0 +1,42 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/yokitheyo/imageprocessor/internal/config"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/processor"
+)
+
+func TestGetWatermarkedUsesOutputQuality(t *testing.T) {
+	data := encodeJPEG(t, 200, 150)
+	id := uuid.NewString()
+	sizes := make(map[int]int)
+	for _, quality := range []int{10, 95} {
+		repo := &fakeImageRepo{images: make(map[string]*domain.Image), log: &eventLog{}}
+		store := newMemStorage()
+		path, err := store.SaveOriginal(context.Background(), "a.jpg", bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("save original: %v", err)
+		}
+		repo.images[id] = &domain.Image{ID: id, OriginalFilename: "photo.jpg", OriginalPath: path, Status: domain.StatusPending}
+
+		cfg := &config.ProcessingConfig{OutputQuality: quality}
+		u := NewWatermarkUsecase(repo, store, processor.NewImageProcessor(cfg), "{user}")
+		buf, name, err := u.GetWatermarked(context.Background(), id, "alice")
+		if err != nil {
+			t.Fatalf("GetWatermarked at quality %d: %v", quality, err)
+		}
+		if name != "photo_watermarked.jpg" {
+			t.Fatalf("filename = %q", name)
+		}
+		sizes[quality] = buf.Len()
+	}
+
+	if sizes[10] >= sizes[95] {
+		t.Fatalf("quality 10 gave %d bytes, quality 95 %d; want the configured quality to shrink the output", sizes[10], sizes[95])
+	}
+}
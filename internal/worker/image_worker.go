@@ -11,26 +11,44 @@ import (
 
 // ImageWorker обрабатывает задачи из очереди
 type ImageWorker struct {
-	processorService domain.ProcessorService
+	processorService  domain.ProcessorService
+	deadLetterUnknown bool
 }
 
-// NewImageWorker создает нового воркера
-func NewImageWorker(processorService domain.ProcessorService) *ImageWorker {
+// NewImageWorker создает нового воркера.
+// deadLetterUnknown: задачи с неизвестным типом обработки переводят
+// изображение в dead_lettered вместо failed
+func NewImageWorker(processorService domain.ProcessorService, deadLetterUnknown bool) *ImageWorker {
 	return &ImageWorker{
-		processorService: processorService,
+		processorService:  processorService,
+		deadLetterUnknown: deadLetterUnknown,
 	}
 }
 
 func (w *ImageWorker) HandleProcessingTask(ctx context.Context, task *dto.ProcessImageRequest) error {
-	// Проверка валидности ProcessingType
-	if task.ProcessingType != string(domain.ProcessingResize) &&
-		task.ProcessingType != string(domain.ProcessingThumbnail) &&
-		task.ProcessingType != string(domain.ProcessingWatermark) {
+	if task.VariantID != "" {
+		return w.handleVariantTask(ctx, task)
+	}
+
+	// Проверка валидности ProcessingType.
+	// Неизвестный тип (например, из старых сообщений после смены enum) не
+	// исправится повторной попыткой: помечаем изображение и возвращаем nil,
+	// чтобы сообщение было закоммичено, а не перечитывалось бесконечно
+	if !domain.ProcessingType(task.ProcessingType).IsValid() {
 		zlog.Logger.Error().
 			Str("image_id", task.ImageID).
 			Str("processing_type", task.ProcessingType).
-			Msg("invalid processing type")
-		return fmt.Errorf("invalid processing type: %s", task.ProcessingType)
+			Bool("dead_letter", w.deadLetterUnknown).
+			Msg("invalid processing type, dropping task")
+
+		reason := fmt.Sprintf("unsupported processing type %q", task.ProcessingType)
+		if err := w.processorService.RejectTask(ctx, task.ImageID, reason, w.deadLetterUnknown); err != nil {
+			zlog.Logger.Warn().
+				Err(err).
+				Str("image_id", task.ImageID).
+				Msg("failed to mark image for rejected task")
+		}
+		return nil
 	}
 
 	zlog.Logger.Info().
@@ -39,7 +57,7 @@ func (w *ImageWorker) HandleProcessingTask(ctx context.Context, task *dto.Proces
 		Msg("starting image processing task")
 
 	// Вызов usecase, который уже обрабатывает и сохраняет изображение
-	if err := w.processorService.ProcessImage(ctx, task.ImageID); err != nil {
+	if err := w.processorService.ProcessImage(ctx, task.ImageID, task.ToProcessingOptions()); err != nil {
 		zlog.Logger.Error().
 			Err(err).
 			Str("image_id", task.ImageID).
@@ -54,3 +72,25 @@ func (w *ImageWorker) HandleProcessingTask(ctx context.Context, task *dto.Proces
 
 	return nil
 }
+
+// handleVariantTask обрабатывает именованный вариант изображения.
+// Тип и опции берутся из записи варианта, ошибка обработки фиксируется
+// в самом варианте, поэтому сообщение коммитится и не перечитывается
+func (w *ImageWorker) handleVariantTask(ctx context.Context, task *dto.ProcessImageRequest) error {
+	zlog.Logger.Info().
+		Str("image_id", task.ImageID).
+		Str("variant_id", task.VariantID).
+		Str("processing_type", task.ProcessingType).
+		Msg("starting variant processing task")
+
+	if err := w.processorService.ProcessVariant(ctx, task.VariantID); err != nil {
+		zlog.Logger.Error().
+			Err(err).
+			Str("image_id", task.ImageID).
+			Str("variant_id", task.VariantID).
+			Msg("failed to process variant")
+		return fmt.Errorf("process variant %s: %w", task.VariantID, err)
+	}
+
+	return nil
+}
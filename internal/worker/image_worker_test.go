@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// fakeProcessorService records which tasks were processed and which rejected.
+type fakeProcessorService struct {
+	domain.ProcessorService
+	processed  []string
+	rejected   []string
+	deadLetter bool
+	rejectErr  error
+}
+
+func (s *fakeProcessorService) ProcessImage(ctx context.Context, imageID string, opts domain.ProcessingOptions) error {
+	s.processed = append(s.processed, imageID)
+	return nil
+}
+
+func (s *fakeProcessorService) RejectTask(ctx context.Context, imageID string, reason string, deadLetter bool) error {
+	s.rejected = append(s.rejected, imageID)
+	s.deadLetter = deadLetter
+	return s.rejectErr
+}
+
+func TestUnknownProcessingTypeIsCommittedAndFailsTheImage(t *testing.T) {
+	for _, deadLetter := range []bool{false, true} {
+		service := &fakeProcessorService{}
+		w := NewImageWorker(service, deadLetter)
+
+		err := w.HandleProcessingTask(context.Background(), &dto.ProcessImageRequest{ImageID: "img-1", ProcessingType: "sepia"})
+		if err != nil {
+			t.Fatalf("HandleProcessingTask = %v; an error leaves the message to be redelivered forever", err)
+		}
+		if len(service.processed) != 0 {
+			t.Fatalf("processed %v for an unknown type", service.processed)
+		}
+		if len(service.rejected) != 1 || service.rejected[0] != "img-1" || service.deadLetter != deadLetter {
+			t.Fatalf("rejected %v with dead letter %v, want img-1 with %v", service.rejected, service.deadLetter, deadLetter)
+		}
+	}
+}
+
+func TestUnknownProcessingTypeCommittedWhenRejectFails(t *testing.T) {
+	service := &fakeProcessorService{rejectErr: errors.New("db down")}
+	w := NewImageWorker(service, false)
+
+	if err := w.HandleProcessingTask(context.Background(), &dto.ProcessImageRequest{ImageID: "img-1", ProcessingType: "sepia"}); err != nil {
+		t.Fatalf("HandleProcessingTask = %v, want nil", err)
+	}
+}
+
+func TestKnownProcessingTypeIsProcessed(t *testing.T) {
+	service := &fakeProcessorService{}
+	w := NewImageWorker(service, false)
+
+	if err := w.HandleProcessingTask(context.Background(), &dto.ProcessImageRequest{ImageID: "img-1", ProcessingType: string(domain.ProcessingResize)}); err != nil {
+		t.Fatalf("HandleProcessingTask: %v", err)
+	}
+	if len(service.processed) != 1 || len(service.rejected) != 0 {
+		t.Fatalf("processed %v, rejected %v; want img-1 processed", service.processed, service.rejected)
+	}
+}
@@ -0,0 +1,106 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+	"github.com/yokitheyo/imageprocessor/internal/infrastructure/storage"
+)
+
+const orphanBatchSize = 500
+
+// OrphanCleaner периодически удаляет из хранилища файлы, на которые не
+// ссылается ни одна запись, например оставшиеся после неудачного удаления
+type OrphanCleaner struct {
+	repo     domain.ImageRepository
+	storage  storage.Storage
+	interval time.Duration
+	// grace защищает файлы, запись о которых еще не создана: загрузка
+	// сохраняет оригинал раньше, чем вставляет строку
+	grace time.Duration
+}
+
+// NewOrphanCleaner создает очистку осиротевших файлов
+func NewOrphanCleaner(repo domain.ImageRepository, storage storage.Storage, interval, grace time.Duration) *OrphanCleaner {
+	return &OrphanCleaner{
+		repo:     repo,
+		storage:  storage,
+		interval: interval,
+		grace:    grace,
+	}
+}
+
+// Run блокируется до отмены ctx
+func (c *OrphanCleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	zlog.Logger.Info().Dur("interval", c.interval).Dur("grace", c.grace).Msg("orphan cleaner started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("orphan cleaner stopped")
+			return
+		case <-ticker.C:
+			c.sweep(ctx)
+		}
+	}
+}
+
+func (c *OrphanCleaner) sweep(ctx context.Context) {
+	cutoff := time.Now().Add(-c.grace)
+	var scanned, removed int
+	batch := make([]string, 0, orphanBatchSize)
+
+	// Файлы проверяются пакетами, чтобы не держать в памяти весь список
+	err := c.storage.List(ctx, func(obj storage.StoredObject) error {
+		scanned++
+		if obj.ModTime.After(cutoff) {
+			return nil
+		}
+		batch = append(batch, obj.Path)
+		if len(batch) < orphanBatchSize {
+			return nil
+		}
+		n, err := c.removeOrphans(ctx, batch)
+		removed += n
+		batch = batch[:0]
+		return err
+	})
+	if err == nil {
+		var n int
+		n, err = c.removeOrphans(ctx, batch)
+		removed += n
+	}
+	if err != nil {
+		zlog.Logger.Error().Err(err).Int("scanned", scanned).Int("removed", removed).Msg("orphan sweep failed")
+		return
+	}
+
+	zlog.Logger.Info().Int("scanned", scanned).Int("removed", removed).Msg("orphan sweep finished")
+}
+
+// removeOrphans удаляет файлы пакета, на которые нет ссылок в базе
+func (c *OrphanCleaner) removeOrphans(ctx context.Context, paths []string) (int, error) {
+	if len(paths) == 0 {
+		return 0, nil
+	}
+	orphans, err := c.repo.UnreferencedPaths(ctx, paths)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range orphans {
+		if err := c.storage.Delete(ctx, path); err != nil {
+			zlog.Logger.Error().Err(err).Str("path", path).Msg("failed to delete orphaned file")
+			continue
+		}
+		zlog.Logger.Info().Str("path", path).Msg("orphaned file deleted")
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+const purgeBatchSize = 100
+
+// PurgeScheduler периодически окончательно удаляет изображения,
+// мягко удаленные раньше, чем retention назад
+type PurgeScheduler struct {
+	service   domain.ProcessorService
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewPurgeScheduler создает планировщик очистки удаленных изображений
+func NewPurgeScheduler(service domain.ProcessorService, retention, interval time.Duration) *PurgeScheduler {
+	return &PurgeScheduler{
+		service:   service,
+		retention: retention,
+		interval:  interval,
+	}
+}
+
+// Run блокируется до отмены ctx
+func (s *PurgeScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	zlog.Logger.Info().Dur("retention", s.retention).Dur("interval", s.interval).Msg("purge scheduler started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("purge scheduler stopped")
+			return
+		case <-ticker.C:
+			s.purgeExpired(ctx)
+		}
+	}
+}
+
+func (s *PurgeScheduler) purgeExpired(ctx context.Context) {
+	before := time.Now().Add(-s.retention)
+	// Полный пакет означает, что удаленных изображений могло остаться больше
+	for ctx.Err() == nil {
+		purged, err := s.service.PurgeDeleted(ctx, before, purgeBatchSize)
+		if err != nil {
+			zlog.Logger.Error().Err(err).Msg("failed to purge deleted images")
+			return
+		}
+		if purged > 0 {
+			zlog.Logger.Info().Int("purged", purged).Msg("deleted images purged")
+		}
+		if purged < purgeBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+const retryBatchSize = 100
+
+// RetryScheduler периодически возвращает в очередь изображения,
+// для которых наступило время повторной попытки обработки
+type RetryScheduler struct {
+	repo     domain.ImageRepository
+	queue    domain.QueueService
+	interval time.Duration
+}
+
+// NewRetryScheduler создает планировщик повторных попыток
+func NewRetryScheduler(repo domain.ImageRepository, queue domain.QueueService, interval time.Duration) *RetryScheduler {
+	return &RetryScheduler{
+		repo:     repo,
+		queue:    queue,
+		interval: interval,
+	}
+}
+
+// Run блокируется до отмены ctx
+func (s *RetryScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	zlog.Logger.Info().Dur("interval", s.interval).Msg("retry scheduler started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("retry scheduler stopped")
+			return
+		case <-ticker.C:
+			s.requeueDue(ctx)
+		}
+	}
+}
+
+func (s *RetryScheduler) requeueDue(ctx context.Context) {
+	// Расписание снимается атомарно при выборке, поэтому ни следующий тик,
+	// ни планировщик другой реплики не отправят ту же задачу повторно
+	images, err := s.repo.ClaimDueForRetry(ctx, time.Now(), retryBatchSize)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to claim images due for retry")
+		return
+	}
+
+	for _, img := range images {
+		if err := s.queue.PublishProcessingTask(ctx, img.ID, img.ProcessingType, domain.ProcessingOptions{}); err != nil {
+			zlog.Logger.Error().Err(err).Str("image_id", img.ID).Msg("failed to requeue image, will try again")
+			now := time.Now()
+			img.NextAttemptAt = &now
+			if err := s.repo.Update(ctx, img); err != nil {
+				zlog.Logger.Error().Err(err).Str("image_id", img.ID).Msg("failed to restore retry schedule")
+			}
+			continue
+		}
+
+		zlog.Logger.Info().
+			Str("image_id", img.ID).
+			Int("attempts", img.Attempts).
+			Msg("image requeued for retry")
+	}
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type fakeRetryRepo struct {
+	domain.ImageRepository
+	due     []*domain.Image
+	claims  int
+	updated []*domain.Image
+}
+
+func (r *fakeRetryRepo) ClaimDueForRetry(ctx context.Context, now time.Time, limit int) ([]*domain.Image, error) {
+	r.claims++
+	due := r.due
+	r.due = nil
+	return due, nil
+}
+
+func (r *fakeRetryRepo) Update(ctx context.Context, image *domain.Image) error {
+	copied := *image
+	r.updated = append(r.updated, &copied)
+	return nil
+}
+
+func TestRetrySchedulerPublishesClaimedImages(t *testing.T) {
+	repo := &fakeRetryRepo{due: []*domain.Image{{ID: "a"}, {ID: "b"}, {ID: "c"}}}
+	queue := &fakeQueue{fail: map[string]bool{"b": true}}
+	s := NewRetryScheduler(repo, queue, time.Minute)
+
+	s.requeueDue(context.Background())
+
+	if len(queue.published) != 2 || queue.published[0] != "a" || queue.published[1] != "c" {
+		t.Fatalf("published %v, want [a c] past the failed one", queue.published)
+	}
+	// the claim already cleared the schedules; only the failed publish
+	// writes one back
+	if len(repo.updated) != 1 || repo.updated[0].ID != "b" || repo.updated[0].NextAttemptAt == nil {
+		t.Fatalf("updated %+v, want only b rescheduled", repo.updated)
+	}
+
+	s.requeueDue(context.Background())
+	if repo.claims != 2 || len(queue.published) != 2 {
+		t.Fatalf("second tick published %v, want nothing new", queue.published)
+	}
+}
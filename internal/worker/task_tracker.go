@@ -0,0 +1,128 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+// recentTasksLimit - сколько завершенных задач хранится для отладки
+const recentTasksLimit = 20
+
+// TaskHandler - обработчик одной задачи из очереди, как HandleProcessingTask
+type TaskHandler func(ctx context.Context, task *dto.ProcessImageRequest) error
+
+// TaskInfo описывает выполняющуюся или завершенную задачу
+type TaskInfo struct {
+	ImageID        string     `json:"image_id"`
+	VariantID      string     `json:"variant_id,omitempty"`
+	ProcessingType string     `json:"processing_type"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	DurationMS     int64      `json:"duration_ms"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// TaskTracker запоминает задачи, которые воркер выполняет сейчас,
+// и последние завершенные, для GET /debug/current
+type TaskTracker struct {
+	mu       sync.Mutex
+	nextID   uint64
+	inFlight map[uint64]TaskInfo
+	recent   []TaskInfo
+}
+
+// NewTaskTracker создает пустой трекер
+func NewTaskTracker() *TaskTracker {
+	return &TaskTracker{inFlight: make(map[uint64]TaskInfo)}
+}
+
+// Track оборачивает handler так, что каждая задача учитывается
+// на время выполнения и попадает в список последних после завершения
+func (t *TaskTracker) Track(handler TaskHandler) TaskHandler {
+	return func(ctx context.Context, task *dto.ProcessImageRequest) error {
+		id := t.start(task)
+		err := handler(ctx, task)
+		t.finish(id, err)
+		return err
+	}
+}
+
+func (t *TaskTracker) start(task *dto.ProcessImageRequest) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	t.inFlight[t.nextID] = TaskInfo{
+		ImageID:        task.ImageID,
+		VariantID:      task.VariantID,
+		ProcessingType: task.ProcessingType,
+		StartedAt:      time.Now(),
+	}
+	return t.nextID
+}
+
+func (t *TaskTracker) finish(id uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	info, ok := t.inFlight[id]
+	if !ok {
+		return
+	}
+	delete(t.inFlight, id)
+
+	now := time.Now()
+	info.FinishedAt = &now
+	info.DurationMS = now.Sub(info.StartedAt).Milliseconds()
+	if err != nil {
+		info.Error = err.Error()
+	}
+
+	// новые в начале списка
+	t.recent = append([]TaskInfo{info}, t.recent...)
+	if len(t.recent) > recentTasksLimit {
+		t.recent = t.recent[:recentTasksLimit]
+	}
+}
+
+// TaskSnapshot - ответ GET /debug/current
+type TaskSnapshot struct {
+	InFlight []TaskInfo `json:"in_flight"`
+	Recent   []TaskInfo `json:"recent"`
+}
+
+// Snapshot возвращает копию текущего состояния; выполняющиеся задачи
+// упорядочены по времени старта, длительность считается на момент вызова
+func (t *TaskTracker) Snapshot() TaskSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	snap := TaskSnapshot{
+		InFlight: make([]TaskInfo, 0, len(t.inFlight)),
+		Recent:   append([]TaskInfo{}, t.recent...),
+	}
+	for _, info := range t.inFlight {
+		info.DurationMS = now.Sub(info.StartedAt).Milliseconds()
+		snap.InFlight = append(snap.InFlight, info)
+	}
+	sort.Slice(snap.InFlight, func(i, j int) bool {
+		return snap.InFlight[i].StartedAt.Before(snap.InFlight[j].StartedAt)
+	})
+	return snap
+}
+
+// Handler отдает Snapshot в JSON
+func (t *TaskTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		_ = json.NewEncoder(w).Encode(t.Snapshot())
+	})
+}
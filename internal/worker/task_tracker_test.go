@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yokitheyo/imageprocessor/internal/dto"
+)
+
+func getSnapshot(t *testing.T, url string) TaskSnapshot {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", cc)
+	}
+	var snap TaskSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&snap); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	return snap
+}
+
+func TestDebugCurrentShowsRunningTask(t *testing.T) {
+	tracker := NewTaskTracker()
+	srv := httptest.NewServer(tracker.Handler())
+	defer srv.Close()
+
+	started := make(chan struct{})
+	release := make(chan error)
+	handler := tracker.Track(func(ctx context.Context, task *dto.ProcessImageRequest) error {
+		close(started)
+		return <-release
+	})
+	done := make(chan error)
+	go func() {
+		done <- handler(context.Background(), &dto.ProcessImageRequest{ImageID: "img-1", ProcessingType: "resize"})
+	}()
+	<-started
+
+	snap := getSnapshot(t, srv.URL)
+	if len(snap.InFlight) != 1 || len(snap.Recent) != 0 {
+		t.Fatalf("snapshot %+v, want one task in flight", snap)
+	}
+	if got := snap.InFlight[0]; got.ImageID != "img-1" || got.ProcessingType != "resize" || got.StartedAt.IsZero() || got.FinishedAt != nil {
+		t.Fatalf("in flight = %+v", got)
+	}
+
+	release <- errors.New("decode image: unexpected EOF")
+	if err := <-done; err == nil {
+		t.Fatal("Track swallowed the handler error")
+	}
+
+	snap = getSnapshot(t, srv.URL)
+	if len(snap.InFlight) != 0 || len(snap.Recent) != 1 {
+		t.Fatalf("snapshot %+v, want the task among recent ones", snap)
+	}
+	if got := snap.Recent[0]; got.ImageID != "img-1" || got.FinishedAt == nil || got.Error != "decode image: unexpected EOF" {
+		t.Fatalf("recent = %+v", got)
+	}
+}
+
+func TestTaskTrackerKeepsNewestCompletions(t *testing.T) {
+	tracker := NewTaskTracker()
+	handler := tracker.Track(func(ctx context.Context, task *dto.ProcessImageRequest) error { return nil })
+
+	for i := range recentTasksLimit + 5 {
+		handler(context.Background(), &dto.ProcessImageRequest{ImageID: fmt.Sprintf("img-%d", i)})
+	}
+
+	recent := tracker.Snapshot().Recent
+	if len(recent) != recentTasksLimit {
+		t.Fatalf("kept %d completions, want %d", len(recent), recentTasksLimit)
+	}
+	if want := fmt.Sprintf("img-%d", recentTasksLimit+4); recent[0].ImageID != want {
+		t.Errorf("newest = %s, want %s first", recent[0].ImageID, want)
+	}
+	if recent[len(recent)-1].ImageID != "img-5" {
+		t.Errorf("oldest kept = %s, want img-5", recent[len(recent)-1].ImageID)
+	}
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/wb-go/wbf/zlog"
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+const variantSweepBatchSize = 100
+
+// VariantSweeper периодически возвращает в очередь именованные варианты,
+// застрявшие в processing дольше lease: воркер, взявший их, упал или
+// потерял задачу. Статус не меняется — новую задачу заберет Claim,
+// поэтому неудачная публикация просто повторится на следующем тике
+type VariantSweeper struct {
+	variants domain.ProcessedVariantRepository
+	queue    domain.QueueService
+	lease    time.Duration
+	interval time.Duration
+}
+
+// NewVariantSweeper создает планировщик возврата просроченных вариантов
+func NewVariantSweeper(variants domain.ProcessedVariantRepository, queue domain.QueueService, lease, interval time.Duration) *VariantSweeper {
+	return &VariantSweeper{
+		variants: variants,
+		queue:    queue,
+		lease:    lease,
+		interval: interval,
+	}
+}
+
+// Run блокируется до отмены ctx
+func (s *VariantSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	zlog.Logger.Info().Dur("lease", s.lease).Dur("interval", s.interval).Msg("variant sweeper started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			zlog.Logger.Info().Msg("variant sweeper stopped")
+			return
+		case <-ticker.C:
+			s.requeueExpired(ctx)
+		}
+	}
+}
+
+func (s *VariantSweeper) requeueExpired(ctx context.Context) {
+	variants, err := s.variants.FindExpiredClaims(ctx, time.Now().Add(-s.lease), variantSweepBatchSize)
+	if err != nil {
+		zlog.Logger.Error().Err(err).Msg("failed to load expired variant claims")
+		return
+	}
+
+	for _, v := range variants {
+		if err := s.queue.PublishVariantTask(ctx, v); err != nil {
+			zlog.Logger.Error().Err(err).Str("variant_id", v.ID).Msg("failed to requeue expired variant, will try again")
+			continue
+		}
+
+		zlog.Logger.Warn().
+			Str("variant_id", v.ID).
+			Str("image_id", v.ImageID).
+			Str("variant", v.Name).
+			Msg("variant claim expired, requeued")
+	}
+}
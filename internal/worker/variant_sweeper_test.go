@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yokitheyo/imageprocessor/internal/domain"
+)
+
+type fakeVariantRepo struct {
+	domain.ProcessedVariantRepository
+	expired       []*domain.ProcessedVariant
+	expiredBefore time.Time
+}
+
+func (r *fakeVariantRepo) FindExpiredClaims(ctx context.Context, expiredBefore time.Time, limit int) ([]*domain.ProcessedVariant, error) {
+	r.expiredBefore = expiredBefore
+	return r.expired, nil
+}
+
+type fakeQueue struct {
+	domain.QueueService
+	fail      map[string]bool
+	published []string
+}
+
+func (q *fakeQueue) PublishVariantTask(ctx context.Context, variant *domain.ProcessedVariant) error {
+	if q.fail[variant.ID] {
+		return errors.New("kafka unavailable")
+	}
+	q.published = append(q.published, variant.ID)
+	return nil
+}
+
+func (q *fakeQueue) PublishProcessingTask(ctx context.Context, imageID string, processingType domain.ProcessingType, opts domain.ProcessingOptions) error {
+	if q.fail[imageID] {
+		return errors.New("kafka unavailable")
+	}
+	q.published = append(q.published, imageID)
+	return nil
+}
+
+func TestVariantSweeperRequeuesExpiredClaims(t *testing.T) {
+	repo := &fakeVariantRepo{expired: []*domain.ProcessedVariant{{ID: "a"}, {ID: "b"}, {ID: "c"}}}
+	queue := &fakeQueue{fail: map[string]bool{"b": true}}
+	lease := 10 * time.Minute
+	s := NewVariantSweeper(repo, queue, lease, time.Minute)
+
+	start := time.Now()
+	s.requeueExpired(context.Background())
+
+	if len(queue.published) != 2 || queue.published[0] != "a" || queue.published[1] != "c" {
+		t.Fatalf("published %v, want [a c] past the failed one", queue.published)
+	}
+	if cutoff := start.Add(-lease); repo.expiredBefore.Before(cutoff) || repo.expiredBefore.After(time.Now().Add(-lease)) {
+		t.Fatalf("expiredBefore = %v, want about %v", repo.expiredBefore, cutoff)
+	}
+}
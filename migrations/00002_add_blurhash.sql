@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS blurhash VARCHAR(64);
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS blurhash;
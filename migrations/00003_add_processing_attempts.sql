@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_images_next_attempt_at ON images(next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_next_attempt_at;
+ALTER TABLE images DROP COLUMN IF EXISTS next_attempt_at;
+ALTER TABLE images DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processing_options JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS processing_options;
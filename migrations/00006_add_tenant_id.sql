@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_images_tenant_created ON images(tenant_id, created_at) WHERE tenant_id IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_tenant_created;
+ALTER TABLE images DROP COLUMN IF EXISTS tenant_id;
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash) WHERE content_hash IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_content_hash;
+ALTER TABLE images DROP COLUMN IF EXISTS content_hash;
@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE SEQUENCE IF NOT EXISTS images_public_id_seq;
+
+-- Existing rows are numbered from the sequence as the column is added
+ALTER TABLE images ADD COLUMN IF NOT EXISTS public_id BIGINT NOT NULL DEFAULT nextval('images_public_id_seq');
+ALTER SEQUENCE images_public_id_seq OWNED BY images.public_id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_images_public_id ON images(public_id);
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_public_id;
+ALTER TABLE images DROP COLUMN IF EXISTS public_id;
+DROP SEQUENCE IF EXISTS images_public_id_seq;
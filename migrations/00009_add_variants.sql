@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS variants;
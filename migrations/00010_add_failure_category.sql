@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS failure_category VARCHAR(32);
+
+CREATE INDEX IF NOT EXISTS idx_images_failure_category ON images(failure_category) WHERE failure_category IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_failure_category;
+ALTER TABLE images DROP COLUMN IF EXISTS failure_category;
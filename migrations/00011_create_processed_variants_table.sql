@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS processed_variants (
+    id VARCHAR(36) PRIMARY KEY,
+    image_id VARCHAR(36) NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    processing_type VARCHAR(20) NOT NULL,
+    processing_options JSONB NOT NULL DEFAULT '{}'::jsonb,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    processed_path TEXT,
+    width INTEGER,
+    height INTEGER,
+    error_message TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    processed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (image_id, name)
+);
+
+
+-- +goose Down
+DROP TABLE IF EXISTS processed_variants;
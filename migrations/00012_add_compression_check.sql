@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_size BIGINT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS poor_compression BOOLEAN NOT NULL DEFAULT FALSE;
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS poor_compression;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_size;
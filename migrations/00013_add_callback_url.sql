@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS callback_url TEXT;
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS callback_url;
@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processing_duration_ms BIGINT;
+
+
+-- +goose Down
+ALTER TABLE images DROP COLUMN IF EXISTS processing_duration_ms;
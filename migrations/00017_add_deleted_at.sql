@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX IF NOT EXISTS idx_images_deleted_at ON images(deleted_at) WHERE deleted_at IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_deleted_at;
+ALTER TABLE images DROP COLUMN IF EXISTS deleted_at;
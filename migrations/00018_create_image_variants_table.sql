@@ -0,0 +1,38 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS image_variants (
+    image_id VARCHAR(36) NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    path TEXT NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    content_type VARCHAR(64) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    PRIMARY KEY (image_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_image_variants_path ON image_variants(path);
+
+INSERT INTO image_variants (image_id, name, path, format, content_type, width, height)
+SELECT i.id, v.name, v.path, v.format, v.content_type, v.width, v.height
+FROM images i,
+     jsonb_to_recordset(i.variants) AS v(name TEXT, path TEXT, format TEXT, content_type TEXT, width INTEGER, height INTEGER)
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE images DROP COLUMN IF EXISTS variants;
+
+
+-- +goose Down
+ALTER TABLE images ADD COLUMN IF NOT EXISTS variants JSONB NOT NULL DEFAULT '[]';
+
+UPDATE images i
+SET variants = (
+    SELECT jsonb_agg(jsonb_build_object(
+        'name', v.name, 'path', v.path, 'format', v.format,
+        'content_type', v.content_type, 'width', v.width, 'height', v.height
+    ) ORDER BY v.name)
+    FROM image_variants v
+    WHERE v.image_id = i.id
+)
+WHERE EXISTS (SELECT 1 FROM image_variants v WHERE v.image_id = i.id);
+
+DROP TABLE IF EXISTS image_variants;
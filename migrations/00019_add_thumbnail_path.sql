@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE images ADD COLUMN IF NOT EXISTS thumbnail_path TEXT;
+CREATE INDEX IF NOT EXISTS idx_images_thumbnail_path ON images(thumbnail_path) WHERE thumbnail_path IS NOT NULL;
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_images_thumbnail_path;
+ALTER TABLE images DROP COLUMN IF EXISTS thumbnail_path;
@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE processed_variants ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP WITH TIME ZONE;
+-- variants already processing get their last update as the claim time
+UPDATE processed_variants SET claimed_at = updated_at WHERE status = 'processing' AND claimed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_processed_variants_claimed_at ON processed_variants(claimed_at) WHERE status = 'processing';
+
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_processed_variants_claimed_at;
+ALTER TABLE processed_variants DROP COLUMN IF EXISTS claimed_at;